@@ -1,21 +1,43 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 	"vaportrail/internal/config"
 	"vaportrail/internal/db"
+	"vaportrail/internal/influx"
+	"vaportrail/internal/otlp"
 	"vaportrail/internal/scheduler"
 	"vaportrail/internal/web"
 )
 
 func main() {
-	cfg := config.Load()
+	cfg, err := config.LoadWithError()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
 	log.Printf("Starting VaporTrail on port %d...", cfg.HTTPPort)
 	log.Printf("Using database at %s", cfg.DBPath)
 
+	if err := cfg.EnsureDataDir(); err != nil {
+		log.Fatalf("Failed to prepare data directory: %v", err)
+	}
+
+	if err := db.SetTDigestCompression(cfg.TDigestCompression); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := db.SetPageCache(cfg.SQLiteCacheSizeKiB, cfg.SQLiteMmapSizeBytes); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := db.SetConnectionPool(cfg.SQLiteReadConns, cfg.SQLiteConnMaxLifetime); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
 	dbConn, err := db.New(cfg.DBPath)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
@@ -23,21 +45,44 @@ func main() {
 	log.Println("Database initialized successfully")
 	defer dbConn.Close()
 
+	if err := scheduler.LoadGlobalRetentionPolicies(dbConn); err != nil {
+		log.Fatalf("Failed to load global retention policies: %v", err)
+	}
+
 	sched := scheduler.New(dbConn)
+	if cfg.MaxProbesPerSecond > 0 {
+		sched.SetMaxProbesPerSecond(cfg.MaxProbesPerSecond)
+	}
+	sched.SetDiskGuard(filepath.Dir(cfg.DBPath), cfg.MinFreeDiskBytes)
+	sched.SetCheckpointInterval(cfg.SQLiteCheckpointInterval, cfg.SQLiteWALTruncateBytes)
+	sched.SetRollupFlushInterval(cfg.RollupFlushInterval)
+	sched.SetFailureLogInterval(cfg.FailureLogInterval)
+	if err := sched.SetLatencyPrecision(cfg.LatencyPrecision); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := sched.SetStoredPercentiles(cfg.StoredPercentiles); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := sched.SetResultBuffer(cfg.ResultBufferSize, cfg.ResultOverflow); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
-	// Add a sample target if none exist
-	targets, _ := dbConn.GetTargets()
-	if len(targets) == 0 {
-		log.Println("Adding sample target: Google")
-		_, err := dbConn.AddTarget(&db.Target{
-			Name:        "Google",
-			Address:     "google.com",
-			ProbeType:   "ping",
-			ProbeConfig: "",
-		})
-		if err != nil {
-			log.Printf("Failed to add sample target: %v", err)
-		}
+	if cfg.SeedSample {
+		seedSampleTarget(dbConn)
+	}
+
+	if cfg.InfluxWriteURL != "" {
+		influxWriter := influx.NewWriter(cfg.InfluxWriteURL)
+		defer influxWriter.Close()
+		sched.RegisterResultHook(influxWriter.Hook)
+		log.Println("Pushing probe results to InfluxDB")
+	}
+
+	if cfg.OTLPMetricsURL != "" {
+		otlpExporter := otlp.NewExporter(cfg.OTLPMetricsURL)
+		defer otlpExporter.Close()
+		sched.RegisterResultHook(otlpExporter.Hook)
+		log.Println("Exporting probe results over OTLP")
 	}
 
 	if err := sched.Start(); err != nil {
@@ -46,6 +91,24 @@ func main() {
 
 	// Start Web Server
 	ws := web.New(cfg, dbConn, sched)
+	if cfg.ReadReplica {
+		readConn, err := db.OpenReadOnly(cfg.DBPath)
+		if err != nil {
+			log.Fatalf("Failed to open read-only database: %v", err)
+		}
+		defer readConn.Close()
+		ws.SetReadDB(readConn)
+		log.Println("Serving result queries from a read-only connection")
+	}
+	if cfg.ReadOnly {
+		log.Println("Read-only mode: API changes are disabled")
+	}
+	if err := ws.LoadTLS(); err != nil {
+		log.Fatalf("Failed to enable HTTPS: %v", err)
+	}
+	if cfg.TLSCertFile != "" {
+		log.Printf("Serving HTTPS with certificate %s", cfg.TLSCertFile)
+	}
 	go func() {
 		if err := ws.Start(); err != nil {
 			log.Fatalf("Web server failed: %v", err)
@@ -53,8 +116,40 @@ func main() {
 	}()
 
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 	sig := <-sigCh
+	for sig == syscall.SIGHUP {
+		if err := ws.ReloadTLS(); err != nil {
+			log.Printf("Failed to reload TLS certificate, keeping the current one: %v", err)
+		} else if cfg.TLSCertFile != "" {
+			log.Println("Reloaded TLS certificate")
+		}
+		sig = <-sigCh
+	}
 	log.Printf("Received %s, shutting down...", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := ws.Shutdown(ctx); err != nil {
+		log.Printf("Web server shutdown: %v", err)
+	}
 	sched.Stop()
 }
+
+// seedSampleTarget adds a ping target for google.com if the database has no
+// targets, so a fresh install has something to graph.
+func seedSampleTarget(database *db.DB) {
+	targets, err := database.GetTargets()
+	if err != nil || len(targets) > 0 {
+		return
+	}
+	log.Println("Adding sample target: Google")
+	_, err = database.AddTarget(&db.Target{
+		Name:      "Google",
+		Address:   "google.com",
+		ProbeType: "ping",
+	})
+	if err != nil {
+		log.Printf("Failed to add sample target: %v", err)
+	}
+}
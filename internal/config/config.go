@@ -1,34 +1,207 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 // ServerConfig holds the global configuration for the VaporTrail server.
 // Probe-specific configurations are stored in the database.
 type ServerConfig struct {
 	// HTTPPort is the port the web server listens on.
-	HTTPPort int
+	HTTPPort int `yaml:"http_port"`
 	// DBPath is the file path to the SQLite database.
-	DBPath string
+	DBPath string `yaml:"db_path"`
+	// DataDir, when set, is the directory that holds the database and any
+	// other files VaporTrail writes. A relative DBPath is resolved against it.
+	DataDir string `yaml:"data_dir"`
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout bound how
+	// long the web server waits on a client; zero disables that limit.
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// MaxHeaderBytes caps the size of request headers.
+	MaxHeaderBytes int `yaml:"max_header_bytes"`
+	// TLSCertFile and TLSKeyFile enable HTTPS when both are set. The files
+	// are re-read on SIGHUP so renewed certificates don't need a restart.
+	TLSCertFile string `yaml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file"`
+	// InfluxWriteURL, when set, is an InfluxDB write endpoint that every
+	// probe result is pushed to as line protocol.
+	InfluxWriteURL string `yaml:"influx_write_url"`
+	// OTLPMetricsURL, when set, is an OpenTelemetry collector's OTLP/HTTP
+	// metrics endpoint, such as http://localhost:4318/v1/metrics, that
+	// probe results are pushed to as metrics.
+	OTLPMetricsURL string `yaml:"otlp_metrics_url"`
+	// MinSamplesForPercentiles is the fewest probes a window needs before
+	// the results API reports its percentiles; sparser windows only report
+	// min, max and average. Zero always reports them.
+	MinSamplesForPercentiles int `yaml:"min_samples_for_percentiles"`
+	// AvailabilitySLO is the availability objective, such as 0.999, that
+	// the availability API reports error budget consumption against when
+	// the request doesn't name one.
+	AvailabilitySLO float64 `yaml:"availability_slo"`
+	// StaleAfterIntervals marks a target stale in the targets and overview
+	// APIs once its latest result is this many probe intervals old (or
+	// timeouts, if longer), so a target that stopped being probed doesn't
+	// pass for a quiet, healthy one. Zero never marks targets stale.
+	StaleAfterIntervals float64 `yaml:"stale_after_intervals"`
+	// MaxTargets caps how many targets can be created through the API, since
+	// each runs its own probe loop. Zero means unlimited.
+	MaxTargets int `yaml:"max_targets"`
+	// MaxProbesPerSecond caps the probes started per second across all
+	// targets; probes over the budget are skipped. Zero means unlimited.
+	MaxProbesPerSecond float64 `yaml:"max_probes_per_second"`
+	// TDigestCompression is how stored t-digests are compressed: "none"
+	// (the default) or "gzip". Digests written either way stay readable.
+	TDigestCompression string `yaml:"tdigest_compression"`
+	// ResultBufferSize is how many probe results can queue for the database
+	// writer, and ResultOverflow what happens when the queue is full:
+	// "drop_newest" (the default) or "drop_oldest". Probes never wait for
+	// the writer, so a full queue loses results instead of stalling probes;
+	// a bigger queue rides out longer database stalls but uses more memory.
+	ResultBufferSize int    `yaml:"result_buffer_size"`
+	ResultOverflow   string `yaml:"result_overflow"`
+	// RollupFlushInterval is how often the window a rollup is still
+	// collecting is written as a partial result, so recent data shows up on
+	// the dashboards before the window closes. Zero only writes complete
+	// windows.
+	RollupFlushInterval time.Duration `yaml:"rollup_flush_interval"`
+	// FailureLogInterval is how often a target that keeps failing the same
+	// way has the failure logged again; the failures in between are counted
+	// in the next line. Zero logs every failure.
+	FailureLogInterval time.Duration `yaml:"failure_log_interval"`
+	// MinFreeDiskBytes is the least free space the database's filesystem
+	// may have before raw results stop being written. Zero only reports free
+	// space on /healthz.
+	MinFreeDiskBytes int64 `yaml:"min_free_disk_bytes"`
+	// DigestCacheSize is how many aggregated results' computed percentiles
+	// the results API keeps in memory, so repeated queries don't decode the
+	// same t-digests again. Zero disables the cache.
+	DigestCacheSize int `yaml:"digest_cache_size"`
+	// StoredPercentiles, such as [50, 95, 99], are computed when each
+	// rollup is written and stored with it, so results queries asking for
+	// just those (percentiles=50,95,99) skip decoding t-digests entirely.
+	// Empty stores none.
+	StoredPercentiles []float64 `yaml:"stored_percentiles"`
+	// LatencyPrecision, such as 1µs, is what latencies are rounded to
+	// before they go into a rollup's t-digest, which then needs fewer
+	// centroids for latencies that only differ in digits the probe can't
+	// really measure. Raw results keep full precision. Zero rounds nothing.
+	LatencyPrecision time.Duration `yaml:"latency_precision"`
+	// PlausibleLatencyMin and PlausibleLatencyMax bound the latencies a
+	// test probe (POST /api/probe-test) can return without a warning that
+	// its units are likely wrong, such as a command's milliseconds read as
+	// nanoseconds. Zero leaves that side unbounded.
+	PlausibleLatencyMin time.Duration `yaml:"plausible_latency_min"`
+	PlausibleLatencyMax time.Duration `yaml:"plausible_latency_max"`
+	// SQLiteCacheSizeKiB is SQLite's page cache per database connection, in
+	// KiB, and SQLiteMmapSizeBytes how much of the database file is read
+	// through memory mapping. Zero keeps SQLite's defaults, a 2 MiB cache
+	// and no mapping. For a read-heavy instance a cache of 32-64 MiB
+	// (32768-65536) and a mapping around the database's size, say 256 MiB
+	// (268435456), keep most results queries off the disk.
+	SQLiteCacheSizeKiB  int64 `yaml:"sqlite_cache_size_kib"`
+	SQLiteMmapSizeBytes int64 `yaml:"sqlite_mmap_size_bytes"`
+	// SQLiteReadConns is how many connections the read replica's pool may
+	// hold. Writes always go through a single connection, since SQLite
+	// serializes writers anyway and a second one only fails with "database
+	// is locked". SQLiteConnMaxLifetime is how long a connection is reused
+	// before it is reopened; zero reuses it forever.
+	SQLiteReadConns       int           `yaml:"sqlite_read_conns"`
+	SQLiteConnMaxLifetime time.Duration `yaml:"sqlite_conn_max_lifetime"`
+	// SQLiteCheckpointInterval is how often the write-ahead log is
+	// checkpointed into the database file, so steady writes don't grow it
+	// without bound. Checkpoints are PASSIVE, not waiting on readers, until
+	// the log exceeds SQLiteWALTruncateBytes; then they are TRUNCATE, which
+	// waits for readers and shrinks the file. Zero leaves checkpointing to
+	// SQLite.
+	SQLiteCheckpointInterval time.Duration `yaml:"sqlite_checkpoint_interval"`
+	SQLiteWALTruncateBytes   int64         `yaml:"sqlite_wal_truncate_bytes"`
+	// DisplayTimezone is the IANA zone name, such as "Europe/Berlin", the
+	// dashboards show times in. Empty uses the browser's zone. The API
+	// always reports times in UTC.
+	DisplayTimezone string `yaml:"display_timezone"`
+	// WriteToken, when set, must be sent as "Authorization: Bearer <token>"
+	// to the API endpoints that rewrite or destroy stored data: editing,
+	// deleting, importing and bulk-creating targets, deleting results,
+	// restoring dumps, rebuilding rollups and stats, and changing retention.
+	// It also guards those that act for the server: pausing and resuming
+	// probing, test probes, and the scheduler's debug view. Creating single
+	// targets and editing dashboards stay open.
+	WriteToken string `yaml:"write_token"`
+	// SeedSample adds a sample ping target on startup when the database has
+	// no targets. Off by default, since it probes an external host.
+	SeedSample bool `yaml:"seed_sample"`
+	// ReadReplica opens a second, read-only connection to the database for
+	// the web API's result queries so they don't compete with probe writes.
+	ReadReplica bool `yaml:"read_replica"`
+	// ReadOnly rejects every API request that would change anything, such
+	// as for a public demo, whatever token it carries. Probing, rollups and
+	// retention carry on as usual.
+	ReadOnly bool `yaml:"read_only"`
 }
 
 // DefaultConfig returns a default configuration.
 func DefaultConfig() *ServerConfig {
 	return &ServerConfig{
-		HTTPPort: 8080,
-		DBPath:   "vaportrail.db",
+		HTTPPort:            8080,
+		DBPath:              "vaportrail.db",
+		ReadHeaderTimeout:   10 * time.Second,
+		ReadTimeout:         30 * time.Second,
+		WriteTimeout:        60 * time.Second,
+		IdleTimeout:         120 * time.Second,
+		MaxHeaderBytes:      1 << 20,
+		DigestCacheSize:     10000,
+		ResultBufferSize:    1000,
+		MinFreeDiskBytes:    100 << 20,
+		FailureLogInterval:  time.Minute,
+		AvailabilitySLO:     0.999,
+		StaleAfterIntervals: 5,
+		PlausibleLatencyMin: time.Microsecond,
+		PlausibleLatencyMax: time.Minute,
+
+		SQLiteReadConns:          4,
+		SQLiteConnMaxLifetime:    time.Hour,
+		SQLiteCheckpointInterval: 5 * time.Minute,
+		SQLiteWALTruncateBytes:   64 << 20,
 	}
 }
 
-// Load loads the configuration from command-line flags and environment variables.
-// Priority order: command-line flags > environment variables > defaults.
+// Load is like LoadWithError but exits if the configuration file can't be
+// read.
 func Load() *ServerConfig {
+	cfg, err := LoadWithError()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	return cfg
+}
+
+// LoadWithError loads the configuration from command-line flags, environment
+// variables and the YAML file named by VAPORTRAIL_CONFIG, if any.
+// Priority order: command-line flags > environment variables > config file > defaults.
+// It fails if the file can't be read or contains unknown keys.
+func LoadWithError() (*ServerConfig, error) {
 	cfg := DefaultConfig()
 
-	// 1. Start with Defaults (already in cfg)
+	// 1. Start with Defaults (already in cfg), then the config file
+	if path := os.Getenv("VAPORTRAIL_CONFIG"); path != "" {
+		if err := loadFile(cfg, path); err != nil {
+			return nil, err
+		}
+	}
 
 	// 2. Override with Environment Variables
 	if portStr := os.Getenv("VAPORTRAIL_HTTP_PORT"); portStr != "" {
@@ -41,10 +214,209 @@ func Load() *ServerConfig {
 		cfg.DBPath = dbPath
 	}
 
+	if dataDir := os.Getenv("VAPORTRAIL_DATA_DIR"); dataDir != "" {
+		cfg.DataDir = dataDir
+	}
+
+	for env, field := range map[string]*time.Duration{
+		"VAPORTRAIL_HTTP_READ_HEADER_TIMEOUT": &cfg.ReadHeaderTimeout,
+		"VAPORTRAIL_HTTP_READ_TIMEOUT":        &cfg.ReadTimeout,
+		"VAPORTRAIL_HTTP_WRITE_TIMEOUT":       &cfg.WriteTimeout,
+		"VAPORTRAIL_HTTP_IDLE_TIMEOUT":        &cfg.IdleTimeout,
+	} {
+		if str := os.Getenv(env); str != "" {
+			if d, err := time.ParseDuration(str); err == nil && d >= 0 {
+				*field = d
+			}
+		}
+	}
+
+	if sizeStr := os.Getenv("VAPORTRAIL_HTTP_MAX_HEADER_BYTES"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size > 0 {
+			cfg.MaxHeaderBytes = size
+		}
+	}
+
+	if certFile := os.Getenv("VAPORTRAIL_TLS_CERT_FILE"); certFile != "" {
+		cfg.TLSCertFile = certFile
+	}
+
+	if keyFile := os.Getenv("VAPORTRAIL_TLS_KEY_FILE"); keyFile != "" {
+		cfg.TLSKeyFile = keyFile
+	}
+
+	if influxURL := os.Getenv("VAPORTRAIL_INFLUX_WRITE_URL"); influxURL != "" {
+		cfg.InfluxWriteURL = influxURL
+	}
+
+	if otlpURL := os.Getenv("VAPORTRAIL_OTLP_METRICS_URL"); otlpURL != "" {
+		cfg.OTLPMetricsURL = otlpURL
+	}
+
+	if minStr := os.Getenv("VAPORTRAIL_MIN_SAMPLES_FOR_PERCENTILES"); minStr != "" {
+		if n, err := strconv.Atoi(minStr); err == nil && n >= 0 {
+			cfg.MinSamplesForPercentiles = n
+		}
+	}
+
+	if sloStr := os.Getenv("VAPORTRAIL_AVAILABILITY_SLO"); sloStr != "" {
+		if slo, err := strconv.ParseFloat(sloStr, 64); err == nil && slo > 0 && slo < 1 {
+			cfg.AvailabilitySLO = slo
+		}
+	}
+
+	if staleStr := os.Getenv("VAPORTRAIL_STALE_AFTER_INTERVALS"); staleStr != "" {
+		if n, err := strconv.ParseFloat(staleStr, 64); err == nil && n >= 0 {
+			cfg.StaleAfterIntervals = n
+		}
+	}
+
+	if maxStr := os.Getenv("VAPORTRAIL_MAX_TARGETS"); maxStr != "" {
+		if n, err := strconv.Atoi(maxStr); err == nil && n >= 0 {
+			cfg.MaxTargets = n
+		}
+	}
+
+	if rateStr := os.Getenv("VAPORTRAIL_MAX_PROBES_PER_SECOND"); rateStr != "" {
+		if rate, err := strconv.ParseFloat(rateStr, 64); err == nil && rate >= 0 {
+			cfg.MaxProbesPerSecond = rate
+		}
+	}
+
+	if compression := os.Getenv("VAPORTRAIL_TDIGEST_COMPRESSION"); compression != "" {
+		cfg.TDigestCompression = compression
+	}
+
+	if sizeStr := os.Getenv("VAPORTRAIL_RESULT_BUFFER_SIZE"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size > 0 {
+			cfg.ResultBufferSize = size
+		}
+	}
+
+	if overflow := os.Getenv("VAPORTRAIL_RESULT_OVERFLOW"); overflow != "" {
+		cfg.ResultOverflow = overflow
+	}
+
+	if flushStr := os.Getenv("VAPORTRAIL_ROLLUP_FLUSH_INTERVAL"); flushStr != "" {
+		if d, err := time.ParseDuration(flushStr); err == nil && d >= 0 {
+			cfg.RollupFlushInterval = d
+		}
+	}
+
+	if failStr := os.Getenv("VAPORTRAIL_FAILURE_LOG_INTERVAL"); failStr != "" {
+		if d, err := time.ParseDuration(failStr); err == nil && d >= 0 {
+			cfg.FailureLogInterval = d
+		}
+	}
+
+	for env, field := range map[string]*time.Duration{
+		"VAPORTRAIL_LATENCY_PRECISION":     &cfg.LatencyPrecision,
+		"VAPORTRAIL_PLAUSIBLE_LATENCY_MIN": &cfg.PlausibleLatencyMin,
+		"VAPORTRAIL_PLAUSIBLE_LATENCY_MAX": &cfg.PlausibleLatencyMax,
+	} {
+		if str := os.Getenv(env); str != "" {
+			if d, err := time.ParseDuration(str); err == nil && d >= 0 {
+				*field = d
+			}
+		}
+	}
+
+	if freeStr := os.Getenv("VAPORTRAIL_MIN_FREE_DISK_BYTES"); freeStr != "" {
+		if n, err := strconv.ParseInt(freeStr, 10, 64); err == nil && n >= 0 {
+			cfg.MinFreeDiskBytes = n
+		}
+	}
+
+	if sizeStr := os.Getenv("VAPORTRAIL_DIGEST_CACHE_SIZE"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size >= 0 {
+			cfg.DigestCacheSize = size
+		}
+	}
+
+	if pStr := os.Getenv("VAPORTRAIL_STORED_PERCENTILES"); pStr != "" {
+		var percentiles []float64
+		for _, field := range strings.Split(pStr, ",") {
+			p, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				percentiles = nil
+				break
+			}
+			percentiles = append(percentiles, p)
+		}
+		if percentiles != nil {
+			cfg.StoredPercentiles = percentiles
+		}
+	}
+
+	if cacheStr := os.Getenv("VAPORTRAIL_SQLITE_CACHE_SIZE_KIB"); cacheStr != "" {
+		if n, err := strconv.ParseInt(cacheStr, 10, 64); err == nil && n >= 0 {
+			cfg.SQLiteCacheSizeKiB = n
+		}
+	}
+
+	if mmapStr := os.Getenv("VAPORTRAIL_SQLITE_MMAP_SIZE_BYTES"); mmapStr != "" {
+		if n, err := strconv.ParseInt(mmapStr, 10, 64); err == nil && n >= 0 {
+			cfg.SQLiteMmapSizeBytes = n
+		}
+	}
+
+	if connsStr := os.Getenv("VAPORTRAIL_SQLITE_READ_CONNS"); connsStr != "" {
+		if n, err := strconv.Atoi(connsStr); err == nil && n >= 1 {
+			cfg.SQLiteReadConns = n
+		}
+	}
+
+	if lifetimeStr := os.Getenv("VAPORTRAIL_SQLITE_CONN_MAX_LIFETIME"); lifetimeStr != "" {
+		if d, err := time.ParseDuration(lifetimeStr); err == nil && d >= 0 {
+			cfg.SQLiteConnMaxLifetime = d
+		}
+	}
+
+	if checkpointStr := os.Getenv("VAPORTRAIL_SQLITE_CHECKPOINT_INTERVAL"); checkpointStr != "" {
+		if d, err := time.ParseDuration(checkpointStr); err == nil && d >= 0 {
+			cfg.SQLiteCheckpointInterval = d
+		}
+	}
+
+	if truncateStr := os.Getenv("VAPORTRAIL_SQLITE_WAL_TRUNCATE_BYTES"); truncateStr != "" {
+		if n, err := strconv.ParseInt(truncateStr, 10, 64); err == nil && n >= 0 {
+			cfg.SQLiteWALTruncateBytes = n
+		}
+	}
+
+	if tz := os.Getenv("VAPORTRAIL_DISPLAY_TIMEZONE"); tz != "" {
+		cfg.DisplayTimezone = tz
+	}
+
+	if token := os.Getenv("VAPORTRAIL_WRITE_TOKEN"); token != "" {
+		cfg.WriteToken = token
+	}
+
+	if seedStr := os.Getenv("VAPORTRAIL_SEED_SAMPLE"); seedStr != "" {
+		if seed, err := strconv.ParseBool(seedStr); err == nil {
+			cfg.SeedSample = seed
+		}
+	}
+
+	if replicaStr := os.Getenv("VAPORTRAIL_READ_REPLICA"); replicaStr != "" {
+		if replica, err := strconv.ParseBool(replicaStr); err == nil {
+			cfg.ReadReplica = replica
+		}
+	}
+
+	if readOnlyStr := os.Getenv("VAPORTRAIL_READ_ONLY"); readOnlyStr != "" {
+		if readOnly, err := strconv.ParseBool(readOnlyStr); err == nil {
+			cfg.ReadOnly = readOnly
+		}
+	}
+
 	// 3. Override with Flags
 	// We need to be careful with flags in tests to avoid "redefined" panics.
 	var portFlag int
 	var dbFlag string
+	var dataDirFlag string
+	var tlsCertFlag string
+	var tlsKeyFlag string
 
 	fs := flag.CommandLine
 
@@ -54,6 +426,16 @@ func Load() *ServerConfig {
 	if fs.Lookup("db") == nil {
 		fs.StringVar(&dbFlag, "db", "", "SQLite database path (env: VAPORTRAIL_DB_PATH)")
 	}
+	if fs.Lookup("data-dir") == nil {
+		fs.StringVar(&dataDirFlag, "data-dir", "", "Directory for the database and other data files (env: VAPORTRAIL_DATA_DIR)")
+	}
+
+	if fs.Lookup("tls-cert") == nil {
+		fs.StringVar(&tlsCertFlag, "tls-cert", "", "TLS certificate file; enables HTTPS with -tls-key (env: VAPORTRAIL_TLS_CERT_FILE)")
+	}
+	if fs.Lookup("tls-key") == nil {
+		fs.StringVar(&tlsKeyFlag, "tls-key", "", "TLS private key file; enables HTTPS with -tls-cert (env: VAPORTRAIL_TLS_KEY_FILE)")
+	}
 
 	if !flag.Parsed() {
 		flag.Parse()
@@ -93,5 +475,97 @@ func Load() *ServerConfig {
 		}
 	}
 
-	return cfg
+	if d := fs.Lookup("data-dir"); d != nil {
+		isSet := false
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "data-dir" {
+				isSet = true
+			}
+		})
+
+		if isSet {
+			cfg.DataDir = d.Value.String()
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "tls-cert":
+			cfg.TLSCertFile = f.Value.String()
+		case "tls-key":
+			cfg.TLSKeyFile = f.Value.String()
+		}
+	})
+
+	if cfg.DataDir != "" && !filepath.IsAbs(cfg.DBPath) {
+		cfg.DBPath = filepath.Join(cfg.DataDir, cfg.DBPath)
+	}
+
+	if cfg.DisplayTimezone != "" {
+		if _, err := time.LoadLocation(cfg.DisplayTimezone); err != nil {
+			return nil, fmt.Errorf("invalid display_timezone: %w", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// DisplayLocation returns the zone named by DisplayTimezone, or the server's
+// local zone when it's empty or invalid.
+func (c *ServerConfig) DisplayLocation() *time.Location {
+	if c.DisplayTimezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.DisplayTimezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// loadFile overlays the settings in a YAML config file onto cfg. Keys use the
+// snake_case names in ServerConfig's yaml tags, and durations are strings
+// such as "30s".
+func loadFile(cfg *ServerConfig, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// EnsureDataDir creates the data directory and the database's parent
+// directory if they don't exist yet and checks that they are writable, so a
+// bad path fails with a clear message instead of an opaque SQLite error.
+func (c *ServerConfig) EnsureDataDir() error {
+	for _, dir := range []string{c.DataDir, filepath.Dir(c.DBPath)} {
+		if dir == "" || dir == "." {
+			continue
+		}
+		if err := ensureWritableDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ensureWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create data directory %q: %w", dir, err)
+	}
+	f, err := os.CreateTemp(dir, ".vaportrail-write-test-*")
+	if err != nil {
+		return fmt.Errorf("data directory %q is not writable: %w", dir, err)
+	}
+	name := f.Name()
+	f.Close()
+	os.Remove(name)
+	return nil
 }
@@ -2,21 +2,30 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
 	// Save original env vars to restore later
 	origPort := os.Getenv("VAPORTRAIL_HTTP_PORT")
 	origDB := os.Getenv("VAPORTRAIL_DB_PATH")
+	origDataDir := os.Getenv("VAPORTRAIL_DATA_DIR")
+	origReplica := os.Getenv("VAPORTRAIL_READ_REPLICA")
 	defer func() {
+		os.Setenv("VAPORTRAIL_READ_REPLICA", origReplica)
 		os.Setenv("VAPORTRAIL_HTTP_PORT", origPort)
 		os.Setenv("VAPORTRAIL_DB_PATH", origDB)
+		os.Setenv("VAPORTRAIL_DATA_DIR", origDataDir)
 	}()
 
 	t.Run("Defaults", func(t *testing.T) {
 		os.Unsetenv("VAPORTRAIL_HTTP_PORT")
 		os.Unsetenv("VAPORTRAIL_DB_PATH")
+		os.Unsetenv("VAPORTRAIL_DATA_DIR")
 
 		cfg := Load()
 		if cfg.HTTPPort != 8080 {
@@ -25,11 +34,15 @@ func TestLoad(t *testing.T) {
 		if cfg.DBPath != "vaportrail.db" {
 			t.Errorf("Expected default db path 'vaportrail.db', got '%s'", cfg.DBPath)
 		}
+		if cfg.DigestCacheSize != 10000 {
+			t.Errorf("Expected default digest cache size 10000, got %d", cfg.DigestCacheSize)
+		}
 	})
 
 	t.Run("Environment Variables", func(t *testing.T) {
 		os.Setenv("VAPORTRAIL_HTTP_PORT", "9090")
 		os.Setenv("VAPORTRAIL_DB_PATH", "/tmp/test.db")
+		os.Setenv("VAPORTRAIL_READ_REPLICA", "true")
 
 		cfg := Load()
 		if cfg.HTTPPort != 9090 {
@@ -38,6 +51,141 @@ func TestLoad(t *testing.T) {
 		if cfg.DBPath != "/tmp/test.db" {
 			t.Errorf("Expected db path '/tmp/test.db', got '%s'", cfg.DBPath)
 		}
+		if !cfg.ReadReplica {
+			t.Errorf("Expected ReadReplica to be enabled")
+		}
+		os.Unsetenv("VAPORTRAIL_READ_REPLICA")
+
+		if cfg.SeedSample {
+			t.Errorf("Expected SeedSample to default to false")
+		}
+		os.Setenv("VAPORTRAIL_SEED_SAMPLE", "true")
+		if cfg := Load(); !cfg.SeedSample {
+			t.Errorf("Expected SeedSample to be enabled")
+		}
+		os.Unsetenv("VAPORTRAIL_SEED_SAMPLE")
+
+		if cfg.ReadOnly {
+			t.Errorf("Expected ReadOnly to default to false")
+		}
+		os.Setenv("VAPORTRAIL_READ_ONLY", "true")
+		if cfg := Load(); !cfg.ReadOnly {
+			t.Errorf("Expected ReadOnly to be enabled")
+		}
+		os.Unsetenv("VAPORTRAIL_READ_ONLY")
+
+		os.Setenv("VAPORTRAIL_DIGEST_CACHE_SIZE", "0")
+		if cfg := Load(); cfg.DigestCacheSize != 0 {
+			t.Errorf("Expected DigestCacheSize 0, got %d", cfg.DigestCacheSize)
+		}
+		os.Unsetenv("VAPORTRAIL_DIGEST_CACHE_SIZE")
+
+		os.Setenv("VAPORTRAIL_STORED_PERCENTILES", "50, 95,99.9")
+		if cfg := Load(); !slices.Equal(cfg.StoredPercentiles, []float64{50, 95, 99.9}) {
+			t.Errorf("Expected StoredPercentiles [50 95 99.9], got %v", cfg.StoredPercentiles)
+		}
+		os.Setenv("VAPORTRAIL_STORED_PERCENTILES", "50,p95")
+		if cfg := Load(); cfg.StoredPercentiles != nil {
+			t.Errorf("Expected an invalid list to be ignored, got %v", cfg.StoredPercentiles)
+		}
+		os.Unsetenv("VAPORTRAIL_STORED_PERCENTILES")
+
+		os.Setenv("VAPORTRAIL_DISPLAY_TIMEZONE", "Europe/Berlin")
+		if cfg := Load(); cfg.DisplayLocation().String() != "Europe/Berlin" {
+			t.Errorf("Expected display location Europe/Berlin, got %v", cfg.DisplayLocation())
+		}
+		os.Setenv("VAPORTRAIL_DISPLAY_TIMEZONE", "Mars/Olympus_Mons")
+		if _, err := LoadWithError(); err == nil {
+			t.Error("Expected an error for an unknown display timezone")
+		}
+		os.Unsetenv("VAPORTRAIL_DISPLAY_TIMEZONE")
+
+		os.Setenv("VAPORTRAIL_WRITE_TOKEN", "s3cret")
+		if cfg := Load(); cfg.WriteToken != "s3cret" {
+			t.Errorf("Expected WriteToken s3cret, got %q", cfg.WriteToken)
+		}
+		os.Unsetenv("VAPORTRAIL_WRITE_TOKEN")
+
+		os.Setenv("VAPORTRAIL_SQLITE_CACHE_SIZE_KIB", "65536")
+		os.Setenv("VAPORTRAIL_SQLITE_MMAP_SIZE_BYTES", "268435456")
+		if cfg := Load(); cfg.SQLiteCacheSizeKiB != 65536 || cfg.SQLiteMmapSizeBytes != 268435456 {
+			t.Errorf("Expected SQLite cache 65536 KiB and mmap 268435456 bytes, got %d and %d", cfg.SQLiteCacheSizeKiB, cfg.SQLiteMmapSizeBytes)
+		}
+		os.Unsetenv("VAPORTRAIL_SQLITE_CACHE_SIZE_KIB")
+		os.Unsetenv("VAPORTRAIL_SQLITE_MMAP_SIZE_BYTES")
+
+		os.Setenv("VAPORTRAIL_SQLITE_READ_CONNS", "8")
+		os.Setenv("VAPORTRAIL_SQLITE_CONN_MAX_LIFETIME", "30m")
+		if cfg := Load(); cfg.SQLiteReadConns != 8 || cfg.SQLiteConnMaxLifetime != 30*time.Minute {
+			t.Errorf("Expected 8 SQLite read connections and a 30m lifetime, got %d and %v", cfg.SQLiteReadConns, cfg.SQLiteConnMaxLifetime)
+		}
+		os.Setenv("VAPORTRAIL_SQLITE_READ_CONNS", "0")
+		if cfg := Load(); cfg.SQLiteReadConns != 4 {
+			t.Errorf("Expected invalid read connections to keep the default 4, got %d", cfg.SQLiteReadConns)
+		}
+		os.Unsetenv("VAPORTRAIL_SQLITE_READ_CONNS")
+		os.Unsetenv("VAPORTRAIL_SQLITE_CONN_MAX_LIFETIME")
+
+		if cfg := Load(); cfg.SQLiteCheckpointInterval != 5*time.Minute || cfg.SQLiteWALTruncateBytes != 64<<20 {
+			t.Errorf("Expected checkpoints every 5m truncating past 64 MiB by default, got %v and %d", cfg.SQLiteCheckpointInterval, cfg.SQLiteWALTruncateBytes)
+		}
+		os.Setenv("VAPORTRAIL_SQLITE_CHECKPOINT_INTERVAL", "1m")
+		os.Setenv("VAPORTRAIL_SQLITE_WAL_TRUNCATE_BYTES", "1048576")
+		if cfg := Load(); cfg.SQLiteCheckpointInterval != time.Minute || cfg.SQLiteWALTruncateBytes != 1<<20 {
+			t.Errorf("Expected checkpoints every 1m truncating past 1 MiB, got %v and %d", cfg.SQLiteCheckpointInterval, cfg.SQLiteWALTruncateBytes)
+		}
+		os.Unsetenv("VAPORTRAIL_SQLITE_CHECKPOINT_INTERVAL")
+		os.Unsetenv("VAPORTRAIL_SQLITE_WAL_TRUNCATE_BYTES")
+
+		os.Setenv("VAPORTRAIL_ROLLUP_FLUSH_INTERVAL", "15s")
+		if cfg := Load(); cfg.RollupFlushInterval != 15*time.Second {
+			t.Errorf("Expected RollupFlushInterval 15s, got %v", cfg.RollupFlushInterval)
+		}
+		os.Unsetenv("VAPORTRAIL_ROLLUP_FLUSH_INTERVAL")
+
+		os.Setenv("VAPORTRAIL_FAILURE_LOG_INTERVAL", "5m")
+		if cfg := Load(); cfg.FailureLogInterval != 5*time.Minute {
+			t.Errorf("Expected FailureLogInterval 5m, got %v", cfg.FailureLogInterval)
+		}
+		os.Unsetenv("VAPORTRAIL_FAILURE_LOG_INTERVAL")
+
+		os.Setenv("VAPORTRAIL_LATENCY_PRECISION", "1us")
+		if cfg := Load(); cfg.LatencyPrecision != time.Microsecond {
+			t.Errorf("Expected LatencyPrecision 1µs, got %v", cfg.LatencyPrecision)
+		}
+		os.Unsetenv("VAPORTRAIL_LATENCY_PRECISION")
+
+		os.Setenv("VAPORTRAIL_PLAUSIBLE_LATENCY_MIN", "0")
+		os.Setenv("VAPORTRAIL_PLAUSIBLE_LATENCY_MAX", "5m")
+		if cfg := Load(); cfg.PlausibleLatencyMin != 0 || cfg.PlausibleLatencyMax != 5*time.Minute {
+			t.Errorf("Expected a plausible latency range of 0 to 5m, got %v to %v", cfg.PlausibleLatencyMin, cfg.PlausibleLatencyMax)
+		}
+		os.Unsetenv("VAPORTRAIL_PLAUSIBLE_LATENCY_MIN")
+		os.Unsetenv("VAPORTRAIL_PLAUSIBLE_LATENCY_MAX")
+
+		os.Setenv("VAPORTRAIL_AVAILABILITY_SLO", "0.9995")
+		if cfg := Load(); cfg.AvailabilitySLO != 0.9995 {
+			t.Errorf("Expected AvailabilitySLO 0.9995, got %v", cfg.AvailabilitySLO)
+		}
+		os.Setenv("VAPORTRAIL_AVAILABILITY_SLO", "1")
+		if cfg := Load(); cfg.AvailabilitySLO != 0.999 {
+			t.Errorf("Expected an SLO of 1 to be ignored, got %v", cfg.AvailabilitySLO)
+		}
+		os.Unsetenv("VAPORTRAIL_AVAILABILITY_SLO")
+
+		os.Setenv("VAPORTRAIL_STALE_AFTER_INTERVALS", "2.5")
+		if cfg := Load(); cfg.StaleAfterIntervals != 2.5 {
+			t.Errorf("Expected StaleAfterIntervals 2.5, got %v", cfg.StaleAfterIntervals)
+		}
+		os.Unsetenv("VAPORTRAIL_STALE_AFTER_INTERVALS")
+
+		os.Setenv("VAPORTRAIL_RESULT_BUFFER_SIZE", "5000")
+		os.Setenv("VAPORTRAIL_RESULT_OVERFLOW", "drop_oldest")
+		if cfg := Load(); cfg.ResultBufferSize != 5000 || cfg.ResultOverflow != "drop_oldest" {
+			t.Errorf("Expected result buffer 5000 with drop_oldest, got %d with %q", cfg.ResultBufferSize, cfg.ResultOverflow)
+		}
+		os.Unsetenv("VAPORTRAIL_RESULT_BUFFER_SIZE")
+		os.Unsetenv("VAPORTRAIL_RESULT_OVERFLOW")
 	})
 
 	t.Run("Invalid Port", func(t *testing.T) {
@@ -49,4 +197,156 @@ func TestLoad(t *testing.T) {
 			t.Errorf("Expected default port 8080 when invalid, got %d", cfg.HTTPPort)
 		}
 	})
+
+	t.Run("Data Directory", func(t *testing.T) {
+		os.Unsetenv("VAPORTRAIL_DB_PATH")
+		os.Setenv("VAPORTRAIL_DATA_DIR", "/data")
+
+		cfg := Load()
+		if cfg.DBPath != filepath.Join("/data", "vaportrail.db") {
+			t.Errorf("Expected db path under data dir, got '%s'", cfg.DBPath)
+		}
+
+		os.Setenv("VAPORTRAIL_DB_PATH", "/tmp/test.db")
+		cfg = Load()
+		if cfg.DBPath != "/tmp/test.db" {
+			t.Errorf("Expected absolute db path to be kept, got '%s'", cfg.DBPath)
+		}
+		os.Unsetenv("VAPORTRAIL_DATA_DIR")
+	})
+
+	t.Run("HTTP Timeouts", func(t *testing.T) {
+		cfg := Load()
+		if cfg.ReadHeaderTimeout != 10*time.Second || cfg.WriteTimeout != 60*time.Second || cfg.MaxHeaderBytes != 1<<20 {
+			t.Errorf("Unexpected HTTP defaults: %+v", cfg)
+		}
+
+		os.Setenv("VAPORTRAIL_HTTP_WRITE_TIMEOUT", "5m")
+		os.Setenv("VAPORTRAIL_HTTP_IDLE_TIMEOUT", "bogus")
+		os.Setenv("VAPORTRAIL_HTTP_MAX_HEADER_BYTES", "4096")
+		defer os.Unsetenv("VAPORTRAIL_HTTP_WRITE_TIMEOUT")
+		defer os.Unsetenv("VAPORTRAIL_HTTP_IDLE_TIMEOUT")
+		defer os.Unsetenv("VAPORTRAIL_HTTP_MAX_HEADER_BYTES")
+
+		cfg = Load()
+		if cfg.WriteTimeout != 5*time.Minute {
+			t.Errorf("Expected write timeout 5m, got %v", cfg.WriteTimeout)
+		}
+		if cfg.IdleTimeout != 120*time.Second {
+			t.Errorf("Expected invalid idle timeout to keep default, got %v", cfg.IdleTimeout)
+		}
+		if cfg.MaxHeaderBytes != 4096 {
+			t.Errorf("Expected max header bytes 4096, got %d", cfg.MaxHeaderBytes)
+		}
+	})
+}
+
+func TestEnsureDataDir(t *testing.T) {
+	root := t.TempDir()
+
+	t.Run("Creates Missing Directory", func(t *testing.T) {
+		dir := filepath.Join(root, "nested", "data")
+		cfg := &ServerConfig{DataDir: dir, DBPath: filepath.Join(dir, "vaportrail.db")}
+		if err := cfg.EnsureDataDir(); err != nil {
+			t.Fatalf("EnsureDataDir failed: %v", err)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			t.Errorf("Expected %s to be created", dir)
+		}
+	})
+
+	t.Run("Path Is A File", func(t *testing.T) {
+		file := filepath.Join(root, "file")
+		if err := os.WriteFile(file, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg := &ServerConfig{DBPath: filepath.Join(file, "vaportrail.db")}
+		if err := cfg.EnsureDataDir(); err == nil {
+			t.Error("Expected error when data directory is a file")
+		}
+	})
+
+	t.Run("Working Directory", func(t *testing.T) {
+		cfg := &ServerConfig{DBPath: "vaportrail.db"}
+		if err := cfg.EnsureDataDir(); err != nil {
+			t.Errorf("Expected no error for relative db path, got %v", err)
+		}
+	})
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	for _, env := range []string{"VAPORTRAIL_CONFIG", "VAPORTRAIL_HTTP_PORT", "VAPORTRAIL_DB_PATH", "VAPORTRAIL_DATA_DIR"} {
+		orig, ok := os.LookupEnv(env)
+		os.Unsetenv(env)
+		defer func() {
+			if ok {
+				os.Setenv(env, orig)
+			}
+		}()
+	}
+
+	writeConfig := func(contents string) string {
+		path := filepath.Join(t.TempDir(), "vaportrail.yaml")
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return path
+	}
+
+	t.Run("File Values", func(t *testing.T) {
+		os.Setenv("VAPORTRAIL_CONFIG", writeConfig("http_port: 9000\ndb_path: /tmp/file.db\nwrite_timeout: 2m\ntls_cert_file: /etc/cert.pem\n"))
+		defer os.Unsetenv("VAPORTRAIL_CONFIG")
+
+		cfg, err := LoadWithError()
+		if err != nil {
+			t.Fatalf("LoadWithError failed: %v", err)
+		}
+		if cfg.HTTPPort != 9000 || cfg.DBPath != "/tmp/file.db" || cfg.WriteTimeout != 2*time.Minute || cfg.TLSCertFile != "/etc/cert.pem" {
+			t.Errorf("Expected file values to be applied, got %+v", cfg)
+		}
+		if cfg.ReadTimeout != 30*time.Second {
+			t.Errorf("Expected unset keys to keep defaults, got read timeout %v", cfg.ReadTimeout)
+		}
+
+		os.Setenv("VAPORTRAIL_HTTP_PORT", "9100")
+		defer os.Unsetenv("VAPORTRAIL_HTTP_PORT")
+		cfg, err = LoadWithError()
+		if err != nil {
+			t.Fatalf("LoadWithError failed: %v", err)
+		}
+		if cfg.HTTPPort != 9100 {
+			t.Errorf("Expected env var to override file, got port %d", cfg.HTTPPort)
+		}
+	})
+
+	t.Run("Unknown Key", func(t *testing.T) {
+		os.Setenv("VAPORTRAIL_CONFIG", writeConfig("http_prot: 9000\n"))
+		defer os.Unsetenv("VAPORTRAIL_CONFIG")
+
+		if _, err := LoadWithError(); err == nil || !strings.Contains(err.Error(), "http_prot") {
+			t.Errorf("Expected an error naming the unknown key, got %v", err)
+		}
+	})
+
+	t.Run("Missing File", func(t *testing.T) {
+		os.Setenv("VAPORTRAIL_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+		defer os.Unsetenv("VAPORTRAIL_CONFIG")
+
+		if _, err := LoadWithError(); err == nil {
+			t.Error("Expected an error for a missing config file")
+		}
+	})
+
+	t.Run("Empty File", func(t *testing.T) {
+		os.Setenv("VAPORTRAIL_CONFIG", writeConfig(""))
+		defer os.Unsetenv("VAPORTRAIL_CONFIG")
+
+		cfg, err := LoadWithError()
+		if err != nil {
+			t.Fatalf("Expected an empty file to be accepted, got %v", err)
+		}
+		if cfg.HTTPPort != 8080 {
+			t.Errorf("Expected default port, got %d", cfg.HTTPPort)
+		}
+	})
 }
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Baseline is a target's expected latency, which results are compared
+// against to spot regressions. It is either set by hand, or learned from the
+// target's own recent results and refreshed as they change.
+type Baseline struct {
+	TargetID  int64
+	LatencyNS float64
+	// Manual is set on a baseline set by hand, which is never relearned.
+	Manual    bool
+	UpdatedAt time.Time
+}
+
+// GetBaseline returns a target's baseline, or nil if it has none.
+func (d *DB) GetBaseline(targetID int64) (*Baseline, error) {
+	b := Baseline{TargetID: targetID}
+	err := d.QueryRow(`SELECT latency_ns, manual, updated_at FROM target_baselines WHERE target_id = ?`, targetID).
+		Scan(&b.LatencyNS, &b.Manual, &b.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// SetBaseline stores a target's baseline, replacing any it had, except that a
+// learned baseline never replaces a manual one.
+func (d *DB) SetBaseline(b *Baseline) error {
+	_, err := d.Exec(`INSERT INTO target_baselines (target_id, latency_ns, manual, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(target_id) DO UPDATE SET latency_ns = excluded.latency_ns, manual = excluded.manual, updated_at = excluded.updated_at
+		WHERE excluded.manual OR NOT target_baselines.manual`,
+		b.TargetID, b.LatencyNS, b.Manual, b.UpdatedAt.UTC())
+	return err
+}
+
+// DeleteBaseline removes a target's baseline, if it has one.
+func (d *DB) DeleteBaseline(targetID int64) error {
+	_, err := d.Exec(`DELETE FROM target_baselines WHERE target_id = ?`, targetID)
+	return err
+}
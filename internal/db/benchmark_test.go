@@ -33,6 +33,16 @@ func setupBenchmarkDB(b *testing.B) (*DB, func()) {
 	}
 }
 
+// benchmarkDBPath returns the file backing the main database of d.
+func benchmarkDBPath(b *testing.B, d *DB) string {
+	var seq int
+	var name, file string
+	if err := d.QueryRow("PRAGMA database_list").Scan(&seq, &name, &file); err != nil {
+		b.Fatalf("Failed to look up db path: %v", err)
+	}
+	return file
+}
+
 func populateBenchmarkData(b *testing.B, d *DB, numTargets, rowsPerTarget int) []int64 {
 	var targetIDs []int64
 	now := time.Now().UTC()
@@ -96,6 +106,155 @@ func BenchmarkGetRawResults(b *testing.B) {
 	}
 }
 
+// BenchmarkGetRawResultsAfter pages through a target's whole raw history,
+// 1000 results at a time, by ID cursor and, for comparison, by OFFSET.
+func BenchmarkGetRawResultsAfter(b *testing.B) {
+	d, cleanup := setupBenchmarkDB(b)
+	defer cleanup()
+
+	numTargets := 5
+	rowsPerTarget := 10000
+	targetIDs := populateBenchmarkData(b, d, numTargets, rowsPerTarget)
+	end := time.Now().UTC().Add(time.Minute)
+	start := end.Add(-time.Duration(rowsPerTarget+1) * time.Minute)
+	const pageSize = 1000
+
+	b.Run("cursor", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tid := targetIDs[i%numTargets]
+			var after int64
+			for {
+				page, err := d.GetRawResultsAfter(tid, after, start, end, pageSize)
+				if err != nil {
+					b.Fatalf("GetRawResultsAfter failed: %v", err)
+				}
+				if len(page) == 0 {
+					break
+				}
+				after = page[len(page)-1].ID
+			}
+		}
+	})
+	b.Run("offset", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tid := targetIDs[i%numTargets]
+			for offset := 0; ; offset += pageSize {
+				page, err := d.queryRawResults(`SELECT id, time, target_id, latency, COALESCE(metadata, '') FROM raw_results
+					WHERE target_id = ? AND time >= ? AND time < ? ORDER BY id ASC LIMIT ? OFFSET ?`, tid, start, end, pageSize, offset)
+				if err != nil {
+					b.Fatalf("Query failed: %v", err)
+				}
+				if len(page) == 0 {
+					break
+				}
+			}
+		}
+	})
+}
+
+// BenchmarkGetRawResults_PageCache repeats BenchmarkGetRawResults over a
+// larger history, with SQLite's default page cache and with the sizes
+// suggested for config.ServerConfig's SQLiteCacheSizeKiB and
+// SQLiteMmapSizeBytes. Queries spread over the whole history, so the default
+// cache can't hold their pages.
+func BenchmarkGetRawResults_PageCache(b *testing.B) {
+	numTargets := 5
+	rowsPerTarget := 200000
+	for _, bc := range []struct {
+		name          string
+		cacheKiB      int64
+		mmapSizeBytes int64
+	}{
+		{"Default", 0, 0},
+		{"Cache64MiB", 64 << 10, 0},
+		{"Cache64MiB_Mmap256MiB", 64 << 10, 256 << 20},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			if err := SetPageCache(bc.cacheKiB, bc.mmapSizeBytes); err != nil {
+				b.Fatal(err)
+			}
+			defer SetPageCache(0, 0)
+			d, cleanup := setupBenchmarkDB(b)
+			defer cleanup()
+			targetIDs := populateBenchmarkData(b, d, numTargets, rowsPerTarget)
+			now := time.Now().UTC()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tid := targetIDs[i%numTargets]
+				end := now.Add(-time.Duration((i*7919)%rowsPerTarget) * time.Minute)
+				if _, err := d.GetRawResults(tid, end.Add(-6*time.Hour), end, 0); err != nil {
+					b.Fatalf("GetRawResults failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGetRawResults_UnderWriteLoad compares reads through the primary
+// connection pool with reads through a separate read-only pool while a
+// background writer keeps inserting raw results.
+func BenchmarkGetRawResults_UnderWriteLoad(b *testing.B) {
+	for _, readOnly := range []bool{false, true} {
+		name := "Primary"
+		if readOnly {
+			name = "ReadOnly"
+		}
+		b.Run(name, func(b *testing.B) {
+			d, cleanup := setupBenchmarkDB(b)
+			defer cleanup()
+
+			numTargets := 5
+			rowsPerTarget := 10000
+			targetIDs := populateBenchmarkData(b, d, numTargets, rowsPerTarget)
+
+			reader := d
+			if readOnly {
+				ro, err := OpenReadOnly(benchmarkDBPath(b, d))
+				if err != nil {
+					b.Fatalf("OpenReadOnly failed: %v", err)
+				}
+				defer ro.Close()
+				reader = ro
+			}
+
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					batch := make([]RawResult, 100)
+					for i := range batch {
+						batch[i] = RawResult{Time: time.Now().UTC(), TargetID: targetIDs[i%numTargets], Latency: 100}
+					}
+					d.AddRawResults(batch)
+				}
+			}()
+			defer func() {
+				close(stop)
+				<-done
+			}()
+
+			now := time.Now().UTC()
+			end := now.Add(-time.Duration(rowsPerTarget/2) * time.Minute)
+			start := end.Add(-60 * time.Minute)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tid := targetIDs[i%numTargets]
+				if _, err := reader.GetRawResults(tid, start, end, 100); err != nil {
+					b.Fatalf("GetRawResults failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkGetEarliestRawResultTime(b *testing.B) {
 	d, cleanup := setupBenchmarkDB(b)
 	defer cleanup()
@@ -166,3 +325,58 @@ func BenchmarkGetEarliestRawResultTime_Sparse(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkGetLatestResults compares fetching every target's newest rollup in
+// one GetLatestResults query with a lookup per target, as target count grows.
+// Each target has a day of 1m rollups and a week of 1h rollups.
+func BenchmarkGetLatestResults(b *testing.B) {
+	for _, numTargets := range []int{10, 100, 1000} {
+		d, cleanup := setupBenchmarkDB(b)
+		now := time.Now().UTC().Truncate(time.Hour)
+		var targetIDs []int64
+		for i := 0; i < numTargets; i++ {
+			id, err := d.AddTarget(&Target{Name: fmt.Sprintf("Target-%d", i), Address: "http://localhost", ProbeType: "http"})
+			if err != nil {
+				b.Fatalf("Failed to add target: %v", err)
+			}
+			targetIDs = append(targetIDs, id)
+			var batch []*AggregatedResult
+			for j := 0; j < 24*60; j++ {
+				batch = append(batch, &AggregatedResult{Time: now.Add(-time.Duration(j) * time.Minute), TargetID: id, WindowSeconds: 60, TDigestData: []byte{0}})
+			}
+			for j := 0; j < 7*24; j++ {
+				batch = append(batch, &AggregatedResult{Time: now.Add(-time.Duration(j) * time.Hour), TargetID: id, WindowSeconds: 3600, TDigestData: []byte{0}})
+			}
+			if err := d.AddAggregatedResults(batch); err != nil {
+				b.Fatalf("Failed to add aggregated results: %v", err)
+			}
+		}
+
+		b.Run(fmt.Sprintf("SingleQuery/targets=%d", numTargets), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				results, err := d.GetLatestResults()
+				if err != nil || len(results) != numTargets {
+					b.Fatalf("GetLatestResults returned %d results: %v", len(results), err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("PerTarget/targets=%d", numTargets), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, id := range targetIDs {
+					windows, err := d.GetAggregatedWindows(id)
+					if err != nil || len(windows) == 0 {
+						b.Fatalf("GetAggregatedWindows failed: %v", err)
+					}
+					_, last, err := d.GetResultTimeRange(id, windows[0])
+					if err != nil {
+						b.Fatalf("GetResultTimeRange failed: %v", err)
+					}
+					if _, err := d.GetAggregatedResults(id, windows[0], last, last.Add(time.Second)); err != nil {
+						b.Fatalf("GetAggregatedResults failed: %v", err)
+					}
+				}
+			}
+		})
+		cleanup()
+	}
+}
@@ -0,0 +1,2 @@
+ALTER TABLE targets DROP COLUMN max_latency_action;
+ALTER TABLE targets DROP COLUMN max_latency_ns;
@@ -0,0 +1,2 @@
+ALTER TABLE targets ADD COLUMN max_latency_ns REAL NOT NULL DEFAULT 0;
+ALTER TABLE targets ADD COLUMN max_latency_action TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE aggregated_results DROP COLUMN sum_sq_ns;
+ALTER TABLE aggregated_results DROP COLUMN sum_ns;
+ALTER TABLE aggregated_results DROP COLUMN sample_count;
@@ -0,0 +1,3 @@
+ALTER TABLE aggregated_results ADD COLUMN sample_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE aggregated_results ADD COLUMN sum_ns REAL NOT NULL DEFAULT 0;
+ALTER TABLE aggregated_results ADD COLUMN sum_sq_ns REAL NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE targets ADD COLUMN warmup_probes INTEGER NOT NULL DEFAULT 0;
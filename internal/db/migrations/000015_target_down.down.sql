@@ -0,0 +1,2 @@
+ALTER TABLE targets DROP COLUMN down;
+ALTER TABLE targets DROP COLUMN down_after;
@@ -0,0 +1,2 @@
+ALTER TABLE targets ADD COLUMN down_after INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE targets ADD COLUMN down INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE targets ADD COLUMN retry_count INTEGER NOT NULL DEFAULT 0;
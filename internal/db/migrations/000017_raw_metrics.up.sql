@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS raw_metrics (
+    time DATETIME NOT NULL,
+    target_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    value REAL NOT NULL,
+    FOREIGN KEY(target_id) REFERENCES targets(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_raw_metrics_target_name_time ON raw_metrics(target_id, name, time);
@@ -0,0 +1,2 @@
+ALTER TABLE targets DROP COLUMN record_metadata;
+ALTER TABLE raw_results DROP COLUMN metadata;
@@ -0,0 +1,2 @@
+ALTER TABLE raw_results ADD COLUMN metadata TEXT;
+ALTER TABLE targets ADD COLUMN record_metadata INTEGER NOT NULL DEFAULT 0;
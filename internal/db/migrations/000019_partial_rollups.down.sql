@@ -0,0 +1,2 @@
+DELETE FROM aggregated_results WHERE partial = 1;
+ALTER TABLE aggregated_results DROP COLUMN partial;
@@ -0,0 +1 @@
+ALTER TABLE aggregated_results ADD COLUMN partial INTEGER NOT NULL DEFAULT 0;
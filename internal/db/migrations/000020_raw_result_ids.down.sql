@@ -0,0 +1,45 @@
+DROP TRIGGER IF EXISTS targets_delete_cleanup;
+
+CREATE TABLE raw_results_old (
+    time DATETIME NOT NULL,
+    target_id INTEGER NOT NULL,
+    latency REAL,
+    metadata TEXT,
+    FOREIGN KEY(target_id) REFERENCES targets(id)
+);
+
+INSERT INTO raw_results_old (time, target_id, latency, metadata)
+SELECT time, target_id, latency, metadata FROM raw_results ORDER BY id;
+
+DROP TABLE raw_results;
+ALTER TABLE raw_results_old RENAME TO raw_results;
+
+CREATE INDEX IF NOT EXISTS idx_raw_results_target_time ON raw_results(target_id, time);
+
+CREATE TRIGGER raw_results_insert_stats
+AFTER INSERT ON raw_results
+BEGIN
+    INSERT INTO data_stats (stat_key, row_count, total_bytes)
+    VALUES ('raw_count', 1, 50)
+    ON CONFLICT(stat_key) DO UPDATE SET
+        row_count = row_count + 1,
+        total_bytes = total_bytes + 50;
+END;
+
+CREATE TRIGGER raw_results_delete_stats
+AFTER DELETE ON raw_results
+BEGIN
+    UPDATE data_stats SET
+        row_count = row_count - 1,
+        total_bytes = total_bytes - 50
+    WHERE stat_key = 'raw_count';
+END;
+
+CREATE TRIGGER targets_delete_cleanup
+BEFORE DELETE ON targets
+BEGIN
+    DELETE FROM results WHERE target_id = OLD.id;
+    DELETE FROM raw_results WHERE target_id = OLD.id;
+    DELETE FROM aggregated_results WHERE target_id = OLD.id;
+    DELETE FROM dashboard_graph_targets WHERE target_id = OLD.id;
+END;
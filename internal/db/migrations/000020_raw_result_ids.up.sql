@@ -0,0 +1,54 @@
+-- Give raw results an explicit id, so results with the same timestamp can be
+-- told apart and the raw API can page by cursor. The implicit rowid isn't
+-- enough: VACUUM may renumber it. AUTOINCREMENT keeps ids from being reused
+-- after the newest results are deleted, so a cursor never skips a result.
+
+-- Dropped while raw_results is rebuilt, since it refers to the table.
+DROP TRIGGER IF EXISTS targets_delete_cleanup;
+
+CREATE TABLE raw_results_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    time DATETIME NOT NULL,
+    target_id INTEGER NOT NULL,
+    latency REAL,
+    metadata TEXT,
+    FOREIGN KEY(target_id) REFERENCES targets(id)
+);
+
+-- Existing results are numbered in time order.
+INSERT INTO raw_results_new (time, target_id, latency, metadata)
+SELECT time, target_id, latency, metadata FROM raw_results ORDER BY time, rowid;
+
+DROP TABLE raw_results;
+ALTER TABLE raw_results_new RENAME TO raw_results;
+
+CREATE INDEX IF NOT EXISTS idx_raw_results_target_time ON raw_results(target_id, time);
+
+-- Recreate the triggers dropped with the old table
+CREATE TRIGGER raw_results_insert_stats
+AFTER INSERT ON raw_results
+BEGIN
+    INSERT INTO data_stats (stat_key, row_count, total_bytes)
+    VALUES ('raw_count', 1, 50)
+    ON CONFLICT(stat_key) DO UPDATE SET
+        row_count = row_count + 1,
+        total_bytes = total_bytes + 50;
+END;
+
+CREATE TRIGGER raw_results_delete_stats
+AFTER DELETE ON raw_results
+BEGIN
+    UPDATE data_stats SET
+        row_count = row_count - 1,
+        total_bytes = total_bytes - 50
+    WHERE stat_key = 'raw_count';
+END;
+
+CREATE TRIGGER targets_delete_cleanup
+BEFORE DELETE ON targets
+BEGIN
+    DELETE FROM results WHERE target_id = OLD.id;
+    DELETE FROM raw_results WHERE target_id = OLD.id;
+    DELETE FROM aggregated_results WHERE target_id = OLD.id;
+    DELETE FROM dashboard_graph_targets WHERE target_id = OLD.id;
+END;
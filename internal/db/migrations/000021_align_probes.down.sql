@@ -0,0 +1 @@
+ALTER TABLE targets DROP COLUMN align_probes;
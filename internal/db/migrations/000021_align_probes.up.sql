@@ -0,0 +1 @@
+ALTER TABLE targets ADD COLUMN align_probes INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE targets ADD COLUMN up_after INTEGER NOT NULL DEFAULT 0;
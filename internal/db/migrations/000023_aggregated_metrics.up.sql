@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS aggregated_metrics (
+    time DATETIME NOT NULL,
+    target_id INTEGER NOT NULL,
+    window_seconds INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    tdigest_data BLOB,
+    sample_count INTEGER NOT NULL DEFAULT 0,
+    sum REAL NOT NULL DEFAULT 0,
+    PRIMARY KEY (target_id, window_seconds, name, time),
+    FOREIGN KEY(target_id) REFERENCES targets(id) ON DELETE CASCADE
+);
@@ -0,0 +1 @@
+ALTER TABLE aggregated_results ADD COLUMN maintenance INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE aggregated_results DROP COLUMN expected_source_windows;
+ALTER TABLE aggregated_results DROP COLUMN source_windows;
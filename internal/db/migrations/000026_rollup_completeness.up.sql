@@ -0,0 +1,2 @@
+ALTER TABLE aggregated_results ADD COLUMN source_windows INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE aggregated_results ADD COLUMN expected_source_windows INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+ALTER TABLE aggregated_results DROP COLUMN max_ns;
+ALTER TABLE aggregated_results DROP COLUMN min_ns;
+ALTER TABLE targets DROP COLUMN aggregation;
@@ -0,0 +1,3 @@
+ALTER TABLE targets ADD COLUMN aggregation TEXT NOT NULL DEFAULT '';
+ALTER TABLE aggregated_results ADD COLUMN min_ns REAL NOT NULL DEFAULT 0;
+ALTER TABLE aggregated_results ADD COLUMN max_ns REAL NOT NULL DEFAULT 0;
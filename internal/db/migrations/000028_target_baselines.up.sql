@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS target_baselines (
+    target_id INTEGER PRIMARY KEY,
+    latency_ns REAL NOT NULL,
+    manual INTEGER NOT NULL DEFAULT 0,
+    updated_at DATETIME NOT NULL,
+    FOREIGN KEY(target_id) REFERENCES targets(id) ON DELETE CASCADE
+);
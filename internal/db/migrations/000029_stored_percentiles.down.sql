@@ -0,0 +1 @@
+ALTER TABLE aggregated_results DROP COLUMN percentiles;
@@ -0,0 +1 @@
+ALTER TABLE aggregated_results ADD COLUMN percentiles TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE targets ADD COLUMN schedule TEXT NOT NULL DEFAULT '';
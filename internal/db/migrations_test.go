@@ -4,7 +4,11 @@ import (
 	"database/sql"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -49,3 +53,81 @@ func TestMigrations(t *testing.T) {
 		}
 	}
 }
+
+func TestMigrations_RawResultIDs(t *testing.T) {
+	conn, err := sql.Open(driverName, sqliteDSN("file:raw_result_ids?mode=memory&cache=shared"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	driver, err := sqlite3.WithInstance(conn, &sqlite3.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := iofs.New(fs, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "sqlite3", driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(19); err != nil {
+		t.Fatalf("Failed to migrate to version 19: %v", err)
+	}
+
+	// Results stored out of time order, two of them at the same time.
+	if _, err := conn.Exec(`INSERT INTO targets (id, name, address, probe_type, probe_config) VALUES (1, 'a', 'example.com', 'http', '')`); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []struct {
+		time    string
+		latency float64
+	}{
+		{"2024-01-01 00:00:02+00:00", 3},
+		{"2024-01-01 00:00:00+00:00", 1},
+		{"2024-01-01 00:00:02+00:00", 4},
+		{"2024-01-01 00:00:01+00:00", 2},
+	} {
+		if _, err := conn.Exec(`INSERT INTO raw_results (time, target_id, latency) VALUES (?, 1, ?)`, row.time, row.latency); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+	d := &DB{conn}
+	results, err := d.GetRawResultsAfter(1, 0, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), 0)
+	if err != nil {
+		t.Fatalf("GetRawResultsAfter failed: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(results))
+	}
+	for i, r := range results {
+		if r.ID != int64(i+1) || r.Latency != float64(i+1) {
+			t.Errorf("Expected result %d to have ID %d and latency %d, got %+v", i, i+1, i+1, r)
+		}
+	}
+
+	// The triggers dropped with the old table are back.
+	stats, err := d.GetRawStats()
+	if err != nil || stats.Count != 4 {
+		t.Errorf("Expected raw stats count 4, got %+v (%v)", stats, err)
+	}
+	if err := d.AddRawResults([]RawResult{{Time: time.Date(2024, 1, 1, 0, 0, 3, 0, time.UTC), TargetID: 1}}); err != nil {
+		t.Fatalf("AddRawResults failed: %v", err)
+	}
+	if stats, _ := d.GetRawStats(); stats.Count != 5 {
+		t.Errorf("Expected raw stats count 5 after an insert, got %d", stats.Count)
+	}
+	if _, err := conn.Exec(`DELETE FROM targets WHERE id = 1`); err != nil {
+		t.Fatalf("Failed to delete target: %v", err)
+	}
+	var remaining int
+	conn.QueryRow(`SELECT COUNT(*) FROM raw_results`).Scan(&remaining)
+	if remaining != 0 {
+		t.Errorf("Expected deleting the target to delete its raw results, %d left", remaining)
+	}
+}
@@ -94,3 +94,64 @@ func TestDeleteAggregatedResultsByWindow(t *testing.T) {
 		t.Errorf("Expected 1 result for window 300 (should be unaffected), got %d", len(results300After))
 	}
 }
+
+func TestDeleteResultsKeepingLast(t *testing.T) {
+	db, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer db.Close()
+
+	targetID, err := db.AddTarget(&Target{Name: "TestTarget", Address: "http://example.com", ProbeType: "http"})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	baseTime := time.Now().UTC().Truncate(time.Second)
+	var raws []RawResult
+	for i := 0; i < 10; i++ {
+		raws = append(raws, RawResult{Time: baseTime.Add(-time.Duration(i) * time.Minute), TargetID: targetID, Latency: float64(i)})
+		for _, w := range []int{60, 300} {
+			db.AddAggregatedResult(&AggregatedResult{
+				Time:          baseTime.Add(-time.Duration(i*w) * time.Second),
+				TargetID:      targetID,
+				WindowSeconds: w,
+			})
+		}
+	}
+	if err := db.AddRawResults(raws); err != nil {
+		t.Fatalf("Failed to add raw results: %v", err)
+	}
+
+	if err := db.DeleteRawResultsKeepingLast(targetID, 3); err != nil {
+		t.Fatalf("DeleteRawResultsKeepingLast failed: %v", err)
+	}
+	left, _ := db.GetRawResults(targetID, baseTime.Add(-time.Hour), baseTime.Add(time.Second), -1)
+	if len(left) != 3 {
+		t.Fatalf("Expected 3 raw results, got %d", len(left))
+	}
+	if !left[0].Time.Equal(baseTime.Add(-2 * time.Minute)) {
+		t.Errorf("Expected oldest kept raw result at %v, got %v", baseTime.Add(-2*time.Minute), left[0].Time)
+	}
+
+	// Asking to keep more rows than exist is a no-op.
+	if err := db.DeleteRawResultsKeepingLast(targetID, 100); err != nil {
+		t.Fatalf("DeleteRawResultsKeepingLast failed: %v", err)
+	}
+	left, _ = db.GetRawResults(targetID, baseTime.Add(-time.Hour), baseTime.Add(time.Second), -1)
+	if len(left) != 3 {
+		t.Errorf("Expected 3 raw results after no-op trim, got %d", len(left))
+	}
+
+	if err := db.DeleteAggregatedResultsKeepingLast(targetID, 60, 4); err != nil {
+		t.Fatalf("DeleteAggregatedResultsKeepingLast failed: %v", err)
+	}
+	w60, _ := db.GetAggregatedResults(targetID, 60, baseTime.Add(-24*time.Hour), baseTime.Add(time.Second))
+	w300, _ := db.GetAggregatedResults(targetID, 300, baseTime.Add(-24*time.Hour), baseTime.Add(time.Second))
+	if len(w60) != 4 {
+		t.Errorf("Expected 4 results for window 60, got %d", len(w60))
+	}
+	if len(w300) != 10 {
+		t.Errorf("Expected window 300 to be untouched, got %d", len(w300))
+	}
+}
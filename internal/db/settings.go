@@ -0,0 +1,35 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Setting keys.
+const (
+	// SettingRetentionPolicies is the JSON retention policies inherited by
+	// targets without their own.
+	SettingRetentionPolicies = "retention_policies"
+)
+
+// GetSetting returns the value of an instance-wide setting, or "" if it
+// isn't set.
+func (d *DB) GetSetting(key string) (string, error) {
+	var value string
+	err := d.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return value, err
+}
+
+// SetSetting stores an instance-wide setting. An empty value unsets it.
+func (d *DB) SetSetting(key, value string) error {
+	if value == "" {
+		_, err := d.Exec(`DELETE FROM settings WHERE key = ?`, key)
+		return err
+	}
+	_, err := d.Exec(`INSERT INTO settings (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`, key, value)
+	return err
+}
@@ -7,7 +7,9 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,7 +19,6 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/sqlite3"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 //go:embed migrations/*.sql
@@ -29,22 +30,28 @@ type Store interface {
 	GetTargets() ([]Target, error)
 	GetTarget(id int64) (*Target, error)
 	DeleteTarget(id int64) error
+	SetTargetDown(id int64, down bool) error
 	AddResult(r *Result) error
 	GetResults(targetID int64, limit int) ([]Result, error)
-	GetResultsByTime(targetID int64, start, end time.Time) ([]Result, error)
+	GetResultsByTime(targetID int64, start, end time.Time, limit int, order string) ([]Result, error)
 	Close() error
 
 	// New methods
 	AddRawResults(results []RawResult) error
 	AddAggregatedResult(r *AggregatedResult) error
 	AddAggregatedResults(results []*AggregatedResult) error
-	GetLastRollupTime(targetID int64, windowSeconds int) (time.Time, error)
+	GetLastRollupTime(targetID int64, windowSeconds int, before time.Time) (time.Time, error)
 	GetRawResults(targetID int64, start, end time.Time, limit int) ([]RawResult, error)
 	GetAggregatedResults(targetID int64, windowSeconds int, start, end time.Time) ([]AggregatedResult, error)
 	DeleteRawResultsBefore(targetID int64, cutoff time.Time) error
+	DeleteResultsBefore(targetID int64, cutoff time.Time) error
 	DeleteAggregatedResultsBefore(targetID int64, windowSeconds int, cutoff time.Time) error
 	DeleteAggregatedResultsByWindow(targetID int64, windowSeconds int) error
+	DeleteRawResultsKeepingLast(targetID int64, n int) error
+	DeleteAggregatedResultsKeepingLast(targetID int64, windowSeconds int, n int) error
 	GetEarliestRawResultTime(targetID int64) (time.Time, error)
+	GetRawMetricValues(targetID int64, start, end time.Time) (map[string][]float64, error)
+	GetAggregatedMetrics(targetID int64, windowSeconds int, name string, start, end time.Time) ([]AggregatedMetric, error)
 
 	// Status Page Stats
 	GetDBSizeBytes() (int64, error)
@@ -55,6 +62,10 @@ type Store interface {
 	GetRawStats() (*RawStats, error)
 	DeleteOrphanedData() (*OrphanedDataCleanupReport, error)
 
+	// Write-ahead log
+	Checkpoint(truncate bool) (WALCheckpoint, error)
+	GetWALSizeBytes() (int64, error)
+
 	// Dashboard methods
 	AddDashboard(d *Dashboard) (int64, error)
 	UpdateDashboard(d *Dashboard) error
@@ -116,14 +127,22 @@ type DB struct {
 }
 
 func New(path string) (*DB, error) {
-	db, err := sql.Open("sqlite3", sqliteDSN(path))
+	db, err := sql.Open(driverName, sqliteDSN(path))
 	if err != nil {
 		return nil, err
 	}
+	limitWriterPool(db, path)
 	if err := db.Ping(); err != nil {
 		return nil, err
 	}
 
+	// WAL lets a separate read-only connection (see OpenReadOnly) read
+	// recent writes without blocking the writer. In-memory databases
+	// silently keep their own journal mode.
+	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		return nil, fmt.Errorf("failed to enable WAL mode: %w", err)
+	}
+
 	s := &DB{db}
 	if err := s.init(); err != nil {
 		return nil, err
@@ -131,6 +150,33 @@ func New(path string) (*DB, error) {
 	return s, nil
 }
 
+// OpenReadOnly opens a second, read-only connection pool to an existing
+// database created by New. It does not run migrations, and any write through
+// it fails. Use it to keep heavy reads off the writer's connection pool.
+func OpenReadOnly(path string) (*DB, error) {
+	db, err := sql.Open(driverName, readOnlyDSN(path))
+	if err != nil {
+		return nil, err
+	}
+	limitReaderPool(db, path)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open read-only database: %w", err)
+	}
+	return &DB{db}, nil
+}
+
+func readOnlyDSN(path string) string {
+	dsn := sqliteDSN(path)
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+	if strings.Contains(dsn, "mode=") {
+		return dsn
+	}
+	return dsn + "&mode=ro"
+}
+
 func sqliteDSN(path string) string {
 	if strings.Contains(path, "_foreign_keys=") || strings.Contains(path, "_fk=") {
 		return path
@@ -174,6 +220,74 @@ type Target struct {
 	ProbeInterval     float64
 	Timeout           float64
 	RetentionPolicies string // JSON
+	// MaxLatencyNS caps a single probe's latency; 0 disables the limit.
+	MaxLatencyNS float64
+	// MaxLatencyAction is what happens to probes over MaxLatencyNS:
+	// MaxLatencyActionTimeout (the default) or MaxLatencyActionClamp.
+	MaxLatencyAction string
+	// WarmupProbes is how many probes are discarded each time the target
+	// starts being probed, to keep cold caches and connection setup out of
+	// the percentiles.
+	WarmupProbes int
+	// DownAfter is how many consecutive timeouts mark the target down; 0
+	// disables up/down tracking.
+	DownAfter int
+	// UpAfter is how many consecutive successful probes bring a down target
+	// back up; 0 or 1 brings it up on the first. Setting it above 1 keeps a
+	// target hovering around its timeout from flapping.
+	UpAfter int
+	// RetryCount is how many times a failed probe is retried before it is
+	// recorded as a timeout; 0 records the first failure.
+	RetryCount int
+	// RecordMetadata stores each probe's Metadata, such as the HTTP status
+	// or DNS answer, with its raw result. Off by default, since raw results
+	// are the bulk of the database.
+	RecordMetadata bool
+	// AlignProbes starts probing at the next multiple of the probe
+	// interval, so aligned targets with the same interval probe at the same
+	// moments, on wall-clock boundaries that rollup windows also fall on.
+	AlignProbes bool
+	// Aggregation is how the target's rollups summarize latencies:
+	// AggregationFull (the default) or AggregationSummary.
+	Aggregation string
+	// Schedule limits probing to certain times of the week, such as
+	// "mon-fri 09:00-17:00"; empty probes all the time. See
+	// scheduler.ParseSchedule for the syntax.
+	Schedule string
+	// Down is set by the scheduler while the target is down. It is not
+	// written by AddTarget or UpdateTarget; see SetTargetDown.
+	Down bool
+}
+
+const (
+	// MaxLatencyActionTimeout records probes over the limit as timeouts.
+	MaxLatencyActionTimeout = "timeout"
+	// MaxLatencyActionClamp records probes over the limit at the limit.
+	MaxLatencyActionClamp = "clamp"
+)
+
+const (
+	// AggregationFull rolls latencies up into a t-digest, for percentiles,
+	// along with the exact count, sums, minimum and maximum.
+	AggregationFull = "full"
+	// AggregationSummary keeps only the exact count, sums, minimum and
+	// maximum: rollups are far smaller and cheaper to build, but report
+	// mean, standard deviation, min and max without any percentiles.
+	AggregationSummary = "summary"
+)
+
+// targetColumns is the column list matching scanTarget.
+const targetColumns = `id, name, address, probe_type, probe_config, probe_interval, timeout, COALESCE(retention_policies, '[]'), max_latency_ns, max_latency_action, warmup_probes, down_after, down, retry_count, record_metadata, align_probes, up_after, aggregation, schedule`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTarget(row rowScanner) (Target, error) {
+	var t Target
+	err := row.Scan(&t.ID, &t.Name, &t.Address, &t.ProbeType, &t.ProbeConfig, &t.ProbeInterval, &t.Timeout, &t.RetentionPolicies,
+		&t.MaxLatencyNS, &t.MaxLatencyAction, &t.WarmupProbes, &t.DownAfter, &t.Down, &t.RetryCount, &t.RecordMetadata, &t.AlignProbes, &t.UpAfter, &t.Aggregation, &t.Schedule)
+	return t, err
 }
 
 type Result struct {
@@ -184,9 +298,30 @@ type Result struct {
 }
 
 type RawResult struct {
+	// ID is assigned when the result is stored and grows with each one, so
+	// it orders results stored at the same time and serves as a cursor.
+	ID       int64
 	Time     time.Time
 	TargetID int64
 	Latency  float64
+
+	// Metrics are auxiliary measurements taken by the same probe, such as
+	// HTTP throughput, keyed by name. They are stored in raw_metrics, share
+	// the raw results' retention, and are rolled up with them into
+	// AggregatedMetrics. GetRawResults doesn't load them.
+	Metrics map[string]float64
+
+	// Metadata is the probe's metadata as a JSON object, for targets with
+	// RecordMetadata; empty otherwise. Rollups ignore it.
+	Metadata string
+}
+
+// MetricPoint is one stored value of an auxiliary metric.
+type MetricPoint struct {
+	// Name is only set when GetRawMetrics returns every metric.
+	Name  string    `json:"name,omitempty"`
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
 }
 
 type AggregatedResult struct {
@@ -195,6 +330,113 @@ type AggregatedResult struct {
 	WindowSeconds int
 	TDigestData   []byte
 	TimeoutCount  int64
+
+	// Exact moments of the successful latencies, merged additively across
+	// rollups so the standard deviation survives at every window. Rows
+	// written before these were tracked have SampleCount 0.
+	SampleCount int64
+	SumNS       float64
+	SumSqNS     float64
+	// MinNS and MaxNS are the exact extremes of the successful latencies,
+	// valid when SampleCount is non-zero. Rows written before they were
+	// stored (migration 27) have both 0.
+	MinNS float64
+	MaxNS float64
+
+	// Partial marks a rollup of a window that hadn't closed yet, written so
+	// recent data shows up early. The complete rollup replaces it.
+	Partial bool
+
+	// Maintenance marks an empty rollup of a window in which probing was
+	// paused, or the target's Schedule was off, so it reads as a planned gap
+	// rather than missing data.
+	Maintenance bool
+
+	// SourceWindows is how many rollups of the finer source window with
+	// results, or in a maintenance gap, a cascaded rollup was merged from,
+	// out of ExpectedSourceWindows; see Completeness. Both are 0 for rollups
+	// of raw results and for rows written before they were tracked.
+	SourceWindows         int
+	ExpectedSourceWindows int
+
+	// Percentiles are precomputed from the digest when the rollup is
+	// written, keyed by PercentileName, so the results API can serve them
+	// without decoding it. Nil unless the scheduler was configured to store
+	// any; see Scheduler.SetStoredPercentiles.
+	Percentiles map[string]float64
+
+	// Metrics are the rollups of the window's auxiliary metrics, stored
+	// with it by AddAggregatedResult(s). GetAggregatedResults doesn't load
+	// them; see GetAggregatedMetrics.
+	Metrics []AggregatedMetric
+}
+
+// PercentileName is the name a percentile, from 0 to 100, is stored and
+// reported under, such as "p50" or "p99.9".
+func PercentileName(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+// encodePercentiles and decodePercentiles convert AggregatedResult's
+// Percentiles to and from the JSON object in the percentiles column, which
+// is empty when there are none.
+func encodePercentiles(p map[string]float64) (string, error) {
+	if len(p) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(p)
+	return string(data), err
+}
+
+func decodePercentiles(s string) (map[string]float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var p map[string]float64
+	if err := json.Unmarshal([]byte(s), &p); err != nil {
+		return nil, fmt.Errorf("invalid stored percentiles: %w", err)
+	}
+	return p, nil
+}
+
+// AggregatedMetric is the rollup of one auxiliary metric over a window, kept
+// in aggregated_metrics alongside the window's AggregatedResult and sharing
+// its retention. Each metric is rolled up on its own, into a t-digest like
+// latencies, with the count and sum of its values for the mean.
+type AggregatedMetric struct {
+	Time          time.Time
+	TargetID      int64
+	WindowSeconds int
+	Name          string
+	TDigestData   []byte
+	SampleCount   int64
+	Sum           float64
+}
+
+// Completeness returns the fraction of its expected source rollups a
+// cascaded rollup was merged from, which is below 1 for a window missing
+// some, such as after the collector was down. It is 1 for rollups that
+// weren't cascaded.
+func (r AggregatedResult) Completeness() float64 {
+	if r.ExpectedSourceWindows <= 0 {
+		return 1
+	}
+	return min(float64(r.SourceWindows)/float64(r.ExpectedSourceWindows), 1)
+}
+
+// StdDevNS returns the population standard deviation of the window's
+// latencies, or false if no moments were recorded.
+func (r AggregatedResult) StdDevNS() (float64, bool) {
+	if r.SampleCount == 0 {
+		return 0, false
+	}
+	n := float64(r.SampleCount)
+	mean := r.SumNS / n
+	variance := r.SumSqNS/n - mean*mean
+	if variance < 0 {
+		variance = 0 // rounding error on near-constant data
+	}
+	return math.Sqrt(variance), true
 }
 
 type Dashboard struct {
@@ -213,21 +455,63 @@ type DashboardGraph struct {
 	TargetNames map[int64]string // Populated on read, implementation detail for API
 }
 
-func (d *DB) AddTarget(t *Target) (int64, error) {
+const insertTarget = `INSERT INTO targets (name, address, probe_type, probe_config, probe_interval, timeout, retention_policies, max_latency_ns, max_latency_action, warmup_probes, down_after, retry_count, record_metadata, align_probes, up_after, aggregation, schedule) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// insertTargetArgs fills in the default interval and timeout and returns the
+// arguments of insertTarget.
+func insertTargetArgs(t *Target) []any {
 	if t.ProbeInterval <= 0 {
 		t.ProbeInterval = 1.0
 	}
 	if t.Timeout <= 0 {
 		t.Timeout = 5.0
 	}
-	res, err := d.Exec(`INSERT INTO targets (name, address, probe_type, probe_config, probe_interval, timeout, retention_policies) VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		t.Name, t.Address, t.ProbeType, t.ProbeConfig, t.ProbeInterval, t.Timeout, t.RetentionPolicies)
+	return []any{t.Name, t.Address, t.ProbeType, t.ProbeConfig, t.ProbeInterval, t.Timeout, t.RetentionPolicies, t.MaxLatencyNS, t.MaxLatencyAction, t.WarmupProbes, t.DownAfter, t.RetryCount, t.RecordMetadata, t.AlignProbes, t.UpAfter, t.Aggregation, t.Schedule}
+}
+
+func (d *DB) AddTarget(t *Target) (int64, error) {
+	res, err := d.Exec(insertTarget, insertTargetArgs(t)...)
 	if err != nil {
 		return 0, err
 	}
 	return res.LastInsertId()
 }
 
+// AddTargets stores targets in a single transaction, setting each one's ID.
+// Either all of them are stored or, on error, none are.
+func (d *DB) AddTargets(targets []*Target) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(insertTarget)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	ids := make([]int64, len(targets))
+	for i, t := range targets {
+		res, err := stmt.Exec(insertTargetArgs(t)...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if ids[i], err = res.LastInsertId(); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for i, t := range targets {
+		t.ID = ids[i]
+	}
+	return nil
+}
+
 func (d *DB) UpdateTarget(t *Target) error {
 	if t.ProbeInterval <= 0 {
 		t.ProbeInterval = 1.0
@@ -235,8 +519,14 @@ func (d *DB) UpdateTarget(t *Target) error {
 	if t.Timeout <= 0 {
 		t.Timeout = 5.0
 	}
-	_, err := d.Exec(`UPDATE targets SET name=?, address=?, probe_type=?, probe_interval=?, timeout=?, retention_policies=? WHERE id=?`,
-		t.Name, t.Address, t.ProbeType, t.ProbeInterval, t.Timeout, t.RetentionPolicies, t.ID)
+	_, err := d.Exec(`UPDATE targets SET name=?, address=?, probe_type=?, probe_config=?, probe_interval=?, timeout=?, retention_policies=?, max_latency_ns=?, max_latency_action=?, warmup_probes=?, down_after=?, retry_count=?, record_metadata=?, align_probes=?, up_after=?, aggregation=?, schedule=? WHERE id=?`,
+		t.Name, t.Address, t.ProbeType, t.ProbeConfig, t.ProbeInterval, t.Timeout, t.RetentionPolicies, t.MaxLatencyNS, t.MaxLatencyAction, t.WarmupProbes, t.DownAfter, t.RetryCount, t.RecordMetadata, t.AlignProbes, t.UpAfter, t.Aggregation, t.Schedule, t.ID)
+	return err
+}
+
+// SetTargetDown records whether a target is currently down.
+func (d *DB) SetTargetDown(id int64, down bool) error {
+	_, err := d.Exec(`UPDATE targets SET down=? WHERE id=?`, down, id)
 	return err
 }
 
@@ -248,7 +538,7 @@ func (d *DB) AddResult(r *Result) error {
 }
 
 func (d *DB) GetTargets() ([]Target, error) {
-	rows, err := d.Query(`SELECT id, name, address, probe_type, probe_config, probe_interval, timeout, COALESCE(retention_policies, '[]') FROM targets`)
+	rows, err := d.Query(`SELECT ` + targetColumns + ` FROM targets`)
 	if err != nil {
 		return nil, err
 	}
@@ -256,8 +546,8 @@ func (d *DB) GetTargets() ([]Target, error) {
 
 	var targets []Target
 	for rows.Next() {
-		var t Target
-		if err := rows.Scan(&t.ID, &t.Name, &t.Address, &t.ProbeType, &t.ProbeConfig, &t.ProbeInterval, &t.Timeout, &t.RetentionPolicies); err != nil {
+		t, err := scanTarget(rows)
+		if err != nil {
 			return nil, err
 		}
 		targets = append(targets, t)
@@ -266,16 +556,43 @@ func (d *DB) GetTargets() ([]Target, error) {
 }
 
 func (d *DB) GetTarget(id int64) (*Target, error) {
-	var t Target
-	err := d.QueryRow(`SELECT id, name, address, probe_type, probe_config, probe_interval, timeout, COALESCE(retention_policies, '[]') FROM targets WHERE id = ?`, id).Scan(
-		&t.ID, &t.Name, &t.Address, &t.ProbeType, &t.ProbeConfig, &t.ProbeInterval, &t.Timeout, &t.RetentionPolicies,
-	)
+	t, err := scanTarget(d.QueryRow(`SELECT `+targetColumns+` FROM targets WHERE id = ?`, id))
 	if err != nil {
 		return nil, err
 	}
 	return &t, nil
 }
 
+// SearchTargets returns up to limit targets whose name or address contains
+// query, ignoring ASCII case. Exact matches come first, then prefix matches,
+// then the rest, each by name. The match can't use an index, but the
+// targets table is small enough to scan.
+func (d *DB) SearchTargets(query string, limit int) ([]Target, error) {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query)
+	rows, err := d.Query(`SELECT `+targetColumns+` FROM targets
+		WHERE name LIKE ?1 ESCAPE '\' OR address LIKE ?1 ESCAPE '\'
+		ORDER BY CASE
+			WHEN name LIKE ?2 ESCAPE '\' OR address LIKE ?2 ESCAPE '\' THEN 0
+			WHEN name LIKE ?3 ESCAPE '\' OR address LIKE ?3 ESCAPE '\' THEN 1
+			ELSE 2
+		END, name, id
+		LIMIT ?4`, "%"+escaped+"%", escaped, escaped+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []Target
+	for rows.Next() {
+		t, err := scanTarget(rows)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
 func (d *DB) GetResults(targetID int64, limit int) ([]Result, error) {
 	rows, err := d.Query(`SELECT time, target_id, timeout_count, tdigest_data 
 		FROM results WHERE target_id = ? ORDER BY time DESC LIMIT ?`, targetID, limit)
@@ -295,9 +612,41 @@ func (d *DB) GetResults(targetID int64, limit int) ([]Result, error) {
 	return results, nil
 }
 
-func (d *DB) GetResultsByTime(targetID int64, start, end time.Time) ([]Result, error) {
-	rows, err := d.Query(`SELECT time, target_id, timeout_count, tdigest_data 
-		FROM results WHERE target_id = ? AND time >= ? AND time <= ? ORDER BY time ASC`, targetID, start, end)
+// Orders accepted by the time-range queries that take one. The empty string
+// means OrderAsc.
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// orderDirection maps an order to its SQL keyword.
+func orderDirection(order string) (string, error) {
+	switch order {
+	case "", OrderAsc:
+		return "ASC", nil
+	case OrderDesc:
+		return "DESC", nil
+	}
+	return "", fmt.Errorf("invalid order %q (expected %q or %q)", order, OrderAsc, OrderDesc)
+}
+
+// GetResultsByTime returns the results in [start, end] sorted by time in the
+// given order. A positive limit caps the number returned, counting from the
+// start of that order: with OrderAsc you get the earliest limit results of
+// the range, not the latest.
+func (d *DB) GetResultsByTime(targetID int64, start, end time.Time, limit int, order string) ([]Result, error) {
+	dir, err := orderDirection(order)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT time, target_id, timeout_count, tdigest_data 
+		FROM results WHERE target_id = ? AND time >= ? AND time <= ? ORDER BY time ` + dir
+	args := []any{targetID, start, end}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := d.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -325,6 +674,7 @@ func (d *DB) DeleteTarget(id int64) error {
 		`DELETE FROM raw_results WHERE target_id = ?`,
 		`DELETE FROM aggregated_results WHERE target_id = ?`,
 		`DELETE FROM dashboard_graph_targets WHERE target_id = ?`,
+		`DELETE FROM target_baselines WHERE target_id = ?`,
 		`DELETE FROM targets WHERE id = ?`,
 	} {
 		if _, err := tx.Exec(query, id); err != nil {
@@ -346,33 +696,77 @@ func (d *DB) AddRawResults(results []RawResult) error {
 	}
 
 	// Prepare statement for bulk insert
-	stmt, err := tx.Prepare(`INSERT INTO raw_results (time, target_id, latency) VALUES (?, ?, ?)`)
+	stmt, err := tx.Prepare(`INSERT INTO raw_results (time, target_id, latency, metadata) VALUES (?, ?, ?, ?)`)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 	defer stmt.Close()
+	var metricStmt *sql.Stmt
 
-	for _, r := range results {
-		_, err = stmt.Exec(r.Time, r.TargetID, r.Latency)
+	for i, r := range results {
+		res, err := stmt.Exec(r.Time, r.TargetID, r.Latency, sql.NullString{String: r.Metadata, Valid: r.Metadata != ""})
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
+		if results[i].ID, err = res.LastInsertId(); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if len(r.Metrics) == 0 {
+			continue
+		}
+		if metricStmt == nil {
+			metricStmt, err = tx.Prepare(`INSERT INTO raw_metrics (time, target_id, name, value) VALUES (?, ?, ?, ?)`)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			defer metricStmt.Close()
+		}
+		for name, value := range r.Metrics {
+			if _, err := metricStmt.Exec(r.Time, r.TargetID, name, value); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
 	}
 	return tx.Commit()
 }
 
+// AddAggregatedResult stores a rollup row. aggregated_results is keyed by
+// (target_id, window_seconds, time), and an existing row for the same key is
+// replaced rather than merged, so recomputing a window is idempotent.
 func (d *DB) AddAggregatedResult(r *AggregatedResult) error {
-	_, err := d.Exec(`INSERT INTO aggregated_results (time, target_id, window_seconds, tdigest_data, timeout_count) 
-		VALUES (?, ?, ?, ?, ?)
+	if len(r.Metrics) > 0 {
+		return d.AddAggregatedResults([]*AggregatedResult{r})
+	}
+	percentiles, err := encodePercentiles(r.Percentiles)
+	if err != nil {
+		return err
+	}
+	_, err = d.Exec(`INSERT INTO aggregated_results (time, target_id, window_seconds, tdigest_data, timeout_count, sample_count, sum_ns, sum_sq_ns, partial, maintenance, source_windows, expected_source_windows, min_ns, max_ns, percentiles) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(time, target_id, window_seconds) DO UPDATE SET
 		tdigest_data=excluded.tdigest_data,
-		timeout_count=excluded.timeout_count`,
-		r.Time, r.TargetID, r.WindowSeconds, r.TDigestData, r.TimeoutCount)
+		timeout_count=excluded.timeout_count,
+		sample_count=excluded.sample_count,
+		sum_ns=excluded.sum_ns,
+		sum_sq_ns=excluded.sum_sq_ns,
+		partial=excluded.partial,
+		maintenance=excluded.maintenance,
+		source_windows=excluded.source_windows,
+		expected_source_windows=excluded.expected_source_windows,
+		min_ns=excluded.min_ns,
+		max_ns=excluded.max_ns,
+		percentiles=excluded.percentiles`,
+		r.Time, r.TargetID, r.WindowSeconds, r.TDigestData, r.TimeoutCount, r.SampleCount, r.SumNS, r.SumSqNS, r.Partial, r.Maintenance, r.SourceWindows, r.ExpectedSourceWindows, r.MinNS, r.MaxNS, percentiles)
 	return err
 }
 
+// AddAggregatedResults stores rollup rows in one transaction, with the same
+// replace-on-conflict semantics as AddAggregatedResult.
 func (d *DB) AddAggregatedResults(results []*AggregatedResult) error {
 	if len(results) == 0 {
 		return nil
@@ -382,30 +776,75 @@ func (d *DB) AddAggregatedResults(results []*AggregatedResult) error {
 		return err
 	}
 
-	stmt, err := tx.Prepare(`INSERT INTO aggregated_results (time, target_id, window_seconds, tdigest_data, timeout_count) 
-		VALUES (?, ?, ?, ?, ?)
+	stmt, err := tx.Prepare(`INSERT INTO aggregated_results (time, target_id, window_seconds, tdigest_data, timeout_count, sample_count, sum_ns, sum_sq_ns, partial, maintenance, source_windows, expected_source_windows, min_ns, max_ns, percentiles) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(time, target_id, window_seconds) DO UPDATE SET
 		tdigest_data=excluded.tdigest_data,
-		timeout_count=excluded.timeout_count`)
+		timeout_count=excluded.timeout_count,
+		sample_count=excluded.sample_count,
+		sum_ns=excluded.sum_ns,
+		sum_sq_ns=excluded.sum_sq_ns,
+		partial=excluded.partial,
+		maintenance=excluded.maintenance,
+		source_windows=excluded.source_windows,
+		expected_source_windows=excluded.expected_source_windows,
+		min_ns=excluded.min_ns,
+		max_ns=excluded.max_ns,
+		percentiles=excluded.percentiles`)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 	defer stmt.Close()
+	var metricStmt *sql.Stmt
 
 	for _, r := range results {
-		_, err = stmt.Exec(r.Time, r.TargetID, r.WindowSeconds, r.TDigestData, r.TimeoutCount)
+		percentiles, err := encodePercentiles(r.Percentiles)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		_, err = stmt.Exec(r.Time, r.TargetID, r.WindowSeconds, r.TDigestData, r.TimeoutCount, r.SampleCount, r.SumNS, r.SumSqNS, r.Partial, r.Maintenance, r.SourceWindows, r.ExpectedSourceWindows, r.MinNS, r.MaxNS, percentiles)
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
+		if len(r.Metrics) == 0 {
+			continue
+		}
+		if metricStmt == nil {
+			metricStmt, err = tx.Prepare(`INSERT INTO aggregated_metrics (time, target_id, window_seconds, name, tdigest_data, sample_count, sum)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(target_id, window_seconds, name, time) DO UPDATE SET
+				tdigest_data=excluded.tdigest_data,
+				sample_count=excluded.sample_count,
+				sum=excluded.sum`)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			defer metricStmt.Close()
+		}
+		for _, m := range r.Metrics {
+			if _, err := metricStmt.Exec(r.Time, r.TargetID, r.WindowSeconds, m.Name, m.TDigestData, m.SampleCount, m.Sum); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
 	}
 	return tx.Commit()
 }
 
-func (d *DB) GetLastRollupTime(targetID int64, windowSeconds int) (time.Time, error) {
+// GetLastRollupTime returns the start of the newest complete rollup of the
+// window starting before before, ignoring partial ones, or the zero time if
+// there is none.
+func (d *DB) GetLastRollupTime(targetID int64, windowSeconds int, before time.Time) (time.Time, error) {
 	var ns sql.NullString
-	err := d.QueryRow(`SELECT MAX(time) FROM aggregated_results WHERE target_id = ? AND window_seconds = ?`, targetID, windowSeconds).Scan(&ns)
+	err := d.QueryRow(`SELECT time FROM aggregated_results WHERE target_id = ? AND window_seconds = ? AND partial = 0 AND time < ?
+		ORDER BY time DESC LIMIT 1`, targetID, windowSeconds, before).Scan(&ns)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
 	if err != nil {
 		return time.Time{}, err
 	}
@@ -416,17 +855,82 @@ func (d *DB) GetLastRollupTime(targetID int64, windowSeconds int) (time.Time, er
 }
 
 func (d *DB) GetRawResults(targetID int64, start, end time.Time, limit int) ([]RawResult, error) {
-	query := `SELECT time, target_id, latency FROM raw_results
-		WHERE target_id = ? AND time >= ? AND time < ? ORDER BY time ASC`
+	query := `SELECT id, time, target_id, latency, COALESCE(metadata, '') FROM raw_results
+		WHERE target_id = ? AND time >= ? AND time < ? ORDER BY time ASC, id ASC`
 	args := []any{targetID, start, end}
 	if limit > 0 {
-		query = `SELECT time, target_id, latency FROM (
-			SELECT time, target_id, latency FROM raw_results
-			WHERE target_id = ? AND time >= ? AND time < ? ORDER BY time DESC LIMIT ?
-		) ORDER BY time ASC`
+		query = `SELECT id, time, target_id, latency, metadata FROM (
+			SELECT id, time, target_id, latency, COALESCE(metadata, '') AS metadata FROM raw_results
+			WHERE target_id = ? AND time >= ? AND time < ? ORDER BY time DESC, id DESC LIMIT ?
+		) ORDER BY time ASC, id ASC`
+		args = append(args, limit)
+	}
+	return d.queryRawResults(query, args...)
+}
+
+// GetRawResultsAfter returns the raw results in [start, end) with an ID
+// greater than afterID, in ID order and capped at limit if it is positive.
+// Passing the last ID of one page as afterID gives the next, without the
+// cost of an OFFSET.
+func (d *DB) GetRawResultsAfter(targetID, afterID int64, start, end time.Time, limit int) ([]RawResult, error) {
+	query := `SELECT id, time, target_id, latency, COALESCE(metadata, '') FROM raw_results
+		WHERE target_id = ? AND id > ? AND time >= ? AND time < ? ORDER BY id ASC`
+	args := []any{targetID, afterID, start, end}
+	if limit > 0 {
+		query += ` LIMIT ?`
 		args = append(args, limit)
 	}
+	return d.queryRawResults(query, args...)
+}
+
+// RawBucket summarizes the raw results in one bucket of GetRawBuckets.
+// MinNS, AvgNS and MaxNS are over the successful probes, and zero without
+// any.
+type RawBucket struct {
+	Time         time.Time // the start of the bucket
+	Count        int64     // successful probes
+	TimeoutCount int64
+	MinNS        float64
+	AvgNS        float64
+	MaxNS        float64
+}
+
+// GetRawBuckets groups the raw results in [start, end) into buckets of the
+// given size, whole seconds counted from the Unix epoch like rollup windows,
+// and summarizes each in SQL. Buckets without results are left out.
+func (d *DB) GetRawBuckets(targetID int64, start, end time.Time, bucket time.Duration) ([]RawBucket, error) {
+	size := int64(bucket / time.Second)
+	if size <= 0 {
+		return nil, fmt.Errorf("bucket must be at least a second, got %v", bucket)
+	}
+	rows, err := d.Query(`SELECT CAST(strftime('%s', time) AS INTEGER) / ? AS bucket,
+			SUM(latency >= 0), SUM(latency < 0),
+			COALESCE(MIN(CASE WHEN latency >= 0 THEN latency END), 0),
+			COALESCE(AVG(CASE WHEN latency >= 0 THEN latency END), 0),
+			COALESCE(MAX(CASE WHEN latency >= 0 THEN latency END), 0)
+		FROM raw_results
+		WHERE target_id = ? AND time >= ? AND time < ?
+		GROUP BY bucket ORDER BY bucket`, size, targetID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var buckets []RawBucket
+	for rows.Next() {
+		var n int64
+		var b RawBucket
+		if err := rows.Scan(&n, &b.Count, &b.TimeoutCount, &b.MinNS, &b.AvgNS, &b.MaxNS); err != nil {
+			return nil, err
+		}
+		b.Time = time.Unix(n*size, 0).UTC()
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
 
+// queryRawResults runs a query selecting id, time, target_id, latency and
+// metadata from raw_results.
+func (d *DB) queryRawResults(query string, args ...any) ([]RawResult, error) {
 	rows, err := d.Query(query, args...)
 	if err != nil {
 		return nil, err
@@ -435,16 +939,35 @@ func (d *DB) GetRawResults(targetID int64, start, end time.Time, limit int) ([]R
 	var res []RawResult
 	for rows.Next() {
 		var r RawResult
-		if err := rows.Scan(&r.Time, &r.TargetID, &r.Latency); err != nil {
+		if err := rows.Scan(&r.ID, &r.Time, &r.TargetID, &r.Latency, &r.Metadata); err != nil {
 			return nil, err
 		}
 		res = append(res, r)
 	}
-	return res, nil
+	return res, rows.Err()
+}
+
+// GetRawResultsPage returns the raw results in [start, end) sorted by time in
+// the given order, capped at limit if it is positive. Unlike GetRawResults,
+// the cap counts from the start of that order, so OrderAsc gives the earliest
+// results of the range and OrderDesc the latest, newest first.
+func (d *DB) GetRawResultsPage(targetID int64, start, end time.Time, limit int, order string) ([]RawResult, error) {
+	dir, err := orderDirection(order)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT id, time, target_id, latency, COALESCE(metadata, '') FROM raw_results
+		WHERE target_id = ? AND time >= ? AND time < ? ORDER BY time ` + dir + `, id ` + dir
+	args := []any{targetID, start, end}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	return d.queryRawResults(query, args...)
 }
 
 func (d *DB) GetAggregatedResults(targetID int64, windowSeconds int, start, end time.Time) ([]AggregatedResult, error) {
-	rows, err := d.Query(`SELECT time, target_id, window_seconds, tdigest_data, timeout_count 
+	rows, err := d.Query(`SELECT time, target_id, window_seconds, tdigest_data, timeout_count, sample_count, sum_ns, sum_sq_ns, partial, maintenance, source_windows, expected_source_windows, min_ns, max_ns, percentiles
 		FROM aggregated_results 
 		WHERE target_id = ? AND window_seconds = ? AND time >= ? AND time < ? ORDER BY time ASC`, targetID, windowSeconds, start, end)
 	if err != nil {
@@ -454,7 +977,11 @@ func (d *DB) GetAggregatedResults(targetID int64, windowSeconds int, start, end
 	var res []AggregatedResult
 	for rows.Next() {
 		var r AggregatedResult
-		if err := rows.Scan(&r.Time, &r.TargetID, &r.WindowSeconds, &r.TDigestData, &r.TimeoutCount); err != nil {
+		var percentiles string
+		if err := rows.Scan(&r.Time, &r.TargetID, &r.WindowSeconds, &r.TDigestData, &r.TimeoutCount, &r.SampleCount, &r.SumNS, &r.SumSqNS, &r.Partial, &r.Maintenance, &r.SourceWindows, &r.ExpectedSourceWindows, &r.MinNS, &r.MaxNS, &percentiles); err != nil {
+			return nil, err
+		}
+		if r.Percentiles, err = decodePercentiles(percentiles); err != nil {
 			return nil, err
 		}
 		res = append(res, r)
@@ -463,17 +990,104 @@ func (d *DB) GetAggregatedResults(targetID int64, windowSeconds int, start, end
 }
 
 func (d *DB) DeleteRawResultsBefore(targetID int64, cutoff time.Time) error {
-	_, err := d.Exec(`DELETE FROM raw_results WHERE target_id = ? AND time < ?`, targetID, cutoff)
+	if _, err := d.Exec(`DELETE FROM raw_results WHERE target_id = ? AND time < ?`, targetID, cutoff); err != nil {
+		return err
+	}
+	_, err := d.Exec(`DELETE FROM raw_metrics WHERE target_id = ? AND time < ?`, targetID, cutoff)
+	return err
+}
+
+// DeleteResultsBefore deletes the legacy per-commit results older than
+// cutoff. They follow the raw results' retention.
+func (d *DB) DeleteResultsBefore(targetID int64, cutoff time.Time) error {
+	_, err := d.Exec(`DELETE FROM results WHERE target_id = ? AND time < ?`, targetID, cutoff)
 	return err
 }
 
 func (d *DB) DeleteAggregatedResultsBefore(targetID int64, windowSeconds int, cutoff time.Time) error {
-	_, err := d.Exec(`DELETE FROM aggregated_results WHERE target_id = ? AND window_seconds = ? AND time < ?`, targetID, windowSeconds, cutoff)
+	if _, err := d.Exec(`DELETE FROM aggregated_results WHERE target_id = ? AND window_seconds = ? AND time < ?`, targetID, windowSeconds, cutoff); err != nil {
+		return err
+	}
+	_, err := d.Exec(`DELETE FROM aggregated_metrics WHERE target_id = ? AND window_seconds = ? AND time < ?`, targetID, windowSeconds, cutoff)
+	return err
+}
+
+// DeleteRawResultsRange deletes a target's raw results, and their auxiliary
+// metrics, in [start, end), returning how many results were deleted.
+func (d *DB) DeleteRawResultsRange(targetID int64, start, end time.Time) (int64, error) {
+	res, err := d.Exec(`DELETE FROM raw_results WHERE target_id = ? AND time >= ? AND time < ?`, targetID, start, end)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := d.Exec(`DELETE FROM raw_metrics WHERE target_id = ? AND time >= ? AND time < ?`, targetID, start, end); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// DeleteAggregatedResultsRange deletes a target's aggregated results, and
+// their metric rollups, that start in [start, end), for one window or, when
+// windowSeconds is 0, every window. It returns how many results were deleted.
+func (d *DB) DeleteAggregatedResultsRange(targetID int64, windowSeconds int, start, end time.Time) (int64, error) {
+	where := ` WHERE target_id = ? AND time >= ? AND time < ?`
+	args := []any{targetID, start, end}
+	if windowSeconds != 0 {
+		where += ` AND window_seconds = ?`
+		args = append(args, windowSeconds)
+	}
+	res, err := d.Exec(`DELETE FROM aggregated_results`+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := d.Exec(`DELETE FROM aggregated_metrics`+where, args...); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// DeleteRawResultsKeepingLast deletes all but the newest n raw results for a
+// target. Rows sharing the nth newest timestamp are kept, and auxiliary
+// metrics older than the oldest kept row are deleted with the rest.
+func (d *DB) DeleteRawResultsKeepingLast(targetID int64, n int) error {
+	if _, err := d.Exec(`DELETE FROM raw_results WHERE target_id = ? AND time < (
+		SELECT time FROM raw_results WHERE target_id = ? ORDER BY time DESC LIMIT 1 OFFSET ?
+	)`, targetID, targetID, n-1); err != nil {
+		return err
+	}
+	_, err := d.Exec(`DELETE FROM raw_metrics WHERE target_id = ? AND time < (
+		SELECT MIN(time) FROM raw_results WHERE target_id = ?
+	)`, targetID, targetID)
+	return err
+}
+
+// DeleteAggregatedResultsKeepingLast deletes all but the newest n aggregated
+// results for a target's window, along with the metric rollups of the
+// windows deleted.
+func (d *DB) DeleteAggregatedResultsKeepingLast(targetID int64, windowSeconds int, n int) error {
+	if _, err := d.Exec(`DELETE FROM aggregated_results WHERE target_id = ? AND window_seconds = ? AND time < (
+		SELECT time FROM aggregated_results WHERE target_id = ? AND window_seconds = ? ORDER BY time DESC LIMIT 1 OFFSET ?
+	)`, targetID, windowSeconds, targetID, windowSeconds, n-1); err != nil {
+		return err
+	}
+	_, err := d.Exec(`DELETE FROM aggregated_metrics WHERE target_id = ? AND window_seconds = ? AND time < (
+		SELECT MIN(time) FROM aggregated_results WHERE target_id = ? AND window_seconds = ?
+	)`, targetID, windowSeconds, targetID, windowSeconds)
 	return err
 }
 
 func (d *DB) DeleteAggregatedResultsByWindow(targetID int64, windowSeconds int) error {
-	_, err := d.Exec(`DELETE FROM aggregated_results WHERE target_id = ? AND window_seconds = ?`, targetID, windowSeconds)
+	if _, err := d.Exec(`DELETE FROM aggregated_results WHERE target_id = ? AND window_seconds = ?`, targetID, windowSeconds); err != nil {
+		return err
+	}
+	_, err := d.Exec(`DELETE FROM aggregated_metrics WHERE target_id = ? AND window_seconds = ?`, targetID, windowSeconds)
 	return err
 }
 
@@ -489,6 +1103,192 @@ func (d *DB) GetEarliestRawResultTime(targetID int64) (time.Time, error) {
 	return time.Time{}, nil
 }
 
+// GetRawMetrics returns the values of a target's auxiliary metric name in
+// [start, end), oldest first. As with GetRawResults, a positive limit keeps
+// the latest values. An empty name returns the values of every metric.
+func (d *DB) GetRawMetrics(targetID int64, name string, start, end time.Time, limit int) ([]MetricPoint, error) {
+	where := `target_id = ? AND time >= ? AND time < ?`
+	args := []any{targetID, start, end}
+	if name != "" {
+		where += ` AND name = ?`
+		args = append(args, name)
+	}
+	query := `SELECT name, time, value FROM raw_metrics WHERE ` + where + ` ORDER BY time ASC`
+	if limit > 0 {
+		query = `SELECT name, time, value FROM (
+			SELECT name, time, value FROM raw_metrics WHERE ` + where + ` ORDER BY time DESC LIMIT ?
+		) ORDER BY time ASC`
+		args = append(args, limit)
+	}
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var points []MetricPoint
+	for rows.Next() {
+		var p MetricPoint
+		if err := rows.Scan(&p.Name, &p.Time, &p.Value); err != nil {
+			return nil, err
+		}
+		if name != "" {
+			p.Name = ""
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// GetRawMetricValues returns the values of each of a target's auxiliary
+// metrics in [start, end), by name and oldest first.
+func (d *DB) GetRawMetricValues(targetID int64, start, end time.Time) (map[string][]float64, error) {
+	rows, err := d.Query(`SELECT name, value FROM raw_metrics
+		WHERE target_id = ? AND time >= ? AND time < ? ORDER BY time ASC`, targetID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := make(map[string][]float64)
+	for rows.Next() {
+		var name string
+		var value float64
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		values[name] = append(values[name], value)
+	}
+	return values, rows.Err()
+}
+
+// GetAggregatedMetrics returns a target's rollups of the auxiliary metric
+// name for a window, starting in [start, end) and oldest first. An empty
+// name returns those of every metric.
+func (d *DB) GetAggregatedMetrics(targetID int64, windowSeconds int, name string, start, end time.Time) ([]AggregatedMetric, error) {
+	query := `SELECT time, target_id, window_seconds, name, tdigest_data, sample_count, sum FROM aggregated_metrics
+		WHERE target_id = ? AND window_seconds = ? AND time >= ? AND time < ?`
+	args := []any{targetID, windowSeconds, start, end}
+	if name != "" {
+		query += ` AND name = ?`
+		args = append(args, name)
+	}
+	rows, err := d.Query(query+` ORDER BY time ASC, name ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []AggregatedMetric
+	for rows.Next() {
+		var m AggregatedMetric
+		if err := rows.Scan(&m.Time, &m.TargetID, &m.WindowSeconds, &m.Name, &m.TDigestData, &m.SampleCount, &m.Sum); err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	return res, rows.Err()
+}
+
+// GetAggregatedWindows returns the window sizes a target has aggregated
+// results stored for, smallest first.
+func (d *DB) GetAggregatedWindows(targetID int64) ([]int, error) {
+	rows, err := d.Query(`SELECT DISTINCT window_seconds FROM aggregated_results WHERE target_id = ? ORDER BY window_seconds ASC`, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var windows []int
+	for rows.Next() {
+		var w int
+		if err := rows.Scan(&w); err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, rows.Err()
+}
+
+// GetLatestResults returns each target's newest complete rollup of its
+// finest aggregated window, in target ID order. Targets with no rollups yet
+// are left out. It is one query, whose cost grows with the number of targets
+// rather than the rows stored, since each lookup is a primary key seek.
+func (d *DB) GetLatestResults() ([]AggregatedResult, error) {
+	rows, err := d.Query(`SELECT a.time, a.target_id, a.window_seconds, a.tdigest_data, a.timeout_count, a.sample_count, a.sum_ns, a.sum_sq_ns, a.partial, a.min_ns, a.max_ns
+		FROM targets t
+		JOIN aggregated_results a ON a.rowid = (
+			SELECT rowid FROM aggregated_results
+			WHERE target_id = t.id AND partial = 0 AND window_seconds = (
+				SELECT MIN(window_seconds) FROM aggregated_results WHERE target_id = t.id
+			)
+			ORDER BY time DESC LIMIT 1
+		)
+		ORDER BY t.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []AggregatedResult
+	for rows.Next() {
+		var r AggregatedResult
+		if err := rows.Scan(&r.Time, &r.TargetID, &r.WindowSeconds, &r.TDigestData, &r.TimeoutCount, &r.SampleCount, &r.SumNS, &r.SumSqNS, &r.Partial, &r.MinNS, &r.MaxNS); err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// GetResultTimeRange returns the times of a target's earliest and latest
+// results for windowSeconds, where 0 means raw results. Both are zero when
+// there are none.
+func (d *DB) GetResultTimeRange(targetID int64, windowSeconds int) (time.Time, time.Time, error) {
+	var first, last sql.NullString
+	var err error
+	if windowSeconds == 0 {
+		err = d.QueryRow(`SELECT MIN(time), MAX(time) FROM raw_results WHERE target_id = ?`, targetID).Scan(&first, &last)
+	} else {
+		err = d.QueryRow(`SELECT MIN(time), MAX(time) FROM aggregated_results WHERE target_id = ? AND window_seconds = ?`, targetID, windowSeconds).Scan(&first, &last)
+	}
+	if err != nil || !first.Valid || !last.Valid {
+		return time.Time{}, time.Time{}, err
+	}
+	start, err := parseDBTime(first.String)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	end, err := parseDBTime(last.String)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return start, end, nil
+}
+
+// GetLastRawResultTimes returns the time of every target's latest raw
+// result, by target ID. Targets without raw results are left out.
+func (d *DB) GetLastRawResultTimes() (map[int64]time.Time, error) {
+	rows, err := d.Query(`SELECT t.id, (SELECT MAX(r.time) FROM raw_results r WHERE r.target_id = t.id) FROM targets t`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	times := make(map[int64]time.Time)
+	for rows.Next() {
+		var id int64
+		var last sql.NullString
+		if err := rows.Scan(&id, &last); err != nil {
+			return nil, err
+		}
+		if !last.Valid {
+			continue
+		}
+		t, err := parseDBTime(last.String)
+		if err != nil {
+			return nil, err
+		}
+		times[id] = t
+	}
+	return times, rows.Err()
+}
+
 func parseDBTime(s string) (time.Time, error) {
 	// Try standard formats
 	// SQLite driver usually uses RFC3339Nano or similar
@@ -640,6 +1440,62 @@ func (d *DB) GetRawStats() (*RawStats, error) {
 	}, nil
 }
 
+// StatsTotals are the data_stats totals across all raw and aggregated
+// results.
+type StatsTotals struct {
+	RawCount        int64 `json:"raw_count"`
+	RawBytes        int64 `json:"raw_bytes"`
+	AggregatedCount int64 `json:"aggregated_count"`
+	AggregatedBytes int64 `json:"aggregated_bytes"`
+}
+
+// RecomputeStats rebuilds data_stats by scanning raw_results and
+// aggregated_results, for when the triggers that maintain it have drifted.
+// It returns the corrected totals. Both tables are read in full, so it is
+// slow on a large database.
+func (d *DB) RecomputeStats() (*StatsTotals, error) {
+	tx, err := d.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The byte counts match the estimates the triggers use.
+	for _, query := range []string{
+		`DELETE FROM data_stats`,
+		`INSERT INTO data_stats (stat_key, row_count, total_bytes)
+		SELECT 'raw_count', COUNT(*), COUNT(*) * 50 FROM raw_results`,
+		`INSERT INTO data_stats (stat_key, row_count, total_bytes)
+		SELECT
+			'agg:' || target_id || ':' || window_seconds,
+			COUNT(*),
+			COALESCE(SUM(LENGTH(tdigest_data)), 0)
+		FROM aggregated_results
+		GROUP BY target_id, window_seconds`,
+	} {
+		if _, err := tx.Exec(query); err != nil {
+			return nil, err
+		}
+	}
+
+	var totals StatsTotals
+	err = tx.QueryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN stat_key = 'raw_count' THEN row_count END), 0),
+			COALESCE(SUM(CASE WHEN stat_key = 'raw_count' THEN total_bytes END), 0),
+			COALESCE(SUM(CASE WHEN stat_key LIKE 'agg:%' THEN row_count END), 0),
+			COALESCE(SUM(CASE WHEN stat_key LIKE 'agg:%' THEN total_bytes END), 0)
+		FROM data_stats
+	`).Scan(&totals.RawCount, &totals.RawBytes, &totals.AggregatedCount, &totals.AggregatedBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &totals, nil
+}
+
 const orphanedDataCleanupBatchLimit = 100000
 const orphanedDataCleanupDeleteChunkSize = 1000
 
@@ -955,6 +1811,17 @@ func (d *DB) GetDashboardGraphs(dashboardID int64) ([]DashboardGraph, error) {
 		if err := rows.Scan(&g.ID, &g.DashboardID, &g.Title, &g.Position); err != nil {
 			return nil, err
 		}
+		graphs = append(graphs, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// The graphs are read in full first: the writer pool has a single
+	// connection, which an open rows would keep busy.
+	rows.Close()
+
+	for i := range graphs {
+		g := &graphs[i]
 		// Fetch target IDs and names for this graph
 		targetRows, err := d.Query(`
 			SELECT dgt.target_id, t.name 
@@ -976,7 +1843,6 @@ func (d *DB) GetDashboardGraphs(dashboardID int64) ([]DashboardGraph, error) {
 			g.TargetNames[tid] = tName
 		}
 		targetRows.Close()
-		graphs = append(graphs, g)
 	}
 	return graphs, nil
 }
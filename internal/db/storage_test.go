@@ -3,6 +3,10 @@ package db
 import (
 	"database/sql"
 	"errors"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 )
@@ -58,6 +62,7 @@ func TestGetLastRollupTime(t *testing.T) {
 
 	id, _ := d.AddTarget(&Target{Name: "test", Address: "test", ProbeType: "http"})
 	now := time.Now().UTC()
+	later := now.Add(time.Hour)
 
 	// Add aggregated result
 	agg := &AggregatedResult{
@@ -70,7 +75,7 @@ func TestGetLastRollupTime(t *testing.T) {
 	d.AddAggregatedResult(agg)
 
 	// Get Last Rollup
-	last, err := d.GetLastRollupTime(id, 60)
+	last, err := d.GetLastRollupTime(id, 60, later)
 	if err != nil {
 		t.Fatalf("GetLastRollupTime failed: %v", err)
 	}
@@ -78,6 +83,29 @@ func TestGetLastRollupTime(t *testing.T) {
 	if last.Format(time.RFC3339) != now.Format(time.RFC3339) {
 		t.Errorf("Expected last rollup %v, got %v", now, last)
 	}
+
+	// A partial rollup of the next window doesn't count until it completes.
+	next := &AggregatedResult{Time: now.Add(time.Minute), TargetID: id, WindowSeconds: 60, Partial: true}
+	d.AddAggregatedResult(next)
+	if last, _ := d.GetLastRollupTime(id, 60, later); last.Format(time.RFC3339) != now.Format(time.RFC3339) {
+		t.Errorf("Expected a partial rollup to be ignored, got last rollup %v", last)
+	}
+	results, _ := d.GetAggregatedResults(id, 60, now, now.Add(2*time.Minute))
+	if len(results) != 2 || !results[1].Partial {
+		t.Errorf("Expected the partial rollup to be returned as partial, got %+v", results)
+	}
+	next.Partial = false
+	d.AddAggregatedResult(next)
+	if last, _ := d.GetLastRollupTime(id, 60, later); last.Format(time.RFC3339) != now.Add(time.Minute).Format(time.RFC3339) {
+		t.Errorf("Expected the completed rollup to count, got last rollup %v", last)
+	}
+	if last, _ := d.GetLastRollupTime(id, 300, later); !last.IsZero() {
+		t.Errorf("Expected no last rollup for an empty window, got %v", last)
+	}
+	// Rollups from before are the only ones considered.
+	if last, _ := d.GetLastRollupTime(id, 60, now.Add(time.Minute)); last.Format(time.RFC3339) != now.Format(time.RFC3339) {
+		t.Errorf("Expected the rollup at the bound to be ignored, got last rollup %v", last)
+	}
 }
 
 func TestDataStatsTriggers_RawResults(t *testing.T) {
@@ -260,6 +288,65 @@ func TestDataStatsTriggers_AggregatedResults(t *testing.T) {
 	}
 }
 
+func TestRecomputeStats(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	id, _ := d.AddTarget(&Target{Name: "TestTarget", Address: "test", ProbeType: "http"})
+	now := time.Now().UTC().Truncate(time.Minute)
+	if err := d.AddRawResults([]RawResult{
+		{Time: now.Add(-2 * time.Minute), TargetID: id, Latency: 100},
+		{Time: now.Add(-time.Minute), TargetID: id, Latency: 200},
+	}); err != nil {
+		t.Fatalf("AddRawResults failed: %v", err)
+	}
+	if err := d.AddAggregatedResult(&AggregatedResult{Time: now, TargetID: id, WindowSeconds: 60, TDigestData: make([]byte, 100)}); err != nil {
+		t.Fatalf("AddAggregatedResult failed: %v", err)
+	}
+
+	// Desync the stats as a lost trigger update would, and leave a stale key
+	// behind.
+	for _, query := range []string{
+		`UPDATE data_stats SET row_count = 7, total_bytes = 1 WHERE stat_key = 'raw_count'`,
+		`UPDATE data_stats SET row_count = 0, total_bytes = 0 WHERE stat_key LIKE 'agg:%'`,
+		`INSERT INTO data_stats (stat_key, row_count, total_bytes) VALUES ('agg:1:3600', 5, 500)`,
+	} {
+		if _, err := d.Exec(query); err != nil {
+			t.Fatalf("Failed to desync stats: %v", err)
+		}
+	}
+
+	totals, err := d.RecomputeStats()
+	if err != nil {
+		t.Fatalf("RecomputeStats failed: %v", err)
+	}
+	want := StatsTotals{RawCount: 2, RawBytes: 2 * 50, AggregatedCount: 1, AggregatedBytes: 100}
+	if *totals != want {
+		t.Errorf("Expected totals %+v, got %+v", want, *totals)
+	}
+
+	raw, err := d.GetRawStats()
+	if err != nil {
+		t.Fatalf("GetRawStats failed: %v", err)
+	}
+	if raw.Count != 2 || raw.TotalBytes != 2*50 {
+		t.Errorf("Expected 2 raw results of %d bytes, got %d of %d", 2*50, raw.Count, raw.TotalBytes)
+	}
+	tdStats, err := d.GetTDigestStats()
+	if err != nil {
+		t.Fatalf("GetTDigestStats failed: %v", err)
+	}
+	if len(tdStats) != 1 {
+		t.Fatalf("Expected 1 tdigest stat, got %d", len(tdStats))
+	}
+	if tdStats[0].WindowSeconds != 60 || tdStats[0].Count != 1 || tdStats[0].TotalBytes != 100 {
+		t.Errorf("Expected 1 60s rollup of 100 bytes, got %+v", tdStats[0])
+	}
+}
+
 func TestForeignKeysEnabled(t *testing.T) {
 	d, err := New(":memory:")
 	if err != nil {
@@ -764,3 +851,866 @@ func TestTDigestStats_EstimatedTotalBytes(t *testing.T) {
 		t.Errorf("Expected EstimatedTotalBytes %d, got %d", expectedEstimate3600, stat3600.EstimatedTotalBytes)
 	}
 }
+
+func TestOpenReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vaportrail.db")
+	d, err := New(path)
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	ro, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("OpenReadOnly failed: %v", err)
+	}
+	defer ro.Close()
+
+	id, err := d.AddTarget(&Target{Name: "test", Address: "test", ProbeType: "http"})
+	if err != nil {
+		t.Fatalf("AddTarget failed: %v", err)
+	}
+	now := time.Now().UTC()
+	if err := d.AddRawResults([]RawResult{{Time: now, TargetID: id, Latency: 100}}); err != nil {
+		t.Fatalf("AddRawResults failed: %v", err)
+	}
+
+	// The reader should see writes committed through the primary connection.
+	raws, err := ro.GetRawResults(id, now.Add(-time.Minute), now.Add(time.Minute), 10)
+	if err != nil {
+		t.Fatalf("GetRawResults on read-only db failed: %v", err)
+	}
+	if len(raws) != 1 {
+		t.Errorf("Expected 1 raw result from read-only db, got %d", len(raws))
+	}
+
+	if _, err := ro.AddTarget(&Target{Name: "nope", Address: "test", ProbeType: "http"}); err == nil {
+		t.Error("Expected write through read-only db to fail")
+	}
+}
+
+func TestGetResultsByTimeLimitAndOrder(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	targetID, err := d.AddTarget(&Target{Name: "test", Address: "test", ProbeType: "http"})
+	if err != nil {
+		t.Fatalf("AddTarget failed: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < 5; i++ {
+		if err := d.AddResult(&Result{Time: now.Add(time.Duration(i) * time.Minute), TargetID: targetID, TimeoutCount: int64(i)}); err != nil {
+			t.Fatalf("AddResult failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		limit int
+		order string
+		want  []int64
+	}{
+		{0, "", []int64{0, 1, 2, 3, 4}},
+		{2, OrderAsc, []int64{0, 1}},
+		{2, OrderDesc, []int64{4, 3}},
+	}
+	for _, tt := range tests {
+		results, err := d.GetResultsByTime(targetID, now, now.Add(time.Hour), tt.limit, tt.order)
+		if err != nil {
+			t.Fatalf("GetResultsByTime(%d, %q) failed: %v", tt.limit, tt.order, err)
+		}
+		var got []int64
+		for _, r := range results {
+			got = append(got, r.TimeoutCount)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("GetResultsByTime(%d, %q) = %v, want %v", tt.limit, tt.order, got, tt.want)
+		}
+	}
+
+	if _, err := d.GetResultsByTime(targetID, now, now.Add(time.Hour), 0, "random"); err == nil {
+		t.Error("Expected an invalid order to be rejected")
+	}
+}
+
+func TestDeleteResultsBefore(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	keepID, _ := d.AddTarget(&Target{Name: "keep", Address: "keep", ProbeType: "http"})
+	trimID, _ := d.AddTarget(&Target{Name: "trim", Address: "trim", ProbeType: "http"})
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, id := range []int64{keepID, trimID} {
+		for i := 0; i < 3; i++ {
+			if err := d.AddResult(&Result{Time: now.Add(-time.Duration(i) * time.Hour), TargetID: id, TimeoutCount: int64(i)}); err != nil {
+				t.Fatalf("AddResult failed: %v", err)
+			}
+		}
+	}
+
+	if err := d.DeleteResultsBefore(trimID, now.Add(-90*time.Minute)); err != nil {
+		t.Fatalf("DeleteResultsBefore failed: %v", err)
+	}
+
+	results, _ := d.GetResultsByTime(trimID, now.Add(-24*time.Hour), now, 0, OrderAsc)
+	var got []int64
+	for _, r := range results {
+		got = append(got, r.TimeoutCount)
+	}
+	if !slices.Equal(got, []int64{1, 0}) {
+		t.Errorf("Expected the results of the last 90 minutes to be kept, got %v", got)
+	}
+	if results, _ := d.GetResults(keepID, 10); len(results) != 3 {
+		t.Errorf("Expected other targets' results to be kept, got %d", len(results))
+	}
+}
+
+func TestGetRawResultsAfter(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	id, _ := d.AddTarget(&Target{Name: "a", Address: "a", ProbeType: "http"})
+	other, _ := d.AddTarget(&Target{Name: "b", Address: "b", ProbeType: "http"})
+	now := time.Now().UTC().Truncate(time.Second)
+	// Two results at the same time, and one of another target in between.
+	batch := []RawResult{
+		{Time: now, TargetID: id, Latency: 1},
+		{Time: now, TargetID: id, Latency: 2},
+		{Time: now, TargetID: other, Latency: 100},
+		{Time: now.Add(time.Second), TargetID: id, Latency: 3},
+	}
+	if err := d.AddRawResults(batch); err != nil {
+		t.Fatalf("AddRawResults failed: %v", err)
+	}
+	for i := 1; i < len(batch); i++ {
+		if batch[i].ID <= batch[i-1].ID {
+			t.Fatalf("Expected AddRawResults to assign increasing IDs, got %d after %d", batch[i].ID, batch[i-1].ID)
+		}
+	}
+
+	var latencies []float64
+	var cursor int64
+	for {
+		page, err := d.GetRawResultsAfter(id, cursor, now, now.Add(time.Minute), 2)
+		if err != nil {
+			t.Fatalf("GetRawResultsAfter failed: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, r := range page {
+			latencies = append(latencies, r.Latency)
+		}
+		cursor = page[len(page)-1].ID
+	}
+	if want := []float64{1, 2, 3}; !slices.Equal(latencies, want) {
+		t.Errorf("Expected pages to hold %v, got %v", want, latencies)
+	}
+
+	results, err := d.GetRawResults(id, now, now.Add(time.Minute), 0)
+	if err != nil {
+		t.Fatalf("GetRawResults failed: %v", err)
+	}
+	if len(results) != 3 || results[0].ID != batch[0].ID || results[1].ID != batch[1].ID {
+		t.Errorf("Expected GetRawResults to return IDs in order, got %+v", results)
+	}
+}
+
+func TestGetRawBuckets(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+	id, err := d.AddTarget(&Target{Name: "t", Address: "127.0.0.1", ProbeType: "ping", ProbeInterval: 1, Timeout: 1})
+	if err != nil {
+		t.Fatalf("AddTarget failed: %v", err)
+	}
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	var raw []RawResult
+	for i, latency := range []float64{10, 20, 30, -1, 40, -1} {
+		// Two results a minute.
+		raw = append(raw, RawResult{Time: start.Add(time.Duration(i) * 30 * time.Second), TargetID: id, Latency: latency})
+	}
+	if err := d.AddRawResults(raw); err != nil {
+		t.Fatalf("AddRawResults failed: %v", err)
+	}
+
+	buckets, err := d.GetRawBuckets(id, start, start.Add(time.Hour), time.Minute)
+	if err != nil {
+		t.Fatalf("GetRawBuckets failed: %v", err)
+	}
+	want := []RawBucket{
+		{Time: start, Count: 2, MinNS: 10, AvgNS: 15, MaxNS: 20},
+		{Time: start.Add(time.Minute), Count: 1, TimeoutCount: 1, MinNS: 30, AvgNS: 30, MaxNS: 30},
+		{Time: start.Add(2 * time.Minute), Count: 1, TimeoutCount: 1, MinNS: 40, AvgNS: 40, MaxNS: 40},
+	}
+	if !slices.Equal(buckets, want) {
+		t.Errorf("Expected %+v, got %+v", want, buckets)
+	}
+
+	if _, err := d.GetRawBuckets(id, start, start.Add(time.Hour), time.Millisecond); err == nil {
+		t.Error("Expected error for a sub-second bucket")
+	}
+}
+
+func TestRawMetrics(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	id, _ := d.AddTarget(&Target{Name: "test", Address: "test", ProbeType: "http"})
+	now := time.Now().UTC()
+	if err := d.AddRawResults([]RawResult{
+		{Time: now.Add(-10 * time.Minute), TargetID: id, Latency: 100, Metrics: map[string]float64{"bytes": 10, "rate": 1}},
+		{Time: now.Add(-5 * time.Minute), TargetID: id, Latency: -1},
+		{Time: now.Add(-1 * time.Minute), TargetID: id, Latency: 200, Metrics: map[string]float64{"bytes": 20}},
+	}); err != nil {
+		t.Fatalf("AddRawResults failed: %v", err)
+	}
+
+	points, err := d.GetRawMetrics(id, "bytes", now.Add(-time.Hour), now, 0)
+	if err != nil {
+		t.Fatalf("GetRawMetrics failed: %v", err)
+	}
+	if len(points) != 2 || points[0].Value != 10 || points[1].Value != 20 {
+		t.Errorf("Unexpected points: %+v", points)
+	}
+	if points, _ := d.GetRawMetrics(id, "bytes", now.Add(-time.Hour), now, 1); len(points) != 1 || points[0].Value != 20 {
+		t.Errorf("Expected the limit to keep the latest value, got %+v", points)
+	}
+	if points, _ := d.GetRawMetrics(id, "", now.Add(-time.Hour), now, 0); len(points) != 3 || points[2].Name != "bytes" || points[2].Value != 20 {
+		t.Errorf("Expected every metric by name, got %+v", points)
+	}
+
+	// Metrics share the raw results' retention.
+	if err := d.DeleteRawResultsBefore(id, now.Add(-2*time.Minute)); err != nil {
+		t.Fatalf("DeleteRawResultsBefore failed: %v", err)
+	}
+	if points, _ := d.GetRawMetrics(id, "bytes", now.Add(-time.Hour), now, 0); len(points) != 1 {
+		t.Errorf("Expected 1 value after retention, got %+v", points)
+	}
+	if points, _ := d.GetRawMetrics(id, "rate", now.Add(-time.Hour), now, 0); len(points) != 0 {
+		t.Errorf("Expected no rate values after retention, got %+v", points)
+	}
+
+	if err := d.DeleteTarget(id); err != nil {
+		t.Fatalf("DeleteTarget failed: %v", err)
+	}
+	var count int
+	d.QueryRow(`SELECT COUNT(*) FROM raw_metrics`).Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected metrics to be deleted with the target, %d left", count)
+	}
+}
+
+func TestAggregatedMetrics(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	id, _ := d.AddTarget(&Target{Name: "test", Address: "test", ProbeType: "http"})
+	now := time.Now().UTC().Truncate(time.Minute)
+	d.AddRawResults([]RawResult{
+		{Time: now.Add(-90 * time.Second), TargetID: id, Latency: 100, Metrics: map[string]float64{"bytes": 10, "rate": 1}},
+		{Time: now.Add(-30 * time.Second), TargetID: id, Latency: 200, Metrics: map[string]float64{"bytes": 20}},
+	})
+	values, err := d.GetRawMetricValues(id, now.Add(-time.Hour), now)
+	if err != nil {
+		t.Fatalf("GetRawMetricValues failed: %v", err)
+	}
+	if len(values["bytes"]) != 2 || values["bytes"][0] != 10 || len(values["rate"]) != 1 {
+		t.Errorf("Unexpected raw metric values: %v", values)
+	}
+
+	var results []*AggregatedResult
+	for i, start := range []time.Time{now.Add(-2 * time.Minute), now.Add(-time.Minute)} {
+		results = append(results, &AggregatedResult{
+			Time: start, TargetID: id, WindowSeconds: 60,
+			Metrics: []AggregatedMetric{
+				{Time: start, TargetID: id, WindowSeconds: 60, Name: "bytes", SampleCount: 1, Sum: float64(10 * (i + 1))},
+				{Time: start, TargetID: id, WindowSeconds: 60, Name: "rate", SampleCount: 1, Sum: 1},
+			},
+		})
+	}
+	if err := d.AddAggregatedResults(results); err != nil {
+		t.Fatalf("AddAggregatedResults failed: %v", err)
+	}
+	// Rewriting a window replaces its metric rollups.
+	results[1].Metrics[0].Sum = 25
+	if err := d.AddAggregatedResult(results[1]); err != nil {
+		t.Fatalf("AddAggregatedResult failed: %v", err)
+	}
+
+	metrics, err := d.GetAggregatedMetrics(id, 60, "bytes", now.Add(-time.Hour), now)
+	if err != nil {
+		t.Fatalf("GetAggregatedMetrics failed: %v", err)
+	}
+	if len(metrics) != 2 || metrics[0].Sum != 10 || metrics[1].Sum != 25 {
+		t.Errorf("Unexpected bytes rollups: %+v", metrics)
+	}
+	if all, _ := d.GetAggregatedMetrics(id, 60, "", now.Add(-time.Hour), now); len(all) != 4 {
+		t.Errorf("Expected 4 rollups of every metric, got %d", len(all))
+	}
+
+	// Metric rollups share their window's retention.
+	if err := d.DeleteAggregatedResultsKeepingLast(id, 60, 1); err != nil {
+		t.Fatalf("DeleteAggregatedResultsKeepingLast failed: %v", err)
+	}
+	if all, _ := d.GetAggregatedMetrics(id, 60, "", now.Add(-time.Hour), now); len(all) != 2 {
+		t.Errorf("Expected 2 rollups after max_rows retention, got %d", len(all))
+	}
+	if err := d.DeleteAggregatedResultsBefore(id, 60, now); err != nil {
+		t.Fatalf("DeleteAggregatedResultsBefore failed: %v", err)
+	}
+	if all, _ := d.GetAggregatedMetrics(id, 60, "", now.Add(-time.Hour), now); len(all) != 0 {
+		t.Errorf("Expected no rollups after retention, got %d", len(all))
+	}
+}
+
+func TestRawResultMetadata(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	id, _ := d.AddTarget(&Target{Name: "test", Address: "test", ProbeType: "http", RecordMetadata: true})
+	if target, err := d.GetTarget(id); err != nil || !target.RecordMetadata {
+		t.Fatalf("Expected RecordMetadata to be stored, got %+v (%v)", target, err)
+	}
+	now := time.Now().UTC()
+	if err := d.AddRawResults([]RawResult{
+		{Time: now.Add(-2 * time.Minute), TargetID: id, Latency: -1, Metadata: `{"http_status":"503"}`},
+		{Time: now.Add(-1 * time.Minute), TargetID: id, Latency: 200},
+	}); err != nil {
+		t.Fatalf("AddRawResults failed: %v", err)
+	}
+
+	for _, limit := range []int{0, 10} {
+		results, err := d.GetRawResults(id, now.Add(-time.Hour), now, limit)
+		if err != nil {
+			t.Fatalf("GetRawResults failed: %v", err)
+		}
+		if len(results) != 2 || results[0].Metadata != `{"http_status":"503"}` || results[1].Metadata != "" {
+			t.Errorf("limit %d: unexpected metadata in %+v", limit, results)
+		}
+	}
+	results, err := d.GetRawResultsPage(id, now.Add(-time.Hour), now, 1, OrderAsc)
+	if err != nil || len(results) != 1 || results[0].Metadata != `{"http_status":"503"}` {
+		t.Errorf("Expected metadata from GetRawResultsPage, got %+v (%v)", results, err)
+	}
+	var stored int
+	d.QueryRow(`SELECT COUNT(*) FROM raw_results WHERE metadata IS NOT NULL`).Scan(&stored)
+	if stored != 1 {
+		t.Errorf("Expected results without metadata to store NULL, got %d non-NULL", stored)
+	}
+}
+
+func TestTargetAlignProbes(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	target := &Target{Name: "test", Address: "test", ProbeType: "http", AlignProbes: true}
+	id, _ := d.AddTarget(target)
+	if got, err := d.GetTarget(id); err != nil || !got.AlignProbes {
+		t.Fatalf("Expected AlignProbes to be stored, got %+v (%v)", got, err)
+	}
+	target.ID = id
+	target.AlignProbes = false
+	if err := d.UpdateTarget(target); err != nil {
+		t.Fatalf("UpdateTarget failed: %v", err)
+	}
+	if got, _ := d.GetTarget(id); got.AlignProbes {
+		t.Errorf("Expected UpdateTarget to clear AlignProbes")
+	}
+}
+
+func TestGetLatestResults(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	a, _ := d.AddTarget(&Target{Name: "a", Address: "a", ProbeType: "http"})
+	b, _ := d.AddTarget(&Target{Name: "b", Address: "b", ProbeType: "http"})
+	d.AddTarget(&Target{Name: "empty", Address: "c", ProbeType: "http"})
+	d.AddAggregatedResults([]*AggregatedResult{
+		{Time: now.Add(-2 * time.Minute), TargetID: a, WindowSeconds: 60, TimeoutCount: 1},
+		{Time: now.Add(-time.Minute), TargetID: a, WindowSeconds: 60, TimeoutCount: 2},
+		{Time: now, TargetID: a, WindowSeconds: 60, TimeoutCount: 3, Partial: true},
+		{Time: now, TargetID: a, WindowSeconds: 3600, TimeoutCount: 4},
+		{Time: now.Add(-time.Hour), TargetID: b, WindowSeconds: 300, TimeoutCount: 5},
+	})
+
+	results, err := d.GetLatestResults()
+	if err != nil {
+		t.Fatalf("GetLatestResults failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected results for the 2 targets with rollups, got %+v", results)
+	}
+	// The finest window's newest complete rollup, skipping the partial one.
+	if results[0].TargetID != a || results[0].WindowSeconds != 60 || results[0].TimeoutCount != 2 {
+		t.Errorf("Unexpected latest result for a: %+v", results[0])
+	}
+	if results[1].TargetID != b || results[1].WindowSeconds != 300 || !results[1].Time.Equal(now.Add(-time.Hour)) {
+		t.Errorf("Unexpected latest result for b: %+v", results[1])
+	}
+}
+
+func TestAggregatedResultCompleteness(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	id, _ := d.AddTarget(&Target{Name: "a", Address: "a", ProbeType: "http"})
+	d.AddAggregatedResults([]*AggregatedResult{
+		{Time: now, TargetID: id, WindowSeconds: 60, SourceWindows: 5, ExpectedSourceWindows: 6},
+		{Time: now.Add(time.Minute), TargetID: id, WindowSeconds: 60},
+	})
+
+	results, err := d.GetAggregatedResults(id, 60, now, now.Add(2*time.Minute))
+	if err != nil || len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d (%v)", len(results), err)
+	}
+	if results[0].SourceWindows != 5 || results[0].ExpectedSourceWindows != 6 {
+		t.Errorf("Expected 5 of 6 source windows, got %+v", results[0])
+	}
+	if c := results[0].Completeness(); c != 5.0/6 {
+		t.Errorf("Expected completeness %v, got %v", 5.0/6, c)
+	}
+	if c := results[1].Completeness(); c != 1 {
+		t.Errorf("Expected an untracked rollup to be complete, got %v", c)
+	}
+}
+
+func TestAggregatedResultPercentiles(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	id, _ := d.AddTarget(&Target{Name: "a", Address: "a", ProbeType: "http"})
+	stored := map[string]float64{PercentileName(50): 1e6, PercentileName(99.9): 5e6}
+	d.AddAggregatedResult(&AggregatedResult{Time: now, TargetID: id, WindowSeconds: 60, Percentiles: stored})
+	d.AddAggregatedResults([]*AggregatedResult{{Time: now.Add(time.Minute), TargetID: id, WindowSeconds: 60}})
+
+	results, err := d.GetAggregatedResults(id, 60, now, now.Add(2*time.Minute))
+	if err != nil || len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d (%v)", len(results), err)
+	}
+	if !maps.Equal(results[0].Percentiles, map[string]float64{"p50": 1e6, "p99.9": 5e6}) {
+		t.Errorf("Expected the stored percentiles back, got %v", results[0].Percentiles)
+	}
+	if results[1].Percentiles != nil {
+		t.Errorf("Expected no percentiles, got %v", results[1].Percentiles)
+	}
+}
+
+func TestAddAggregatedResultsReplacesWindow(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	id, _ := d.AddTarget(&Target{Name: "a", Address: "a", ProbeType: "http"})
+	// Reprocessing a window writes it again; the second write wins.
+	if err := d.AddAggregatedResults([]*AggregatedResult{{Time: now, TargetID: id, WindowSeconds: 60, TimeoutCount: 1, SampleCount: 10, SumNS: 1000}}); err != nil {
+		t.Fatalf("First write failed: %v", err)
+	}
+	if err := d.AddAggregatedResults([]*AggregatedResult{{Time: now, TargetID: id, WindowSeconds: 60, TimeoutCount: 2, SampleCount: 20, SumNS: 3000}}); err != nil {
+		t.Fatalf("Second write failed: %v", err)
+	}
+
+	var rows int
+	if err := d.QueryRow("SELECT COUNT(*) FROM aggregated_results WHERE target_id = ? AND window_seconds = 60", id).Scan(&rows); err != nil {
+		t.Fatalf("Failed to count rows: %v", err)
+	}
+	if rows != 1 {
+		t.Fatalf("Expected 1 row, got %d", rows)
+	}
+	results, err := d.GetAggregatedResults(id, 60, now, now.Add(time.Minute))
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d (%v)", len(results), err)
+	}
+	if r := results[0]; r.TimeoutCount != 2 || r.SampleCount != 20 || r.SumNS != 3000 {
+		t.Errorf("Expected the second write, got %+v", r)
+	}
+}
+
+func TestGetLastRawResultTimes(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	a, _ := d.AddTarget(&Target{Name: "a", Address: "a", ProbeType: "http"})
+	empty, _ := d.AddTarget(&Target{Name: "empty", Address: "b", ProbeType: "http"})
+	d.AddRawResults([]RawResult{
+		{Time: now.Add(-time.Minute), TargetID: a, Latency: 1},
+		{Time: now, TargetID: a, Latency: -1},
+		{Time: now.Add(-2 * time.Minute), TargetID: a, Latency: 2},
+	})
+
+	times, err := d.GetLastRawResultTimes()
+	if err != nil {
+		t.Fatalf("GetLastRawResultTimes failed: %v", err)
+	}
+	if !times[a].Equal(now) {
+		t.Errorf("Expected the latest result at %v, got %v", now, times[a])
+	}
+	if _, ok := times[empty]; ok || len(times) != 1 {
+		t.Errorf("Expected no time for a target without results, got %v", times)
+	}
+}
+
+func TestDeleteResultsRange(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	id, _ := d.AddTarget(&Target{Name: "test", Address: "test", ProbeType: "http"})
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var raw []RawResult
+	for i := range 4 {
+		raw = append(raw, RawResult{Time: base.Add(time.Duration(i) * time.Minute), TargetID: id, Latency: 100, Metrics: map[string]float64{"bytes": 1}})
+	}
+	if err := d.AddRawResults(raw); err != nil {
+		t.Fatalf("AddRawResults failed: %v", err)
+	}
+	for _, window := range []int{60, 3600} {
+		for i := range 4 {
+			if err := d.AddAggregatedResult(&AggregatedResult{Time: base.Add(time.Duration(i) * time.Minute), TargetID: id, WindowSeconds: window}); err != nil {
+				t.Fatalf("AddAggregatedResult failed: %v", err)
+			}
+		}
+	}
+
+	// [1m, 3m) covers the second and third of each.
+	start, end := base.Add(time.Minute), base.Add(3*time.Minute)
+	if n, err := d.DeleteRawResultsRange(id, start, end); err != nil || n != 2 {
+		t.Errorf("DeleteRawResultsRange = %d, %v; want 2", n, err)
+	}
+	if points, _ := d.GetRawMetrics(id, "bytes", base, base.Add(time.Hour), 0); len(points) != 2 {
+		t.Errorf("Expected metrics in the range to be deleted, got %+v", points)
+	}
+	if n, err := d.DeleteAggregatedResultsRange(id, 60, start, end); err != nil || n != 2 {
+		t.Errorf("DeleteAggregatedResultsRange(60) = %d, %v; want 2", n, err)
+	}
+	if n, err := d.DeleteAggregatedResultsRange(id, 0, base, end); err != nil || n != 4 {
+		t.Errorf("DeleteAggregatedResultsRange(all) = %d, %v; want 4", n, err)
+	}
+	var count int
+	d.QueryRow(`SELECT COUNT(*) FROM aggregated_results WHERE target_id = ?`, id).Scan(&count)
+	if count != 2 {
+		t.Errorf("Expected 2 aggregated results left, got %d", count)
+	}
+}
+
+func TestSetPageCache(t *testing.T) {
+	t.Cleanup(func() { SetPageCache(0, 0) })
+	if err := SetPageCache(-1, 0); err == nil {
+		t.Errorf("Expected error for a negative cache size")
+	}
+	if err := SetPageCache(4096, 1<<20); err != nil {
+		t.Fatalf("SetPageCache failed: %v", err)
+	}
+
+	for _, path := range []string{":memory:", filepath.Join(t.TempDir(), "cache.db")} {
+		d, err := New(path)
+		if err != nil {
+			t.Fatalf("New(%s) failed: %v", path, err)
+		}
+		defer d.Close()
+		var cacheSize int64
+		if err := d.QueryRow("PRAGMA cache_size").Scan(&cacheSize); err != nil || cacheSize != -4096 {
+			t.Errorf("%s: expected cache_size -4096, got %d (%v)", path, cacheSize, err)
+		}
+		if path == ":memory:" {
+			continue
+		}
+		var mmapSize int64
+		if err := d.QueryRow("PRAGMA mmap_size").Scan(&mmapSize); err != nil || mmapSize != 1<<20 {
+			t.Errorf("%s: expected mmap_size %d, got %d (%v)", path, 1<<20, mmapSize, err)
+		}
+	}
+}
+
+func TestSetConnectionPool(t *testing.T) {
+	t.Cleanup(func() { SetConnectionPool(DefaultReadConns, 0) })
+	if err := SetConnectionPool(0, 0); err == nil {
+		t.Errorf("Expected error for no read connections")
+	}
+	if err := SetConnectionPool(2, -time.Second); err == nil {
+		t.Errorf("Expected error for a negative lifetime")
+	}
+	if err := SetConnectionPool(2, time.Hour); err != nil {
+		t.Fatalf("SetConnectionPool failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "pool.db")
+	d, err := New(path)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer d.Close()
+	ro, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("OpenReadOnly failed: %v", err)
+	}
+	defer ro.Close()
+	if n := d.Stats().MaxOpenConnections; n != 1 {
+		t.Errorf("Expected 1 writer connection, got %d", n)
+	}
+	if n := ro.Stats().MaxOpenConnections; n != 2 {
+		t.Errorf("Expected 2 read connections, got %d", n)
+	}
+}
+
+// TestConcurrentWrites checks that writers racing each other, as probes,
+// rollups and API requests do, queue on the single writer connection instead
+// of failing with "database is locked", while readers carry on alongside.
+// RecomputeStats stands in for a transaction that reads before it writes.
+func TestConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "concurrent.db")
+	d, err := New(path)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer d.Close()
+	ro, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("OpenReadOnly failed: %v", err)
+	}
+	defer ro.Close()
+
+	id, err := d.AddTarget(&Target{Name: "t", Address: "127.0.0.1", ProbeType: "ping", ProbeInterval: 1, Timeout: 1})
+	if err != nil {
+		t.Fatalf("AddTarget failed: %v", err)
+	}
+
+	const writers, batches, batchSize = 8, 20, 50
+	start := time.Now().Truncate(time.Second)
+	errs := make(chan error, 3*writers*batches)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for b := 0; b < batches; b++ {
+				batch := make([]RawResult, batchSize)
+				for i := range batch {
+					n := (w*batches+b)*batchSize + i
+					batch[i] = RawResult{Time: start.Add(time.Duration(n) * time.Millisecond), TargetID: id, Latency: float64(n)}
+				}
+				if err := d.AddRawResults(batch); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for b := 0; b < batches; b++ {
+				if _, err := ro.GetRawResults(id, start, start.Add(time.Hour), 100); err != nil {
+					errs <- err
+				}
+				if b%5 == 0 {
+					if _, err := d.RecomputeStats(); err != nil {
+						errs <- err
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Concurrent access failed: %v", err)
+	}
+
+	var count int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM raw_results WHERE target_id = ?`, id).Scan(&count); err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+	if want := writers * batches * batchSize; count != want {
+		t.Errorf("Expected %d raw results, got %d", want, count)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	if v, err := d.GetSetting(SettingRetentionPolicies); err != nil || v != "" {
+		t.Fatalf("Expected an unset setting, got %q (%v)", v, err)
+	}
+	for _, want := range []string{`[{"window":0,"retention":60}]`, `[{"window":0,"retention":120}]`} {
+		if err := d.SetSetting(SettingRetentionPolicies, want); err != nil {
+			t.Fatalf("SetSetting failed: %v", err)
+		}
+		if v, _ := d.GetSetting(SettingRetentionPolicies); v != want {
+			t.Errorf("Expected %q, got %q", want, v)
+		}
+	}
+	if err := d.SetSetting(SettingRetentionPolicies, ""); err != nil {
+		t.Fatalf("SetSetting failed: %v", err)
+	}
+	if v, _ := d.GetSetting(SettingRetentionPolicies); v != "" {
+		t.Errorf("Expected the setting to be unset, got %q", v)
+	}
+}
+
+func TestBaselines(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+	id, err := d.AddTarget(&Target{Name: "t", Address: "127.0.0.1", ProbeType: "ping", ProbeInterval: 1, Timeout: 1})
+	if err != nil {
+		t.Fatalf("AddTarget failed: %v", err)
+	}
+
+	if b, err := d.GetBaseline(id); err != nil || b != nil {
+		t.Fatalf("Expected no baseline, got %+v (%v)", b, err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, want := range []Baseline{
+		{TargetID: id, LatencyNS: 1e6, UpdatedAt: now},
+		{TargetID: id, LatencyNS: 2e6, Manual: true, UpdatedAt: now.Add(time.Minute)},
+	} {
+		if err := d.SetBaseline(&want); err != nil {
+			t.Fatalf("SetBaseline failed: %v", err)
+		}
+		b, err := d.GetBaseline(id)
+		if err != nil || b == nil {
+			t.Fatalf("GetBaseline failed: %v", err)
+		}
+		if b.LatencyNS != want.LatencyNS || b.Manual != want.Manual || !b.UpdatedAt.Equal(want.UpdatedAt) {
+			t.Errorf("Expected %+v, got %+v", want, *b)
+		}
+	}
+
+	if err := d.DeleteTarget(id); err != nil {
+		t.Fatalf("DeleteTarget failed: %v", err)
+	}
+	if b, err := d.GetBaseline(id); err != nil || b != nil {
+		t.Errorf("Expected the baseline to go with its target, got %+v (%v)", b, err)
+	}
+}
+
+func TestAddTargets(t *testing.T) {
+	d, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	targets := []*Target{
+		{Name: "a", Address: "a.example", ProbeType: "http"},
+		{Name: "b", Address: "b.example", ProbeType: "dns", ProbeInterval: 10, Schedule: "daily 09:00-17:00"},
+	}
+	if err := d.AddTargets(targets); err != nil {
+		t.Fatalf("AddTargets failed: %v", err)
+	}
+	for _, want := range targets {
+		got, err := d.GetTarget(want.ID)
+		if err != nil {
+			t.Fatalf("GetTarget(%d) failed: %v", want.ID, err)
+		}
+		if *got != *want {
+			t.Errorf("Expected %+v, got %+v", *want, *got)
+		}
+	}
+	if targets[0].ProbeInterval != 1 || targets[0].Timeout != 5 {
+		t.Errorf("Expected the default interval and timeout, got %+v", *targets[0])
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	d, err := New(filepath.Join(t.TempDir(), "wal.db"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer d.Close()
+
+	id, err := d.AddTarget(&Target{Name: "t", Address: "127.0.0.1", ProbeType: "ping", ProbeInterval: 1, Timeout: 1})
+	if err != nil {
+		t.Fatalf("AddTarget failed: %v", err)
+	}
+	start := time.Now().Truncate(time.Second)
+	batch := make([]RawResult, 1000)
+	for i := range batch {
+		batch[i] = RawResult{Time: start.Add(time.Duration(i) * time.Millisecond), TargetID: id, Latency: float64(i)}
+	}
+	if err := d.AddRawResults(batch); err != nil {
+		t.Fatalf("AddRawResults failed: %v", err)
+	}
+
+	size, err := d.GetWALSizeBytes()
+	if err != nil || size == 0 {
+		t.Fatalf("Expected a WAL after writing, got %d bytes (%v)", size, err)
+	}
+	passive, err := d.Checkpoint(false)
+	if err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	if passive.Busy || passive.LogFrames == 0 || passive.Checkpointed != passive.LogFrames {
+		t.Errorf("Expected a passive checkpoint to copy the whole log, got %+v", passive)
+	}
+	if after, _ := d.GetWALSizeBytes(); after != size {
+		t.Errorf("Expected a passive checkpoint to leave the WAL at %d bytes, got %d", size, after)
+	}
+
+	if _, err := d.Checkpoint(true); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	if after, _ := d.GetWALSizeBytes(); after != 0 {
+		t.Errorf("Expected a truncating checkpoint to empty the WAL, got %d bytes", after)
+	}
+
+	mem, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer mem.Close()
+	if size, err := mem.GetWALSizeBytes(); err != nil || size != 0 {
+		t.Errorf("Expected no WAL for an in-memory database, got %d bytes (%v)", size, err)
+	}
+	if _, err := mem.Checkpoint(false); err != nil {
+		t.Errorf("Expected checkpointing an in-memory database to do nothing, got %v", err)
+	}
+}
@@ -2,13 +2,69 @@ package db
 
 import (
 	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync/atomic"
 
 	"github.com/caio/go-tdigest/v4"
 )
 
+// Stored digests start with a codec byte. Uncompressed digests have none:
+// the go-tdigest encoding starts with a big-endian int32 version, so their
+// first byte is always 0, which is what lets blobs written before
+// compression existed be read unchanged.
+const (
+	codecGzip byte = 1
+)
+
+// TDigest compression settings accepted by SetTDigestCompression.
+const (
+	TDigestCompressionNone = "none"
+	TDigestCompressionGzip = "gzip"
+)
+
+// tdigestCodec is the codec new digests are written with; 0 means none.
+var tdigestCodec atomic.Uint32
+
+// SetTDigestCompression chooses how SerializeTDigest compresses digests.
+// Digests are always readable whatever the setting, so it can be changed at
+// any time; existing rows keep their encoding until they are rewritten.
+func SetTDigestCompression(compression string) error {
+	switch compression {
+	case "", TDigestCompressionNone:
+		tdigestCodec.Store(0)
+	case TDigestCompressionGzip:
+		tdigestCodec.Store(uint32(codecGzip))
+	default:
+		return fmt.Errorf("unknown t-digest compression %q (expected %q or %q)", compression, TDigestCompressionNone, TDigestCompressionGzip)
+	}
+	return nil
+}
+
 // SerializeTDigest serializes the T-Digest to bytes for storage.
 func SerializeTDigest(td *tdigest.TDigest) ([]byte, error) {
-	return td.AsBytes()
+	data, err := td.AsBytes()
+	if err != nil {
+		return nil, err
+	}
+	if byte(tdigestCodec.Load()) != codecGzip {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(codecGzip)
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(data) {
+		return data, nil // Small digests don't cover the gzip framing.
+	}
+	return buf.Bytes(), nil
 }
 
 // DeserializeTDigest deserializes bytes to a T-Digest.
@@ -17,5 +73,26 @@ func DeserializeTDigest(data []byte) (*tdigest.TDigest, error) {
 	if len(data) == 0 {
 		return tdigest.New(tdigest.Compression(100))
 	}
+	data, err := DecompressTDigest(data)
+	if err != nil {
+		return nil, err
+	}
 	return tdigest.FromBytes(bytes.NewReader(data))
 }
+
+// DecompressTDigest returns a stored digest in the uncompressed go-tdigest
+// encoding, whichever codec it was written with.
+func DecompressTDigest(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] == 0 {
+		return data, nil
+	}
+	switch data[0] {
+	case codecGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data[1:]))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(zr)
+	}
+	return nil, fmt.Errorf("unknown t-digest codec %d", data[0])
+}
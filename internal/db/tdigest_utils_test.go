@@ -0,0 +1,87 @@
+package db
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func sampleDigest(t *testing.T, n int) *tdigest.TDigest {
+	t.Helper()
+	td, err := tdigest.New(tdigest.Compression(100))
+	if err != nil {
+		t.Fatalf("Failed to create digest: %v", err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		td.Add(20e6 + rng.ExpFloat64()*5e6) // ~20ms with a long tail, in ns
+	}
+	return td
+}
+
+func TestTDigestCompressionRoundTrip(t *testing.T) {
+	t.Cleanup(func() { SetTDigestCompression(TDigestCompressionNone) })
+	td := sampleDigest(t, 10000)
+	want, _ := td.AsBytes()
+
+	sizes := map[string]int{}
+	for _, compression := range []string{TDigestCompressionNone, TDigestCompressionGzip} {
+		if err := SetTDigestCompression(compression); err != nil {
+			t.Fatalf("SetTDigestCompression(%q) failed: %v", compression, err)
+		}
+		data, err := SerializeTDigest(td)
+		if err != nil {
+			t.Fatalf("%s: SerializeTDigest failed: %v", compression, err)
+		}
+		sizes[compression] = len(data)
+
+		got, err := DeserializeTDigest(data)
+		if err != nil {
+			t.Fatalf("%s: DeserializeTDigest failed: %v", compression, err)
+		}
+		gotBytes, _ := got.AsBytes()
+		if !bytes.Equal(gotBytes, want) {
+			t.Errorf("%s: round trip changed the digest", compression)
+		}
+		if raw, err := DecompressTDigest(data); err != nil || !bytes.Equal(raw, want) {
+			t.Errorf("%s: DecompressTDigest didn't return the uncompressed encoding (err %v)", compression, err)
+		}
+	}
+
+	if sizes[TDigestCompressionGzip] >= sizes[TDigestCompressionNone] {
+		t.Errorf("Expected gzip to shrink the digest, got %d bytes vs %d", sizes[TDigestCompressionGzip], sizes[TDigestCompressionNone])
+	}
+	t.Logf("Digest of 10000 samples: %d bytes uncompressed, %d gzipped (%.0f%%)",
+		sizes[TDigestCompressionNone], sizes[TDigestCompressionGzip],
+		100*float64(sizes[TDigestCompressionGzip])/float64(sizes[TDigestCompressionNone]))
+}
+
+func TestTDigestCompressionReadsUncompressedBlobs(t *testing.T) {
+	t.Cleanup(func() { SetTDigestCompression(TDigestCompressionNone) })
+	legacy, _ := sampleDigest(t, 100).AsBytes()
+
+	SetTDigestCompression(TDigestCompressionGzip)
+	td, err := DeserializeTDigest(legacy)
+	if err != nil {
+		t.Fatalf("Failed to read an uncompressed digest: %v", err)
+	}
+	if td.Count() != 100 {
+		t.Errorf("Expected 100 samples, got %d", td.Count())
+	}
+
+	// Compressing a digest this small doesn't pay, so it's stored as is.
+	tiny := sampleDigest(t, 2)
+	want, _ := tiny.AsBytes()
+	if data, _ := SerializeTDigest(tiny); !bytes.Equal(data, want) {
+		t.Errorf("Expected a tiny digest to be stored uncompressed, got %d bytes vs %d", len(data), len(want))
+	}
+
+	if _, err := DeserializeTDigest([]byte{0x7f, 1, 2, 3}); err == nil {
+		t.Error("Expected an unknown codec to be rejected")
+	}
+	if err := SetTDigestCompression("lz4"); err == nil {
+		t.Error("Expected an unknown compression setting to be rejected")
+	}
+}
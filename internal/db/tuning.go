@@ -0,0 +1,114 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	gosqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// driverName is the SQLite driver New and OpenReadOnly open databases with.
+// It is mattn/go-sqlite3 with a hook applying SetPageCache's settings, since
+// PRAGMAs only affect the one connection they run on and database/sql pools
+// several.
+const driverName = "sqlite3_vaportrail"
+
+// Page cache settings applied to new connections; 0 keeps SQLite's default.
+var (
+	cacheSizeKiB  atomic.Int64
+	mmapSizeBytes atomic.Int64
+)
+
+// DefaultReadConns is how many connections an OpenReadOnly pool may hold
+// unless SetConnectionPool says otherwise.
+const DefaultReadConns = 4
+
+// Connection pool settings applied by New and OpenReadOnly.
+var (
+	readConns       atomic.Int64
+	connMaxLifetime atomic.Int64
+)
+
+func init() {
+	readConns.Store(DefaultReadConns)
+
+	sql.Register(driverName, &gosqlite3.SQLiteDriver{
+		ConnectHook: func(conn *gosqlite3.SQLiteConn) error {
+			if kib := cacheSizeKiB.Load(); kib > 0 {
+				// A negative cache_size is in KiB rather than pages.
+				if _, err := conn.Exec(fmt.Sprintf("PRAGMA cache_size = -%d", kib), nil); err != nil {
+					return fmt.Errorf("failed to set cache_size: %w", err)
+				}
+			}
+			if n := mmapSizeBytes.Load(); n > 0 {
+				// In-memory databases ignore mmap_size.
+				if _, err := conn.Exec(fmt.Sprintf("PRAGMA mmap_size = %d", n), nil); err != nil {
+					return fmt.Errorf("failed to set mmap_size: %w", err)
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// SetPageCache sets the page cache size, in KiB, and the memory-mapped I/O
+// size, in bytes, of connections opened afterwards. Zero keeps SQLite's
+// defaults: a 2 MiB cache and no memory mapping. SQLite caps mmap_size at its
+// compile-time maximum.
+func SetPageCache(cacheKiB, mmapBytes int64) error {
+	if cacheKiB < 0 || mmapBytes < 0 {
+		return fmt.Errorf("page cache sizes cannot be negative (cache %d KiB, mmap %d bytes)", cacheKiB, mmapBytes)
+	}
+	cacheSizeKiB.Store(cacheKiB)
+	mmapSizeBytes.Store(mmapBytes)
+	return nil
+}
+
+// SetConnectionPool sets how many connections pools opened afterwards by
+// OpenReadOnly may hold, and how long any pooled connection is reused before
+// it is closed and reopened. A maxLifetime of zero reuses connections
+// forever. The writer pool opened by New always holds a single connection.
+func SetConnectionPool(maxReadConns int, maxLifetime time.Duration) error {
+	if maxReadConns < 1 {
+		return fmt.Errorf("read connections must be at least 1, got %d", maxReadConns)
+	}
+	if maxLifetime < 0 {
+		return fmt.Errorf("connection lifetime cannot be negative, got %v", maxLifetime)
+	}
+	readConns.Store(int64(maxReadConns))
+	connMaxLifetime.Store(int64(maxLifetime))
+	return nil
+}
+
+// limitWriterPool restricts db to one connection. SQLite allows a single
+// writer at a time, and a second connection starting a write while the first
+// holds the lock fails with "database is locked" rather than waiting, so
+// several pooled writers only turn contention into errors. With one
+// connection database/sql queues writers instead. Reads aren't held up for
+// long in WAL mode, and OpenReadOnly pools take the heavy ones elsewhere.
+func limitWriterPool(db *sql.DB, path string) {
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	if !isInMemory(path) {
+		db.SetConnMaxLifetime(time.Duration(connMaxLifetime.Load()))
+	}
+}
+
+// limitReaderPool applies SetConnectionPool's settings to a read-only pool.
+func limitReaderPool(db *sql.DB, path string) {
+	n := int(readConns.Load())
+	db.SetMaxOpenConns(n)
+	db.SetMaxIdleConns(n)
+	if !isInMemory(path) {
+		db.SetConnMaxLifetime(time.Duration(connMaxLifetime.Load()))
+	}
+}
+
+// isInMemory reports whether path names an in-memory database, which lives
+// only as long as a connection to it does and so must never be recycled.
+func isInMemory(path string) bool {
+	return strings.Contains(path, ":memory:") || strings.Contains(path, "mode=memory")
+}
@@ -0,0 +1,69 @@
+package db
+
+import "os"
+
+// WALCheckpoint is the outcome of a Checkpoint, in WAL frames of one page
+// each.
+type WALCheckpoint struct {
+	// Busy is set when the checkpoint couldn't finish, because a reader was
+	// still using the log or, for TRUNCATE, didn't let go of it in time.
+	Busy         bool
+	LogFrames    int64 // frames in the log
+	Checkpointed int64 // frames copied back into the database
+}
+
+// Checkpoint copies the write-ahead log back into the database file. A
+// PASSIVE checkpoint copies what it can without waiting on anyone; with
+// truncate, it waits for readers to move past the log and then truncates the
+// log file to zero bytes.
+//
+// It runs on the writer's single connection, so it is queued behind writes
+// already in progress rather than competing with them for the lock.
+func (d *DB) Checkpoint(truncate bool) (WALCheckpoint, error) {
+	mode := "PASSIVE"
+	if truncate {
+		mode = "TRUNCATE"
+	}
+	var busy int
+	var c WALCheckpoint
+	if err := d.QueryRow("PRAGMA wal_checkpoint("+mode+")").Scan(&busy, &c.LogFrames, &c.Checkpointed); err != nil {
+		return WALCheckpoint{}, err
+	}
+	c.Busy = busy != 0
+	return c, nil
+}
+
+// GetWALSizeBytes returns the size of the database's write-ahead log file,
+// which is zero for an in-memory database or one without a log yet.
+func (d *DB) GetWALSizeBytes() (int64, error) {
+	rows, err := d.Query("PRAGMA database_list")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var path string
+	for rows.Next() {
+		var seq int
+		var name, file string
+		if err := rows.Scan(&seq, &name, &file); err != nil {
+			return 0, err
+		}
+		if name == "main" {
+			path = file
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if path == "" {
+		return 0, nil
+	}
+	info, err := os.Stat(path + "-wal")
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,74 @@
+package influx
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"vaportrail/internal/db"
+)
+
+func TestPointAppendTo(t *testing.T) {
+	ts := time.Unix(1700000000, 123456789)
+	p := Point{
+		Measurement: "latency",
+		Tags:        []Tag{{"target", "my host,a=b"}, {"empty", ""}},
+		Fields: []Field{
+			{"p50", 1.5},
+			{"timeouts", int64(3)},
+			{"ok", true},
+			{"note", `say "hi"`},
+		},
+		Time: ts,
+	}
+
+	want := `latency,target=my\ host\,a\=b p50=1.5,timeouts=3i,ok=true,note="say \"hi\"" 1700000000123456789` + "\n"
+	if got := string(p.AppendTo(nil, time.Nanosecond)); got != want {
+		t.Errorf("AppendTo() =\n%s\nwant\n%s", got, want)
+	}
+	if got := string(p.AppendTo(nil, time.Second)); !strings.HasSuffix(got, " 1700000000\n") {
+		t.Errorf("Expected a seconds timestamp, got %s", got)
+	}
+}
+
+func TestParsePrecision(t *testing.T) {
+	for in, want := range map[string]time.Duration{"": time.Nanosecond, "us": time.Microsecond, "ms": time.Millisecond, "s": time.Second} {
+		if got, ok := ParsePrecision(in); !ok || got != want {
+			t.Errorf("ParsePrecision(%q) = %v, %v", in, got, ok)
+		}
+	}
+	if _, ok := ParsePrecision("h"); ok {
+		t.Error("Expected an unsupported precision to be rejected")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var mu sync.Mutex
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		body += string(b)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	w := NewWriter(srv.URL + "/write?db=test")
+	target := db.Target{ID: 1, Name: "web", ProbeType: "http"}
+	ts := time.Unix(1700000000, 0)
+	w.Hook(target, db.RawResult{Time: ts, TargetID: 1, Latency: 2500})
+	w.Hook(target, db.RawResult{Time: ts, TargetID: 1, Latency: -1})
+	w.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := "latency,target=web,probe_type=http latency_ns=2500,timeout=0i 1700000000000000000\n" +
+		"latency,target=web,probe_type=http timeout=1i 1700000000000000000\n"
+	if body != want {
+		t.Errorf("Unexpected body written:\n%s\nwant\n%s", body, want)
+	}
+}
@@ -0,0 +1,92 @@
+// Package influx encodes VaporTrail data as InfluxDB line protocol.
+package influx
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tag is a line protocol tag. Tags with an empty value are omitted, as
+// InfluxDB rejects them.
+type Tag struct {
+	Key, Value string
+}
+
+// Field is a line protocol field. Value must be a float64, int64, bool or
+// string.
+type Field struct {
+	Key   string
+	Value any
+}
+
+// Point is a single line protocol entry.
+type Point struct {
+	Measurement string
+	Tags        []Tag
+	Fields      []Field
+	Time        time.Time
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// AppendTo appends the point, terminated by a newline, to b. The timestamp
+// is written in units of precision, which must be one of time.Nanosecond,
+// time.Microsecond, time.Millisecond or time.Second.
+func (p Point) AppendTo(b []byte, precision time.Duration) []byte {
+	b = append(b, measurementEscaper.Replace(p.Measurement)...)
+	for _, t := range p.Tags {
+		if t.Value == "" {
+			continue
+		}
+		b = append(b, ',')
+		b = append(b, keyEscaper.Replace(t.Key)...)
+		b = append(b, '=')
+		b = append(b, keyEscaper.Replace(t.Value)...)
+	}
+	for i, f := range p.Fields {
+		if i == 0 {
+			b = append(b, ' ')
+		} else {
+			b = append(b, ',')
+		}
+		b = append(b, keyEscaper.Replace(f.Key)...)
+		b = append(b, '=')
+		switch v := f.Value.(type) {
+		case float64:
+			b = strconv.AppendFloat(b, v, 'f', -1, 64)
+		case int64:
+			b = strconv.AppendInt(b, v, 10)
+			b = append(b, 'i')
+		case bool:
+			b = strconv.AppendBool(b, v)
+		case string:
+			b = append(b, '"')
+			b = append(b, stringEscaper.Replace(v)...)
+			b = append(b, '"')
+		}
+	}
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, p.Time.UnixNano()/int64(precision), 10)
+	return append(b, '\n')
+}
+
+// ParsePrecision maps InfluxDB's precision names (ns, us, ms, s) to a
+// duration. An empty string means nanoseconds.
+func ParsePrecision(s string) (time.Duration, bool) {
+	switch s {
+	case "", "ns", "n":
+		return time.Nanosecond, true
+	case "us", "u":
+		return time.Microsecond, true
+	case "ms":
+		return time.Millisecond, true
+	case "s":
+		return time.Second, true
+	}
+	return 0, false
+}
@@ -0,0 +1,132 @@
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"vaportrail/internal/db"
+)
+
+const (
+	writerQueueSize     = 10000
+	writerBatchSize     = 1000
+	writerFlushInterval = 5 * time.Second
+)
+
+// Writer pushes raw results to an InfluxDB write endpoint. Its Hook method
+// can be registered with the scheduler as a result hook; it only queues the
+// point, and a background goroutine posts batches, so it never blocks the
+// caller. Points are dropped if InfluxDB can't keep up.
+type Writer struct {
+	url    string
+	client *http.Client
+	points chan Point
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewWriter starts a writer posting to url, which should be a full write
+// endpoint including the database or bucket, e.g.
+// http://localhost:8086/write?db=vaportrail. Points are sent with
+// nanosecond timestamps.
+func NewWriter(url string) *Writer {
+	w := &Writer{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		points: make(chan Point, writerQueueSize),
+		done:   make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+// ResultPoint converts a raw probe result to a "latency" point tagged with
+// the target's name and probe type. Timeouts (-1) are written as timeout=1i
+// with no latency field.
+func ResultPoint(target db.Target, r db.RawResult) Point {
+	p := Point{
+		Measurement: "latency",
+		Tags:        []Tag{{"target", target.Name}, {"probe_type", target.ProbeType}},
+		Time:        r.Time,
+	}
+	if r.Latency < 0 {
+		p.Fields = []Field{{"timeout", int64(1)}}
+	} else {
+		p.Fields = []Field{{"latency_ns", r.Latency}, {"timeout", int64(0)}}
+	}
+	return p
+}
+
+// Hook queues a result for writing.
+func (w *Writer) Hook(target db.Target, r db.RawResult) {
+	select {
+	case w.points <- ResultPoint(target, r):
+	default:
+	}
+}
+
+// Close flushes queued points and stops the writer.
+func (w *Writer) Close() {
+	close(w.done)
+	w.wg.Wait()
+}
+
+func (w *Writer) run() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(writerFlushInterval)
+	defer ticker.Stop()
+
+	var buf []byte
+	var n int
+	flush := func() {
+		if n == 0 {
+			return
+		}
+		if err := w.post(buf); err != nil {
+			log.Printf("Failed to write %d points to InfluxDB: %v", n, err)
+		}
+		buf, n = buf[:0], 0
+	}
+
+	for {
+		select {
+		case p := <-w.points:
+			buf = p.AppendTo(buf, time.Nanosecond)
+			n++
+			if n >= writerBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-w.done:
+			for {
+				select {
+				case p := <-w.points:
+					buf = p.AppendTo(buf, time.Nanosecond)
+					n++
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *Writer) post(body []byte) error {
+	resp, err := w.client.Post(w.url, "text/plain; charset=utf-8", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+const (
+	exporterQueueSize = 10000
+	exportInterval    = 10 * time.Second
+)
+
+// LatencyBounds are the upper bounds, in seconds, of the buckets of the
+// exported latency histogram; a last bucket holds everything slower.
+var LatencyBounds = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025,
+	0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// Exporter pushes probe results to an OTLP/HTTP metrics endpoint. Its Hook
+// method can be registered with the scheduler as a result hook; it only
+// queues the result, so it never blocks the caller, and drops results once
+// the queue is full.
+//
+// Every export interval the queued results are summarized per target into
+// three metrics, attributed with the target's name and probe type:
+// vaportrail.probes and vaportrail.probe.timeouts count probes and
+// timeouts, and vaportrail.probe.duration is a histogram of successful
+// probes' latencies in seconds. The histogram's buckets are read off a
+// t-digest of the interval's latencies at LatencyBounds, as the results
+// API's histograms are, so they are approximate; its count, sum, min and
+// max are exact. Points have delta temporality and cover one interval
+// each. An interval that fails to export is logged and dropped rather than
+// retried, so a slow or unreachable collector costs data, not memory.
+type Exporter struct {
+	url      string
+	client   *http.Client
+	results  chan result
+	done     chan struct{}
+	wg       sync.WaitGroup
+	interval time.Duration
+	dropped  atomic.Int64
+}
+
+type result struct {
+	target db.Target
+	raw    db.RawResult
+}
+
+// NewExporter starts an exporter posting to url, the collector's full
+// metrics endpoint, e.g. http://localhost:4318/v1/metrics.
+func NewExporter(url string) *Exporter {
+	e := &Exporter{
+		url:      url,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		results:  make(chan result, exporterQueueSize),
+		done:     make(chan struct{}),
+		interval: exportInterval,
+	}
+	e.wg.Add(1)
+	go e.run()
+	return e
+}
+
+// Hook queues a result for the next export.
+func (e *Exporter) Hook(target db.Target, r db.RawResult) {
+	select {
+	case e.results <- result{target, r}:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Close exports queued results and stops the exporter.
+func (e *Exporter) Close() {
+	close(e.done)
+	e.wg.Wait()
+}
+
+// targetStats accumulates one target's results for an interval.
+type targetStats struct {
+	target   db.Target
+	probes   uint64
+	timeouts uint64
+	sum      float64 // nanoseconds
+	min, max float64
+	digest   *tdigest.TDigest
+}
+
+func (st *targetStats) add(latency float64) {
+	st.probes++
+	if latency < 0 {
+		st.timeouts++
+		return
+	}
+	if st.digest == nil {
+		st.digest, _ = tdigest.New(tdigest.Compression(100))
+		st.min, st.max = latency, latency
+	}
+	st.digest.Add(latency)
+	st.sum += latency
+	st.min = math.Min(st.min, latency)
+	st.max = math.Max(st.max, latency)
+}
+
+func (e *Exporter) run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	stats := make(map[int64]*targetStats)
+	add := func(r result) {
+		st := stats[r.raw.TargetID]
+		if st == nil {
+			st = &targetStats{target: r.target}
+			stats[r.raw.TargetID] = st
+		}
+		st.add(r.raw.Latency)
+	}
+	export := func() {
+		now := time.Now()
+		if dropped := e.dropped.Swap(0); dropped > 0 {
+			log.Printf("OTLP exporter is falling behind; dropped %d results", dropped)
+		}
+		if len(stats) > 0 {
+			if err := e.post(buildRequest(stats, start, now)); err != nil {
+				log.Printf("Failed to export %d targets' results over OTLP: %v", len(stats), err)
+			}
+			clear(stats)
+		}
+		start = now
+	}
+
+	for {
+		select {
+		case r := <-e.results:
+			add(r)
+		case <-ticker.C:
+			export()
+		case <-e.done:
+			for {
+				select {
+				case r := <-e.results:
+					add(r)
+				default:
+					export()
+					return
+				}
+			}
+		}
+	}
+}
+
+// buildRequest summarizes an interval's results from start to end.
+func buildRequest(stats map[int64]*targetStats, start, end time.Time) exportRequest {
+	startNS, endNS := uintString(uint64(start.UnixNano())), uintString(uint64(end.UnixNano()))
+	probes := metric{
+		Name:        "vaportrail.probes",
+		Description: "Probes run, including timeouts",
+		Unit:        "{probe}",
+		Sum:         &sum{AggregationTemporality: aggregationTemporalityDelta, IsMonotonic: true},
+	}
+	timeouts := metric{
+		Name:        "vaportrail.probe.timeouts",
+		Description: "Probes that timed out or failed",
+		Unit:        "{probe}",
+		Sum:         &sum{AggregationTemporality: aggregationTemporalityDelta, IsMonotonic: true},
+	}
+	duration := metric{
+		Name:        "vaportrail.probe.duration",
+		Description: "Latency of successful probes",
+		Unit:        "s",
+		Histogram:   &histogram{AggregationTemporality: aggregationTemporalityDelta},
+	}
+	for _, st := range stats {
+		attrs := []keyValue{stringAttr("target", st.target.Name), stringAttr("probe_type", st.target.ProbeType)}
+		probes.Sum.DataPoints = append(probes.Sum.DataPoints, numberDataPoint{
+			Attributes: attrs, StartTimeUnixNano: startNS, TimeUnixNano: endNS, AsInt: uintString(st.probes),
+		})
+		timeouts.Sum.DataPoints = append(timeouts.Sum.DataPoints, numberDataPoint{
+			Attributes: attrs, StartTimeUnixNano: startNS, TimeUnixNano: endNS, AsInt: uintString(st.timeouts),
+		})
+		if st.digest == nil {
+			continue
+		}
+		duration.Histogram.DataPoints = append(duration.Histogram.DataPoints, histogramDataPoint{
+			Attributes:        attrs,
+			StartTimeUnixNano: startNS,
+			TimeUnixNano:      endNS,
+			Count:             uintString(st.digest.Count()),
+			Sum:               st.sum / 1e9,
+			BucketCounts:      bucketCounts(st.digest, LatencyBounds),
+			ExplicitBounds:    LatencyBounds,
+			Min:               st.min / 1e9,
+			Max:               st.max / 1e9,
+		})
+	}
+
+	metrics := []metric{probes, timeouts}
+	if len(duration.Histogram.DataPoints) > 0 {
+		metrics = append(metrics, duration)
+	}
+	return exportRequest{ResourceMetrics: []resourceMetrics{{
+		Resource:     resource{Attributes: []keyValue{stringAttr("service.name", "vaportrail")}},
+		ScopeMetrics: []scopeMetrics{{Scope: scope{Name: "vaportrail"}, Metrics: metrics}},
+	}}}
+}
+
+// bucketCounts returns the number of nanosecond latencies in td in each
+// bucket bounded in seconds by bounds, plus one past the last bound. Counts
+// come from the digest's CDF, rounded so they are whole and sum to its
+// count exactly.
+func bucketCounts(td *tdigest.TDigest, bounds []float64) []string {
+	total := td.Count()
+	counts := make([]string, len(bounds)+1)
+	var prev uint64
+	for i, bound := range bounds {
+		cum := min(uint64(math.Round(td.CDF(bound*1e9)*float64(total))), total)
+		cum = max(cum, prev)
+		counts[i] = uintString(cum - prev)
+		prev = cum
+	}
+	counts[len(bounds)] = uintString(total - prev)
+	return counts
+}
+
+func (e *Exporter) post(req exportRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
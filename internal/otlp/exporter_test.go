@@ -0,0 +1,108 @@
+package otlp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func TestBucketCounts(t *testing.T) {
+	td, _ := tdigest.New(tdigest.Compression(100))
+	for i := 1; i <= 1000; i++ {
+		td.Add(float64(i) * 1e6) // 1ms to 1s
+	}
+	counts := bucketCounts(td, []float64{0.1, 0.5, 2})
+	var total uint64
+	for _, c := range counts {
+		var n uint64
+		json.Unmarshal([]byte(c), &n)
+		total += n
+	}
+	if total != 1000 {
+		t.Errorf("Expected counts summing to 1000, got %v", counts)
+	}
+	if counts[3] != "0" {
+		t.Errorf("Expected nothing past the last bound, got %v", counts)
+	}
+	for i, want := range []float64{100, 400, 500} {
+		var n float64
+		json.Unmarshal([]byte(counts[i]), &n)
+		if n < want-15 || n > want+15 {
+			t.Errorf("Bucket %d: expected about %g, got %g", i, want, n)
+		}
+	}
+}
+
+func TestExporter(t *testing.T) {
+	var mu sync.Mutex
+	var requests []exportRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Unexpected Content-Type %q", ct)
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req exportRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("Failed to decode export: %v", err)
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	e := NewExporter(srv.URL + "/v1/metrics")
+	target := db.Target{ID: 1, Name: "web", ProbeType: "http"}
+	ts := time.Unix(1700000000, 0)
+	e.Hook(target, db.RawResult{Time: ts, TargetID: 1, Latency: 2e6})
+	e.Hook(target, db.RawResult{Time: ts, TargetID: 1, Latency: 4e6})
+	e.Hook(target, db.RawResult{Time: ts, TargetID: 1, Latency: -1})
+	e.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 {
+		t.Fatalf("Expected one export on Close, got %d", len(requests))
+	}
+	metrics := requests[0].ResourceMetrics[0].ScopeMetrics[0].Metrics
+	byName := make(map[string]metric)
+	for _, m := range metrics {
+		byName[m.Name] = m
+	}
+	wantAttrs := []keyValue{stringAttr("target", "web"), stringAttr("probe_type", "http")}
+	if p := byName["vaportrail.probes"].Sum.DataPoints; len(p) != 1 || p[0].AsInt != "3" || !slices.Equal(p[0].Attributes, wantAttrs) {
+		t.Errorf("Unexpected probe count: %+v", p)
+	}
+	if p := byName["vaportrail.probe.timeouts"].Sum.DataPoints; len(p) != 1 || p[0].AsInt != "1" {
+		t.Errorf("Unexpected timeout count: %+v", p)
+	}
+	h := byName["vaportrail.probe.duration"].Histogram
+	if h == nil || len(h.DataPoints) != 1 {
+		t.Fatalf("Expected a latency histogram, got %+v", byName["vaportrail.probe.duration"])
+	}
+	p := h.DataPoints[0]
+	if p.Count != "2" || p.Sum != 0.006 || p.Min != 0.002 || p.Max != 0.004 || h.AggregationTemporality != aggregationTemporalityDelta {
+		t.Errorf("Unexpected histogram point: %+v", p)
+	}
+	// 0.001 < 2ms, 4ms <= 0.005.
+	if len(p.BucketCounts) != len(LatencyBounds)+1 || p.BucketCounts[4] != "1" || p.BucketCounts[5] != "1" {
+		t.Errorf("Unexpected buckets %v for bounds %v", p.BucketCounts, p.ExplicitBounds)
+	}
+}
+
+func TestExporter_DropsWhenFull(t *testing.T) {
+	e := &Exporter{results: make(chan result, 1)}
+	e.Hook(db.Target{}, db.RawResult{})
+	e.Hook(db.Target{}, db.RawResult{})
+	if n := e.dropped.Load(); n != 1 {
+		t.Errorf("Expected one result dropped, got %d", n)
+	}
+}
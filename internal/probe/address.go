@@ -0,0 +1,140 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resolveTimeout bounds the DNS lookup NormalizeAddress does for ping
+// targets.
+const resolveTimeout = 3 * time.Second
+
+// NormalizeAddress checks that address is usable by the given probe type and
+// returns it in the form it should be stored in:
+//
+//   - ping takes a bare host name or IP address, and host names must resolve.
+//   - http takes a URL; "http://" is added when the scheme is missing.
+//   - dns takes the resolver's host name or IP address, optionally with a port.
+//
+// Surrounding whitespace is trimmed for every type. Ping host names are looked
+// up through resolver ("host:port") when it's set, as the probe will. Only a
+// lookup that finds no such host fails; other resolver errors are assumed to
+// be transient.
+func NormalizeAddress(probeType, address, resolver string) (string, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "", errors.New("address is empty")
+	}
+
+	switch probeType {
+	case "ping":
+		if net.ParseIP(address) != nil {
+			return address, nil
+		}
+		if strings.ContainsAny(address, "/:") {
+			return "", fmt.Errorf("%q is not a host; ping takes a host name or IP address without a scheme, port or path", address)
+		}
+		if err := checkHostname(address); err != nil {
+			return "", err
+		}
+		if err := resolve(address, resolver); err != nil {
+			return "", err
+		}
+		return address, nil
+
+	case "http":
+		if !strings.Contains(address, "://") {
+			address = "http://" + address
+		}
+		u, err := url.Parse(address)
+		if err != nil {
+			return "", fmt.Errorf("invalid URL: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return "", fmt.Errorf("unsupported URL scheme %q (expected http or https)", u.Scheme)
+		}
+		if u.Hostname() == "" {
+			return "", fmt.Errorf("URL %q has no host", address)
+		}
+		if net.ParseIP(u.Hostname()) == nil {
+			if err := checkHostname(u.Hostname()); err != nil {
+				return "", err
+			}
+		}
+		if err := checkPort(u.Port()); err != nil {
+			return "", err
+		}
+		return u.String(), nil
+
+	case "dns":
+		if net.ParseIP(address) != nil {
+			return address, nil
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			host, port = address, ""
+		}
+		if net.ParseIP(host) == nil {
+			if err := checkHostname(host); err != nil {
+				return "", err
+			}
+		}
+		if err := checkPort(port); err != nil {
+			return "", err
+		}
+		return address, nil
+	}
+	return "", fmt.Errorf("unknown probe type: %s", probeType)
+}
+
+// checkHostname reports whether name is a syntactically valid DNS name.
+// Underscores are allowed since they turn up in real internal names.
+func checkHostname(name string) error {
+	trimmed := strings.TrimSuffix(name, ".")
+	if trimmed == "" || len(trimmed) > 253 {
+		return fmt.Errorf("invalid host name %q", name)
+	}
+	for _, label := range strings.Split(trimmed, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid host name %q", name)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return fmt.Errorf("invalid host name %q: unexpected character %q", name, c)
+			}
+		}
+	}
+	return nil
+}
+
+func checkPort(port string) error {
+	if port == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// resolve fails only if the resolver says host doesn't exist.
+func resolve(host, resolver string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	r := resolverFor(resolver)
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	_, err := r.LookupHost(ctx, host)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return fmt.Errorf("cannot resolve host %q", host)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Address modes, for targets whose host name resolves to several addresses,
+// such as a pool of backends behind DNS load balancing.
+const (
+	// AddressModeOne probes whichever address the host name resolves to
+	// first, as a plain probe does.
+	AddressModeOne = "one"
+	// AddressModeRoundRobin probes the next address each time, so every
+	// backend is sampled in turn.
+	AddressModeRoundRobin = "roundrobin"
+	// AddressModeAll probes every address each time, in parallel and within
+	// the one timeout. The probe's latency is the slowest address's, and it
+	// fails only if every address fails.
+	AddressModeAll = "all"
+)
+
+// MetricAddressLatencyPrefix, followed by an IP address, names the latency
+// in nanoseconds of the probe sent to that address by a roundrobin or all
+// target. In all mode a failed address reports -1; a failed roundrobin probe
+// reports no metrics, as any failed probe doesn't.
+const MetricAddressLatencyPrefix = "address_latency_ns:"
+
+// pinnedIPKey is the context key http probes use to send a request to a
+// specific IP address while keeping the URL's host for Host and TLS.
+type pinnedIPKey struct{}
+
+// runAddresses runs a roundrobin or all probe. Targets addressed by IP have
+// only the one address and are probed normally.
+func runAddresses(ctx context.Context, cfg Config) (float64, Metrics, error) {
+	host := targetHost(cfg)
+	if host == "" || net.ParseIP(host) != nil {
+		return runSingle(ctx, cfg)
+	}
+	r := resolverFor(cfg.Resolver)
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	addrs, err := r.LookupHost(ctx, host)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	slices.Sort(addrs) // Keep the round-robin order stable.
+
+	if cfg.AddressMode == AddressModeRoundRobin {
+		ip := addrs[int(cfg.rr.Add(1)-1)%len(addrs)]
+		setMetadata(ctx, MetadataAddress, ip)
+		res, metrics, err := runPinned(ctx, cfg, ip)
+		if err != nil {
+			return 0, nil, err
+		}
+		if metrics == nil {
+			metrics = Metrics{}
+		}
+		metrics[MetricAddressLatencyPrefix+ip] = res
+		return res, metrics, nil
+	}
+
+	results := make([]float64, len(addrs))
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, ip := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _, errs[i] = runPinned(withoutMetadata(ctx), cfg, ip)
+		}()
+	}
+	wg.Wait()
+
+	metrics := make(Metrics, len(addrs))
+	slowest := -1.0
+	for i, ip := range addrs {
+		if errs[i] != nil {
+			metrics[MetricAddressLatencyPrefix+ip] = -1
+			continue
+		}
+		metrics[MetricAddressLatencyPrefix+ip] = results[i]
+		slowest = max(slowest, results[i])
+	}
+	if slowest < 0 {
+		return 0, nil, fmt.Errorf("all %d addresses of %s failed: %w", len(addrs), host, errors.Join(errs...))
+	}
+	return slowest, metrics, nil
+}
+
+// runPinned runs cfg's probe against ip instead of the address's host.
+func runPinned(ctx context.Context, cfg Config, ip string) (float64, Metrics, error) {
+	switch cfg.Type {
+	case "http":
+		ctx = context.WithValue(ctx, pinnedIPKey{}, ip)
+	case "ping":
+		cfg.Address = ip
+		args := append([]string(nil), cfg.Args...)
+		args[len(args)-1] = ip // The address is always the last argument.
+		cfg.Args = args
+	case "dns":
+		if _, port, err := net.SplitHostPort(cfg.Address); err == nil {
+			cfg.Address = net.JoinHostPort(ip, port)
+		} else {
+			cfg.Address = net.JoinHostPort(ip, "53")
+		}
+	}
+	return runSingle(ctx, cfg)
+}
+
+// targetHost returns the host name or IP address in cfg's address.
+func targetHost(cfg Config) string {
+	switch cfg.Type {
+	case "http":
+		address := cfg.Address
+		if !strings.Contains(address, "://") {
+			address = "http://" + address
+		}
+		u, err := url.Parse(address)
+		if err != nil {
+			return ""
+		}
+		return u.Hostname()
+	case "dns":
+		if host, _, err := net.SplitHostPort(cfg.Address); err == nil {
+			return host
+		}
+	}
+	return cfg.Address
+}
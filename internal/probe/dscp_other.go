@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+package probe
+
+import "syscall"
+
+const dscpSupported = false
+
+func dscpControl(dscp int) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build linux || darwin || freebsd
+
+package probe
+
+import "syscall"
+
+// dscpSupported reports whether probes can be marked with a DSCP class on
+// this platform.
+const dscpSupported = true
+
+// dscpControl returns a net.Dialer Control function that marks a socket's
+// packets with DSCP class dscp, through the IPv4 TOS byte or IPv6 traffic
+// class, whose upper six bits it occupies.
+func dscpControl(dscp int) func(network, address string, c syscall.RawConn) error {
+	tos := dscp << 2
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			switch network {
+			case "tcp6", "udp6":
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+			default:
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
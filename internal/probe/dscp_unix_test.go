@@ -0,0 +1,34 @@
+//go:build linux || darwin || freebsd
+
+package probe
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestDSCPControl(t *testing.T) {
+	dialer := net.Dialer{Control: dscpControl(46)}
+	conn, err := dialer.Dial("udp4", "127.0.0.1:9")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	raw, err := conn.(*net.UDPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn failed: %v", err)
+	}
+	var tos int
+	var sockErr error
+	raw.Control(func(fd uintptr) {
+		tos, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS)
+	})
+	if sockErr != nil {
+		t.Fatalf("GetsockoptInt failed: %v", sockErr)
+	}
+	if tos != 46<<2 {
+		t.Errorf("Expected TOS %d, got %d", 46<<2, tos)
+	}
+}
@@ -0,0 +1,89 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+)
+
+// Metadata is diagnostic context from a single probe, such as the HTTP
+// status it got, keyed by name. Unlike Metrics it isn't numeric, and it is
+// reported for failed probes too, where it matters most.
+type Metadata map[string]string
+
+// Metadata keys.
+const (
+	MetadataHTTPStatus   = "http_status"
+	MetadataHTTPProtocol = "http_protocol"
+	MetadataTLSVersion   = "tls_version"
+	MetadataTLSCipher    = "tls_cipher"
+	MetadataDNSRcode     = "dns_rcode"
+	// MetadataDNSAnswer is the A and AAAA records of a DNS probe's answer,
+	// comma-separated.
+	MetadataDNSAnswer = "dns_answer"
+	// MetadataAddress is the address a roundrobin target probed; see
+	// AddressModeRoundRobin.
+	MetadataAddress = "address"
+)
+
+// MetadataRunner is implemented by Runners that can also report each probe's
+// Metadata.
+type MetadataRunner interface {
+	RunWithMetadata(cfg Config) (float64, Metrics, Metadata, error)
+}
+
+func (r RealRunner) RunWithMetadata(cfg Config) (float64, Metrics, Metadata, error) {
+	return RunWithMetadata(cfg)
+}
+
+// RunWithMetadata is RunWithMetrics, also returning the probe's Metadata,
+// which may be non-nil when it fails.
+func RunWithMetadata(cfg Config) (float64, Metrics, Metadata, error) {
+	c := &metadataCollector{}
+	res, metrics, err := runWithContext(context.WithValue(context.Background(), metadataKey{}, c), cfg)
+	return res, metrics, c.metadata(), err
+}
+
+// metadataKey is the context key under which a probe finds the
+// metadataCollector to report its Metadata to.
+type metadataKey struct{}
+
+type metadataCollector struct {
+	mu sync.Mutex
+	md Metadata
+}
+
+func (c *metadataCollector) metadata() Metadata {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.md
+}
+
+// setMetadata reports a Metadata value if the probe is collecting them.
+func setMetadata(ctx context.Context, key, value string) {
+	c, _ := ctx.Value(metadataKey{}).(*metadataCollector)
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.md == nil {
+		c.md = Metadata{}
+	}
+	c.md[key] = value
+}
+
+// withoutMetadata stops probes run with the returned context from reporting
+// Metadata, for sub-probes whose values would overwrite each other.
+func withoutMetadata(ctx context.Context) context.Context {
+	return context.WithValue(ctx, metadataKey{}, (*metadataCollector)(nil))
+}
+
+// setTLSMetadata reports the negotiated TLS version and cipher suite.
+func setTLSMetadata(ctx context.Context, state *tls.ConnectionState) {
+	if state == nil {
+		return
+	}
+	setMetadata(ctx, MetadataTLSVersion, tls.VersionName(state.Version))
+	setMetadata(ctx, MetadataTLSCipher, tls.CipherSuiteName(state.CipherSuite))
+}
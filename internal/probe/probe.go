@@ -2,16 +2,21 @@ package probe
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,20 +32,211 @@ func (r RealRunner) Run(cfg Config) (float64, error) {
 	return Run(cfg)
 }
 
+func (r RealRunner) RunWithMetrics(cfg Config) (float64, Metrics, error) {
+	return RunWithMetrics(cfg)
+}
+
+// MetricsRunner is implemented by Runners that can also report the auxiliary
+// metrics some probes measure alongside latency.
+type MetricsRunner interface {
+	RunWithMetrics(cfg Config) (float64, Metrics, error)
+}
+
+// Metrics are auxiliary measurements from a single probe, keyed by name.
+type Metrics map[string]float64
+
+// Auxiliary metric names.
+const (
+	// MetricHTTPBodyBytes is the size of the response body read, which is
+	// capped at the target's max_body_bytes.
+	MetricHTTPBodyBytes = "http_body_bytes"
+	// MetricHTTPThroughput is MetricHTTPBodyBytes divided by the probe's
+	// latency, in bytes per second.
+	MetricHTTPThroughput = "http_throughput_bytes_per_second"
+	// MetricHTTPConnReused is 1 when an http probe with a connection mode
+	// was sent on a reused connection and 0 when it dialed a new one.
+	MetricHTTPConnReused = "http_connection_reused"
+	// MetricCommandDuration is the wall-clock time a command probe's process
+	// took, in nanoseconds, and MetricCommandOverhead how much longer that
+	// was than the latency it reported: process startup, name resolution
+	// and the like. A large overhead means the probe's time is a poor
+	// stand-in for what the command actually waited on.
+	MetricCommandDuration = "command_duration_ns"
+	MetricCommandOverhead = "command_overhead_ns"
+)
+
 // Config defines how to run a probe.
 type Config struct {
 	Type    string `json:"type"`    // "ping", "http", "dns"
 	Address string `json:"address"` // Target address
 
 	// Deprecated fields, kept for "ping" command execution
-	Command    string        `json:"command"`
-	Args       []string       `json:"args"`
-	Pattern    string         `json:"pattern"`
-	Multiplier float64        `json:"multiplier"`
-	Timeout    time.Duration  `json:"-"`
+	Command         string         `json:"command"`
+	Args            []string       `json:"args"`
+	Pattern         string         `json:"pattern"`
+	Multiplier      float64        `json:"multiplier"`
+	Timeout         time.Duration  `json:"-"`
 	CompiledPattern *regexp.Regexp `json:"-"`
+
+	// MetricMappings reports further named capture groups of Pattern as
+	// auxiliary metrics, keyed by group name, for commands that print
+	// several values at once; see PingOptions. A group that doesn't take
+	// part in the match is left out. See CompilePattern.
+	MetricMappings map[string]MetricMapping `json:"metric_mappings,omitempty"`
+
+	// RecordMetadata asks the runner for the probe's Metadata; see
+	// MetadataRunner.
+	RecordMetadata bool `json:"-"`
+
+	// HTTP probe options, set from the target's ProbeConfig.
+	UserAgent string            `json:"user_agent,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+
+	// StatusMin and StatusMax bound the HTTP status codes counted as
+	// success. Zero means any status is accepted.
+	StatusMin int `json:"-"`
+	StatusMax int `json:"-"`
+
+	// SourceAddress is the local IP probes are sent from, for multi-homed
+	// hosts. Empty lets the OS pick based on the routing table.
+	SourceAddress string `json:"source_address,omitempty"`
+
+	// Resolver is the DNS server ("host:port") used to resolve the target's
+	// host name instead of the system resolver.
+	Resolver string `json:"resolver,omitempty"`
+
+	// DSCP is the DiffServ class probe packets are marked with; 0 leaves
+	// them unmarked.
+	DSCP int `json:"-"`
+
+	// AddressMode is how many of the host name's addresses each probe goes
+	// to; see SourceOptions. rr picks the next one in roundrobin mode.
+	AddressMode string `json:"-"`
+	rr          *atomic.Uint64
+
+	// MeasureThroughput makes http probes report MetricHTTPBodyBytes and
+	// MetricHTTPThroughput, reading at most MaxBodyBytes of the body.
+	MeasureThroughput bool  `json:"-"`
+	MaxBodyBytes      int64 `json:"-"`
+
+	// Connection is the http probe's ConnectionWarm or ConnectionCold mode,
+	// or empty to share pooled connections with other targets. client is
+	// the target's own client for either mode.
+	Connection string `json:"-"`
+	client     *http.Client
+}
+
+// MetricMapping names the metric a capture group of a command probe's
+// pattern is reported as, and what its value is multiplied by, as with
+// Config.Multiplier; zero means 1.
+type MetricMapping struct {
+	Metric     string  `json:"metric"`
+	Multiplier float64 `json:"multiplier,omitempty"`
+}
+
+// SourceOptions are accepted in every probe type's ProbeConfig.
+type SourceOptions struct {
+	// SourceAddress binds the probe to a local IP address, e.g. to measure
+	// a specific upstream on a multi-homed collector.
+	SourceAddress string `json:"source_address" desc:"Local IP address to send probes from"`
+	// Resolver resolves the target's host name through a specific DNS
+	// server, for split-horizon setups where the system resolver would pick
+	// a different backend.
+	Resolver string `json:"resolver" desc:"DNS server (host:port) to resolve the target's host name with"`
+	// AddressMode chooses which of the addresses a host name resolves to
+	// are probed: AddressModeOne (the default), AddressModeRoundRobin or
+	// AddressModeAll.
+	AddressMode string `json:"address_mode" desc:"one, roundrobin (a different address each probe) or all (every address each probe)"`
+	// DSCP marks probe packets with a DiffServ class, to measure the path
+	// a particular class of traffic takes through a QoS-aware network. The
+	// native probes set it on their sockets, which works on Linux, macOS
+	// and FreeBSD; ping passes it with -Q, which only Linux's iputils ping
+	// accepts. Networks are free to rewrite or ignore the marking.
+	DSCP int `json:"dscp" desc:"DiffServ code point (0-63) to mark probe packets with, e.g. 46 for expedited forwarding"`
+}
+
+// MaxDSCP is the largest DiffServ code point, which is six bits wide.
+const MaxDSCP = 63
+
+// HTTPOptions are the per-target settings accepted in an http target's
+// ProbeConfig JSON, e.g. {"user_agent": "...", "headers": {"Host": "..."}}.
+type HTTPOptions struct {
+	SourceOptions
+	UserAgent string            `json:"user_agent" desc:"User-Agent header to send"`
+	Headers   map[string]string `json:"headers" desc:"Extra request headers; Host overrides the virtual host"`
+	// ExpectedStatus is a status code ("200"), class ("2xx") or inclusive
+	// range ("200-399"). Responses outside it count as failures.
+	ExpectedStatus string `json:"expected_status" desc:"Status code, class or range counted as success, e.g. 200, 2xx or 200-399"`
+	// MeasureThroughput records the body size and bytes per second with
+	// each probe, to tell a slow network from a large payload. The body is
+	// then read up to MaxBodyBytes (DefaultMaxBodyBytes if zero), and the
+	// latency covers only that much of it.
+	MeasureThroughput bool  `json:"measure_throughput" desc:"Also record the response size and throughput"`
+	MaxBodyBytes      int64 `json:"max_body_bytes" desc:"Stop reading the body after this many bytes when measuring throughput"`
+	// Connection gives the target its own connection handling: "warm"
+	// keeps a persistent (HTTP/2 when offered) connection across probes to
+	// measure steady-state latency, and "cold" dials a new connection for
+	// every probe so setup is always included.
+	Connection string `json:"connection" desc:"warm to reuse a persistent connection, cold to dial a new one for every probe"`
+}
+
+// HTTP probe connection modes.
+const (
+	ConnectionWarm = "warm"
+	ConnectionCold = "cold"
+)
+
+const (
+	// DefaultMaxBodyBytes is how much of a response body throughput is
+	// measured over unless the target sets max_body_bytes.
+	DefaultMaxBodyBytes = 10 << 20
+	// MaxBodyBytes is the largest max_body_bytes a target may set.
+	MaxBodyBytes = 1 << 30
+)
+
+// ErrUnexpectedStatus is returned (wrapped) by http probes whose response
+// status falls outside the target's expected_status.
+var ErrUnexpectedStatus = errors.New("unexpected_status")
+
+// OutputError is returned by command probes whose command failed or printed
+// nothing the pattern matched, carrying what it printed so a misconfigured
+// probe can be debugged.
+type OutputError struct {
+	Msg    string
+	Output string
+}
+
+func (e *OutputError) Error() string {
+	return e.Msg + ", output: " + e.Output
 }
 
+// PingOptions are the per-target settings accepted in a ping target's
+// ProbeConfig JSON, e.g. {"payload_size": 1400}.
+type PingOptions struct {
+	SourceOptions
+	// PayloadSize is the ICMP echo payload in bytes, passed to ping -s.
+	// Zero keeps ping's default.
+	PayloadSize int `json:"payload_size" desc:"ICMP payload size in bytes"`
+	// AllowFragmentation permits payloads larger than fit in a single
+	// 1500-byte Ethernet frame.
+	AllowFragmentation bool `json:"allow_fragmentation" desc:"Allow payloads larger than 1472 bytes"`
+	// Pattern replaces the regular expression the round trip is read from
+	// ping's output with, so MetricMappings can pick up more of the line,
+	// such as the TTL. It needs a "val" group with the round trip in ms.
+	Pattern string `json:"pattern" desc:"Regex over ping's output, with a 'val' group for the round trip in ms"`
+	// MetricMappings reports further named groups of Pattern as auxiliary
+	// metrics; see Config.MetricMappings.
+	MetricMappings map[string]MetricMapping `json:"metric_mappings" desc:"Capture groups of pattern to report as metrics, as {group: {metric, multiplier}}"`
+}
+
+const (
+	// MaxUnfragmentedPayload is the largest ICMP payload that fits a 1500 byte
+	// MTU: 1500 - 20 (IPv4 header) - 8 (ICMP header).
+	MaxUnfragmentedPayload = 1472
+	// MaxPingPayload is the largest payload an IPv4 datagram can carry.
+	MaxPingPayload = 65507
+)
+
 // GetConfig returns the probe configuration for a given type and target address.
 func GetConfig(probeType, address string) (Config, error) {
 	cfg := Config{
@@ -53,9 +249,7 @@ func GetConfig(probeType, address string) (Config, error) {
 		cfg.Command = "ping"
 		cfg.Args = []string{"-c", "1", address}
 		cfg.Pattern = "time=(?P<val>[0-9.]+) ms"
-		var err error
-		cfg.CompiledPattern, err = regexp.Compile(cfg.Pattern)
-		if err != nil {
+		if err := CompilePattern(&cfg); err != nil {
 			return Config{}, fmt.Errorf("failed to compile ping pattern: %w", err)
 		}
 		cfg.Multiplier = 1000000
@@ -68,26 +262,314 @@ func GetConfig(probeType, address string) (Config, error) {
 	return cfg, nil
 }
 
+// GetTargetConfig returns the probe configuration for a target, applying the
+// options in its ProbeConfig JSON on top of the defaults from GetConfig.
+// An empty probeConfig means no options.
+func GetTargetConfig(probeType, address, probeConfig string) (Config, error) {
+	cfg, err := GetConfig(probeType, address)
+	if err != nil {
+		return Config{}, err
+	}
+	if strings.TrimSpace(probeConfig) == "" {
+		return cfg, nil
+	}
+
+	switch probeType {
+	case "http":
+		var opts HTTPOptions
+		if err := decodeOptions(probeConfig, &opts); err != nil {
+			return Config{}, fmt.Errorf("invalid http probe config: %w", err)
+		}
+		if err := validateHeaderValue(opts.UserAgent); err != nil {
+			return Config{}, fmt.Errorf("invalid user_agent: %w", err)
+		}
+		for name, value := range opts.Headers {
+			if !validHeaderName(name) {
+				return Config{}, fmt.Errorf("invalid header name %q", name)
+			}
+			if err := validateHeaderValue(value); err != nil {
+				return Config{}, fmt.Errorf("invalid value for header %q: %w", name, err)
+			}
+		}
+		if opts.ExpectedStatus != "" {
+			cfg.StatusMin, cfg.StatusMax, err = parseStatusRange(opts.ExpectedStatus)
+			if err != nil {
+				return Config{}, err
+			}
+		}
+		if opts.MaxBodyBytes < 0 || opts.MaxBodyBytes > MaxBodyBytes {
+			return Config{}, fmt.Errorf("max_body_bytes must be between 0 and %d", MaxBodyBytes)
+		}
+		cfg.MeasureThroughput = opts.MeasureThroughput
+		cfg.MaxBodyBytes = opts.MaxBodyBytes
+		if cfg.MeasureThroughput && cfg.MaxBodyBytes == 0 {
+			cfg.MaxBodyBytes = DefaultMaxBodyBytes
+		}
+		switch opts.Connection {
+		case "", ConnectionWarm, ConnectionCold:
+		default:
+			return Config{}, fmt.Errorf("invalid connection %q: expected %q or %q", opts.Connection, ConnectionWarm, ConnectionCold)
+		}
+		cfg.Connection = opts.Connection
+		cfg.UserAgent = opts.UserAgent
+		cfg.Headers = opts.Headers
+		cfg.SourceAddress = opts.SourceAddress
+		cfg.Resolver = opts.Resolver
+		cfg.AddressMode = opts.AddressMode
+		cfg.DSCP = opts.DSCP
+	case "dns":
+		var opts SourceOptions
+		if err := decodeOptions(probeConfig, &opts); err != nil {
+			return Config{}, fmt.Errorf("invalid dns probe config: %w", err)
+		}
+		cfg.SourceAddress = opts.SourceAddress
+		cfg.Resolver = opts.Resolver
+		cfg.AddressMode = opts.AddressMode
+		cfg.DSCP = opts.DSCP
+	case "ping":
+		var opts PingOptions
+		if err := decodeOptions(probeConfig, &opts); err != nil {
+			return Config{}, fmt.Errorf("invalid ping probe config: %w", err)
+		}
+		if opts.PayloadSize != 0 {
+			limit := MaxUnfragmentedPayload
+			if opts.AllowFragmentation {
+				limit = MaxPingPayload
+			}
+			if opts.PayloadSize < 0 || opts.PayloadSize > limit {
+				return Config{}, fmt.Errorf("payload_size must be between 0 and %d bytes", limit)
+			}
+			// Insert before the address, which is always the last argument.
+			cfg.Args = append(cfg.Args[:len(cfg.Args)-1], "-s", strconv.Itoa(opts.PayloadSize), address)
+		}
+		if opts.SourceAddress != "" {
+			cfg.Args = append(cfg.Args[:len(cfg.Args)-1], "-I", opts.SourceAddress, address)
+		}
+		if opts.DSCP > 0 && opts.DSCP <= MaxDSCP {
+			// -Q takes the whole TOS byte, with DSCP in its upper six bits.
+			cfg.Args = append(cfg.Args[:len(cfg.Args)-1], "-Q", strconv.Itoa(opts.DSCP<<2), address)
+		}
+		if opts.Pattern != "" || len(opts.MetricMappings) > 0 {
+			if opts.Pattern != "" {
+				cfg.Pattern = opts.Pattern
+			}
+			cfg.MetricMappings = opts.MetricMappings
+			if err := CompilePattern(&cfg); err != nil {
+				return Config{}, fmt.Errorf("invalid pattern: %w", err)
+			}
+		}
+		cfg.SourceAddress = opts.SourceAddress
+		cfg.Resolver = opts.Resolver
+		cfg.AddressMode = opts.AddressMode
+		cfg.DSCP = opts.DSCP
+	default:
+		return Config{}, fmt.Errorf("probe type %s does not accept a probe config", probeType)
+	}
+
+	if cfg.SourceAddress != "" && net.ParseIP(cfg.SourceAddress) == nil {
+		return Config{}, fmt.Errorf("invalid source_address %q: not an IP address", cfg.SourceAddress)
+	}
+	if cfg.Resolver != "" {
+		if err := checkResolver(cfg.Resolver); err != nil {
+			return Config{}, err
+		}
+	}
+	if cfg.DSCP < 0 || cfg.DSCP > MaxDSCP {
+		return Config{}, fmt.Errorf("dscp must be between 0 and %d", MaxDSCP)
+	}
+	if cfg.DSCP != 0 && !dscpSupported {
+		return Config{}, fmt.Errorf("dscp is not supported on this platform")
+	}
+	multi := false
+	switch cfg.AddressMode {
+	case "", AddressModeOne:
+	case AddressModeRoundRobin, AddressModeAll:
+		multi = true
+		cfg.rr = new(atomic.Uint64)
+	default:
+		return Config{}, fmt.Errorf("invalid address_mode %q: expected %q, %q or %q", cfg.AddressMode, AddressModeOne, AddressModeRoundRobin, AddressModeAll)
+	}
+	if multi && cfg.Connection == ConnectionWarm {
+		return Config{}, fmt.Errorf("connection %q can't be combined with address_mode %q", ConnectionWarm, cfg.AddressMode)
+	}
+	if probeType == "http" && (cfg.Connection != "" || multi) {
+		// Connections to one address mustn't be reused for another, so
+		// multi-address probes always dial afresh.
+		transport := newHTTPTransport(cfg.SourceAddress, cfg.Resolver, cfg.DSCP)
+		transport.DisableKeepAlives = cfg.Connection == ConnectionCold || multi
+		cfg.client = &http.Client{Transport: transport}
+	}
+	return cfg, nil
+}
+
+// CompilePattern compiles cfg's Pattern into CompiledPattern, checking that
+// it has a "val" group and every group its MetricMappings refer to, and that
+// the mappings name distinct metrics.
+func CompilePattern(cfg *Config) error {
+	re, err := regexp.Compile(cfg.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid regex pattern: %w", err)
+	}
+	if err := checkPattern(re, cfg.MetricMappings); err != nil {
+		return err
+	}
+	cfg.CompiledPattern = re
+	return nil
+}
+
+func checkPattern(re *regexp.Regexp, mappings map[string]MetricMapping) error {
+	if re.SubexpIndex("val") < 0 {
+		return fmt.Errorf("capture group 'val' not found")
+	}
+	metrics := make(map[string]string, len(mappings))
+	for group, m := range mappings {
+		if group == "val" {
+			return fmt.Errorf("capture group 'val' is the latency and can't be mapped to a metric")
+		}
+		if re.SubexpIndex(group) < 0 {
+			return fmt.Errorf("capture group %q of metric mapping not found in pattern", group)
+		}
+		switch m.Metric {
+		case "":
+			return fmt.Errorf("metric mapping for capture group %q has no metric name", group)
+		case MetricCommandDuration, MetricCommandOverhead:
+			return fmt.Errorf("metric %q is reserved", m.Metric)
+		}
+		if other, ok := metrics[m.Metric]; ok {
+			return fmt.Errorf("capture groups %q and %q are both mapped to metric %q", other, group, m.Metric)
+		}
+		metrics[m.Metric] = group
+		if math.IsNaN(m.Multiplier) || math.IsInf(m.Multiplier, 0) {
+			return fmt.Errorf("metric mapping for capture group %q has an invalid multiplier", group)
+		}
+	}
+	return nil
+}
+
+// CheckSourceAddress verifies that cfg's SourceAddress, if any, is assigned
+// to one of this host's interfaces. Binding to anything else fails at probe
+// time, so targets are checked when they are created.
+func CheckSourceAddress(cfg Config) error {
+	if cfg.SourceAddress == "" {
+		return nil
+	}
+	ip := net.ParseIP(cfg.SourceAddress)
+	if ip == nil {
+		return fmt.Errorf("invalid source_address %q: not an IP address", cfg.SourceAddress)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("failed to list interface addresses: %w", err)
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("source_address %s is not assigned to any local interface", cfg.SourceAddress)
+}
+
+// parseStatusRange parses an expected_status spec into an inclusive range.
+func parseStatusRange(spec string) (int, int, error) {
+	spec = strings.TrimSpace(spec)
+	invalid := fmt.Errorf("invalid expected_status %q: use a code (200), class (2xx) or range (200-399)", spec)
+
+	var lo, hi int
+	switch {
+	case len(spec) == 3 && strings.HasSuffix(strings.ToLower(spec), "xx"):
+		class, err := strconv.Atoi(spec[:1])
+		if err != nil {
+			return 0, 0, invalid
+		}
+		lo, hi = class*100, class*100+99
+	case strings.Contains(spec, "-"):
+		parts := strings.SplitN(spec, "-", 2)
+		var err1, err2 error
+		lo, err1 = strconv.Atoi(strings.TrimSpace(parts[0]))
+		hi, err2 = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err1 != nil || err2 != nil {
+			return 0, 0, invalid
+		}
+	default:
+		code, err := strconv.Atoi(spec)
+		if err != nil {
+			return 0, 0, invalid
+		}
+		lo, hi = code, code
+	}
+
+	if lo < 100 || hi > 599 || lo > hi {
+		return 0, 0, invalid
+	}
+	return lo, hi, nil
+}
+
+// decodeOptions strictly decodes a ProbeConfig JSON object into v, so typos in
+// option names are reported instead of silently ignored.
+func decodeOptions(probeConfig string, v any) error {
+	dec := json.NewDecoder(strings.NewReader(probeConfig))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// validHeaderName reports whether name is a valid RFC 7230 header field name.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validateHeaderValue rejects control characters, which would otherwise let a
+// value smuggle in extra header lines.
+func validateHeaderValue(value string) error {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return fmt.Errorf("control character 0x%02x not allowed", c)
+		}
+	}
+	return nil
+}
+
 // Run executes the probe and returns the latency in nanoseconds.
 func Run(cfg Config) (float64, error) {
+	res, _, err := RunWithMetrics(cfg)
+	return res, err
+}
+
+// RunWithMetrics is Run, also returning any auxiliary metrics the probe
+// measured. Metrics are nil when the probe fails.
+func RunWithMetrics(cfg Config) (float64, Metrics, error) {
+	return runWithContext(context.Background(), cfg)
+}
+
+// runWithContext is RunWithMetrics, running the probe with a context derived
+// from parent.
+func runWithContext(parent context.Context, cfg Config) (float64, Metrics, error) {
 	// Jitter: Sleep for a random duration between 0 and 100ms to avoid thundering herd on local resources
 	time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
 
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	ctx, cancel := context.WithTimeout(parent, cfg.Timeout)
 	defer cancel()
 
 	var res float64
+	var metrics Metrics
 	var err error
 
-	switch cfg.Type {
-	case "http":
-		res, err = runHTTP(ctx, cfg.Address)
-	case "dns":
-		res, err = runDNS(ctx, cfg.Address)
-	case "ping":
-		res, err = runPing(ctx, cfg)
+	switch cfg.AddressMode {
+	case AddressModeRoundRobin, AddressModeAll:
+		res, metrics, err = runAddresses(ctx, cfg)
 	default:
-		return 0, fmt.Errorf("unknown probe type: %s", cfg.Type)
+		res, metrics, err = runSingle(ctx, cfg)
 	}
 
 	// If success, enforce timeout check. Sometimes net calls might return success slightly after timeout?
@@ -95,20 +577,34 @@ func Run(cfg Config) (float64, error) {
 	// Let's be strict.
 	if err == nil {
 		if res >= float64(cfg.Timeout.Nanoseconds()) {
-			return 0, fmt.Errorf("probe timed out: duration %v exceeded limit %v", time.Duration(res), cfg.Timeout)
+			return 0, nil, fmt.Errorf("probe timed out: duration %v exceeded limit %v", time.Duration(res), cfg.Timeout)
 		}
 	}
 
 	if err != nil {
 		if strings.Contains(err.Error(), "probe timed out") {
-			return 0, err
+			return 0, nil, err
 		}
 		if isTimeout(err) {
-			return 0, fmt.Errorf("probe timed out: %w", err)
+			return 0, nil, fmt.Errorf("probe timed out: %w", err)
 		}
-		return 0, err
+		return 0, nil, err
+	}
+	return res, metrics, nil
+}
+
+// runSingle runs one probe of cfg's type against its address.
+func runSingle(ctx context.Context, cfg Config) (float64, Metrics, error) {
+	switch cfg.Type {
+	case "http":
+		return runHTTP(ctx, cfg)
+	case "dns":
+		res, err := runDNS(ctx, cfg.Address, cfg.SourceAddress, cfg.Resolver, cfg.DSCP)
+		return res, nil, err
+	case "ping":
+		return runPing(ctx, cfg)
 	}
-	return res, nil
+	return 0, nil, fmt.Errorf("unknown probe type: %s", cfg.Type)
 }
 
 func isTimeout(err error) bool {
@@ -122,32 +618,132 @@ func isTimeout(err error) bool {
 	return false
 }
 
-func runHTTP(ctx context.Context, address string) (float64, error) {
+func runHTTP(ctx context.Context, cfg Config) (float64, Metrics, error) {
+	address := cfg.Address
 	if !strings.HasPrefix(address, "http") {
 		address = "http://" + address
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "GET", address, nil)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
+	}
+	for name, value := range cfg.Headers {
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	if cfg.UserAgent != "" {
+		req.Header.Set("User-Agent", cfg.UserAgent)
+	}
+
+	client := cfg.client
+	var reused bool
+	if client == nil {
+		client = httpClient(cfg.SourceAddress, cfg.Resolver, cfg.DSCP)
+	} else {
+		req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+		}))
 	}
 
 	start := time.Now()
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 	defer resp.Body.Close()
 
 	// Read body to ensure we measure full transfer time
-	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
-		return 0, err
+	var body io.Reader = resp.Body
+	if cfg.MeasureThroughput {
+		body = io.LimitReader(resp.Body, cfg.MaxBodyBytes)
+	}
+	n, err := io.Copy(io.Discard, body)
+	if err != nil {
+		return 0, nil, err
 	}
+	latency := float64(time.Since(start).Nanoseconds())
+	setMetadata(ctx, MetadataHTTPStatus, strconv.Itoa(resp.StatusCode))
+	setMetadata(ctx, MetadataHTTPProtocol, resp.Proto)
+	setTLSMetadata(ctx, resp.TLS)
 
-	return float64(time.Since(start).Nanoseconds()), nil
+	if cfg.StatusMin != 0 && (resp.StatusCode < cfg.StatusMin || resp.StatusCode > cfg.StatusMax) {
+		return 0, nil, fmt.Errorf("%w: got %d, expected %d-%d", ErrUnexpectedStatus, resp.StatusCode, cfg.StatusMin, cfg.StatusMax)
+	}
+
+	var metrics Metrics
+	if cfg.Connection != "" {
+		metrics = Metrics{MetricHTTPConnReused: 0}
+		if reused {
+			metrics[MetricHTTPConnReused] = 1
+		}
+	}
+	if cfg.MeasureThroughput {
+		if metrics == nil {
+			metrics = Metrics{}
+		}
+		metrics[MetricHTTPBodyBytes] = float64(n)
+		if latency > 0 {
+			metrics[MetricHTTPThroughput] = float64(n) / (latency / 1e9)
+		}
+	}
+	return latency, metrics, nil
+}
+
+// dialClients caches one http.Client per source address, resolver and DSCP
+// class so customized probes reuse connections the same way plain ones do
+// via http.DefaultClient.
+var dialClients sync.Map // map[dialKey]*http.Client
+
+type dialKey struct {
+	sourceAddress, resolver string
+	dscp                    int
+}
+
+func httpClient(sourceAddress, resolver string, dscp int) *http.Client {
+	if sourceAddress == "" && resolver == "" && dscp == 0 {
+		return http.DefaultClient
+	}
+	key := dialKey{sourceAddress, resolver, dscp}
+	if c, ok := dialClients.Load(key); ok {
+		return c.(*http.Client)
+	}
+	c, _ := dialClients.LoadOrStore(key, &http.Client{Transport: newHTTPTransport(sourceAddress, resolver, dscp)})
+	return c.(*http.Client)
 }
 
-func runDNS(ctx context.Context, address string) (float64, error) {
+// newHTTPTransport returns a copy of http.DefaultTransport, which attempts
+// HTTP/2, dialing from sourceAddress, resolving through resolver and marking
+// packets with DSCP class dscp when they're set. A request whose context
+// carries a pinnedIPKey dials that IP instead of resolving the URL's host.
+func newHTTPTransport(sourceAddress, resolver string, dscp int) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Resolver:  resolverFor(resolver),
+	}
+	if sourceAddress != "" {
+		dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(sourceAddress)}
+	}
+	if dscp != 0 {
+		dialer.Control = dscpControl(dscp)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if ip, ok := ctx.Value(pinnedIPKey{}).(string); ok {
+			if _, port, err := net.SplitHostPort(addr); err == nil {
+				addr = net.JoinHostPort(ip, port)
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return transport
+}
+
+func runDNS(ctx context.Context, address, sourceAddress, resolver string, dscp int) (float64, error) {
 	// Query the DNS server at `address` for "example.com" A record
 	// using raw DNS packet construction
 
@@ -205,7 +801,13 @@ func runDNS(ctx context.Context, address string) (float64, error) {
 	packet := append(header, question...)
 
 	// Create UDP connection
-	dialer := net.Dialer{}
+	dialer := net.Dialer{Resolver: resolverFor(resolver)}
+	if sourceAddress != "" {
+		dialer.LocalAddr = &net.UDPAddr{IP: net.ParseIP(sourceAddress)}
+	}
+	if dscp != 0 {
+		dialer.Control = dscpControl(dscp)
+	}
 	conn, err := dialer.DialContext(ctx, "udp", targetAddr)
 	if err != nil {
 		return 0, fmt.Errorf("failed to dial DNS server: %w", err)
@@ -245,6 +847,10 @@ func runDNS(ctx context.Context, address string) (float64, error) {
 
 	// Check RCODE in flags (lower 4 bits of byte 3)
 	rcode := response[3] & 0x0F
+	setMetadata(ctx, MetadataDNSRcode, strconv.Itoa(int(rcode)))
+	if answer := dnsAnswerAddresses(response[:n], len(packet)); len(answer) > 0 {
+		setMetadata(ctx, MetadataDNSAnswer, strings.Join(answer, ","))
+	}
 	if rcode != 0 {
 		return 0, fmt.Errorf("DNS query failed with RCODE: %d", rcode)
 	}
@@ -252,49 +858,121 @@ func runDNS(ctx context.Context, address string) (float64, error) {
 	return elapsed, nil
 }
 
-// runPing executes the ping command and parses the result
-func runPing(ctx context.Context, cfg Config) (float64, error) {
+// dnsAnswerAddresses returns the A and AAAA records in the answer section
+// of a DNS response whose question section ends at offset, as our queries'
+// do. It stops at the first malformed record.
+func dnsAnswerAddresses(msg []byte, offset int) []string {
+	anCount := int(msg[6])<<8 | int(msg[7])
+	var addrs []string
+	for range anCount {
+		// Skip the owner name: labels ending in a zero byte, or a
+		// compression pointer.
+		for offset < len(msg) {
+			l := int(msg[offset])
+			if l&0xC0 == 0xC0 {
+				offset += 2
+				break
+			}
+			offset += 1 + l
+			if l == 0 {
+				break
+			}
+		}
+		if offset+10 > len(msg) {
+			return addrs
+		}
+		rrType := int(msg[offset])<<8 | int(msg[offset+1])
+		rdLen := int(msg[offset+8])<<8 | int(msg[offset+9])
+		offset += 10
+		if offset+rdLen > len(msg) {
+			return addrs
+		}
+		if (rrType == 1 && rdLen == net.IPv4len) || (rrType == 28 && rdLen == net.IPv6len) {
+			addrs = append(addrs, net.IP(msg[offset:offset+rdLen]).String())
+		}
+		offset += rdLen
+	}
+	return addrs
+}
+
+// runPing executes the ping command and parses the result. Each probe runs
+// its own ping process with its own ICMP socket, and the kernel and ping
+// match echo replies to it by identifier and sequence number, so concurrent
+// probes of different targets can't be handed each other's replies.
+func runPing(ctx context.Context, cfg Config) (float64, Metrics, error) {
+	if cfg.Resolver != "" && net.ParseIP(cfg.Address) == nil && len(cfg.Args) > 0 {
+		// ping uses the system resolver, so hand it the address instead.
+		addrs, err := resolverFor(cfg.Resolver).LookupHost(ctx, cfg.Address)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to resolve %s via %s: %w", cfg.Address, cfg.Resolver, err)
+		}
+		args := append([]string(nil), cfg.Args...)
+		args[len(args)-1] = addrs[0] // The address is always the last argument.
+		cfg.Args = args
+	}
 	return runCommand(ctx, cfg)
 }
 
-func runCommand(ctx context.Context, cfg Config) (float64, error) {
+// runCommand runs cfg's command and parses the latency out of its output
+// with cfg's pattern, also reporting MetricCommandDuration,
+// MetricCommandOverhead and cfg's MetricMappings.
+func runCommand(ctx context.Context, cfg Config) (float64, Metrics, error) {
 	cmd := exec.CommandContext(ctx, cfg.Command, cfg.Args...)
+	start := time.Now()
 	output, err := cmd.CombinedOutput()
+	duration := float64(time.Since(start).Nanoseconds())
 	if err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
-			return 0, fmt.Errorf("probe timed out after %v", cfg.Timeout)
+			return 0, nil, fmt.Errorf("probe timed out after %v", cfg.Timeout)
 		}
-		return 0, fmt.Errorf("command failed: %v, output: %s", err, string(output))
+		return 0, nil, &OutputError{Msg: fmt.Sprintf("command failed: %v", err), Output: string(output)}
 	}
 
 	var re *regexp.Regexp
 	if cfg.CompiledPattern != nil {
 		re = cfg.CompiledPattern
 	} else {
-		var err error
-		re, err = regexp.Compile(cfg.Pattern)
-		if err != nil {
-			return 0, fmt.Errorf("invalid regex pattern: %w", err)
+		if err := CompilePattern(&cfg); err != nil {
+			return 0, nil, err
 		}
+		re = cfg.CompiledPattern
 	}
 
 	matches := re.FindStringSubmatch(string(output))
 	if matches == nil {
-		return 0, fmt.Errorf("pattern not found in output: %s", string(output))
+		return 0, nil, &OutputError{Msg: "pattern not found", Output: string(output)}
 	}
 
 	valIdx := re.SubexpIndex("val")
 	if valIdx < 0 || valIdx >= len(matches) {
-		return 0, fmt.Errorf("capture group 'val' not found")
+		return 0, nil, fmt.Errorf("capture group 'val' not found")
 	}
 
 	valStr := matches[valIdx]
 	val, err := strconv.ParseFloat(valStr, 64)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse value '%s': %w", valStr, err)
+		return 0, nil, fmt.Errorf("failed to parse value '%s': %w", valStr, err)
 	}
 
 	// Convert to nanoseconds
 	valNS := val * cfg.Multiplier
-	return valNS, nil
+	metrics := Metrics{
+		MetricCommandDuration: duration,
+		MetricCommandOverhead: duration - valNS,
+	}
+	for group, m := range cfg.MetricMappings {
+		idx := re.SubexpIndex(group)
+		if idx < 0 || matches[idx] == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(matches[idx], 64)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to parse value '%s' of %s: %w", matches[idx], m.Metric, err)
+		}
+		if m.Multiplier != 0 {
+			v *= m.Multiplier
+		}
+		metrics[m.Metric] = v
+	}
+	return valNS, metrics, nil
 }
@@ -1,6 +1,15 @@
 package probe
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -135,3 +144,584 @@ func TestRunDNS_LookupIP_Integration(t *testing.T) {
 	}
 	t.Logf("DNS Probe -> 1.1.1.1 took %.2f ms", val/1e6)
 }
+
+func TestGetTargetConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		probeType   string
+		probeConfig string
+		wantErr     bool
+	}{
+		{"Empty", "http", "", false},
+		{"Headers", "http", `{"user_agent": "vaportrail/1.0", "headers": {"Host": "internal.example.com", "X-Probe": "1"}}`, false},
+		{"Unknown field", "http", `{"useragent": "x"}`, true},
+		{"Bad header name", "http", `{"headers": {"Bad Header": "x"}}`, true},
+		{"Header injection", "http", `{"headers": {"X-Probe": "a\r\nX-Evil: 1"}}`, true},
+		{"User agent injection", "http", `{"user_agent": "a\nb"}`, true},
+		{"Not JSON", "http", `nope`, true},
+		{"Config on ping", "ping", `{"headers": {}}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := GetTargetConfig(tt.probeType, "example.com", tt.probeConfig)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetTargetConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunHTTPHeaders(t *testing.T) {
+	var gotUA, gotHost, gotCustom string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUA = r.UserAgent()
+		gotHost = r.Host
+		gotCustom = r.Header.Get("X-Probe")
+	}))
+	defer srv.Close()
+
+	cfg, err := GetTargetConfig("http", srv.URL, `{"user_agent": "vaportrail-test", "headers": {"Host": "vhost.example.com", "X-Probe": "yes"}}`)
+	if err != nil {
+		t.Fatalf("GetTargetConfig failed: %v", err)
+	}
+	cfg.Timeout = 5 * time.Second
+
+	if _, err := Run(cfg); err != nil {
+		t.Fatalf("Run(http) failed: %v", err)
+	}
+	if gotUA != "vaportrail-test" {
+		t.Errorf("Expected User-Agent vaportrail-test, got %q", gotUA)
+	}
+	if gotHost != "vhost.example.com" {
+		t.Errorf("Expected Host vhost.example.com, got %q", gotHost)
+	}
+	if gotCustom != "yes" {
+		t.Errorf("Expected X-Probe yes, got %q", gotCustom)
+	}
+}
+
+func TestGetTargetConfigPingPayload(t *testing.T) {
+	cfg, err := GetTargetConfig("ping", "1.1.1.1", `{"payload_size": 1400}`)
+	if err != nil {
+		t.Fatalf("GetTargetConfig failed: %v", err)
+	}
+	want := []string{"-c", "1", "-s", "1400", "1.1.1.1"}
+	if strings.Join(cfg.Args, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected args %v, got %v", want, cfg.Args)
+	}
+
+	if _, err := GetTargetConfig("ping", "1.1.1.1", `{"payload_size": 9000}`); err == nil {
+		t.Error("Expected error for payload above MTU without allow_fragmentation")
+	}
+	if _, err := GetTargetConfig("ping", "1.1.1.1", `{"payload_size": 9000, "allow_fragmentation": true}`); err != nil {
+		t.Errorf("Expected fragmented payload to be allowed, got %v", err)
+	}
+	if _, err := GetTargetConfig("ping", "1.1.1.1", `{"payload_size": -1}`); err == nil {
+		t.Error("Expected error for negative payload size")
+	}
+}
+
+func TestGetTargetConfigDSCP(t *testing.T) {
+	cfg, err := GetTargetConfig("ping", "1.1.1.1", `{"dscp": 46}`)
+	if err != nil {
+		t.Fatalf("GetTargetConfig failed: %v", err)
+	}
+	if want := "-c 1 -Q 184 1.1.1.1"; strings.Join(cfg.Args, " ") != want {
+		t.Errorf("Expected args %q, got %q", want, strings.Join(cfg.Args, " "))
+	}
+	for _, probeType := range []string{"http", "dns"} {
+		cfg, err := GetTargetConfig(probeType, "1.1.1.1", `{"dscp": 10}`)
+		if err != nil || cfg.DSCP != 10 {
+			t.Errorf("%s: expected DSCP 10, got %d (%v)", probeType, cfg.DSCP, err)
+		}
+	}
+	for _, dscp := range []string{"-1", "64"} {
+		if _, err := GetTargetConfig("dns", "1.1.1.1", `{"dscp": `+dscp+`}`); err == nil {
+			t.Errorf("Expected error for DSCP %s", dscp)
+		}
+	}
+}
+
+func TestSourceAddress(t *testing.T) {
+	cfg, err := GetTargetConfig("ping", "1.1.1.1", `{"payload_size": 100, "source_address": "127.0.0.1"}`)
+	if err != nil {
+		t.Fatalf("GetTargetConfig failed: %v", err)
+	}
+	want := "-c 1 -s 100 -I 127.0.0.1 1.1.1.1"
+	if got := strings.Join(cfg.Args, " "); got != want {
+		t.Errorf("Expected args %q, got %q", want, got)
+	}
+	if err := CheckSourceAddress(cfg); err != nil {
+		t.Errorf("Expected loopback to be a local address, got %v", err)
+	}
+
+	if _, err := GetTargetConfig("dns", "1.1.1.1", `{"source_address": "not-an-ip"}`); err == nil {
+		t.Error("Expected error for a non-IP source address")
+	}
+
+	cfg, err = GetTargetConfig("dns", "1.1.1.1", `{"source_address": "192.0.2.55"}`)
+	if err != nil {
+		t.Fatalf("GetTargetConfig failed: %v", err)
+	}
+	if err := CheckSourceAddress(cfg); err == nil {
+		t.Error("Expected error for an address not assigned to any interface")
+	}
+}
+
+func TestRunHTTPSourceAddress(t *testing.T) {
+	var remote string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote = r.RemoteAddr
+	}))
+	defer srv.Close()
+
+	cfg, err := GetTargetConfig("http", srv.URL, `{"source_address": "127.0.0.1"}`)
+	if err != nil {
+		t.Fatalf("GetTargetConfig failed: %v", err)
+	}
+	cfg.Timeout = 5 * time.Second
+	if _, err := Run(cfg); err != nil {
+		t.Fatalf("Run(http) failed: %v", err)
+	}
+	if !strings.HasPrefix(remote, "127.0.0.1:") {
+		t.Errorf("Expected request from 127.0.0.1, got %s", remote)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	for _, resolver := range []string{"10.0.0.53:53", "[::1]:5353", "ns1.example.com:53"} {
+		cfg, err := GetTargetConfig("ping", "example.com", `{"resolver": "`+resolver+`"}`)
+		if err != nil {
+			t.Errorf("GetTargetConfig rejected resolver %q: %v", resolver, err)
+		} else if cfg.Resolver != resolver {
+			t.Errorf("Expected Resolver %q, got %q", resolver, cfg.Resolver)
+		}
+	}
+	for _, resolver := range []string{"10.0.0.53", "10.0.0.53:0", "10.0.0.53:dns", "bad_host-:53", ":53"} {
+		if _, err := GetTargetConfig("dns", "1.1.1.1", `{"resolver": "`+resolver+`"}`); err == nil {
+			t.Errorf("Expected error for resolver %q", resolver)
+		}
+	}
+
+	// Nothing listens on port 1, so the lookup can only fail if it goes
+	// there rather than to the system resolver.
+	cfg, err := GetTargetConfig("http", "http://probe.test/", `{"resolver": "127.0.0.1:1"}`)
+	if err != nil {
+		t.Fatalf("GetTargetConfig failed: %v", err)
+	}
+	cfg.Timeout = 5 * time.Second
+	_, err = Run(cfg)
+	if err == nil || !strings.Contains(err.Error(), "127.0.0.1:1") {
+		t.Errorf("Expected lookup via 127.0.0.1:1 to fail, got %v", err)
+	}
+}
+
+func TestRunHTTPThroughput(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	cfg, err := GetTargetConfig("http", srv.URL, `{}`)
+	if err != nil {
+		t.Fatalf("GetTargetConfig failed: %v", err)
+	}
+	cfg.Timeout = 5 * time.Second
+	if _, metrics, err := RunWithMetrics(cfg); err != nil || metrics != nil {
+		t.Errorf("Expected no metrics without measure_throughput, got %v, %v", metrics, err)
+	}
+
+	cfg, err = GetTargetConfig("http", srv.URL, `{"measure_throughput": true, "max_body_bytes": 100}`)
+	if err != nil {
+		t.Fatalf("GetTargetConfig failed: %v", err)
+	}
+	cfg.Timeout = 5 * time.Second
+	latency, metrics, err := RunWithMetrics(cfg)
+	if err != nil {
+		t.Fatalf("RunWithMetrics failed: %v", err)
+	}
+	if metrics[MetricHTTPBodyBytes] != 100 {
+		t.Errorf("Expected the body to be read up to 100 bytes, got %v", metrics[MetricHTTPBodyBytes])
+	}
+	if want := 100 / (latency / 1e9); metrics[MetricHTTPThroughput] != want {
+		t.Errorf("Expected throughput %v, got %v", want, metrics[MetricHTTPThroughput])
+	}
+
+	if cfg, _ := GetTargetConfig("http", srv.URL, `{"measure_throughput": true}`); cfg.MaxBodyBytes != DefaultMaxBodyBytes {
+		t.Errorf("Expected default max body of %d, got %d", DefaultMaxBodyBytes, cfg.MaxBodyBytes)
+	}
+	if _, err := GetTargetConfig("http", srv.URL, `{"max_body_bytes": -1}`); err == nil {
+		t.Errorf("Expected error for negative max_body_bytes")
+	}
+}
+
+func TestRunHTTPConnection(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		connection string
+		reused     []float64
+	}{
+		{ConnectionWarm, []float64{0, 1, 1}},
+		{ConnectionCold, []float64{0, 0, 0}},
+	} {
+		cfg, err := GetTargetConfig("http", srv.URL, `{"connection": "`+tt.connection+`"}`)
+		if err != nil {
+			t.Fatalf("GetTargetConfig(%s) failed: %v", tt.connection, err)
+		}
+		cfg.Timeout = 5 * time.Second
+		for i, want := range tt.reused {
+			_, metrics, err := RunWithMetrics(cfg)
+			if err != nil {
+				t.Fatalf("RunWithMetrics(%s) failed: %v", tt.connection, err)
+			}
+			if got, ok := metrics[MetricHTTPConnReused]; !ok || got != want {
+				t.Errorf("%s probe %d: expected %s %v, got %v", tt.connection, i, MetricHTTPConnReused, want, metrics)
+			}
+		}
+	}
+
+	cfg, _ := GetTargetConfig("http", srv.URL, `{}`)
+	cfg.Timeout = 5 * time.Second
+	if _, metrics, err := RunWithMetrics(cfg); err != nil || metrics != nil {
+		t.Errorf("Expected no metrics without a connection mode, got %v, %v", metrics, err)
+	}
+	if _, err := GetTargetConfig("http", srv.URL, `{"connection": "lukewarm"}`); err == nil {
+		t.Errorf("Expected error for an unknown connection mode")
+	}
+}
+
+func TestRunAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	address := "http://localhost:" + port
+
+	// localhost may also resolve to ::1, where nothing listens; that address
+	// fails without failing the probe.
+	for _, mode := range []string{AddressModeRoundRobin, AddressModeAll} {
+		cfg, err := GetTargetConfig("http", address, `{"address_mode": "`+mode+`"}`)
+		if err != nil {
+			t.Fatalf("GetTargetConfig(%s) failed: %v", mode, err)
+		}
+		cfg.Timeout = 5 * time.Second
+		seen := map[string]bool{}
+		for range 4 {
+			_, metrics, err := RunWithMetrics(cfg)
+			if err != nil && mode == AddressModeAll {
+				t.Fatalf("RunWithMetrics(%s) failed: %v", mode, err)
+			}
+			for name := range metrics {
+				seen[name] = true
+			}
+		}
+		if !seen[MetricAddressLatencyPrefix+"127.0.0.1"] {
+			t.Errorf("%s: expected a latency for 127.0.0.1, got %v", mode, seen)
+		}
+	}
+
+	if _, err := GetTargetConfig("http", address, `{"address_mode": "some"}`); err == nil {
+		t.Errorf("Expected error for an unknown address mode")
+	}
+	if _, err := GetTargetConfig("http", address, `{"address_mode": "all", "connection": "warm"}`); err == nil {
+		t.Errorf("Expected error for a warm connection to all addresses")
+	}
+}
+
+func TestRunHTTPExpectedStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		spec    string
+		wantErr bool
+	}{
+		{"Any status", "/broken", "", false},
+		{"Exact match", "/", "200", false},
+		{"Class match", "/", "2xx", false},
+		{"Range match", "/", "200-399", false},
+		{"Class mismatch", "/broken", "2xx", true},
+		{"Exact mismatch", "/", "204", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probeConfig := ""
+			if tt.spec != "" {
+				probeConfig = `{"expected_status": "` + tt.spec + `"}`
+			}
+			cfg, err := GetTargetConfig("http", srv.URL+tt.path, probeConfig)
+			if err != nil {
+				t.Fatalf("GetTargetConfig failed: %v", err)
+			}
+			cfg.Timeout = 5 * time.Second
+			_, err = Run(cfg)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnexpectedStatus) {
+					t.Errorf("Expected ErrUnexpectedStatus, got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+
+	for _, spec := range []string{"abc", "6xx", "99", "300-200", "200-"} {
+		if _, err := GetTargetConfig("http", srv.URL, `{"expected_status": "`+spec+`"}`); err == nil {
+			t.Errorf("Expected error for expected_status %q", spec)
+		}
+	}
+}
+
+func TestTypes(t *testing.T) {
+	types := Types()
+	if len(types) == 0 {
+		t.Fatal("Expected registered probe types")
+	}
+	zero := map[string]string{"string": `""`, "integer": "0", "number": "0", "boolean": "false", "object": "{}"}
+	for _, info := range types {
+		if _, err := GetConfig(info.Name, "example.com"); err != nil {
+			t.Errorf("Registered type %s is not supported by GetConfig: %v", info.Name, err)
+		}
+		// Every advertised option must be accepted by GetTargetConfig.
+		var fields []string
+		for _, opt := range info.Options {
+			fields = append(fields, fmt.Sprintf("%q: %s", opt.Name, zero[opt.Type]))
+		}
+		probeConfig := "{" + strings.Join(fields, ", ") + "}"
+		if _, err := GetTargetConfig(info.Name, "example.com", probeConfig); err != nil {
+			t.Errorf("Type %s rejects its advertised options %s: %v", info.Name, probeConfig, err)
+		}
+		if interval, timeout, ok := TypeDefaults(info.Name); !ok || interval != info.DefaultProbeInterval || timeout != info.DefaultTimeout {
+			t.Errorf("TypeDefaults(%s) = %v, %v, %v; want %v, %v", info.Name, interval, timeout, ok, info.DefaultProbeInterval, info.DefaultTimeout)
+		}
+	}
+	if _, _, ok := TypeDefaults("carrier-pigeon"); ok {
+		t.Error("Expected no defaults for an unknown type")
+	}
+
+	var httpInfo TypeInfo
+	for _, info := range types {
+		if info.Name == "http" {
+			httpInfo = info
+		}
+	}
+	var names []string
+	for _, opt := range httpInfo.Options {
+		names = append(names, opt.Name+":"+opt.Type)
+	}
+	if got := strings.Join(names, ","); got != "source_address:string,resolver:string,address_mode:string,dscp:integer,user_agent:string,headers:object,expected_status:string,measure_throughput:boolean,max_body_bytes:integer,connection:string" {
+		t.Errorf("Unexpected http options: %s", got)
+	}
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		probeType string
+		address   string
+		want      string
+		wantErr   bool
+	}{
+		{"ping", " 127.0.0.1 ", "127.0.0.1", false},
+		{"ping", "::1", "::1", false},
+		{"ping", "localhost", "localhost", false},
+		{"ping", "http://localhost", "", true},
+		{"ping", "localhost:80", "", true},
+		{"ping", "bad host", "", true},
+		{"ping", "nonexistent.invalid", "", true},
+		{"http", "example.com/health", "http://example.com/health", false},
+		{"http", "https://example.com:8443/", "https://example.com:8443/", false},
+		{"http", "ftp://example.com", "", true},
+		{"http", "http://", "", true},
+		{"http", "http://exa mple.com", "", true},
+		{"http", "http://example.com:99999", "", true},
+		{"dns", "8.8.8.8", "8.8.8.8", false},
+		{"dns", "2001:4860:4860::8888", "2001:4860:4860::8888", false},
+		{"dns", "[::1]:5353", "[::1]:5353", false},
+		{"dns", "resolver.example.com:53", "resolver.example.com:53", false},
+		{"dns", "-bad-.example.com", "", true},
+		{"dns", "8.8.8.8:0", "", true},
+		{"ping", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeAddress(tt.probeType, tt.address, "")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeAddress(%q, %q) error = %v, wantErr %v", tt.probeType, tt.address, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeAddress(%q, %q) = %q, want %q", tt.probeType, tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestRunWithMetadata(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	cfg, err := GetTargetConfig("http", srv.URL, `{"expected_status": "2xx"}`)
+	if err != nil {
+		t.Fatalf("GetTargetConfig failed: %v", err)
+	}
+	cfg.Timeout = 5 * time.Second
+	_, _, metadata, err := RunWithMetadata(cfg)
+	if err == nil {
+		t.Fatal("Expected the unexpected status to fail the probe")
+	}
+	if metadata[MetadataHTTPStatus] != "503" || metadata[MetadataHTTPProtocol] != "HTTP/1.1" {
+		t.Errorf("Expected the failed probe's status in its metadata, got %v", metadata)
+	}
+	if _, ok := metadata[MetadataTLSCipher]; ok {
+		t.Errorf("Expected no TLS metadata over plain HTTP, got %v", metadata)
+	}
+}
+
+func TestDNSAnswerAddresses(t *testing.T) {
+	question := []byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 1, 0, 1}
+	msg := append([]byte{0, 1, 0x81, 0x80, 0, 1, 0, 3, 0, 0, 0, 0}, question...)
+	// A record with a compressed name.
+	msg = append(msg, 0xC0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 93, 184, 215, 14)
+	// CNAME, which is skipped.
+	msg = append(msg, 0xC0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 2, 0xC0, 12)
+	// AAAA record with an uncompressed name.
+	msg = append(msg, question[:13]...)
+	msg = append(msg, 0, 28, 0, 1, 0, 0, 0, 60, 0, 16, 0x26, 0x06, 0x28, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1)
+
+	want := []string{"93.184.215.14", "2606:2800::1"}
+	if got := dnsAnswerAddresses(msg, 12+len(question)); !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	// A truncated response keeps the records read before the cut.
+	if got := dnsAnswerAddresses(msg[:len(msg)-5], 12+len(question)); !slices.Equal(got, want[:1]) {
+		t.Errorf("Expected %v from a truncated response, got %v", want[:1], got)
+	}
+}
+
+func TestRunCommandOverhead(t *testing.T) {
+	// The command reports 1ms but takes at least 50ms to do so.
+	cfg := Config{
+		Command:    "sh",
+		Args:       []string{"-c", "sleep 0.05; echo time=1 ms"},
+		Pattern:    `time=(?P<val>[0-9.]+)`,
+		Multiplier: 1e6,
+		Timeout:    5 * time.Second,
+	}
+	res, metrics, err := runCommand(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("runCommand failed: %v", err)
+	}
+	if res != 1e6 {
+		t.Errorf("Expected a reported latency of 1ms, got %v", time.Duration(res))
+	}
+	duration, overhead := metrics[MetricCommandDuration], metrics[MetricCommandOverhead]
+	if duration < float64(50*time.Millisecond) {
+		t.Errorf("Expected a command duration of at least 50ms, got %v", time.Duration(duration))
+	}
+	if overhead != duration-res {
+		t.Errorf("Expected an overhead of %v, got %v", time.Duration(duration-res), time.Duration(overhead))
+	}
+}
+
+func TestRunCommandOutputError(t *testing.T) {
+	cfg := Config{
+		Command:    "sh",
+		Args:       []string{"-c", "echo unexpected output"},
+		Pattern:    `time=(?P<val>[0-9.]+)`,
+		Multiplier: 1e6,
+		Timeout:    5 * time.Second,
+	}
+	_, _, err := runCommand(t.Context(), cfg)
+	var oe *OutputError
+	if !errors.As(err, &oe) || oe.Output != "unexpected output\n" {
+		t.Errorf("Expected an OutputError with the command's output, got %v", err)
+	}
+
+	cfg.Args = []string{"-c", "echo broken; exit 2"}
+	_, _, err = runCommand(t.Context(), cfg)
+	if !errors.As(err, &oe) || oe.Output != "broken\n" {
+		t.Errorf("Expected an OutputError for a failed command, got %v", err)
+	}
+}
+
+func TestRunCommandMetricMappings(t *testing.T) {
+	cfg := Config{
+		Command:    "sh",
+		Args:       []string{"-c", "echo loss=12.5% avg=3.2 ms"},
+		Pattern:    `loss=(?P<loss>[0-9.]+)%(?: jitter=(?P<jitter>[0-9.]+))? avg=(?P<val>[0-9.]+) ms`,
+		Multiplier: 1e6,
+		Timeout:    5 * time.Second,
+		MetricMappings: map[string]MetricMapping{
+			"loss":   {Metric: "loss_ratio", Multiplier: 0.01},
+			"jitter": {Metric: "jitter_ns", Multiplier: 1e6},
+		},
+	}
+	if err := CompilePattern(&cfg); err != nil {
+		t.Fatalf("CompilePattern failed: %v", err)
+	}
+	res, metrics, err := runCommand(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("runCommand failed: %v", err)
+	}
+	if res != 3.2e6 {
+		t.Errorf("Expected a latency of 3.2ms, got %v", time.Duration(res))
+	}
+	if got := metrics["loss_ratio"]; got != 0.125 {
+		t.Errorf("Expected loss_ratio 0.125, got %v", got)
+	}
+	if _, ok := metrics["jitter_ns"]; ok {
+		t.Errorf("Expected an unmatched group to be left out, got %v", metrics)
+	}
+
+	// Ping targets set them in their ProbeConfig, with a pattern that
+	// captures more of ping's output.
+	cfg, err = GetTargetConfig("ping", "1.1.1.1", `{"pattern": "ttl=(?P<ttl>\\d+) time=(?P<val>[0-9.]+) ms", "metric_mappings": {"ttl": {"metric": "ttl"}}}`)
+	if err != nil {
+		t.Fatalf("GetTargetConfig failed: %v", err)
+	}
+	cfg.Command, cfg.Args = "sh", []string{"-c", "echo 64 bytes from 1.1.1.1: icmp_seq=1 ttl=57 time=4.21 ms"}
+	res, metrics, err = runCommand(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("runCommand failed: %v", err)
+	}
+	if res != 4.21e6 || metrics["ttl"] != 57 {
+		t.Errorf("Expected 4.21ms with ttl 57, got %v and %v", time.Duration(res), metrics)
+	}
+	for _, probeConfig := range []string{
+		`{"metric_mappings": {"ttl": {"metric": "ttl"}}}`,
+		`{"pattern": "ttl=(?P<ttl>\\d+)", "metric_mappings": {"ttl": {"metric": "ttl"}}}`,
+		`{"pattern": "("}`,
+	} {
+		if _, err := GetTargetConfig("ping", "1.1.1.1", probeConfig); err == nil {
+			t.Errorf("Expected ping config %s to be rejected", probeConfig)
+		}
+	}
+
+	tests := []struct {
+		pattern  string
+		mappings map[string]MetricMapping
+	}{
+		{`(?P<loss>[0-9.]+)`, nil},
+		{`(?P<val>[0-9.]+)`, map[string]MetricMapping{"loss": {Metric: "loss"}}},
+		{`(?P<val>[0-9.]+)`, map[string]MetricMapping{"val": {Metric: "latency"}}},
+		{`(?P<val>[0-9.]+) (?P<a>\d+)`, map[string]MetricMapping{"a": {}}},
+		{`(?P<val>[0-9.]+) (?P<a>\d+) (?P<b>\d+)`, map[string]MetricMapping{"a": {Metric: "x"}, "b": {Metric: "x"}}},
+		{`(?P<val>[0-9.]+) (?P<a>\d+)`, map[string]MetricMapping{"a": {Metric: MetricCommandDuration}}},
+		{`(?P<val>[0-9.]+) (?P<a>\d+)`, map[string]MetricMapping{"a": {Metric: "x", Multiplier: math.NaN()}}},
+	}
+	for _, tt := range tests {
+		cfg := Config{Pattern: tt.pattern, MetricMappings: tt.mappings}
+		if err := CompilePattern(&cfg); err == nil {
+			t.Errorf("Expected pattern %q with mappings %v to be rejected", tt.pattern, tt.mappings)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// resolvers caches one *net.Resolver per custom DNS server address.
+var resolvers sync.Map // map[string]*net.Resolver
+
+// resolverFor returns a resolver that sends every query to server, or nil
+// (the system resolver) when server is empty.
+func resolverFor(server string) *net.Resolver {
+	if server == "" {
+		return nil
+	}
+	if r, ok := resolvers.Load(server); ok {
+		return r.(*net.Resolver)
+	}
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+	actual, _ := resolvers.LoadOrStore(server, r)
+	return actual.(*net.Resolver)
+}
+
+// checkResolver validates a resolver option, which must be a host:port.
+func checkResolver(server string) error {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		return fmt.Errorf("invalid resolver %q: expected host:port", server)
+	}
+	if net.ParseIP(host) == nil {
+		if err := checkHostname(host); err != nil {
+			return fmt.Errorf("invalid resolver %q: %w", server, err)
+		}
+	}
+	if port == "" {
+		return fmt.Errorf("invalid resolver %q: expected host:port", server)
+	}
+	if err := checkPort(port); err != nil {
+		return fmt.Errorf("invalid resolver %q: %w", server, err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package probe
+
+import (
+	"reflect"
+	"strings"
+)
+
+// TypeInfo describes a probe type and the options its ProbeConfig accepts.
+type TypeInfo struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Command     string       `json:"command,omitempty"` // external command it runs, if any
+	Options     []OptionInfo `json:"options"`
+	// DefaultProbeInterval and DefaultTimeout, in seconds, are used for new
+	// targets of this type that don't set their own.
+	DefaultProbeInterval float64 `json:"default_probe_interval"`
+	DefaultTimeout       float64 `json:"default_timeout"`
+}
+
+// OptionInfo describes a single ProbeConfig key.
+type OptionInfo struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"` // JSON type: string, integer, number, boolean or object
+	Description string `json:"description,omitempty"`
+}
+
+// registeredTypes lists every probe type in the order they're offered to
+// users. Options are read from the struct GetTargetConfig decodes each
+// type's ProbeConfig into, so the two can't drift apart.
+//
+// The default interval and timeout suit the type's usual targets: ping is
+// cheap and mostly aimed at nearby hosts, while an HTTP probe may fetch a
+// heavy endpoint that shouldn't be hit every second.
+var registeredTypes = []struct {
+	name        string
+	description string
+	options     any
+	interval    float64
+	timeout     float64
+}{
+	{"ping", "ICMP echo round trip, measured with the system ping command", PingOptions{}, 1, 2},
+	{"http", "Time to receive the response headers of an HTTP GET", HTTPOptions{}, 10, 5},
+	{"dns", "Round trip of a UDP DNS query to the address, which must be a resolver", SourceOptions{}, 5, 2},
+}
+
+// TypeDefaults returns the default probe interval and timeout, in seconds,
+// for new targets of a probe type. ok is false for unknown types.
+func TypeDefaults(probeType string) (interval, timeout float64, ok bool) {
+	for _, rt := range registeredTypes {
+		if rt.name == probeType {
+			return rt.interval, rt.timeout, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Types returns the available probe types.
+func Types() []TypeInfo {
+	types := make([]TypeInfo, 0, len(registeredTypes))
+	for _, rt := range registeredTypes {
+		info := TypeInfo{
+			Name:                 rt.name,
+			Description:          rt.description,
+			Options:              optionsOf(reflect.TypeOf(rt.options)),
+			DefaultProbeInterval: rt.interval,
+			DefaultTimeout:       rt.timeout,
+		}
+		if cfg, err := GetConfig(rt.name, ""); err == nil {
+			info.Command = cfg.Command
+		}
+		types = append(types, info)
+	}
+	return types
+}
+
+// optionsOf lists the JSON fields of an options struct, flattening embedded
+// structs the way encoding/json does.
+func optionsOf(t reflect.Type) []OptionInfo {
+	var opts []OptionInfo
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			opts = append(opts, optionsOf(f.Type)...)
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		opts = append(opts, OptionInfo{
+			Name:        name,
+			Type:        JSONType(f.Type),
+			Description: f.Tag.Get("desc"),
+		})
+	}
+	return opts
+}
+
+// JSONType returns the JSON Schema type that values of Go type t encode as.
+func JSONType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "string"
+}
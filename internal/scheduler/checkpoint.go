@@ -0,0 +1,151 @@
+package scheduler
+
+import (
+	"log"
+	"sync"
+	"time"
+	"vaportrail/internal/db"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// CheckpointStatus is the Checkpointer's latest view of the write-ahead log.
+type CheckpointStatus struct {
+	// WALSizeBytes is the size of the log file after the last checkpoint.
+	// PASSIVE checkpoints let SQLite reuse the file but never shrink it.
+	WALSizeBytes  int64  `json:"wal_size_bytes"`
+	TruncateBytes int64  `json:"truncate_bytes"`
+	LastMode      string `json:"last_mode,omitempty"` // "PASSIVE" or "TRUNCATE"
+	// Busy is set when the last checkpoint couldn't copy the whole log back
+	// because of readers still using it.
+	Busy           bool      `json:"busy"`
+	CheckpointedAt time.Time `json:"checkpointed_at,omitzero"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// Checkpointer checkpoints the database's write-ahead log on an interval,
+// so steady writes don't grow it without bound. Checkpoints are PASSIVE,
+// copying what readers allow without waiting on them, unless the log has
+// grown past truncateBytes; then a TRUNCATE checkpoint waits for readers and
+// shrinks the file back to nothing.
+type Checkpointer struct {
+	db            db.Store
+	interval      time.Duration
+	truncateBytes int64
+	clock         clockwork.Clock
+	stop          chan struct{}
+	wg            sync.WaitGroup
+
+	mu     sync.Mutex
+	status CheckpointStatus
+}
+
+// NewCheckpointer creates a checkpointer running every interval. A
+// truncateBytes of zero never truncates the log.
+func NewCheckpointer(database db.Store, interval time.Duration, truncateBytes int64) *Checkpointer {
+	return &Checkpointer{
+		db:            database,
+		interval:      interval,
+		truncateBytes: truncateBytes,
+		clock:         clockwork.NewRealClock(),
+		stop:          make(chan struct{}),
+		status:        CheckpointStatus{TruncateBytes: truncateBytes},
+	}
+}
+
+func (c *Checkpointer) Start() {
+	c.wg.Add(1)
+	go c.run()
+}
+
+func (c *Checkpointer) Stop() {
+	close(c.stop)
+	c.wg.Wait()
+}
+
+func (c *Checkpointer) run() {
+	defer c.wg.Done()
+	ticker := c.clock.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.Chan():
+			c.checkpoint()
+		}
+	}
+}
+
+// Status returns the result of the latest checkpoint.
+func (c *Checkpointer) Status() CheckpointStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+func (c *Checkpointer) checkpoint() {
+	fail := func(err error) {
+		c.mu.Lock()
+		c.status.Error = err.Error()
+		c.mu.Unlock()
+		log.Printf("Checkpointer: Failed to checkpoint the WAL: %v", err)
+	}
+
+	size, err := c.db.GetWALSizeBytes()
+	if err != nil {
+		fail(err)
+		return
+	}
+	truncate := c.truncateBytes > 0 && size > c.truncateBytes
+	mode := "PASSIVE"
+	if truncate {
+		mode = "TRUNCATE"
+	}
+	result, err := c.db.Checkpoint(truncate)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if truncate {
+		if result.Busy {
+			log.Printf("Warning: Checkpointer: WAL of %d bytes is over %d, but readers kept it from being truncated", size, c.truncateBytes)
+		} else {
+			log.Printf("Checkpointer: Truncated the WAL from %d bytes", size)
+		}
+	}
+	if size, err = c.db.GetWALSizeBytes(); err != nil {
+		fail(err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status.WALSizeBytes = size
+	c.status.LastMode = mode
+	c.status.Busy = result.Busy
+	c.status.CheckpointedAt = c.clock.Now()
+	c.status.Error = ""
+}
+
+// SetCheckpointInterval makes the scheduler checkpoint the database's
+// write-ahead log every interval, truncating it once it is over
+// truncateBytes. An interval of zero leaves checkpointing to SQLite. It must
+// be called before Start.
+func (s *Scheduler) SetCheckpointInterval(interval time.Duration, truncateBytes int64) {
+	if interval <= 0 {
+		s.checkpointer = nil
+		return
+	}
+	s.checkpointer = NewCheckpointer(s.db, interval, truncateBytes)
+}
+
+// CheckpointStatus reports the checkpointer's latest checkpoint, if
+// SetCheckpointInterval enabled it.
+func (s *Scheduler) CheckpointStatus() (CheckpointStatus, bool) {
+	if s.checkpointer == nil {
+		return CheckpointStatus{}, false
+	}
+	return s.checkpointer.Status(), true
+}
@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"vaportrail/internal/db"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func TestCheckpointer(t *testing.T) {
+	store := NewMockStore()
+	var modes []bool
+	store.CheckpointFn = func(truncate bool) (db.WALCheckpoint, error) {
+		modes = append(modes, truncate)
+		if truncate {
+			store.WALSizeBytes = 0
+		}
+		return db.WALCheckpoint{LogFrames: 10, Checkpointed: 10}, nil
+	}
+
+	s := New(store)
+	if _, ok := s.CheckpointStatus(); ok {
+		t.Error("Expected no checkpoint status before SetCheckpointInterval")
+	}
+	s.SetCheckpointInterval(time.Minute, 1000)
+	c := s.checkpointer
+	clock := clockwork.NewFakeClock()
+	c.clock = clock
+
+	// Under the threshold, checkpoints don't wait on readers.
+	store.WALSizeBytes = 500
+	c.checkpoint()
+	status, ok := s.CheckpointStatus()
+	if !ok {
+		t.Fatal("Expected a checkpoint status")
+	}
+	if status.LastMode != "PASSIVE" || status.WALSizeBytes != 500 || status.TruncateBytes != 1000 || !status.CheckpointedAt.Equal(clock.Now()) {
+		t.Errorf("Expected a passive checkpoint leaving 500 bytes, got %+v", status)
+	}
+
+	// Over it, the log is truncated.
+	store.WALSizeBytes = 2000
+	c.checkpoint()
+	if status := c.Status(); status.LastMode != "TRUNCATE" || status.WALSizeBytes != 0 {
+		t.Errorf("Expected a truncating checkpoint emptying the WAL, got %+v", status)
+	}
+	if len(modes) != 2 || modes[0] || !modes[1] {
+		t.Errorf("Expected a passive then a truncating checkpoint, got %v", modes)
+	}
+
+	// Readers holding the log are reported, not an error.
+	store.WALSizeBytes = 2000
+	store.CheckpointFn = func(truncate bool) (db.WALCheckpoint, error) {
+		return db.WALCheckpoint{Busy: true, LogFrames: 10, Checkpointed: 4}, nil
+	}
+	c.checkpoint()
+	if status := c.Status(); !status.Busy || status.WALSizeBytes != 2000 || status.Error != "" {
+		t.Errorf("Expected a busy checkpoint leaving the WAL, got %+v", status)
+	}
+
+	store.CheckpointFn = func(truncate bool) (db.WALCheckpoint, error) {
+		return db.WALCheckpoint{}, errors.New("disk I/O error")
+	}
+	c.checkpoint()
+	if status := c.Status(); status.Error != "disk I/O error" {
+		t.Errorf("Expected the checkpoint error, got %+v", status)
+	}
+
+	s.SetCheckpointInterval(0, 1000)
+	if _, ok := s.CheckpointStatus(); ok {
+		t.Error("Expected a zero interval to disable checkpointing")
+	}
+}
+
+func TestCheckpointer_RunsOnInterval(t *testing.T) {
+	store := NewMockStore()
+	done := make(chan struct{}, 1)
+	store.CheckpointFn = func(truncate bool) (db.WALCheckpoint, error) {
+		done <- struct{}{}
+		return db.WALCheckpoint{}, nil
+	}
+	c := NewCheckpointer(store, time.Minute, 0)
+	clock := clockwork.NewFakeClock()
+	c.clock = clock
+	c.Start()
+	defer c.Stop()
+
+	clock.BlockUntilContext(t.Context(), 1)
+	clock.Advance(time.Minute)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a checkpoint after the interval")
+	}
+}
@@ -0,0 +1,50 @@
+package scheduler
+
+import (
+	"log"
+	"os/exec"
+	"sort"
+	"strings"
+	"vaportrail/internal/db"
+	"vaportrail/internal/probe"
+)
+
+// lookPath is swapped out in tests.
+var lookPath = exec.LookPath
+
+// MissingCommands reports the external commands needed by the given targets'
+// probes that aren't installed, mapped to the names of the affected targets.
+// Probe types implemented natively (http, dns) need no command and are
+// skipped. The result is empty when everything is available.
+func MissingCommands(targets []db.Target) map[string][]string {
+	missing := make(map[string][]string)
+	found := make(map[string]bool)
+	for _, t := range targets {
+		cfg, err := probe.GetConfig(t.ProbeType, t.Address)
+		if err != nil || cfg.Command == "" {
+			continue
+		}
+		ok, checked := found[cfg.Command]
+		if !checked {
+			_, err := lookPath(cfg.Command)
+			ok = err == nil
+			found[cfg.Command] = ok
+		}
+		if !ok {
+			missing[cfg.Command] = append(missing[cfg.Command], t.Name)
+		}
+	}
+	return missing
+}
+
+func logMissingCommands(missing map[string][]string) {
+	commands := make([]string, 0, len(missing))
+	for cmd := range missing {
+		commands = append(commands, cmd)
+	}
+	sort.Strings(commands)
+	for _, cmd := range commands {
+		log.Printf("Warning: probe command %q not found in PATH; these targets will fail until it is installed: %s",
+			cmd, strings.Join(missing[cmd], ", "))
+	}
+}
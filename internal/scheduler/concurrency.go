@@ -0,0 +1,68 @@
+package scheduler
+
+import (
+	"sync/atomic"
+)
+
+// MaxConcurrentProbes is how many of a target's probes may be in flight at
+// once. A tick that finds them all still running is skipped.
+const MaxConcurrentProbes = 5
+
+// ProbeConcurrency reports how close a target runs to MaxConcurrentProbes.
+// A target that is often busy, or keeps skipping probes, has a timeout (or
+// retries) too long for its probe interval.
+type ProbeConcurrency struct {
+	InFlight    int   `json:"in_flight"`
+	MaxInFlight int   `json:"max_in_flight"` // high-water mark since the target started
+	Limit       int   `json:"limit"`
+	Skipped     int64 `json:"skipped"` // ticks skipped because Limit probes were in flight
+	Busy        bool  `json:"busy"`    // InFlight has reached Limit
+}
+
+// probeSlots is a target's probe semaphore, with counters for
+// ProbeConcurrency. runProbeLoop takes a slot by sending to sem and counts a
+// tick that finds none free in skipped.
+type probeSlots struct {
+	sem     chan struct{}
+	max     atomic.Int64
+	skipped atomic.Int64
+}
+
+func newProbeSlots() *probeSlots {
+	return &probeSlots{sem: make(chan struct{}, MaxConcurrentProbes)}
+}
+
+// started raises the high-water mark to the slots now taken, after a probe
+// took one.
+func (p *probeSlots) started() {
+	n := int64(len(p.sem))
+	for {
+		cur := p.max.Load()
+		if n <= cur || p.max.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
+
+func (p *probeSlots) stats() ProbeConcurrency {
+	inFlight := len(p.sem)
+	return ProbeConcurrency{
+		InFlight:    inFlight,
+		MaxInFlight: int(p.max.Load()),
+		Limit:       cap(p.sem),
+		Skipped:     p.skipped.Load(),
+		Busy:        inFlight >= cap(p.sem),
+	}
+}
+
+// ProbeConcurrency returns the probe concurrency of every target being
+// probed, by target ID.
+func (s *Scheduler) ProbeConcurrency() map[int64]ProbeConcurrency {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[int64]ProbeConcurrency, len(s.slots))
+	for id, slots := range s.slots {
+		stats[id] = slots.stats()
+	}
+	return stats
+}
@@ -0,0 +1,68 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+	"vaportrail/internal/db"
+	"vaportrail/internal/probe"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func TestScheduler_ProbeConcurrency(t *testing.T) {
+	mockDB := NewMockStore()
+	fakeClock := clockwork.NewFakeClock()
+	s := New(mockDB)
+	s.Clock = fakeClock
+	s.Start()
+	defer s.Stop()
+
+	release := make(chan struct{})
+	s.probeRunner = &MockRunner{
+		RunFn: func(cfg probe.Config) (float64, error) {
+			<-release
+			return 100, nil
+		},
+	}
+
+	target := db.Target{Name: "Slow", Address: "example.com", ProbeType: "http", ProbeInterval: 1, Timeout: 10}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+	s.AddTarget(target)
+
+	waitFor := func(cond func(ProbeConcurrency) bool) ProbeConcurrency {
+		t.Helper()
+		var c ProbeConcurrency
+		for range 100 {
+			c = s.ProbeConcurrency()[id]
+			if cond(c) {
+				return c
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("Timed out waiting for concurrency, last %+v", c)
+		return c
+	}
+
+	// Two more ticks than there are slots, all blocked.
+	for i := 0; i < MaxConcurrentProbes+2; i++ {
+		fakeClock.BlockUntilContext(t.Context(), 1)
+		fakeClock.Advance(time.Second)
+		waitFor(func(c ProbeConcurrency) bool { return c.InFlight+int(c.Skipped) == i+1 })
+	}
+	c := s.ProbeConcurrency()[id]
+	if c.InFlight != MaxConcurrentProbes || c.MaxInFlight != MaxConcurrentProbes || c.Skipped != 2 || !c.Busy || c.Limit != MaxConcurrentProbes {
+		t.Errorf("Unexpected concurrency while saturated: %+v", c)
+	}
+
+	close(release)
+	c = waitFor(func(c ProbeConcurrency) bool { return c.InFlight == 0 })
+	if c.MaxInFlight != MaxConcurrentProbes || c.Busy {
+		t.Errorf("Expected the high-water mark to be kept once idle, got %+v", c)
+	}
+
+	s.RemoveTarget(id)
+	if _, ok := s.ProbeConcurrency()[id]; ok {
+		t.Error("Expected a removed target to have no concurrency stats")
+	}
+}
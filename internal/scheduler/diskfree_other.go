@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package scheduler
+
+import "errors"
+
+func diskFreeBytes(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package scheduler
+
+import "syscall"
+
+// diskFreeBytes returns the space available to unprivileged users on the
+// filesystem containing path.
+func diskFreeBytes(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
@@ -0,0 +1,197 @@
+package scheduler
+
+import (
+	"log"
+	"sync"
+	"time"
+	"vaportrail/internal/db"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// diskCheckInterval is how often DiskGuard checks free space.
+const diskCheckInterval = time.Minute
+
+// DiskStatus is DiskGuard's latest view of the database's filesystem.
+type DiskStatus struct {
+	// FreeBytes is the space available for the database to grow into: the
+	// filesystem's free space plus pages SQLite has freed and will reuse.
+	FreeBytes int64 `json:"free_bytes"`
+	// ProjectedGrowthBytes is how much more the aggregated results will
+	// take once every window fills its retention, from the
+	// EstimatedTotalBytes of GetTDigestStats.
+	ProjectedGrowthBytes int64 `json:"projected_growth_bytes"`
+	MinFreeBytes         int64 `json:"min_free_bytes"`
+	// Low is set while FreeBytes is under MinFreeBytes. Raw results are
+	// dropped instead of written until it clears.
+	Low            bool      `json:"low"`
+	DroppedResults int64     `json:"dropped_results"`
+	CheckedAt      time.Time `json:"checked_at"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// DiskGuard watches free space on the filesystem holding the database. When
+// it drops below MinFreeBytes the guard runs retention on every check and
+// tells the scheduler to stop writing raw results until space is back, so a
+// full disk doesn't take the rest of the host down with it.
+type DiskGuard struct {
+	db        db.Store
+	path      string
+	minFree   int64
+	retention *RetentionManager
+	clock     clockwork.Clock
+	freeSpace func(path string) (int64, error)
+	stop      chan struct{}
+	wg        sync.WaitGroup
+
+	mu             sync.Mutex
+	status         DiskStatus
+	projectionWarn bool
+}
+
+// NewDiskGuard creates a guard for the filesystem containing path. A
+// minFreeBytes of zero only reports free space and never refuses writes.
+func NewDiskGuard(database db.Store, path string, minFreeBytes int64, retention *RetentionManager) *DiskGuard {
+	return &DiskGuard{
+		db:        database,
+		path:      path,
+		minFree:   minFreeBytes,
+		retention: retention,
+		clock:     clockwork.NewRealClock(),
+		freeSpace: diskFreeBytes,
+		stop:      make(chan struct{}),
+		status:    DiskStatus{MinFreeBytes: minFreeBytes},
+	}
+}
+
+func (g *DiskGuard) Start() {
+	g.wg.Add(1)
+	go g.run()
+}
+
+func (g *DiskGuard) Stop() {
+	close(g.stop)
+	g.wg.Wait()
+}
+
+func (g *DiskGuard) run() {
+	defer g.wg.Done()
+	ticker := g.clock.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+
+	g.check()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.Chan():
+			g.check()
+		}
+	}
+}
+
+// Status returns the result of the latest check.
+func (g *DiskGuard) Status() DiskStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.status
+}
+
+// low reports whether writes should be refused.
+func (g *DiskGuard) low() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.status.Low
+}
+
+func (g *DiskGuard) dropped(n int) {
+	g.mu.Lock()
+	g.status.DroppedResults += int64(n)
+	g.mu.Unlock()
+}
+
+func (g *DiskGuard) check() {
+	free, err := g.available()
+	if err != nil {
+		g.mu.Lock()
+		g.status.Error = err.Error()
+		g.status.CheckedAt = g.clock.Now()
+		g.mu.Unlock()
+		log.Printf("DiskGuard: Failed to check free space for %s: %v", g.path, err)
+		return
+	}
+
+	if g.minFree > 0 && free < g.minFree {
+		// Retention usually runs hourly; freed pages are reused by SQLite,
+		// so catching up may be enough to stay above the threshold. It keeps
+		// running on every check until space is back, to delete results as
+		// soon as they expire.
+		if !g.low() {
+			log.Printf("DiskGuard: Only %d bytes free (minimum %d), enforcing retention", free, g.minFree)
+		}
+		g.retention.enforceRetention()
+		if free, err = g.available(); err != nil {
+			log.Printf("DiskGuard: Failed to check free space for %s: %v", g.path, err)
+			return
+		}
+	}
+
+	var growth int64
+	if stats, err := g.db.GetTDigestStats(); err == nil {
+		for _, s := range stats {
+			growth += max(0, s.EstimatedTotalBytes-s.TotalBytes)
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	wasLow := g.status.Low
+	g.status.FreeBytes = free
+	g.status.ProjectedGrowthBytes = growth
+	g.status.Low = g.minFree > 0 && free < g.minFree
+	g.status.CheckedAt = g.clock.Now()
+	g.status.Error = ""
+
+	switch {
+	case g.status.Low && !wasLow:
+		log.Printf("Warning: DiskGuard: %d bytes free is below the minimum of %d; refusing to write raw results", free, g.minFree)
+	case !g.status.Low && wasLow:
+		log.Printf("DiskGuard: %d bytes free, writing raw results again (%d dropped)", free, g.status.DroppedResults)
+	}
+	if warn := free-growth < g.minFree; warn != g.projectionWarn {
+		g.projectionWarn = warn
+		if warn {
+			log.Printf("Warning: DiskGuard: aggregated results are projected to grow by %d bytes under current retention, but only %d bytes are free", growth, free)
+		}
+	}
+}
+
+// available is the filesystem's free space plus the database's freelist.
+func (g *DiskGuard) available() (int64, error) {
+	free, err := g.freeSpace(g.path)
+	if err != nil {
+		return 0, err
+	}
+	if pages, err := g.db.GetFreelistCount(); err == nil {
+		if size, err := g.db.GetPageSize(); err == nil {
+			free += pages * size
+		}
+	}
+	return free, nil
+}
+
+// SetDiskGuard makes the scheduler watch free space on the filesystem
+// containing path, refusing raw result writes while less than minFreeBytes
+// is available. It must be called before Start.
+func (s *Scheduler) SetDiskGuard(path string, minFreeBytes int64) {
+	s.diskGuard = NewDiskGuard(s.db, path, minFreeBytes, s.retentionManager)
+}
+
+// DiskStatus reports the disk guard's latest check, if SetDiskGuard was
+// called.
+func (s *Scheduler) DiskStatus() (DiskStatus, bool) {
+	if s.diskGuard == nil {
+		return DiskStatus{}, false
+	}
+	return s.diskGuard.Status(), true
+}
@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+	"vaportrail/internal/db"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func TestDiskGuard(t *testing.T) {
+	store := NewMockStore()
+	store.Targets[1] = db.Target{ID: 1, Name: "t", RetentionPolicies: `[{"window": 0, "retention": 3600}]`}
+	now := time.Now()
+	store.RawResults[1] = []db.RawResult{
+		{Time: now.Add(-2 * time.Hour), TargetID: 1, Latency: 1},
+		{Time: now.Add(-time.Minute), TargetID: 1, Latency: 2},
+	}
+	store.TDigestStats = []db.TDigestStat{
+		{TargetID: 1, WindowSeconds: 60, TotalBytes: 1000, EstimatedTotalBytes: 5000},
+		{TargetID: 1, WindowSeconds: 3600, TotalBytes: 2000, EstimatedTotalBytes: 1500}, // Already over its projection.
+	}
+
+	s := New(store)
+	s.SetDiskGuard("/data", 1000)
+	g := s.diskGuard
+	clock := clockwork.NewFakeClockAt(now)
+	g.clock = clock
+	g.retention.clock = clock
+	free := int64(500)
+	g.freeSpace = func(path string) (int64, error) {
+		if path != "/data" {
+			t.Errorf("Expected free space of /data, got %s", path)
+		}
+		return free, nil
+	}
+
+	g.check()
+	status, ok := s.DiskStatus()
+	if !ok {
+		t.Fatal("Expected a disk status")
+	}
+	if !status.Low || status.FreeBytes != 500 || status.MinFreeBytes != 1000 {
+		t.Errorf("Expected low status with 500 bytes free, got %+v", status)
+	}
+	if status.ProjectedGrowthBytes != 4000 {
+		t.Errorf("Expected projected growth 4000, got %d", status.ProjectedGrowthBytes)
+	}
+	if len(store.RawResults[1]) != 1 {
+		t.Errorf("Expected retention to run when space got low, %d raw results left", len(store.RawResults[1]))
+	}
+
+	// Raw results are dropped while space is low.
+	s.batchWG.Add(1)
+	go s.runBatchWriter()
+	s.rawResultChan <- db.RawResult{Time: now, TargetID: 1, Latency: 3}
+	close(s.batchStopChan)
+	s.batchWG.Wait()
+	if len(store.RawResults[1]) != 1 {
+		t.Errorf("Expected the result to be dropped, have %d raw results", len(store.RawResults[1]))
+	}
+	if status := g.Status(); status.DroppedResults != 1 {
+		t.Errorf("Expected 1 dropped result, got %d", status.DroppedResults)
+	}
+
+	// Retention keeps running while space stays low.
+	clock.Advance(time.Hour)
+	g.check()
+	if !g.low() || len(store.RawResults[1]) != 0 {
+		t.Errorf("Expected retention to run again while space is low, %d raw results left", len(store.RawResults[1]))
+	}
+
+	free = 5000
+	g.check()
+	if g.low() {
+		t.Errorf("Expected writes to resume once space is back")
+	}
+
+	// Without a minimum, the guard only reports.
+	s = New(store)
+	if _, ok := s.DiskStatus(); ok {
+		t.Errorf("Expected no disk status without SetDiskGuard")
+	}
+	s.SetDiskGuard("/data", 0)
+	s.diskGuard.freeSpace = func(string) (int64, error) { return 0, nil }
+	s.diskGuard.check()
+	if s.diskGuard.low() {
+		t.Errorf("Expected no write refusal without a minimum")
+	}
+}
+
+func TestDiskFreeBytes(t *testing.T) {
+	free, err := diskFreeBytes(t.TempDir())
+	if err != nil {
+		t.Skipf("Free space not supported here: %v", err)
+	}
+	if free <= 0 {
+		t.Errorf("Expected free space, got %d", free)
+	}
+}
@@ -0,0 +1,62 @@
+package scheduler
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultFailureLogInterval is how often a target failing the same way
+// repeatedly gets its failure logged again; see SetFailureLogInterval.
+const DefaultFailureLogInterval = time.Minute
+
+// failureLog rate-limits a target's "Probe failed" log lines, so an outage
+// doesn't log one on every probe. A failure is logged when it is the first,
+// differs from the last one logged, or interval has passed since; the rest
+// are only counted, and the count is reported with the next line logged.
+// The first success after a failure logs a recovery.
+type failureLog struct {
+	interval time.Duration
+
+	mu         sync.Mutex
+	msg        string // last failure logged; empty while the target is healthy
+	logged     time.Time
+	suppressed int
+}
+
+func (f *failureLog) failed(now time.Time, name string, err error) {
+	msg := err.Error()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if msg == f.msg && now.Sub(f.logged) < f.interval {
+		f.suppressed++
+		return
+	}
+	if f.suppressed > 0 {
+		log.Printf("Probe failed for %s: %v (%d failures suppressed since last logged)", name, err, f.suppressed)
+	} else {
+		log.Printf("Probe failed for %s: %v", name, err)
+	}
+	f.msg, f.logged, f.suppressed = msg, now, 0
+}
+
+func (f *failureLog) recovered(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.msg == "" {
+		return
+	}
+	if f.suppressed > 0 {
+		log.Printf("Probe for %s recovered (%d failures suppressed since last logged)", name, f.suppressed)
+	} else {
+		log.Printf("Probe for %s recovered", name)
+	}
+	f.msg, f.suppressed = "", 0
+}
+
+// SetFailureLogInterval sets how often a target failing the same way
+// repeatedly has its failure logged again, DefaultFailureLogInterval unless
+// set. Zero logs every failure. Call it before Start.
+func (s *Scheduler) SetFailureLogInterval(interval time.Duration) {
+	s.failureLogInterval = interval
+}
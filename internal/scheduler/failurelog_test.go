@@ -0,0 +1,75 @@
+package scheduler
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailureLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	lines := func() []string {
+		defer buf.Reset()
+		return strings.Split(strings.TrimSpace(buf.String()), "\n")
+	}
+
+	f := &failureLog{interval: time.Minute}
+	start := time.Now()
+	refused := errors.New("connection refused")
+
+	f.failed(start, "web", refused)
+	for i := 1; i <= 5; i++ {
+		f.failed(start.Add(time.Duration(i)*time.Second), "web", refused)
+	}
+	if got := lines(); len(got) != 1 || !strings.HasSuffix(got[0], "Probe failed for web: connection refused") {
+		t.Fatalf("Expected only the first failure logged, got %q", got)
+	}
+
+	f.failed(start.Add(time.Minute), "web", refused)
+	if got := lines(); len(got) != 1 || !strings.HasSuffix(got[0], "(5 failures suppressed since last logged)") {
+		t.Fatalf("Expected a periodic line with the suppressed count, got %q", got)
+	}
+
+	// A different failure is logged right away.
+	f.failed(start.Add(time.Minute+time.Second), "web", errors.New("no route to host"))
+	f.failed(start.Add(time.Minute+2*time.Second), "web", errors.New("no route to host"))
+	if got := lines(); len(got) != 1 || !strings.HasSuffix(got[0], "Probe failed for web: no route to host") {
+		t.Fatalf("Expected the new failure logged, got %q", got)
+	}
+
+	f.recovered("web")
+	if got := lines(); len(got) != 1 || !strings.HasSuffix(got[0], "Probe for web recovered (1 failures suppressed since last logged)") {
+		t.Fatalf("Expected a recovery line, got %q", got)
+	}
+	f.recovered("web")
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing logged for a healthy target, got %q", buf.String())
+	}
+
+	// After recovery the next failure is logged even within the interval.
+	f.failed(start.Add(time.Minute+3*time.Second), "web", refused)
+	if got := lines(); len(got) != 1 || got[0] == "" {
+		t.Errorf("Expected the failure after recovery logged, got %q", got)
+	}
+}
+
+func TestFailureLog_ZeroIntervalLogsEveryFailure(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	f := &failureLog{}
+	now := time.Now()
+	for range 3 {
+		f.failed(now, "web", errors.New("connection refused"))
+	}
+	if n := strings.Count(buf.String(), "Probe failed for web"); n != 3 {
+		t.Errorf("Expected 3 failures logged, got %d", n)
+	}
+}
@@ -0,0 +1,67 @@
+package scheduler
+
+import (
+	"log"
+	"vaportrail/internal/db"
+)
+
+// ResultHook is called with every raw result once it has been written to the
+// database, along with the target that produced it. A timed-out or failed
+// probe has a Latency of -1.
+//
+// Hooks run one after another on a single dispatcher goroutine, not on the
+// probe loops, so a slow hook delays the hooks behind it but never probing
+// or storage. Batches that arrive while the dispatcher is behind are dropped,
+// so hooks must not block indefinitely: bound any network I/O with a timeout
+// or hand the work off to a goroutine of your own.
+type ResultHook func(target db.Target, r db.RawResult)
+
+// hookQueueSize is how many flushed batches may wait for the hook dispatcher
+// before new ones are dropped.
+const hookQueueSize = 64
+
+// RegisterResultHook adds a hook to be called for every committed result.
+func (s *Scheduler) RegisterResultHook(hook ResultHook) {
+	s.mu.Lock()
+	s.hooks = append(s.hooks, hook)
+	s.mu.Unlock()
+}
+
+// queueHooks hands a flushed batch to the dispatcher without blocking the
+// batch writer.
+func (s *Scheduler) queueHooks(batch []db.RawResult) {
+	s.mu.Lock()
+	hasHooks := len(s.hooks) > 0
+	s.mu.Unlock()
+	if !hasHooks {
+		return
+	}
+	select {
+	case s.hookChan <- append([]db.RawResult(nil), batch...):
+	default:
+		log.Printf("Result hooks are falling behind; dropped %d results", len(batch))
+	}
+}
+
+func (s *Scheduler) runHooks() {
+	defer s.hookWG.Done()
+	for batch := range s.hookChan {
+		s.mu.Lock()
+		hooks := append([]ResultHook(nil), s.hooks...)
+		targets := make(map[int64]db.Target, len(batch))
+		for _, r := range batch {
+			targets[r.TargetID] = s.targets[r.TargetID]
+		}
+		s.mu.Unlock()
+
+		for _, r := range batch {
+			t := targets[r.TargetID]
+			if t.ID == 0 {
+				t.ID = r.TargetID
+			}
+			for _, hook := range hooks {
+				hook(t, r)
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package scheduler
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// MaxSampleLatencyNS caps a probe's latency. No probe legitimately takes
+// this long, but a buggy probe (a custom pattern matching the wrong number,
+// say) can return anything, and a single huge sample would swamp a window's
+// sums and overflow the int64 nanosecond fields the API reports.
+const MaxSampleLatencyNS = float64(time.Hour)
+
+// checkLatency vets the latency of a successful probe. NaN, infinite and
+// negative latencies are rejected with an error; the -1 timeout sentinel is
+// only ever set by the scheduler itself, so it's rejected here too. Anything
+// over MaxSampleLatencyNS is capped.
+func checkLatency(latency float64) (float64, error) {
+	if math.IsNaN(latency) || math.IsInf(latency, 0) || latency < 0 {
+		return 0, fmt.Errorf("probe returned invalid latency %v", latency)
+	}
+	return min(latency, MaxSampleLatencyNS), nil
+}
+
+// validStoredLatency reports whether a stored raw latency can be aggregated:
+// the -1 timeout sentinel or a finite, non-negative value.
+func validStoredLatency(latency float64) bool {
+	return latency == -1 || (latency >= 0 && !math.IsInf(latency, 0))
+}
+
+// RejectedSamples returns how many probe results have been dropped because
+// the probe returned an invalid latency.
+func (s *Scheduler) RejectedSamples() int64 {
+	return s.rejectedSamples.Load()
+}
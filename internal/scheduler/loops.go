@@ -0,0 +1,136 @@
+package scheduler
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"vaportrail/internal/probe"
+)
+
+// MaxFailureOutput is how much of a failed command probe's output is kept
+// for LoopState.LastFailureOutput, in bytes.
+const MaxFailureOutput = 4096
+
+// LoopState is a snapshot of a target's probe loop, for debugging a target
+// that isn't probing as expected.
+type LoopState struct {
+	TargetID        int64            `json:"target_id"`
+	Name            string           `json:"name"`
+	ProbeType       string           `json:"probe_type"`
+	IntervalSeconds float64          `json:"interval_seconds"`
+	Started         time.Time        `json:"started"`
+	Concurrency     ProbeConcurrency `json:"concurrency"`
+	// LastProbe is when the last probe started, and LastError the error
+	// of the last one that failed, timeouts included, at LastErrorTime.
+	LastProbe     time.Time `json:"last_probe,omitzero"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitzero"`
+	// LastFailureOutput is what the command of the last probe printed, if
+	// the probe failed because the command did or its output didn't match
+	// the pattern, up to MaxFailureOutput bytes. It is cleared by any
+	// other outcome, success included.
+	LastFailureOutput string `json:"last_failure_output,omitempty"`
+	Down              bool   `json:"down"`
+	// Exited is set on a loop that gave up, such as on a probe config that
+	// no longer parses, while the target is still registered. LastError
+	// says why.
+	Exited bool `json:"exited,omitempty"`
+}
+
+// loopState is the mutable part of a LoopState, updated by the target's
+// probe loop and its probes.
+type loopState struct {
+	mu            sync.Mutex
+	name          string // the target's, which UpdateTarget may change
+	started       time.Time
+	interval      time.Duration
+	lastProbe     time.Time
+	lastError     string
+	lastErrorTime time.Time
+	lastOutput    string
+	exited        bool
+	status        *downTracker
+}
+
+func (l *loopState) targetName() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.name
+}
+
+func (l *loopState) probed(at time.Time) {
+	l.mu.Lock()
+	l.lastProbe = at
+	l.mu.Unlock()
+}
+
+// failed records a probe's error, along with the command output it carries
+// if it is a probe.OutputError.
+func (l *loopState) failed(at time.Time, err error) {
+	var output string
+	var oe *probe.OutputError
+	if errors.As(err, &oe) {
+		output = truncateOutput(oe.Output)
+	}
+	l.mu.Lock()
+	l.lastError, l.lastErrorTime = err.Error(), at
+	l.lastOutput = output
+	l.mu.Unlock()
+}
+
+// succeeded clears the output of an earlier failure.
+func (l *loopState) succeeded() {
+	l.mu.Lock()
+	l.lastOutput = ""
+	l.mu.Unlock()
+}
+
+// truncateOutput cuts output to MaxFailureOutput bytes, without splitting a
+// UTF-8 sequence.
+func truncateOutput(output string) string {
+	if len(output) <= MaxFailureOutput {
+		return output
+	}
+	return strings.ToValidUTF8(output[:MaxFailureOutput], "") + "... (truncated)"
+}
+
+// ProbeLoops returns the state of every target's probe loop, by target ID.
+// It only copies what the loops already track, so it is cheap to call.
+func (s *Scheduler) ProbeLoops() []LoopState {
+	var states []LoopState
+	var trackers []*downTracker
+	s.mu.Lock()
+	for id, l := range s.loops {
+		t := s.targets[id]
+		state := LoopState{TargetID: id, Name: t.Name, ProbeType: t.ProbeType}
+		if slots := s.slots[id]; slots != nil {
+			state.Concurrency = slots.stats()
+		}
+		l.mu.Lock()
+		state.IntervalSeconds = l.interval.Seconds()
+		state.Started = l.started
+		state.LastProbe = l.lastProbe
+		state.LastError = l.lastError
+		state.LastErrorTime = l.lastErrorTime
+		state.LastFailureOutput = l.lastOutput
+		state.Exited = l.exited
+		trackers = append(trackers, l.status)
+		l.mu.Unlock()
+		states = append(states, state)
+	}
+	s.mu.Unlock()
+
+	// observe takes s.mu while holding a tracker's lock, so trackers are
+	// only read once s.mu is released.
+	for i, dt := range trackers {
+		if dt != nil {
+			dt.mu.Lock()
+			states[i].Down = dt.down
+			dt.mu.Unlock()
+		}
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].TargetID < states[j].TargetID })
+	return states
+}
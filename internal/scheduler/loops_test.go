@@ -0,0 +1,134 @@
+package scheduler
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"vaportrail/internal/db"
+	"vaportrail/internal/probe"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func TestScheduler_ProbeLoops(t *testing.T) {
+	mockDB := NewMockStore()
+	fakeClock := clockwork.NewFakeClock()
+	s := New(mockDB)
+	s.Clock = fakeClock
+	s.probeRunner = &MockRunner{
+		RunFn: func(cfg probe.Config) (float64, error) {
+			return 0, errors.New("probe timed out: no reply")
+		},
+	}
+	s.Start()
+	defer s.Stop()
+
+	target := db.Target{Name: "Flaky", Address: "example.com", ProbeType: "http", ProbeInterval: 2, Timeout: 1, DownAfter: 1}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+	s.AddTarget(target)
+	broken := db.Target{Name: "Broken", Address: "example.com", ProbeType: "carrier-pigeon"}
+	brokenID, _ := mockDB.AddTarget(&broken)
+	broken.ID = brokenID
+	s.AddTarget(broken)
+
+	// The batch writer's ticker and Flaky's.
+	fakeClock.BlockUntilContext(t.Context(), 2)
+	fakeClock.Advance(2 * time.Second)
+
+	var loops []LoopState
+	for range 100 {
+		loops = s.ProbeLoops()
+		if len(loops) == 2 && loops[0].Down && loops[1].Exited {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(loops) != 2 || loops[0].TargetID != id || loops[1].TargetID != brokenID {
+		t.Fatalf("Expected both loops in ID order, got %+v", loops)
+	}
+
+	flaky := loops[0]
+	if flaky.Name != "Flaky" || flaky.ProbeType != "http" || flaky.IntervalSeconds != 2 {
+		t.Errorf("Unexpected loop definition: %+v", flaky)
+	}
+	if !flaky.LastProbe.Equal(fakeClock.Now().UTC()) || flaky.LastError != "probe timed out: no reply" || !flaky.Down || flaky.Exited {
+		t.Errorf("Expected a timed-out probe that marked the target down, got %+v", flaky)
+	}
+	if flaky.Concurrency.Limit != MaxConcurrentProbes {
+		t.Errorf("Expected the concurrency limit, got %+v", flaky.Concurrency)
+	}
+	if b := loops[1]; !b.Exited || b.LastError == "" || !b.LastProbe.IsZero() {
+		t.Errorf("Expected the broken target's loop to have exited, got %+v", b)
+	}
+
+	s.RemoveTarget(brokenID)
+	if loops := s.ProbeLoops(); len(loops) != 1 {
+		t.Errorf("Expected a removed target's loop to be gone, got %+v", loops)
+	}
+}
+
+func TestScheduler_ProbeLoopsFailureOutput(t *testing.T) {
+	mockDB := NewMockStore()
+	fakeClock := clockwork.NewFakeClock()
+	s := New(mockDB)
+	s.Clock = fakeClock
+	var fail atomic.Bool
+	fail.Store(true)
+	s.probeRunner = &MockRunner{
+		RunFn: func(cfg probe.Config) (float64, error) {
+			if fail.Load() {
+				return 0, &probe.OutputError{Msg: "pattern not found", Output: "ping: unknown host"}
+			}
+			return 100, nil
+		},
+	}
+	s.Start()
+	defer s.Stop()
+
+	target := db.Target{Name: "Custom", Address: "example.com", ProbeType: "http", ProbeInterval: 1, Timeout: 1}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+	s.AddTarget(target)
+
+	waitFor := func(cond func(LoopState) bool) LoopState {
+		t.Helper()
+		var state LoopState
+		for range 100 {
+			if loops := s.ProbeLoops(); len(loops) == 1 {
+				state = loops[0]
+				if cond(state) {
+					return state
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("Timed out waiting for the loop, last %+v", state)
+		return state
+	}
+
+	fakeClock.BlockUntilContext(t.Context(), 2)
+	fakeClock.Advance(time.Second)
+	waitFor(func(l LoopState) bool { return l.LastFailureOutput == "ping: unknown host" })
+
+	fail.Store(false)
+	fakeClock.Advance(time.Second)
+	state := waitFor(func(l LoopState) bool { return l.LastFailureOutput == "" })
+	if !strings.HasPrefix(state.LastError, "pattern not found") {
+		t.Errorf("Expected the last error to be kept after a success, got %q", state.LastError)
+	}
+}
+
+func TestTruncateOutput(t *testing.T) {
+	if got := truncateOutput("short"); got != "short" {
+		t.Errorf("Expected short output unchanged, got %q", got)
+	}
+	// A multi-byte rune straddling the limit is dropped whole.
+	long := strings.Repeat("a", MaxFailureOutput-1) + "é" + "tail"
+	got := truncateOutput(long)
+	if want := strings.Repeat("a", MaxFailureOutput-1) + "... (truncated)"; got != want {
+		t.Errorf("Expected %d bytes and a marker, got %d bytes ending %q", len(want), len(got), got[len(got)-20:])
+	}
+}
@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// pauseHistory is how long a finished pause is remembered for marking
+// rollups; windows rolled up later than that get ordinary empty rollups.
+const pauseHistory = 7 * 24 * time.Hour
+
+// MaintenanceStatus reports whether probing is paused for maintenance.
+type MaintenanceStatus struct {
+	Paused bool      `json:"paused"`
+	Since  time.Time `json:"since,omitzero"`
+	// Until is when probing resumes by itself; zero waits for
+	// ResumeProbing.
+	Until time.Time `json:"until,omitzero"`
+}
+
+// pausePeriod is one pause, with a zero end while it lasts.
+type pausePeriod struct {
+	start, end time.Time
+}
+
+// maintenance is the scheduler's pause state, shared with its rollup
+// manager so windows left without data by a pause become maintenance gaps.
+// It is kept in memory only; a restart resumes probing.
+type maintenance struct {
+	mu     sync.Mutex
+	until  time.Time
+	pauses []pausePeriod // oldest first; only the last may be ongoing
+}
+
+func (m *maintenance) pause(now, until time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resumeIfDue(now)
+	m.until = until
+	if n := len(m.pauses); n > 0 && m.pauses[n-1].end.IsZero() {
+		return // Already paused; only the resume time changes.
+	}
+	for len(m.pauses) > 0 && now.Sub(m.pauses[0].end) > pauseHistory {
+		m.pauses = m.pauses[1:]
+	}
+	m.pauses = append(m.pauses, pausePeriod{start: now})
+}
+
+// resume ends the current pause at now and reports whether there was one.
+func (m *maintenance) resume(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.end(now)
+}
+
+func (m *maintenance) end(at time.Time) bool {
+	n := len(m.pauses)
+	if n == 0 || !m.pauses[n-1].end.IsZero() {
+		return false
+	}
+	m.pauses[n-1].end = at
+	m.until = time.Time{}
+	return true
+}
+
+// resumeIfDue ends the current pause if its resume time has passed. m.mu
+// must be held.
+func (m *maintenance) resumeIfDue(now time.Time) {
+	if !m.until.IsZero() && !now.Before(m.until) {
+		if m.end(m.until) {
+			log.Printf("Scheduler: Maintenance window over, resuming probes")
+		}
+	}
+}
+
+func (m *maintenance) status(now time.Time) MaintenanceStatus {
+	if m == nil {
+		return MaintenanceStatus{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resumeIfDue(now)
+	n := len(m.pauses)
+	if n == 0 || !m.pauses[n-1].end.IsZero() {
+		return MaintenanceStatus{}
+	}
+	return MaintenanceStatus{Paused: true, Since: m.pauses[n-1].start, Until: m.until}
+}
+
+// overlaps reports whether probing was paused at any time in [start, end).
+func (m *maintenance) overlaps(start, end time.Time) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.pauses {
+		if p.start.Before(end) && (p.end.IsZero() || p.end.After(start)) {
+			return true
+		}
+	}
+	return false
+}
+
+// PauseProbing stops every target's probes, for a planned outage that
+// shouldn't be recorded, until ResumeProbing or, if until isn't zero, until
+// then. Targets and their configuration are kept, and rollup windows left
+// without data are marked as maintenance gaps rather than written empty.
+func (s *Scheduler) PauseProbing(until time.Time) {
+	s.maintenance.pause(s.Clock.Now(), until)
+	if until.IsZero() {
+		log.Printf("Scheduler: Paused probes for maintenance")
+	} else {
+		log.Printf("Scheduler: Paused probes for maintenance until %s", until.Format(time.RFC3339))
+	}
+}
+
+// ResumeProbing ends a pause started by PauseProbing.
+func (s *Scheduler) ResumeProbing() {
+	if s.maintenance.resume(s.Clock.Now()) {
+		log.Printf("Scheduler: Resumed probes")
+	}
+}
+
+// MaintenanceStatus returns whether probing is paused.
+func (s *Scheduler) MaintenanceStatus() MaintenanceStatus {
+	return s.maintenance.status(s.Clock.Now())
+}
@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+	"vaportrail/internal/db"
+	"vaportrail/internal/probe"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func TestScheduler_PauseProbing(t *testing.T) {
+	mockDB := NewMockStore()
+	fakeClock := clockwork.NewFakeClock()
+	s := New(mockDB)
+	s.Clock = fakeClock
+	var runs atomic.Int64
+	s.probeRunner = &MockRunner{
+		RunFn: func(cfg probe.Config) (float64, error) {
+			runs.Add(1)
+			return 500.0, nil
+		},
+	}
+	s.Start()
+	defer s.Stop()
+
+	target := db.Target{Name: "Paused", Address: "example.com", ProbeType: "http", ProbeInterval: 1}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+	s.AddTarget(target)
+	time.Sleep(50 * time.Millisecond)
+
+	tick := func(n int) int64 {
+		before := runs.Load()
+		for range n {
+			fakeClock.Advance(time.Second)
+			time.Sleep(20 * time.Millisecond)
+		}
+		return runs.Load() - before
+	}
+
+	if n := tick(3); n == 0 {
+		t.Fatal("Expected probes before pausing")
+	}
+
+	until := fakeClock.Now().Add(5 * time.Second)
+	s.PauseProbing(until)
+	if st := s.MaintenanceStatus(); !st.Paused || !st.Until.Equal(until) {
+		t.Fatalf("Expected a pause until %v, got %+v", until, st)
+	}
+	time.Sleep(50 * time.Millisecond) // Let a probe started before the pause finish.
+	if n := tick(3); n != 0 {
+		t.Errorf("Expected no probes while paused, got %d", n)
+	}
+	// Past until, probing resumes by itself.
+	if n := tick(3); n == 0 {
+		t.Error("Expected probes to resume after the pause ended")
+	}
+	if st := s.MaintenanceStatus(); st.Paused {
+		t.Errorf("Expected the pause to have ended, got %+v", st)
+	}
+
+	s.PauseProbing(time.Time{})
+	time.Sleep(50 * time.Millisecond)
+	if n := tick(3); n != 0 {
+		t.Errorf("Expected no probes while paused, got %d", n)
+	}
+	s.ResumeProbing()
+	if n := tick(3); n == 0 {
+		t.Error("Expected probes after ResumeProbing")
+	}
+}
+
+func TestRollupManager_MaintenanceGaps(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(mockDB)
+	rm.maintenance = &maintenance{}
+	target := db.Target{ID: 1, Name: "Maintenance", Timeout: 1.0}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rm.maintenance.pause(start.Add(90*time.Second), time.Time{})
+	rm.maintenance.resume(start.Add(4 * time.Minute))
+	// Data up to the pause, then nothing.
+	for i := range 90 {
+		mockDB.AddRawResults([]db.RawResult{{Time: start.Add(time.Duration(i) * time.Second), TargetID: 1, Latency: 100}})
+	}
+
+	var minutes []*db.AggregatedResult
+	for i := range 5 {
+		ws := start.Add(time.Duration(i) * time.Minute)
+		agg := rm.aggregateWindow(target, 60, 0, ws, ws.Add(time.Minute), false)
+		minutes = append(minutes, agg)
+		mockDB.AddAggregatedResult(agg)
+	}
+	for i, want := range []bool{false, false, true, true, false} {
+		if minutes[i].Maintenance != want {
+			t.Errorf("Minute %d: expected Maintenance %v, got %v", i, want, minutes[i].Maintenance)
+		}
+	}
+
+	// Coarser windows are gaps only when every source window is.
+	if agg := rm.aggregateWindow(target, 120, 60, start.Add(2*time.Minute), start.Add(4*time.Minute), false); !agg.Maintenance {
+		t.Error("Expected a window of only maintenance gaps to be one")
+	}
+	if agg := rm.aggregateWindow(target, 120, 60, start, start.Add(2*time.Minute), false); agg.Maintenance {
+		t.Error("Expected a window with data not to be a maintenance gap")
+	}
+}
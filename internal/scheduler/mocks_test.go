@@ -2,6 +2,8 @@ package scheduler
 
 import (
 	"errors"
+	"slices"
+	"sort"
 	"time"
 	"vaportrail/internal/db"
 	"vaportrail/internal/probe"
@@ -13,12 +15,15 @@ type MockStore struct {
 	Results           map[int64][]db.Result // Legacy
 	RawResults        map[int64][]db.RawResult
 	AggregatedResults map[int64][]db.AggregatedResult
+	TDigestStats      []db.TDigestStat
+	WALSizeBytes      int64
 
 	AddTargetFn    func(t *db.Target) (int64, error)
 	GetTargetsFn   func() ([]db.Target, error)
 	AddResultFn    func(r *db.Result) error
 	DeleteTargetFn func(id int64) error
 	CloseFn        func() error
+	CheckpointFn   func(truncate bool) (db.WALCheckpoint, error)
 }
 
 func NewMockStore() *MockStore {
@@ -67,6 +72,16 @@ func (m *MockStore) DeleteTarget(id int64) error {
 	return nil
 }
 
+func (m *MockStore) SetTargetDown(id int64, down bool) error {
+	t, ok := m.Targets[id]
+	if !ok {
+		return errors.New("target not found")
+	}
+	t.Down = down
+	m.Targets[id] = t
+	return nil
+}
+
 func (m *MockStore) GetTarget(id int64) (*db.Target, error) {
 	if t, ok := m.Targets[id]; ok {
 		return &t, nil
@@ -90,13 +105,20 @@ func (m *MockStore) GetResults(targetID int64, limit int) ([]db.Result, error) {
 	return res, nil
 }
 
-func (m *MockStore) GetResultsByTime(targetID int64, start, end time.Time) ([]db.Result, error) {
+func (m *MockStore) GetResultsByTime(targetID int64, start, end time.Time, limit int, order string) ([]db.Result, error) {
 	var res []db.Result
 	for _, r := range m.Results[targetID] {
 		if (r.Time.After(start) || r.Time.Equal(start)) && (r.Time.Before(end) || r.Time.Equal(end)) {
 			res = append(res, r)
 		}
 	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Time.Before(res[j].Time) })
+	if order == db.OrderDesc {
+		slices.Reverse(res)
+	}
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
 	return res, nil
 }
 
@@ -114,22 +136,29 @@ func (m *MockStore) AddRawResults(results []db.RawResult) error {
 	return nil
 }
 
+// AddAggregatedResult mirrors the DB's UPSERT on (target_id, window_seconds, time).
 func (m *MockStore) AddAggregatedResult(r *db.AggregatedResult) error {
+	for i, existing := range m.AggregatedResults[r.TargetID] {
+		if existing.WindowSeconds == r.WindowSeconds && existing.Time.Equal(r.Time) {
+			m.AggregatedResults[r.TargetID][i] = *r
+			return nil
+		}
+	}
 	m.AggregatedResults[r.TargetID] = append(m.AggregatedResults[r.TargetID], *r)
 	return nil
 }
 
 func (m *MockStore) AddAggregatedResults(results []*db.AggregatedResult) error {
 	for _, r := range results {
-		m.AggregatedResults[r.TargetID] = append(m.AggregatedResults[r.TargetID], *r)
+		m.AddAggregatedResult(r)
 	}
 	return nil
 }
 
-func (m *MockStore) GetLastRollupTime(targetID int64, windowSeconds int) (time.Time, error) {
+func (m *MockStore) GetLastRollupTime(targetID int64, windowSeconds int, before time.Time) (time.Time, error) {
 	var maxTime time.Time
 	for _, r := range m.AggregatedResults[targetID] {
-		if r.WindowSeconds == windowSeconds {
+		if r.WindowSeconds == windowSeconds && !r.Partial && r.Time.Before(before) {
 			if r.Time.After(maxTime) {
 				maxTime = r.Time
 			}
@@ -175,6 +204,17 @@ func (m *MockStore) DeleteRawResultsBefore(targetID int64, cutoff time.Time) err
 	return nil
 }
 
+func (m *MockStore) DeleteResultsBefore(targetID int64, cutoff time.Time) error {
+	var keep []db.Result
+	for _, r := range m.Results[targetID] {
+		if !r.Time.Before(cutoff) {
+			keep = append(keep, r)
+		}
+	}
+	m.Results[targetID] = keep
+	return nil
+}
+
 func (m *MockStore) DeleteAggregatedResultsBefore(targetID int64, windowSeconds int, cutoff time.Time) error {
 	var keep []db.AggregatedResult
 	for _, r := range m.AggregatedResults[targetID] {
@@ -192,6 +232,33 @@ func (m *MockStore) DeleteAggregatedResultsBefore(targetID int64, windowSeconds
 	return nil
 }
 
+func (m *MockStore) DeleteRawResultsKeepingLast(targetID int64, n int) error {
+	raws := append([]db.RawResult(nil), m.RawResults[targetID]...)
+	sort.Slice(raws, func(i, j int) bool { return raws[i].Time.After(raws[j].Time) })
+	if len(raws) > n {
+		raws = raws[:n]
+	}
+	m.RawResults[targetID] = raws
+	return nil
+}
+
+func (m *MockStore) DeleteAggregatedResultsKeepingLast(targetID int64, windowSeconds int, n int) error {
+	var keep, window []db.AggregatedResult
+	for _, r := range m.AggregatedResults[targetID] {
+		if r.WindowSeconds == windowSeconds {
+			window = append(window, r)
+		} else {
+			keep = append(keep, r)
+		}
+	}
+	sort.Slice(window, func(i, j int) bool { return window[i].Time.After(window[j].Time) })
+	if len(window) > n {
+		window = window[:n]
+	}
+	m.AggregatedResults[targetID] = append(keep, window...)
+	return nil
+}
+
 func (m *MockStore) DeleteAggregatedResultsByWindow(targetID int64, windowSeconds int) error {
 	var keep []db.AggregatedResult
 	for _, r := range m.AggregatedResults[targetID] {
@@ -213,6 +280,34 @@ func (m *MockStore) GetEarliestRawResultTime(targetID int64) (time.Time, error)
 	return minTime, nil
 }
 
+func (m *MockStore) GetRawMetricValues(targetID int64, start, end time.Time) (map[string][]float64, error) {
+	values := make(map[string][]float64)
+	for _, r := range m.RawResults[targetID] {
+		if !r.Time.Before(start) && r.Time.Before(end) {
+			for name, v := range r.Metrics {
+				values[name] = append(values[name], v)
+			}
+		}
+	}
+	return values, nil
+}
+
+// GetAggregatedMetrics returns the Metrics stored with AggregatedResults.
+func (m *MockStore) GetAggregatedMetrics(targetID int64, windowSeconds int, name string, start, end time.Time) ([]db.AggregatedMetric, error) {
+	var res []db.AggregatedMetric
+	for _, r := range m.AggregatedResults[targetID] {
+		if r.WindowSeconds != windowSeconds || r.Time.Before(start) || !r.Time.Before(end) {
+			continue
+		}
+		for _, metric := range r.Metrics {
+			if name == "" || metric.Name == name {
+				res = append(res, metric)
+			}
+		}
+	}
+	return res, nil
+}
+
 // MockStore implements db.Store interface
 func (m *MockStore) GetDBSizeBytes() (int64, error) {
 	return 0, nil
@@ -230,8 +325,19 @@ func (m *MockStore) GetFreelistCount() (int64, error) {
 	return 0, nil
 }
 
+func (m *MockStore) Checkpoint(truncate bool) (db.WALCheckpoint, error) {
+	if m.CheckpointFn != nil {
+		return m.CheckpointFn(truncate)
+	}
+	return db.WALCheckpoint{}, nil
+}
+
+func (m *MockStore) GetWALSizeBytes() (int64, error) {
+	return m.WALSizeBytes, nil
+}
+
 func (m *MockStore) GetTDigestStats() ([]db.TDigestStat, error) {
-	return nil, nil
+	return m.TDigestStats, nil
 }
 
 func (m *MockStore) GetRawStats() (*db.RawStats, error) {
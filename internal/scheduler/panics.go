@@ -0,0 +1,26 @@
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// panicError handles a value recovered from a panic in a probe or rollup,
+// so a bug in one target's probe doesn't kill its loop, or the scheduler
+// with it. It logs the panic with its stack, counts it in panics if that is
+// non-nil, and returns it as an error. Call it from the deferred function
+// that recovered, so the stack still shows where the panic happened.
+func panicError(panics *atomic.Int64, where string, r any) error {
+	log.Printf("Scheduler: recovered from panic in %s: %v\n%s", where, r, debug.Stack())
+	if panics != nil {
+		panics.Add(1)
+	}
+	return fmt.Errorf("panic: %v", r)
+}
+
+// Panics returns how many panics in probes and rollups have been recovered.
+func (s *Scheduler) Panics() int64 {
+	return s.panics.Load()
+}
@@ -0,0 +1,65 @@
+package scheduler
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"vaportrail/internal/db"
+	"vaportrail/internal/probe"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func TestScheduler_ProbePanicRecovered(t *testing.T) {
+	mockDB := NewMockStore()
+	fakeClock := clockwork.NewFakeClock()
+	s := New(mockDB)
+	s.Clock = fakeClock
+	var calls atomic.Int64
+	s.probeRunner = &MockRunner{
+		RunFn: func(cfg probe.Config) (float64, error) {
+			if calls.Add(1) == 1 {
+				var cfgs map[string]*probe.Config
+				return cfgs["missing"].Timeout.Seconds(), nil // nil dereference
+			}
+			return 100, nil
+		},
+	}
+	s.Start()
+
+	target := db.Target{Name: "Buggy", Address: "example.com", ProbeType: "http", ProbeInterval: 1, Timeout: 1}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+	s.AddTarget(target)
+
+	fakeClock.BlockUntilContext(t.Context(), 2)
+	fakeClock.Advance(time.Second)
+	for range 100 {
+		if s.Panics() == 1 && len(s.ProbeLoops()) == 1 && s.ProbeLoops()[0].LastError != "" {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := s.Panics(); got != 1 {
+		t.Fatalf("Expected 1 recovered panic, got %d", got)
+	}
+	state := s.ProbeLoops()[0]
+	if !strings.HasPrefix(state.LastError, "panic: ") || state.Exited {
+		t.Errorf("Expected the panic recorded on a running loop, got %+v", state)
+	}
+
+	// The loop keeps probing, and the slot the panicking probe held was
+	// released.
+	fakeClock.Advance(time.Second)
+	for range 100 {
+		if calls.Load() == 2 && s.ProbeConcurrency()[id].InFlight == 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	s.Stop()
+	if got := len(mockDB.RawResults[id]); got != 1 {
+		t.Errorf("Expected the probe after the panic to be stored, got %d results", got)
+	}
+}
@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// ProbeStats is a snapshot of the scheduler's global probe accounting.
+type ProbeStats struct {
+	// Rate is the number of probes started in the last full second.
+	Rate int64
+	// RateLimit is the configured MaxProbesPerSecond; 0 means unlimited.
+	RateLimit float64
+	// Started and RateLimited count probes started and skipped for lack of
+	// budget since the scheduler was created.
+	Started     int64
+	RateLimited int64
+}
+
+// probeLimiter is a token bucket shared by every probe loop. It holds up to
+// one second's worth of tokens, so targets that tick together aren't
+// penalized for it, but a sustained rate over the limit is.
+type probeLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second; 0 disables the limit
+	tokens float64
+	last   time.Time
+
+	// Probes started in the second starting at sec, and in the one before.
+	sec       int64
+	cur, prev int64
+
+	started, limited int64
+}
+
+// setRate changes the limit, starting with a full bucket.
+func (l *probeLimiter) setRate(rate float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.tokens = l.burst()
+	l.last = time.Time{}
+}
+
+func (l *probeLimiter) burst() float64 {
+	return max(l.rate, 1)
+}
+
+// allow takes a token if one is available, counting the probe as started or
+// rate limited.
+func (l *probeLimiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate > 0 {
+		if !l.last.IsZero() {
+			l.tokens = min(l.burst(), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		}
+		l.last = now
+		if l.tokens < 1 {
+			l.limited++
+			return false
+		}
+		l.tokens--
+	}
+
+	l.started++
+	sec := now.Unix()
+	if sec != l.sec {
+		if sec == l.sec+1 {
+			l.prev = l.cur
+		} else {
+			l.prev = 0
+		}
+		l.sec, l.cur = sec, 0
+	}
+	l.cur++
+	return true
+}
+
+func (l *probeLimiter) stats(now time.Time) ProbeStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := ProbeStats{RateLimit: l.rate, Started: l.started, RateLimited: l.limited}
+	switch now.Unix() {
+	case l.sec:
+		st.Rate = l.prev
+	case l.sec + 1:
+		st.Rate = l.cur
+	}
+	return st
+}
+
+// SetMaxProbesPerSecond caps the probes started per second across all
+// targets. Probes over the budget are skipped, not delayed, so one target
+// with a mistakenly short interval can't starve the others for long. Zero
+// removes the limit.
+func (s *Scheduler) SetMaxProbesPerSecond(rate float64) {
+	s.limiter.setRate(rate)
+}
+
+// ProbeStats reports the current global probe rate and how many probes the
+// rate limit has skipped.
+func (s *Scheduler) ProbeStats() ProbeStats {
+	return s.limiter.stats(s.Clock.Now())
+}
@@ -0,0 +1,53 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProbeLimiter(t *testing.T) {
+	var l probeLimiter
+	l.setRate(2)
+	now := time.Unix(1000, 0)
+
+	// A full bucket allows a burst of one second's worth.
+	if !l.allow(now) || !l.allow(now) {
+		t.Fatal("Expected the first two probes to be allowed")
+	}
+	if l.allow(now) {
+		t.Fatal("Expected the third probe in the same instant to be rate limited")
+	}
+
+	// Tokens refill at the configured rate.
+	now = now.Add(500 * time.Millisecond)
+	if !l.allow(now) {
+		t.Fatal("Expected a probe to be allowed after half a second")
+	}
+	if l.allow(now) {
+		t.Fatal("Expected only one token to have been refilled")
+	}
+
+	stats := l.stats(now.Add(time.Second))
+	if stats.Started != 3 || stats.RateLimited != 2 || stats.RateLimit != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if stats.Rate != 3 {
+		t.Errorf("Expected a rate of 3 for the last full second, got %d", stats.Rate)
+	}
+	if stats := l.stats(now.Add(5 * time.Second)); stats.Rate != 0 {
+		t.Errorf("Expected the rate to drop to 0 once probing stops, got %d", stats.Rate)
+	}
+}
+
+func TestProbeLimiter_Unlimited(t *testing.T) {
+	var l probeLimiter
+	now := time.Unix(1000, 0)
+	for i := 0; i < 100; i++ {
+		if !l.allow(now) {
+			t.Fatalf("Probe %d was rate limited without a limit", i)
+		}
+	}
+	if stats := l.stats(now); stats.Started != 100 || stats.RateLimited != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
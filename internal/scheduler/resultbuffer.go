@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"vaportrail/internal/db"
+)
+
+// DefaultResultBufferSize is how many raw results can wait for the batch
+// writer before probes start dropping them.
+const DefaultResultBufferSize = 1000
+
+// Result buffer overflow policies, for SetResultBuffer.
+const (
+	// OverflowDropNewest drops the result that didn't fit, keeping the
+	// backlog as it was.
+	OverflowDropNewest = "drop_newest"
+	// OverflowDropOldest drops the oldest waiting result to make room, so
+	// the newest data is kept.
+	OverflowDropOldest = "drop_oldest"
+)
+
+// SetResultBuffer sizes the queue of raw results waiting to be written and
+// chooses what happens when it is full. Probes never wait for the writer:
+// a full queue drops a result, counted in DroppedResults, rather than stall
+// the probe and hold its concurrency slot. A bigger buffer rides out longer
+// database stalls at the cost of memory and of more results lost if the
+// process dies with them queued. Call it before Start.
+func (s *Scheduler) SetResultBuffer(size int, overflow string) error {
+	if size <= 0 {
+		return fmt.Errorf("result buffer size must be positive, got %d", size)
+	}
+	switch overflow {
+	case "":
+		overflow = OverflowDropNewest
+	case OverflowDropNewest, OverflowDropOldest:
+	default:
+		return fmt.Errorf("unknown result overflow policy %q (expected %q or %q)", overflow, OverflowDropNewest, OverflowDropOldest)
+	}
+	s.rawResultChan = make(chan db.RawResult, size)
+	s.resultOverflow = overflow
+	return nil
+}
+
+// DroppedResults returns how many raw results have been dropped because the
+// result buffer was full.
+func (s *Scheduler) DroppedResults() int64 {
+	return s.droppedResults.Load()
+}
+
+// enqueueResult hands a raw result to the batch writer without blocking,
+// applying the overflow policy if the buffer is full.
+func (s *Scheduler) enqueueResult(raw db.RawResult) {
+	for {
+		select {
+		case s.rawResultChan <- raw:
+			return
+		default:
+		}
+		if s.droppedResults.Add(1) == 1 {
+			log.Printf("Raw result buffer is full (%d results); dropping results (%s)", cap(s.rawResultChan), s.resultOverflow)
+		}
+		if s.resultOverflow != OverflowDropOldest {
+			return
+		}
+		select {
+		case <-s.rawResultChan:
+		default:
+			// The writer emptied a slot meanwhile; the drop counted above
+			// didn't happen after all.
+			s.droppedResults.Add(-1)
+		}
+	}
+}
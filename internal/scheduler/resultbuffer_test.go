@@ -0,0 +1,54 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+	"vaportrail/internal/db"
+)
+
+func TestEnqueueResultOverflow(t *testing.T) {
+	for _, tt := range []struct {
+		overflow string
+		kept     []float64
+	}{
+		{OverflowDropNewest, []float64{1, 2}},
+		{OverflowDropOldest, []float64{3, 4}},
+	} {
+		s := New(NewMockStore())
+		if err := s.SetResultBuffer(2, tt.overflow); err != nil {
+			t.Fatalf("SetResultBuffer(%s) failed: %v", tt.overflow, err)
+		}
+
+		// Nothing drains the buffer, so the last two results overflow, and
+		// the sends must return anyway.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 1; i <= 4; i++ {
+				s.enqueueResult(db.RawResult{TargetID: 1, Latency: float64(i)})
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: enqueueResult blocked on a full buffer", tt.overflow)
+		}
+
+		if got := s.DroppedResults(); got != 2 {
+			t.Errorf("%s: expected 2 dropped results, got %d", tt.overflow, got)
+		}
+		for _, want := range tt.kept {
+			if got := (<-s.rawResultChan).Latency; got != want {
+				t.Errorf("%s: expected to keep result %v, got %v", tt.overflow, want, got)
+			}
+		}
+	}
+
+	s := New(NewMockStore())
+	if err := s.SetResultBuffer(0, ""); err == nil {
+		t.Error("Expected error for an empty buffer")
+	}
+	if err := s.SetResultBuffer(10, "drop_all"); err == nil {
+		t.Error("Expected error for an unknown overflow policy")
+	}
+}
@@ -68,21 +68,44 @@ func (rm *RetentionManager) enforceRetention() {
 		}
 
 		for _, p := range policies {
-			cutoff := rm.clock.Now().Add(-time.Duration(p.Retention) * time.Second)
-
-			if p.Window == 0 {
-				// Raw data retention
-				// We need a DeleteRawResults method in DB
-				if err := rm.db.DeleteRawResultsBefore(t.ID, cutoff); err != nil {
-					log.Printf("RetentionManager: Failed to delete raw results for %s: %v", t.Name, err)
-				}
-			} else {
-				// Aggregated data retention
-				// We need a DeleteAggregatedResultsBefore method
-				if err := rm.db.DeleteAggregatedResultsBefore(t.ID, p.Window, cutoff); err != nil {
-					log.Printf("RetentionManager: Failed to delete aggregated results (w=%d) for %s: %v", p.Window, t.Name, err)
-				}
+			if p.Retention > 0 {
+				rm.enforceAge(t, p)
+			}
+			if p.MaxRows > 0 {
+				rm.enforceMaxRows(t, p)
 			}
 		}
 	}
 }
+
+func (rm *RetentionManager) enforceAge(t db.Target, p RetentionPolicy) {
+	cutoff := rm.clock.Now().Add(-time.Duration(p.Retention) * time.Second)
+
+	if p.Window == 0 {
+		// Raw data retention
+		if err := rm.db.DeleteRawResultsBefore(t.ID, cutoff); err != nil {
+			log.Printf("RetentionManager: Failed to delete raw results for %s: %v", t.Name, err)
+		}
+		// Legacy per-commit results share the raw retention.
+		if err := rm.db.DeleteResultsBefore(t.ID, cutoff); err != nil {
+			log.Printf("RetentionManager: Failed to delete results for %s: %v", t.Name, err)
+		}
+	} else {
+		// Aggregated data retention
+		if err := rm.db.DeleteAggregatedResultsBefore(t.ID, p.Window, cutoff); err != nil {
+			log.Printf("RetentionManager: Failed to delete aggregated results (w=%d) for %s: %v", p.Window, t.Name, err)
+		}
+	}
+}
+
+func (rm *RetentionManager) enforceMaxRows(t db.Target, p RetentionPolicy) {
+	if p.Window == 0 {
+		if err := rm.db.DeleteRawResultsKeepingLast(t.ID, p.MaxRows); err != nil {
+			log.Printf("RetentionManager: Failed to trim raw results for %s: %v", t.Name, err)
+		}
+	} else {
+		if err := rm.db.DeleteAggregatedResultsKeepingLast(t.ID, p.Window, p.MaxRows); err != nil {
+			log.Printf("RetentionManager: Failed to trim aggregated results (w=%d) for %s: %v", p.Window, t.Name, err)
+		}
+	}
+}
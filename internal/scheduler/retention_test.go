@@ -73,3 +73,82 @@ func TestRetentionManager(t *testing.T) {
 		t.Errorf("Expected T-10s agg to be kept, got %v", aggs[0].Time)
 	}
 }
+
+func TestRetentionManager_MaxRows(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRetentionManager(mockDB)
+	fakeClock := clockwork.NewFakeClock()
+	rm.clock = fakeClock
+
+	// Raw: keep the last 2 rows regardless of age.
+	// Window 60: 100s retention and at most 1 row, whichever removes more.
+	target := db.Target{
+		Name:      "MaxRowsTarget",
+		ProbeType: "http",
+		RetentionPolicies: `[
+			{"window": 0, "max_rows": 2},
+			{"window": 60, "retention": 100, "max_rows": 1}
+		]`,
+	}
+	id, _ := mockDB.AddTarget(&target)
+
+	baseTime := fakeClock.Now()
+	mockDB.AddRawResults([]db.RawResult{
+		{Time: baseTime.Add(-300 * 24 * time.Hour), TargetID: id, Latency: 100},
+		{Time: baseTime.Add(-200 * 24 * time.Hour), TargetID: id, Latency: 200},
+		{Time: baseTime.Add(-100 * 24 * time.Hour), TargetID: id, Latency: 300},
+	})
+	for _, age := range []time.Duration{500, 60, 30} {
+		mockDB.AddAggregatedResult(&db.AggregatedResult{
+			Time: baseTime.Add(-age * time.Second), TargetID: id, WindowSeconds: 60,
+		})
+	}
+
+	rm.enforceRetention()
+
+	raws, _ := mockDB.GetRawResults(id, baseTime.Add(-400*24*time.Hour), baseTime, -1)
+	if len(raws) != 2 {
+		t.Fatalf("Expected 2 raw results kept, got %d", len(raws))
+	}
+	for _, r := range raws {
+		if r.Latency == 100 {
+			t.Errorf("Expected the oldest raw result to be deleted")
+		}
+	}
+
+	aggs, _ := mockDB.GetAggregatedResults(id, 60, baseTime.Add(-time.Hour), baseTime)
+	if len(aggs) != 1 {
+		t.Fatalf("Expected 1 aggregated result kept, got %d", len(aggs))
+	}
+	if !aggs[0].Time.Equal(baseTime.Add(-30 * time.Second)) {
+		t.Errorf("Expected newest aggregated result to be kept, got %v", aggs[0].Time)
+	}
+}
+
+func TestRetentionManager_LegacyResults(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRetentionManager(mockDB)
+	fakeClock := clockwork.NewFakeClock()
+	rm.clock = fakeClock
+
+	target := db.Target{
+		Name:              "LegacyTarget",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 10}]`,
+	}
+	id, _ := mockDB.AddTarget(&target)
+
+	baseTime := fakeClock.Now()
+	mockDB.AddResult(&db.Result{Time: baseTime.Add(-30 * time.Second), TargetID: id, TimeoutCount: 1})
+	mockDB.AddResult(&db.Result{Time: baseTime.Add(-5 * time.Second), TargetID: id, TimeoutCount: 2})
+
+	rm.enforceRetention()
+
+	results := mockDB.Results[id]
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result kept, got %d", len(results))
+	}
+	if results[0].TimeoutCount != 2 {
+		t.Errorf("Expected the T-5s result to be kept, got %+v", results[0])
+	}
+}
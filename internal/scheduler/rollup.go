@@ -5,8 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"math"
+	"slices"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 	"vaportrail/internal/db"
 
@@ -16,7 +20,20 @@ import (
 
 type RetentionPolicy struct {
 	Window    int `json:"window"`
-	Retention int `json:"retention"`
+	Retention int `json:"retention"` // seconds; 0 means no age limit if MaxRows is set
+	// MaxRows, when non-zero, keeps only the newest MaxRows rows for this
+	// window. If Retention is also set, both limits apply.
+	MaxRows int `json:"max_rows,omitempty"`
+	// Enabled false stops new rollups of this window while its retention
+	// still applies to the existing ones, such as to shed rollup load for a
+	// while. Coarser windows are then built from the next finer enabled
+	// window. Unset means enabled; see IsEnabled.
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+// IsEnabled reports whether rollups of p's window are generated.
+func (p RetentionPolicy) IsEnabled() bool {
+	return p.Enabled == nil || *p.Enabled
 }
 
 var defaultPolicies = []RetentionPolicy{
@@ -35,6 +52,12 @@ func ValidateRetentionPolicies(policies []RetentionPolicy) error {
 		if p.Window < 0 {
 			return errors.New("retention window cannot be negative")
 		}
+		if p.Retention < 0 || p.MaxRows < 0 {
+			return fmt.Errorf("window %d: retention and max_rows cannot be negative", p.Window)
+		}
+		if p.Retention == 0 && p.MaxRows == 0 {
+			return fmt.Errorf("window %d: retention or max_rows must be set", p.Window)
+		}
 		if i == 0 {
 			if p.Window == 0 {
 				continue // 0 (Raw) is valid base
@@ -61,7 +84,8 @@ func sortPolicies(policies []RetentionPolicy) {
 	})
 }
 
-// DefaultPolicies returns the default retention policies for use at target creation time.
+// DefaultPolicies returns the built-in retention policies, inherited by
+// targets without their own unless global policies are set.
 func DefaultPolicies() []RetentionPolicy {
 	// Return a copy to prevent mutation
 	result := make([]RetentionPolicy, len(defaultPolicies))
@@ -75,21 +99,75 @@ func DefaultPoliciesJSON() string {
 	return string(data)
 }
 
-// ErrNoRetentionPolicies is returned when a target has no retention policies configured.
-var ErrNoRetentionPolicies = errors.New("no retention policies configured")
+// globalPolicies, when set, replaces defaultPolicies as the policies of
+// targets without their own; see SetGlobalRetentionPolicies.
+var globalPolicies atomic.Pointer[[]RetentionPolicy]
+
+// SetGlobalRetentionPolicies sets the retention policies inherited by
+// targets without their own, in place of the built-in DefaultPolicies. Nil or
+// empty policies restore the built-in ones. The policies must be valid; see
+// ValidateRetentionPolicies.
+func SetGlobalRetentionPolicies(policies []RetentionPolicy) {
+	if len(policies) == 0 {
+		globalPolicies.Store(nil)
+		return
+	}
+	p := slices.Clone(policies)
+	sortPolicies(p)
+	globalPolicies.Store(&p)
+}
+
+// GlobalRetentionPolicies returns the retention policies inherited by
+// targets without their own, and whether they were set with
+// SetGlobalRetentionPolicies rather than being the built-in defaults.
+func GlobalRetentionPolicies() ([]RetentionPolicy, bool) {
+	if p := globalPolicies.Load(); p != nil {
+		return slices.Clone(*p), true
+	}
+	return DefaultPolicies(), false
+}
+
+// LoadGlobalRetentionPolicies sets the global retention policies to those
+// stored in the database's settings, if any.
+func LoadGlobalRetentionPolicies(d *db.DB) error {
+	data, err := d.GetSetting(db.SettingRetentionPolicies)
+	if err != nil || data == "" {
+		SetGlobalRetentionPolicies(nil)
+		return err
+	}
+	var policies []RetentionPolicy
+	if err := json.Unmarshal([]byte(data), &policies); err != nil {
+		return fmt.Errorf("failed to parse global retention policies: %w", err)
+	}
+	if err := ValidateRetentionPolicies(policies); err != nil {
+		return fmt.Errorf("invalid global retention policies: %w", err)
+	}
+	SetGlobalRetentionPolicies(policies)
+	return nil
+}
+
+// InheritsRetentionPolicies reports whether a target has no retention
+// policies of its own, and so uses the global ones.
+func InheritsRetentionPolicies(t db.Target) bool {
+	return t.RetentionPolicies == "" || t.RetentionPolicies == "[]"
+}
 
 // GetRetentionPolicies parses and returns retention policies for a target.
-// Returns ErrNoRetentionPolicies if no policies are configured.
+// A target's own policies take precedence; a target without any inherits the
+// global policies set with SetGlobalRetentionPolicies, or failing those the
+// built-in DefaultPolicies.
 func GetRetentionPolicies(t db.Target) ([]RetentionPolicy, error) {
-	if t.RetentionPolicies == "" || t.RetentionPolicies == "[]" {
-		return nil, ErrNoRetentionPolicies
+	if InheritsRetentionPolicies(t) {
+		policies, _ := GlobalRetentionPolicies()
+		return policies, nil
 	}
 	var p []RetentionPolicy
 	if err := json.Unmarshal([]byte(t.RetentionPolicies), &p); err != nil {
 		return nil, fmt.Errorf("failed to parse retention policies: %w", err)
 	}
 	if len(p) == 0 {
-		return nil, ErrNoRetentionPolicies
+		policies, _ := GlobalRetentionPolicies()
+		return policies, nil
 	}
 	return p, nil
 }
@@ -99,6 +177,36 @@ type RollupManager struct {
 	clock clockwork.Clock
 	stop  chan struct{}
 	wg    sync.WaitGroup
+
+	// flushInterval, when set, is how often the open window of each rollup
+	// built from raw results is written as a partial rollup; see
+	// SetRollupFlushInterval. lastFlush is when each target and window was
+	// last flushed.
+	flushInterval time.Duration
+	lastFlush     map[rollupKey]time.Time
+	// skewWarned holds the targets and windows already warned about for
+	// having only future-dated raw results, so the warning isn't repeated
+	// on every pass.
+	skewWarned map[rollupKey]bool
+
+	// maintenance is the scheduler's pause state, or nil for a manager of
+	// its own; see PauseProbing.
+	maintenance *maintenance
+	// panics counts the rollups that panicked, or is nil for a manager of
+	// its own.
+	panics *atomic.Int64
+
+	// storedPercentiles are precomputed into each rollup; see
+	// SetStoredPercentiles.
+	storedPercentiles []float64
+	// latencyPrecision, in nanoseconds, is what latencies are rounded to
+	// before they go into a digest; see SetLatencyPrecision.
+	latencyPrecision float64
+}
+
+type rollupKey struct {
+	targetID      int64
+	windowSeconds int
 }
 
 func NewRollupManager(database db.Store) *RollupManager {
@@ -159,17 +267,39 @@ func (rm *RollupManager) processRollups() {
 				lastWindow = 0
 				continue
 			}
+			if !p.IsEnabled() {
+				continue
+			}
 
 			// Process this window using lastWindow as source
-			rm.processTargetWindow(t, p.Window, lastWindow)
+			rm.safeProcessTargetWindow(t, p.Window, lastWindow)
 			lastWindow = p.Window
 		}
 	}
 }
 
+// safeProcessTargetWindow is processTargetWindow, recovering from a panic
+// so it only costs this target's window a pass.
+func (rm *RollupManager) safeProcessTargetWindow(t db.Target, windowSeconds int, sourceWindow int) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicError(rm.panics, fmt.Sprintf("rollup of %s (w=%d)", t.Name, windowSeconds), r)
+		}
+	}()
+	rm.processTargetWindow(t, windowSeconds, sourceWindow)
+}
+
 func (rm *RollupManager) processTargetWindow(t db.Target, windowSeconds int, sourceWindow int) {
+	// Safety: don't process future
+	// Cutoff logic: Now - (MaxTimeout + CommitBuffer + 1s)
+	now := rm.clock.Now()
+	cutoff := rollupCutoff(t, now)
+
 	// 1. Get last rollup time
-	lastTime, err := rm.db.GetLastRollupTime(t.ID, windowSeconds)
+	// Rollups dated past the cutoff can't have been written by a pass over
+	// closed windows; they come from a skewed host or an import, and
+	// resuming after them would skip the windows in between.
+	lastTime, err := rm.db.GetLastRollupTime(t.ID, windowSeconds, cutoff)
 	if err != nil {
 		log.Printf("RollupManager: Failed to get last rollup time for %s (w=%d): %v", t.Name, windowSeconds, err)
 		return
@@ -195,6 +325,21 @@ func (rm *RollupManager) processTargetWindow(t db.Target, windowSeconds int, sou
 			// No raw data? Nothing to roll up.
 			return
 		}
+		if earliest.After(cutoff) {
+			// Only future-dated data, from a skewed probing host or an
+			// import. Starting from it would move the rollup cursor past
+			// the real data that arrives in the meantime.
+			key := rollupKey{t.ID, windowSeconds}
+			if earliest.After(now) && !rm.skewWarned[key] {
+				if rm.skewWarned == nil {
+					rm.skewWarned = make(map[rollupKey]bool)
+				}
+				rm.skewWarned[key] = true
+				log.Printf("RollupManager: Warning: earliest raw result for %s (w=%ds) is in the future (%s); possible clock skew",
+					t.Name, windowSeconds, earliest.Format(time.RFC3339))
+			}
+			return
+		}
 		// Truncate to window alignment
 		start = earliest.Truncate(time.Duration(windowSeconds) * time.Second)
 	}
@@ -210,12 +355,11 @@ func (rm *RollupManager) processTargetWindow(t db.Target, windowSeconds int, sou
 		nextWindowStart = start // Start fresh from that point
 	}
 
-	// Safety: don't process future
-	// Cutoff logic: Now - (MaxTimeout + CommitBuffer + 1s)
-	// MaxTimeout is in t.Timeout (seconds). Buffer is 2s (from Scheduler).
-	cutoff := rm.clock.Now().Add(-time.Duration(t.Timeout+3) * time.Second)
-
-	// Collect all aggregated results to commit in a single transaction
+	// Collect all aggregated results to commit in a single transaction.
+	// Each window is recomputed from its source rows and written with an
+	// UPSERT keyed on (target_id, window_seconds, time), so reprocessing a
+	// window (e.g. after a restart or a stale GetLastRollupTime) replaces the
+	// previous row instead of double-counting it.
 	var results []*db.AggregatedResult
 
 	for {
@@ -224,13 +368,21 @@ func (rm *RollupManager) processTargetWindow(t db.Target, windowSeconds int, sou
 			break // Caught up
 		}
 
-		agg := rm.aggregateWindow(t, windowSeconds, sourceWindow, nextWindowStart, windowEnd)
+		agg := rm.aggregateWindow(t, windowSeconds, sourceWindow, nextWindowStart, windowEnd, false)
 		if agg != nil {
 			results = append(results, agg)
 		}
 		nextWindowStart = windowEnd
 	}
 
+	if sourceWindow == 0 && nextWindowStart.Before(cutoff) && rm.flushDue(t.ID, windowSeconds, now) {
+		// The open window so far, until the complete rollup replaces it.
+		if agg := rm.aggregateWindow(t, windowSeconds, 0, nextWindowStart, cutoff, true); agg != nil {
+			agg.Partial = true
+			results = append(results, agg)
+		}
+	}
+
 	// Commit all results in a single transaction
 	if len(results) > 0 {
 		if err := rm.db.AddAggregatedResults(results); err != nil {
@@ -239,12 +391,201 @@ func (rm *RollupManager) processTargetWindow(t db.Target, windowSeconds int, sou
 	}
 }
 
-func (rm *RollupManager) aggregateWindow(t db.Target, windowSeconds int, sourceWindow int, start, end time.Time) *db.AggregatedResult {
+// flushDue reports whether the target's open window is due for a partial
+// rollup, and if so counts it as flushed at now.
+func (rm *RollupManager) flushDue(targetID int64, windowSeconds int, now time.Time) bool {
+	if rm.flushInterval <= 0 || time.Duration(windowSeconds)*time.Second <= rm.flushInterval {
+		return false
+	}
+	key := rollupKey{targetID, windowSeconds}
+	if last, ok := rm.lastFlush[key]; ok && now.Sub(last) < rm.flushInterval {
+		return false
+	}
+	if rm.lastFlush == nil {
+		rm.lastFlush = make(map[rollupKey]time.Time)
+	}
+	rm.lastFlush[key] = now
+	return true
+}
+
+// ErrWindowNotConfigured is returned by Backfill for a window that isn't one
+// of the target's aggregated windows.
+var ErrWindowNotConfigured = errors.New("window is not an aggregated window of the target")
+
+// backfillBatchSize is how many rebuilt windows Backfill commits per
+// transaction.
+const backfillBatchSize = 1000
+
+// Backfill recomputes the target's rollups for windowSeconds over [start, end)
+// from their source window, then those of every coarser window in its
+// policies, since each is built from the one below it. Windows are aligned
+// outward to cover the range, and only complete windows are rebuilt.
+// Rebuilt rows replace existing ones; windows whose source has no rows are
+// left alone, so data whose source has already expired isn't wiped out.
+// It returns the number of windows written across all rebuilt window sizes.
+func (rm *RollupManager) Backfill(targetID int64, windowSeconds int, start, end time.Time) (int, error) {
+	t, err := rm.db.GetTarget(targetID)
+	if err != nil {
+		return 0, fmt.Errorf("target %d not found: %w", targetID, err)
+	}
+	policies, err := GetRetentionPolicies(*t)
+	if err != nil {
+		return 0, err
+	}
+	sortPolicies(policies)
+
+	// Find the requested window and the source each window is built from,
+	// mirroring processRollups. The requested window is rebuilt even if it
+	// is disabled.
+	type step struct{ window, source int }
+	var steps []step
+	lastWindow := 0
+	for _, p := range policies {
+		if p.Window == 0 || (!p.IsEnabled() && p.Window != windowSeconds) {
+			continue
+		}
+		if p.Window == windowSeconds || len(steps) > 0 {
+			steps = append(steps, step{p.Window, lastWindow})
+		}
+		lastWindow = p.Window
+	}
+	if len(steps) == 0 {
+		return 0, fmt.Errorf("%w: %ds (target %s)", ErrWindowNotConfigured, windowSeconds, t.Name)
+	}
+
+	cutoff := rollupCutoff(*t, rm.clock.Now())
+	built := 0
+	for _, st := range steps {
+		size := time.Duration(st.window) * time.Second
+		var batch []*db.AggregatedResult
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			if err := rm.db.AddAggregatedResults(batch); err != nil {
+				return fmt.Errorf("failed to save rebuilt %ds windows: %w", st.window, err)
+			}
+			built += len(batch)
+			batch = batch[:0]
+			return nil
+		}
+		for ws := start.Truncate(size); ws.Before(end); ws = ws.Add(size) {
+			we := ws.Add(size)
+			if we.After(cutoff) {
+				break
+			}
+			if agg := rm.aggregateWindow(*t, st.window, st.source, ws, we, true); agg != nil {
+				batch = append(batch, agg)
+			}
+			if len(batch) >= backfillBatchSize {
+				if err := flush(); err != nil {
+					return built, err
+				}
+			}
+		}
+		if err := flush(); err != nil {
+			return built, err
+		}
+	}
+	return built, nil
+}
+
+// rollupCutoff is the end of the newest data that is safe to roll up: probes
+// started before it have either finished or timed out, and their results
+// have been flushed by the batch writer.
+func rollupCutoff(t db.Target, now time.Time) time.Time {
+	// MaxTimeout is in t.Timeout (seconds). Buffer is 2s (from Scheduler).
+	return now.Add(-time.Duration(t.Timeout+3) * time.Second)
+}
+
+// RollupLag is how far a target's newest rollup for one window trails the
+// newest data that could have been rolled up.
+type RollupLag struct {
+	TargetID      int64
+	TargetName    string
+	WindowSeconds int
+	Lag           time.Duration
+}
+
+// RollupLags measures the lag of every target and enabled rollup window that
+// has been rolled up at least once. Since rollups run every 10 seconds, a lag
+// of a few ticks is normal; a growing one means the rollup worker can't keep
+// up.
+// It only reads the database, so it can be computed on demand rather than on
+// the rollup path.
+func RollupLags(store db.Store, now time.Time) ([]RollupLag, error) {
+	targets, err := store.GetTargets()
+	if err != nil {
+		return nil, err
+	}
+	var lags []RollupLag
+	for _, t := range targets {
+		policies, err := GetRetentionPolicies(t)
+		if err != nil {
+			continue
+		}
+		cutoff := rollupCutoff(t, now)
+		for _, p := range policies {
+			if p.Window <= 0 || !p.IsEnabled() {
+				continue
+			}
+			last, err := store.GetLastRollupTime(t.ID, p.Window, cutoff)
+			if err != nil {
+				return nil, err
+			}
+			if last.IsZero() {
+				continue
+			}
+			// The newest window that could be complete ends at the last
+			// window boundary before the cutoff.
+			size := time.Duration(p.Window) * time.Second
+			lag := cutoff.Truncate(size).Sub(last.Add(size))
+			lags = append(lags, RollupLag{
+				TargetID:      t.ID,
+				TargetName:    t.Name,
+				WindowSeconds: p.Window,
+				Lag:           max(lag, 0),
+			})
+		}
+	}
+	return lags, nil
+}
+
+// aggregateWindow computes the rollup of [start, end) from sourceWindow's rows
+// (raw results when sourceWindow is 0). If the source has no rows it returns
+// an empty rollup, or nil when skipEmpty is set.
+func (rm *RollupManager) aggregateWindow(t db.Target, windowSeconds int, sourceWindow int, start, end time.Time, skipEmpty bool) *db.AggregatedResult {
 	// Source Data Fetching
 	var tDigest *tdigest.TDigest
 	var timeoutCount int64
 	var rowsProcessed int
 	var err error
+	// Running moments for stddev. momentsComplete turns false if any source
+	// rollup predates moment tracking, since the sums would then be partial.
+	var sampleCount int64
+	var sumNS, sumSqNS float64
+	momentsComplete := true
+	// Exact extremes of the successful latencies, set once sampled is.
+	// extremesMissing turns true if a source rollup with samples predates
+	// them and has no digest to estimate them from.
+	var minNS, maxNS float64
+	var sampled, extremesMissing bool
+	sample := func(lo, hi float64) {
+		if !sampled || lo < minNS {
+			minNS = lo
+		}
+		if !sampled || hi > maxNS {
+			maxNS = hi
+		}
+		sampled = true
+	}
+	// Summary targets keep no digest at all; see db.AggregationSummary.
+	summary := t.Aggregation == db.AggregationSummary
+	// maintenanceOnly is set when every source rollup is a maintenance gap.
+	var maintenanceOnly bool
+	// For cascaded rollups, how many source rollups were found out of those
+	// that fit in the window.
+	var sourceWindows, expectedSourceWindows int
 
 	if sourceWindow == 0 {
 		// Aggregate from Raw
@@ -255,17 +596,50 @@ func (rm *RollupManager) aggregateWindow(t db.Target, windowSeconds int, sourceW
 		}
 		rowsProcessed = len(raws)
 		if len(raws) == 0 {
-			return rm.createEmptyRollup(t, windowSeconds, start)
+			if skipEmpty {
+				return nil
+			}
+			return rm.createEmptyRollup(t, windowSeconds, start, end)
 		}
 
-		tDigest, _ = tdigest.New(tdigest.Compression(100))
+		// With a latency precision, each rounded latency goes into the
+		// digest once, weighted by how often it occurred; the digest keeps
+		// repeats of a value in separate centroids otherwise.
+		var rounded map[float64]uint64
+		if !summary {
+			tDigest, _ = tdigest.New(tdigest.Compression(100))
+			if rm.latencyPrecision > 0 {
+				rounded = make(map[float64]uint64)
+			}
+		}
+		var invalidCount int
 		for _, r := range raws {
+			if !validStoredLatency(r.Latency) {
+				invalidCount++
+				continue
+			}
+			r.Latency = min(r.Latency, MaxSampleLatencyNS)
 			if r.Latency == -1 {
 				timeoutCount++
 			} else {
-				tDigest.Add(r.Latency)
+				if rounded != nil {
+					rounded[rm.quantize(r.Latency)]++
+				} else if tDigest != nil {
+					tDigest.Add(r.Latency)
+				}
+				sample(r.Latency, r.Latency)
+				sampleCount++
+				sumNS += r.Latency
+				sumSqNS += r.Latency * r.Latency
 			}
 		}
+		for _, v := range slices.Sorted(maps.Keys(rounded)) {
+			tDigest.AddWeighted(v, rounded[v])
+		}
+		if invalidCount > 0 {
+			log.Printf("RollupManager: Warning: skipped %d raw results with invalid latencies for %s (w=%ds, start=%s)",
+				invalidCount, t.Name, windowSeconds, start.Format("15:04:05"))
+		}
 
 	} else {
 		// Aggregate from Sub-Rollup
@@ -281,46 +655,216 @@ func (rm *RollupManager) aggregateWindow(t db.Target, windowSeconds int, sourceW
 		}
 		rowsProcessed = len(results)
 		if len(results) == 0 {
-			return rm.createEmptyRollup(t, windowSeconds, start)
+			if skipEmpty {
+				return nil
+			}
+			empty := rm.createEmptyRollup(t, windowSeconds, start, end)
+			empty.ExpectedSourceWindows = expectedSources(t, windowSeconds, sourceWindow)
+			return empty
 		}
 
-		tDigest, _ = tdigest.New(tdigest.Compression(100))
+		if !summary {
+			tDigest, _ = tdigest.New(tdigest.Compression(100))
+		}
+		maintenanceOnly = true
+		expectedSourceWindows = expectedSources(t, windowSeconds, sourceWindow)
 		for _, res := range results {
+			maintenanceOnly = maintenanceOnly && res.Maintenance
 			timeoutCount += res.TimeoutCount
+			sampleCount += res.SampleCount
+			sumNS += res.SumNS
+			sumSqNS += res.SumSqNS
+			var subTD *tdigest.TDigest
 			if len(res.TDigestData) > 0 {
-				subTD, err := db.DeserializeTDigest(res.TDigestData)
-				if err == nil {
-					tDigest.Merge(subTD)
+				// Digests are merged weighted by their counts, so empty
+				// source rollups add nothing and are skipped.
+				if td, err := db.DeserializeTDigest(res.TDigestData); err == nil && td.Count() > 0 {
+					subTD = td
+				}
+			}
+			if res.SampleCount > 0 {
+				switch {
+				case res.MaxNS > 0:
+					sample(res.MinNS, res.MaxNS)
+				case subTD != nil:
+					// Written before extremes were stored, which left
+					// them 0; the digest's outermost centroids are next
+					// best.
+					sample(digestExtremes(subTD))
+				default:
+					extremesMissing = true
+				}
+			}
+			// Empty source rollups are written for windows without
+			// probes too, such as while the collector was down, so only
+			// those with results, or in a maintenance gap, count as found.
+			if res.SampleCount > 0 || res.TimeoutCount > 0 || res.Maintenance || subTD != nil {
+				sourceWindows++
+			}
+			if tDigest != nil && subTD != nil {
+				tDigest.Merge(subTD)
+				if int64(subTD.Count()) != res.SampleCount {
+					momentsComplete = false
 				}
 			}
 		}
 	}
 
-	tdBytes, err := db.SerializeTDigest(tDigest)
-	if err != nil {
-		log.Printf("RollupManager: Serialization failed: %v", err)
-		return nil
+	var tdBytes []byte
+	if tDigest != nil {
+		if tdBytes, err = db.SerializeTDigest(tDigest); err != nil {
+			log.Printf("RollupManager: Serialization failed: %v", err)
+			return nil
+		}
 	}
 
 	log.Printf("RollupManager: Aggregated %s (w=%ds, start=%s): %d rows, %d timeouts", t.Name, windowSeconds, start.Format("15:04:05"), rowsProcessed, timeoutCount)
 
-	return &db.AggregatedResult{
+	agg := &db.AggregatedResult{
 		Time:          start,
 		TargetID:      t.ID,
 		WindowSeconds: windowSeconds,
 		TDigestData:   tdBytes,
 		TimeoutCount:  timeoutCount,
+		Maintenance:   maintenanceOnly,
+
+		SourceWindows:         sourceWindows,
+		ExpectedSourceWindows: expectedSourceWindows,
+	}
+	if momentsComplete {
+		agg.SampleCount = sampleCount
+		agg.SumNS = sumNS
+		agg.SumSqNS = sumSqNS
+		if !extremesMissing {
+			agg.MinNS = minNS
+			agg.MaxNS = maxNS
+		}
+		// The results API serves stored percentiles alongside the exact
+		// moments, so they're only worth storing with them.
+		if tDigest != nil && sampleCount > 0 && len(rm.storedPercentiles) > 0 {
+			agg.Percentiles = make(map[string]float64, len(rm.storedPercentiles))
+			for _, p := range rm.storedPercentiles {
+				agg.Percentiles[db.PercentileName(p)] = tDigest.Quantile(p / 100)
+			}
+		}
 	}
+	agg.Metrics = rm.aggregateMetrics(t, windowSeconds, sourceWindow, start, end)
+	return agg
 }
 
-func (rm *RollupManager) createEmptyRollup(t db.Target, windowSeconds int, start time.Time) *db.AggregatedResult {
-	td, _ := tdigest.New(tdigest.Compression(100))
-	tdBytes, _ := db.SerializeTDigest(td)
+// aggregateMetrics rolls up each of the target's auxiliary metrics over
+// [start, end) from sourceWindow, like aggregateWindow does latencies.
+func (rm *RollupManager) aggregateMetrics(t db.Target, windowSeconds int, sourceWindow int, start, end time.Time) []db.AggregatedMetric {
+	type rollup struct {
+		db.AggregatedMetric
+		td *tdigest.TDigest
+	}
+	rollups := make(map[string]*rollup)
+	metric := func(name string) *rollup {
+		m, ok := rollups[name]
+		if !ok {
+			m = &rollup{AggregatedMetric: db.AggregatedMetric{Time: start, TargetID: t.ID, WindowSeconds: windowSeconds, Name: name}}
+			m.td, _ = tdigest.New(tdigest.Compression(100))
+			rollups[name] = m
+		}
+		return m
+	}
+
+	if sourceWindow == 0 {
+		values, err := rm.db.GetRawMetricValues(t.ID, start, end)
+		if err != nil {
+			log.Printf("RollupManager: Error fetching raw metrics: %v", err)
+			return nil
+		}
+		for name, vs := range values {
+			m := metric(name)
+			for _, v := range vs {
+				m.td.Add(v)
+				m.SampleCount++
+				m.Sum += v
+			}
+		}
+	} else {
+		sources, err := rm.db.GetAggregatedMetrics(t.ID, sourceWindow, "", start, end)
+		if err != nil {
+			log.Printf("RollupManager: Error fetching aggregated metrics (w=%d): %v", sourceWindow, err)
+			return nil
+		}
+		for _, src := range sources {
+			m := metric(src.Name)
+			m.SampleCount += src.SampleCount
+			m.Sum += src.Sum
+			if len(src.TDigestData) > 0 {
+				if subTD, err := db.DeserializeTDigest(src.TDigestData); err == nil && subTD.Count() > 0 {
+					m.td.Merge(subTD)
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(rollups))
+	for name := range rollups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]db.AggregatedMetric, 0, len(names))
+	for _, name := range names {
+		m := rollups[name]
+		data, err := db.SerializeTDigest(m.td)
+		if err != nil {
+			log.Printf("RollupManager: Serialization of metric %s failed: %v", name, err)
+			continue
+		}
+		m.TDigestData = data
+		metrics = append(metrics, m.AggregatedMetric)
+	}
+	return metrics
+}
+
+// digestExtremes returns the means of td's first and last centroids, which
+// at the tails hold single samples unless td is very large.
+func digestExtremes(td *tdigest.TDigest) (lo, hi float64) {
+	first := true
+	td.ForEachCentroid(func(mean float64, count uint64) bool {
+		if first {
+			lo, first = mean, false
+		}
+		hi = mean
+		return true
+	})
+	return lo, hi
+}
+
+// quantize rounds a latency to the nearest multiple of latencyPrecision,
+// which must be set.
+func (rm *RollupManager) quantize(latency float64) float64 {
+	return math.Round(latency/rm.latencyPrecision) * rm.latencyPrecision
+}
+
+// createEmptyRollup returns the rollup of a window without data, marked as
+// maintenance if probing was paused, or the target's schedule off, during it.
+// expectedSources is how many of the source rollups of a cascaded window
+// should have results: all of them, unless the target probes less often than
+// once per source window.
+func expectedSources(t db.Target, windowSeconds, sourceWindow int) int {
+	if t.ProbeInterval > float64(sourceWindow) {
+		return max(1, int(float64(windowSeconds)/t.ProbeInterval))
+	}
+	return windowSeconds / sourceWindow
+}
+
+func (rm *RollupManager) createEmptyRollup(t db.Target, windowSeconds int, start, end time.Time) *db.AggregatedResult {
+	var tdBytes []byte
+	if t.Aggregation != db.AggregationSummary {
+		td, _ := tdigest.New(tdigest.Compression(100))
+		tdBytes, _ = db.SerializeTDigest(td)
+	}
 	return &db.AggregatedResult{
 		Time:          start,
 		TargetID:      t.ID,
 		WindowSeconds: windowSeconds,
 		TDigestData:   tdBytes,
 		TimeoutCount:  0,
+		Maintenance:   rm.maintenance.overlaps(start, end) || targetSchedule(t.Schedule).OffDuring(start, end),
 	}
 }
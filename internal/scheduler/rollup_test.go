@@ -1,10 +1,17 @@
 package scheduler
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
 	"testing"
 	"time"
 	"vaportrail/internal/db"
 
+	"github.com/caio/go-tdigest/v4"
 	"github.com/jonboulle/clockwork"
 )
 
@@ -229,4 +236,879 @@ func TestRollupManager_Cascading(t *testing.T) {
 	if td.Quantile(0.5) != 100.0 {
 		t.Errorf("Expected Median 100.0, got %v", td.Quantile(0.5))
 	}
+	if c := results60s[0].Completeness(); c != 1.0 || results60s[0].ExpectedSourceWindows != 6 {
+		t.Errorf("Expected completeness 1.0 from all six 10s rollups, got %v (%d of %d)", c, results60s[0].SourceWindows, results60s[0].ExpectedSourceWindows)
+	}
+}
+
+func TestRollupManager_CascadingGaps(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(mockDB)
+	target := db.Target{ID: 1, Name: "Gaps", Timeout: 1.0}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Four of six 10s rollups: two with data, two empty.
+	for i, latencies := range [][]float64{{100, 100}, {}, {300}, {}} {
+		td, _ := tdigest.New(tdigest.Compression(100))
+		for _, l := range latencies {
+			td.Add(l)
+		}
+		data, _ := db.SerializeTDigest(td)
+		mockDB.AddAggregatedResult(&db.AggregatedResult{
+			Time:          start.Add(time.Duration(i) * 10 * time.Second),
+			TargetID:      1,
+			WindowSeconds: 10,
+			TDigestData:   data,
+			SampleCount:   int64(len(latencies)),
+		})
+	}
+
+	// Empty rollups don't count as found.
+	agg := rm.aggregateWindow(target, 60, 10, start, start.Add(time.Minute), false)
+	if agg.SourceWindows != 2 || agg.ExpectedSourceWindows != 6 {
+		t.Errorf("Expected 2 of 6 source windows, got %d of %d", agg.SourceWindows, agg.ExpectedSourceWindows)
+	}
+	if c := agg.Completeness(); math.Abs(c-2.0/6) > 1e-9 {
+		t.Errorf("Expected completeness %v, got %v", 2.0/6, c)
+	}
+	td, _ := db.DeserializeTDigest(agg.TDigestData)
+	if td.Count() != 3 || agg.SampleCount != 3 {
+		t.Errorf("Expected empty source rollups to add nothing, got count %v and %d samples", td.Count(), agg.SampleCount)
+	}
+
+	// Nothing to cascade from at all.
+	empty := rm.aggregateWindow(target, 60, 10, start.Add(time.Minute), start.Add(2*time.Minute), false)
+	if empty.Completeness() != 0 {
+		t.Errorf("Expected completeness 0 without source rollups, got %v", empty.Completeness())
+	}
+	// Rollups of raw results are complete.
+	if raw := rm.aggregateWindow(target, 10, 0, start, start.Add(10*time.Second), false); raw.Completeness() != 1 {
+		t.Errorf("Expected completeness 1 for a raw rollup, got %v", raw.Completeness())
+	}
+	// Probing every 30s, only two of the six are expected to have results.
+	target.ProbeInterval = 30
+	if agg := rm.aggregateWindow(target, 60, 10, start, start.Add(time.Minute), false); agg.Completeness() != 1 {
+		t.Errorf("Expected completeness 1 for a slow probe, got %d of %d", agg.SourceWindows, agg.ExpectedSourceWindows)
+	}
+}
+
+func TestRollupManager_CompletenessAcrossDataGap(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(mockDB)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fakeClock := clockwork.NewFakeClockAt(start)
+	rm.clock = fakeClock
+
+	target := db.Target{
+		Name:              "GapTarget",
+		ProbeInterval:     1.0,
+		Timeout:           1.0,
+		RetentionPolicies: `[{"window": 0, "retention": 3600}, {"window": 10, "retention": 3600}, {"window": 60, "retention": 3600}]`,
+	}
+	id, _ := mockDB.AddTarget(&target)
+
+	// Two minutes of results every second, but for 20 seconds of the first
+	// minute while the collector was down.
+	for i := range 120 {
+		if i >= 20 && i < 40 {
+			continue
+		}
+		mockDB.AddRawResults([]db.RawResult{{Time: start.Add(time.Duration(i) * time.Second), TargetID: id, Latency: 100}})
+	}
+
+	fakeClock.Advance(2*time.Minute + 10*time.Second)
+	rm.processRollups()
+	rm.processRollups()
+
+	// The 10s windows of the gap were rolled up empty, which doesn't count.
+	tens, _ := mockDB.GetAggregatedResults(id, 10, start, start.Add(time.Minute))
+	if len(tens) != 6 {
+		t.Fatalf("Expected 6 rollups of 10s, got %d", len(tens))
+	}
+	minutes, _ := mockDB.GetAggregatedResults(id, 60, start, start.Add(2*time.Minute))
+	if len(minutes) != 2 {
+		t.Fatalf("Expected 2 rollups of 60s, got %d", len(minutes))
+	}
+	if c := minutes[0].Completeness(); math.Abs(c-4.0/6) > 1e-9 {
+		t.Errorf("Expected completeness %v across the gap, got %v (%d of %d)", 4.0/6, c, minutes[0].SourceWindows, minutes[0].ExpectedSourceWindows)
+	}
+	if c := minutes[1].Completeness(); c != 1 {
+		t.Errorf("Expected the minute without a gap complete, got %v", c)
+	}
+}
+
+func TestRollupManager_WeightedMerge(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(mockDB)
+	target := db.Target{ID: 1, Name: "Weighted", Timeout: 1.0}
+
+	// A busy 10s rollup, a nearly idle one far slower, and an empty one.
+	// Merged equally, the idle one would drag the median up to its latency.
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var all []float64
+	sources := [][]float64{make([]float64, 10000), {50000, 50000, 50000, 50000, 50000, 50000, 50000, 50000, 50000, 50000}, {}}
+	for i := range sources[0] {
+		sources[0][i] = 1000 + float64(i)/10
+	}
+	for i, latencies := range sources {
+		td, _ := tdigest.New(tdigest.Compression(100))
+		for _, l := range latencies {
+			td.Add(l)
+		}
+		all = append(all, latencies...)
+		data, _ := db.SerializeTDigest(td)
+		mockDB.AddAggregatedResult(&db.AggregatedResult{
+			Time:          start.Add(time.Duration(i) * 10 * time.Second),
+			TargetID:      1,
+			WindowSeconds: 10,
+			TDigestData:   data,
+			SampleCount:   int64(len(latencies)),
+		})
+	}
+	slices.Sort(all)
+
+	agg := rm.aggregateWindow(target, 60, 10, start, start.Add(time.Minute), false)
+	td, _ := db.DeserializeTDigest(agg.TDigestData)
+	if td.Count() != uint64(len(all)) || agg.SampleCount != int64(len(all)) {
+		t.Fatalf("Expected the merge to keep all %d samples, got count %v and %d samples", len(all), td.Count(), agg.SampleCount)
+	}
+	for _, q := range []float64{0.01, 0.5, 0.9, 0.99} {
+		want := all[int(q*float64(len(all)))]
+		if got := td.Quantile(q); math.Abs(got-want) > 5 {
+			t.Errorf("Quantile %v: expected %v over all points, got %v", q, want, got)
+		}
+	}
+	if got := td.Quantile(1); math.Abs(got-50000) > 1 {
+		t.Errorf("Expected the idle rollup's latency as the maximum, got %v", got)
+	}
+}
+
+func TestRollupManager_FutureDatedRawData(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(mockDB)
+	fakeClock := clockwork.NewFakeClock()
+	rm.clock = fakeClock
+
+	target := db.Target{
+		Name:              "SkewTarget",
+		Address:           "skew.com",
+		ProbeType:         "http",
+		Timeout:           1.0,
+		RetentionPolicies: `[{"window": 60, "retention": 3600}]`,
+	}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+
+	startTime := fakeClock.Now().Truncate(time.Minute)
+
+	// One good minute of data, plus a raw result from a host whose clock is an hour ahead.
+	for i := 0; i < 60; i++ {
+		mockDB.AddRawResults([]db.RawResult{{
+			Time:     startTime.Add(time.Duration(i) * time.Second),
+			TargetID: id,
+			Latency:  100.0,
+		}})
+	}
+	mockDB.AddRawResults([]db.RawResult{{
+		Time:     startTime.Add(time.Hour),
+		TargetID: id,
+		Latency:  999999.0,
+	}})
+
+	// A skewed rollup row in the future must not stall processing of the past.
+	mockDB.AddAggregatedResult(&db.AggregatedResult{
+		Time:          startTime.Add(2 * time.Hour),
+		TargetID:      id,
+		WindowSeconds: 60,
+	})
+
+	fakeClock.Advance(70 * time.Second)
+	rm.processRollups()
+
+	results, _ := mockDB.GetAggregatedResults(id, 60, startTime, startTime.Add(time.Minute))
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 aggregated result for the past window, got %d", len(results))
+	}
+	td, _ := db.DeserializeTDigest(results[0].TDigestData)
+	if td.Count() != 60 {
+		t.Errorf("Expected Count 60, got %v", td.Count())
+	}
+	if td.Quantile(1.0) != 100.0 {
+		t.Errorf("Expected Max 100.0, got %v", td.Quantile(1.0))
+	}
+
+	// Nothing should have been rolled up between now and the future-dated data.
+	future, _ := mockDB.GetAggregatedResults(id, 60, startTime.Add(time.Minute), startTime.Add(2*time.Hour))
+	if len(future) != 0 {
+		t.Errorf("Expected no rollups beyond the cutoff, got %d", len(future))
+	}
+
+	// A target whose only raw result is an hour ahead has nothing to roll up
+	// yet, and mustn't start its rollups from that result.
+	skewed := db.Target{Name: "OnlySkewed", Timeout: 1.0, RetentionPolicies: `[{"window": 60, "retention": 3600}]`}
+	skewedID, _ := mockDB.AddTarget(&skewed)
+	skewedStart := fakeClock.Now().Truncate(time.Minute)
+	mockDB.AddRawResults([]db.RawResult{{Time: skewedStart.Add(time.Hour), TargetID: skewedID, Latency: 999999.0}})
+	fakeClock.Advance(5 * time.Minute)
+	rm.processRollups()
+	if last, _ := mockDB.GetLastRollupTime(skewedID, 60, fakeClock.Now()); !last.IsZero() {
+		t.Fatalf("Expected no rollups from future-dated data alone, got one at %v", last)
+	}
+
+	// Results written late for the minutes that have passed since still
+	// get rolled up.
+	for i := 0; i < 60; i++ {
+		mockDB.AddRawResults([]db.RawResult{{Time: skewedStart.Add(time.Duration(i) * time.Second), TargetID: skewedID, Latency: 100.0}})
+	}
+	rm.processRollups()
+	late, _ := mockDB.GetAggregatedResults(skewedID, 60, skewedStart, skewedStart.Add(time.Minute))
+	if len(late) != 1 || late[0].SampleCount != 60 {
+		t.Fatalf("Expected the late minute rolled up with 60 samples, got %+v", late)
+	}
+
+	// The skewed rollup row didn't make the first target skip the minutes
+	// that closed since.
+	want := int(rollupCutoff(target, fakeClock.Now()).Sub(startTime) / time.Minute)
+	if past, _ := mockDB.GetAggregatedResults(id, 60, startTime, startTime.Add(time.Hour)); len(past) != want {
+		t.Errorf("Expected every one of the %d closed minutes rolled up, got %d", want, len(past))
+	}
+}
+
+func TestRollupManager_InvalidRawLatencies(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(mockDB)
+	target := db.Target{ID: 1, Name: "Invalid", Timeout: 1.0}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, latency := range []float64{100, -1, -7, math.NaN(), math.Inf(1), 1e300, 300} {
+		mockDB.AddRawResults([]db.RawResult{{Time: start.Add(time.Duration(i) * time.Second), TargetID: 1, Latency: latency}})
+	}
+
+	agg := rm.aggregateWindow(target, 60, 0, start, start.Add(time.Minute), false)
+	if agg.TimeoutCount != 1 || agg.SampleCount != 3 {
+		t.Errorf("Expected 1 timeout and 3 samples, got %d and %d", agg.TimeoutCount, agg.SampleCount)
+	}
+	if want := 400 + MaxSampleLatencyNS; agg.SumNS != want {
+		t.Errorf("Expected SumNS %v, got %v", want, agg.SumNS)
+	}
+	td, _ := db.DeserializeTDigest(agg.TDigestData)
+	if td.Quantile(0) != 100 || math.Abs(td.Quantile(1)-MaxSampleLatencyNS) > 1e-6*MaxSampleLatencyNS {
+		t.Errorf("Expected samples between 100 and %v, got %v to %v", MaxSampleLatencyNS, td.Quantile(0), td.Quantile(1))
+	}
+}
+
+func TestRollupManager_DisabledWindow(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(mockDB)
+	fakeClock := clockwork.NewFakeClock()
+	rm.clock = fakeClock
+
+	// The 10s window is disabled, so 60s rollups come straight from raw.
+	target := db.Target{
+		Name:              "DisabledWindow",
+		Address:           "disabled.pcom",
+		ProbeType:         "http",
+		Timeout:           1.0,
+		RetentionPolicies: `[{"window": 0, "retention": 3600}, {"window": 10, "retention": 3600, "enabled": false}, {"window": 60, "retention": 3600, "enabled": true}]`,
+	}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+
+	policies, err := GetRetentionPolicies(target)
+	if err != nil {
+		t.Fatalf("GetRetentionPolicies failed: %v", err)
+	}
+	if err := ValidateRetentionPolicies(policies); err != nil {
+		t.Fatalf("Expected policies with enabled flags to be valid: %v", err)
+	}
+	if policies[1].IsEnabled() || !policies[2].IsEnabled() || !(RetentionPolicy{}).IsEnabled() {
+		t.Errorf("Unexpected IsEnabled: %+v", policies)
+	}
+
+	startTime := fakeClock.Now().Truncate(time.Minute)
+	for _, w := range []int{10, 60} {
+		mockDB.AddAggregatedResult(&db.AggregatedResult{Time: startTime.Add(-time.Duration(w) * time.Second), TargetID: id, WindowSeconds: w})
+	}
+	for i := range 60 {
+		mockDB.AddRawResults([]db.RawResult{{Time: startTime.Add(time.Duration(i) * time.Second), TargetID: id, Latency: 100}})
+	}
+
+	fakeClock.Advance(70 * time.Second)
+	rm.processRollups()
+
+	if results, _ := mockDB.GetAggregatedResults(id, 10, startTime, startTime.Add(time.Minute)); len(results) != 0 {
+		t.Errorf("Expected no rollups of the disabled window, got %d", len(results))
+	}
+	results, _ := mockDB.GetAggregatedResults(id, 60, startTime, startTime.Add(time.Minute))
+	if len(results) != 1 || results[0].SampleCount != 60 || results[0].ExpectedSourceWindows != 0 {
+		t.Fatalf("Expected a 60s rollup of all 60 raw results, got %+v", results)
+	}
+
+	lags, err := RollupLags(mockDB, fakeClock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lag := range lags {
+		if lag.WindowSeconds == 10 {
+			t.Errorf("Expected no lag reported for the disabled window, got %+v", lag)
+		}
+	}
+}
+
+func TestRollupManager_SummaryAggregation(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(mockDB)
+	target := db.Target{ID: 1, Name: "Summary", Timeout: 1.0, Aggregation: db.AggregationSummary}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, latency := range []float64{300, -1, 100, 200, 500, 400} {
+		mockDB.AddRawResults([]db.RawResult{{Time: start.Add(time.Duration(i) * 10 * time.Second), TargetID: 1, Latency: latency}})
+	}
+
+	var minutes []*db.AggregatedResult
+	for i := range 2 {
+		ws := start.Add(time.Duration(i) * 30 * time.Second)
+		agg := rm.aggregateWindow(target, 30, 0, ws, ws.Add(30*time.Second), false)
+		minutes = append(minutes, agg)
+		mockDB.AddAggregatedResult(agg)
+	}
+	first := minutes[0]
+	if first.TDigestData != nil {
+		t.Errorf("Expected no digest in summary mode, got %d bytes", len(first.TDigestData))
+	}
+	if first.SampleCount != 2 || first.TimeoutCount != 1 || first.SumNS != 400 || first.MinNS != 100 || first.MaxNS != 300 {
+		t.Errorf("Unexpected summary rollup: %+v", first)
+	}
+
+	agg := rm.aggregateWindow(target, 60, 30, start, start.Add(time.Minute), false)
+	if agg.TDigestData != nil || agg.SampleCount != 5 || agg.TimeoutCount != 1 || agg.MinNS != 100 || agg.MaxNS != 500 {
+		t.Errorf("Unexpected cascaded summary rollup: %+v", agg)
+	}
+	if sd, ok := agg.StdDevNS(); !ok || math.Abs(sd-math.Sqrt(20000)) > 1e-9 {
+		t.Errorf("Expected stddev %v, got %v", math.Sqrt(20000), sd)
+	}
+	if empty := rm.aggregateWindow(target, 60, 30, start.Add(time.Minute), start.Add(2*time.Minute), false); empty.TDigestData != nil {
+		t.Error("Expected no digest in an empty summary rollup")
+	}
+
+	// Full aggregation records the same extremes alongside the digest.
+	target.Aggregation = ""
+	full := rm.aggregateWindow(target, 60, 0, start, start.Add(time.Minute), false)
+	if len(full.TDigestData) == 0 || full.MinNS != 100 || full.MaxNS != 500 {
+		t.Errorf("Unexpected full rollup: %+v", full)
+	}
+}
+
+func TestRollupManager_CascadedExtremesFromOldRows(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(mockDB)
+	target := db.Target{ID: 1, Name: "Old rows", Timeout: 1.0, Aggregation: db.AggregationSummary}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	td, _ := tdigest.New(tdigest.Compression(100))
+	td.Add(50)
+	td.Add(80)
+	data, _ := db.SerializeTDigest(td)
+	// A current summary rollup, and one written with a digest before
+	// extremes were stored, when the target still had full aggregation.
+	mockDB.AddAggregatedResult(&db.AggregatedResult{Time: start, TargetID: 1, WindowSeconds: 30, SampleCount: 2, SumNS: 500, SumSqNS: 130000, MinNS: 200, MaxNS: 300})
+	mockDB.AddAggregatedResult(&db.AggregatedResult{Time: start.Add(30 * time.Second), TargetID: 1, WindowSeconds: 30, TDigestData: data, SampleCount: 2, SumNS: 130, SumSqNS: 8900})
+
+	agg := rm.aggregateWindow(target, 60, 30, start, start.Add(time.Minute), false)
+	if agg.SampleCount != 4 || agg.MinNS != 50 || agg.MaxNS != 300 {
+		t.Errorf("Expected extremes 50 and 300 with the old row's from its digest, got %+v", agg)
+	}
+
+	// Without a digest the old row's extremes are unknown, and so are the
+	// cascaded rollup's.
+	mockDB.AddAggregatedResult(&db.AggregatedResult{Time: start.Add(time.Minute), TargetID: 1, WindowSeconds: 30, SampleCount: 2, SumNS: 500, SumSqNS: 130000, MinNS: 200, MaxNS: 300})
+	mockDB.AddAggregatedResult(&db.AggregatedResult{Time: start.Add(90 * time.Second), TargetID: 1, WindowSeconds: 30, SampleCount: 2, SumNS: 130, SumSqNS: 8900})
+	agg = rm.aggregateWindow(target, 60, 30, start.Add(time.Minute), start.Add(2*time.Minute), false)
+	if agg.SampleCount != 4 || agg.MinNS != 0 || agg.MaxNS != 0 {
+		t.Errorf("Expected unknown extremes, got %+v", agg)
+	}
+}
+
+func TestValidateRetentionPolicies_MaxRows(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []RetentionPolicy
+		wantErr  bool
+	}{
+		{"Retention only", []RetentionPolicy{{Window: 0, Retention: 60}}, false},
+		{"MaxRows only", []RetentionPolicy{{Window: 0, MaxRows: 10000}}, false},
+		{"Both", []RetentionPolicy{{Window: 0, Retention: 60, MaxRows: 10}}, false},
+		{"Neither", []RetentionPolicy{{Window: 0}}, true},
+		{"Negative MaxRows", []RetentionPolicy{{Window: 0, Retention: 60, MaxRows: -1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRetentionPolicies(tt.policies)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRetentionPolicies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRollupManager_StdDevMoments(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(mockDB)
+
+	target := db.Target{
+		Name:              "StdDevTarget",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 3600}, {"window": 60, "retention": 3600}, {"window": 300, "retention": 3600}]`,
+	}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Minute 0 is constant; minutes 1-4 vary. One timeout is mixed in and must
+	// not contribute to the moments.
+	var all []float64
+	for m := 0; m < 5; m++ {
+		for i := 0; i < 10; i++ {
+			latency := 1000.0
+			if m > 0 {
+				latency = float64(1000 + m*137 + i*i*29)
+			}
+			all = append(all, latency)
+			mockDB.AddRawResults([]db.RawResult{{
+				Time:     start.Add(time.Duration(m)*time.Minute + time.Duration(i)*time.Second),
+				TargetID: id,
+				Latency:  latency,
+			}})
+		}
+	}
+	mockDB.AddRawResults([]db.RawResult{{Time: start.Add(90 * time.Second), TargetID: id, Latency: -1}})
+
+	var minutes []*db.AggregatedResult
+	for m := 0; m < 5; m++ {
+		ws := start.Add(time.Duration(m) * time.Minute)
+		agg := rm.aggregateWindow(target, 60, 0, ws, ws.Add(time.Minute), false)
+		if agg == nil {
+			t.Fatalf("aggregateWindow returned nil for minute %d", m)
+		}
+		minutes = append(minutes, agg)
+	}
+	mockDB.AddAggregatedResults(minutes)
+
+	if sd, ok := minutes[0].StdDevNS(); !ok || sd != 0 {
+		t.Errorf("Expected stddev 0 for constant data, got %v (ok=%v)", sd, ok)
+	}
+	if minutes[1].SampleCount != 10 {
+		t.Errorf("Expected timeout to be excluded from sample count, got %d", minutes[1].SampleCount)
+	}
+
+	five := rm.aggregateWindow(target, 300, 60, start, start.Add(5*time.Minute), false)
+	if five == nil {
+		t.Fatal("aggregateWindow returned nil for the 5m window")
+	}
+	got, ok := five.StdDevNS()
+	if !ok {
+		t.Fatal("Expected 5m rollup to carry moments")
+	}
+
+	var sum float64
+	for _, v := range all {
+		sum += v
+	}
+	mean := sum / float64(len(all))
+	var sq float64
+	for _, v := range all {
+		sq += (v - mean) * (v - mean)
+	}
+	want := math.Sqrt(sq / float64(len(all)))
+	if math.Abs(got-want) > 1e-6 {
+		t.Errorf("Expected merged stddev %v, got %v", want, got)
+	}
+
+	// A source rollup without moments (written before they were tracked)
+	// makes the merged moments unknown rather than wrong.
+	mockDB.AggregatedResults[id][0].SampleCount = 0
+	mockDB.AggregatedResults[id][0].SumNS = 0
+	mockDB.AggregatedResults[id][0].SumSqNS = 0
+	partial := rm.aggregateWindow(target, 300, 60, start, start.Add(5*time.Minute), false)
+	if _, ok := partial.StdDevNS(); ok {
+		t.Error("Expected no stddev when a source rollup lacks moments")
+	}
+}
+
+// staleRollupStore reports no previous rollups, as if GetLastRollupTime raced
+// with a write, forcing every pass to reprocess all windows.
+type staleRollupStore struct {
+	*MockStore
+}
+
+func (s staleRollupStore) GetLastRollupTime(targetID int64, windowSeconds int, before time.Time) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func TestRollupManager_ReprocessingIsIdempotent(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(staleRollupStore{mockDB})
+	fakeClock := clockwork.NewFakeClock()
+	rm.clock = fakeClock
+
+	target := db.Target{
+		Name:              "IdempotentTarget",
+		ProbeType:         "http",
+		Timeout:           1.0,
+		RetentionPolicies: `[{"window": 0, "retention": 3600}, {"window": 60, "retention": 3600}, {"window": 300, "retention": 3600}]`,
+	}
+	id, _ := mockDB.AddTarget(&target)
+
+	start := fakeClock.Now().Add(-10 * time.Minute).Truncate(5 * time.Minute)
+	for i := 0; i < 300; i++ {
+		latency := float64(100 + i%7)
+		if i%50 == 0 {
+			latency = -1
+		}
+		mockDB.AddRawResults([]db.RawResult{{Time: start.Add(time.Duration(i) * time.Second), TargetID: id, Latency: latency}})
+	}
+
+	snapshot := func() map[string]db.AggregatedResult {
+		m := make(map[string]db.AggregatedResult)
+		for _, r := range mockDB.AggregatedResults[id] {
+			m[fmt.Sprintf("%d@%d", r.WindowSeconds, r.Time.Unix())] = r
+		}
+		return m
+	}
+
+	rm.processRollups()
+	first := snapshot()
+	firstRows := len(mockDB.AggregatedResults[id])
+	rm.processRollups()
+	second := snapshot()
+
+	if len(mockDB.AggregatedResults[id]) != firstRows {
+		t.Fatalf("Expected %d aggregated rows after reprocessing, got %d", firstRows, len(mockDB.AggregatedResults[id]))
+	}
+	key := fmt.Sprintf("300@%d", start.Unix())
+	if first[key].SampleCount != 294 || first[key].TimeoutCount != 6 {
+		t.Fatalf("Expected 5m window with 294 samples and 6 timeouts, got %d and %d", first[key].SampleCount, first[key].TimeoutCount)
+	}
+	for k, a := range first {
+		b, ok := second[k]
+		if !ok {
+			t.Errorf("Row %s disappeared after reprocessing", k)
+			continue
+		}
+		if a.TimeoutCount != b.TimeoutCount || a.SampleCount != b.SampleCount || a.SumNS != b.SumNS || !bytes.Equal(a.TDigestData, b.TDigestData) {
+			t.Errorf("Row %s changed after reprocessing: %+v -> %+v", k, a, b)
+		}
+	}
+}
+
+func TestRollupManager_Backfill(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(mockDB)
+	fakeClock := clockwork.NewFakeClock()
+	rm.clock = fakeClock
+
+	target := db.Target{
+		Name:              "BackfillTarget",
+		ProbeType:         "http",
+		Timeout:           1.0,
+		RetentionPolicies: `[{"window": 0, "retention": 3600}, {"window": 60, "retention": 3600}, {"window": 300, "retention": 3600}]`,
+	}
+	id, _ := mockDB.AddTarget(&target)
+
+	start := fakeClock.Now().Add(-time.Hour).Truncate(5 * time.Minute)
+	// Raw data for the first 10 minutes only, one sample per second.
+	for i := 0; i < 600; i++ {
+		mockDB.AddRawResults([]db.RawResult{{Time: start.Add(time.Duration(i) * time.Second), TargetID: id, Latency: 100}})
+	}
+	// A stale 1m rollup that should be replaced, and one whose raw data has
+	// already expired, which must survive.
+	mockDB.AddAggregatedResult(&db.AggregatedResult{Time: start, TargetID: id, WindowSeconds: 60, TimeoutCount: 99})
+	expired := start.Add(12 * time.Minute)
+	mockDB.AddAggregatedResult(&db.AggregatedResult{Time: expired, TargetID: id, WindowSeconds: 60, TimeoutCount: 7})
+
+	built, err := rm.Backfill(id, 60, start, start.Add(15*time.Minute))
+	if err != nil {
+		t.Fatalf("Backfill failed: %v", err)
+	}
+	// 10 one-minute windows with raw data, plus three five-minute windows
+	// (the last built from the surviving 1m row alone).
+	if built != 13 {
+		t.Errorf("Expected 13 windows rebuilt, got %d", built)
+	}
+
+	minutes, _ := mockDB.GetAggregatedResults(id, 60, start, start.Add(time.Hour))
+	byTime := make(map[time.Time]db.AggregatedResult)
+	for _, r := range minutes {
+		byTime[r.Time] = r
+	}
+	if r := byTime[start]; r.TimeoutCount != 0 || r.SampleCount != 60 {
+		t.Errorf("Expected stale 1m row to be rebuilt with 60 samples, got %d samples and %d timeouts", r.SampleCount, r.TimeoutCount)
+	}
+	if r := byTime[expired]; r.TimeoutCount != 7 {
+		t.Errorf("Expected the 1m row without raw data to be left alone, got %+v", r)
+	}
+
+	fives, _ := mockDB.GetAggregatedResults(id, 300, start, start.Add(time.Hour))
+	if len(fives) != 3 || fives[0].SampleCount != 300 || fives[2].TimeoutCount != 7 {
+		t.Errorf("Expected 5m windows to cascade from the rebuilt 1m windows, got %+v", fives)
+	}
+
+	if _, err := rm.Backfill(id, 120, start, start.Add(time.Hour)); !errors.Is(err, ErrWindowNotConfigured) {
+		t.Errorf("Expected ErrWindowNotConfigured for an unknown window, got %v", err)
+	}
+}
+
+func TestRollupLags(t *testing.T) {
+	mockDB := NewMockStore()
+	target := db.Target{
+		Name:              "LagTarget",
+		ProbeType:         "http",
+		Timeout:           2.0,
+		RetentionPolicies: `[{"window": 0, "retention": 3600}, {"window": 60, "retention": 3600}, {"window": 300, "retention": 3600}]`,
+	}
+	id, _ := mockDB.AddTarget(&target)
+
+	now := time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)
+	// The cutoff is 12:00:25, so the newest complete minute and five minutes
+	// both end at 12:00. The 1m rollup ends at 11:55, five minutes behind;
+	// the 5m rollup is current.
+	mockDB.AddAggregatedResult(&db.AggregatedResult{Time: now.Add(-6*time.Minute - 30*time.Second), TargetID: id, WindowSeconds: 60})
+	mockDB.AddAggregatedResult(&db.AggregatedResult{Time: now.Add(-5*time.Minute - 30*time.Second), TargetID: id, WindowSeconds: 300})
+
+	lags, err := RollupLags(mockDB, now)
+	if err != nil {
+		t.Fatalf("RollupLags failed: %v", err)
+	}
+	want := map[int]time.Duration{60: 5 * time.Minute, 300: 0}
+	if len(lags) != len(want) {
+		t.Fatalf("Expected %d lags, got %+v", len(want), lags)
+	}
+	for _, l := range lags {
+		if l.TargetID != id || l.Lag != want[l.WindowSeconds] {
+			t.Errorf("Window %ds: expected lag %v, got %+v", l.WindowSeconds, want[l.WindowSeconds], l)
+		}
+	}
+}
+
+func TestRollupManager_PartialFlush(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(mockDB)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	fakeClock := clockwork.NewFakeClockAt(base.Add(34 * time.Second))
+	rm.clock = fakeClock
+	rm.flushInterval = 30 * time.Second
+
+	target := db.Target{
+		Name:              "PartialTarget",
+		Address:           "partial.example",
+		ProbeType:         "http",
+		Timeout:           1.0,
+		RetentionPolicies: `[{"window": 60, "retention": 3600}]`,
+	}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+	mockDB.AddAggregatedResult(&db.AggregatedResult{Time: base.Add(-time.Minute), TargetID: id, WindowSeconds: 60})
+	for i := 0; i < 60; i++ {
+		mockDB.AddRawResults([]db.RawResult{{Time: base.Add(time.Duration(i) * time.Second), TargetID: id, Latency: 100}})
+	}
+
+	window := func() db.AggregatedResult {
+		t.Helper()
+		results, _ := mockDB.GetAggregatedResults(id, 60, base, base.Add(time.Minute))
+		if len(results) != 1 {
+			t.Fatalf("Expected 1 rollup of the window, got %d", len(results))
+		}
+		return results[0]
+	}
+	samples := func(agg db.AggregatedResult) uint64 {
+		td, _ := db.DeserializeTDigest(agg.TDigestData)
+		return td.Count()
+	}
+
+	// The cutoff is 30s into the window, which is written as far as it goes
+	// without counting as rolled up.
+	rm.processTargetWindow(target, 60, 0)
+	if agg := window(); !agg.Partial || samples(agg) != 30 {
+		t.Errorf("Expected a partial rollup of 30 samples, got partial=%v with %v", agg.Partial, samples(agg))
+	}
+	if last, _ := mockDB.GetLastRollupTime(id, 60, fakeClock.Now()); !last.Equal(base.Add(-time.Minute)) {
+		t.Errorf("Expected the partial rollup not to count as rolled up, got last rollup %v", last)
+	}
+
+	// Not yet due again.
+	fakeClock.Advance(10 * time.Second)
+	rm.processTargetWindow(target, 60, 0)
+	if agg := window(); samples(agg) != 30 {
+		t.Errorf("Expected the partial rollup to wait for the flush interval, got %v samples", samples(agg))
+	}
+
+	// Once the window closes, the complete rollup replaces the partial one.
+	fakeClock.Advance(30 * time.Second)
+	rm.processTargetWindow(target, 60, 0)
+	if agg := window(); agg.Partial || samples(agg) != 60 {
+		t.Errorf("Expected the complete rollup of 60 samples, got partial=%v with %v", agg.Partial, samples(agg))
+	}
+}
+
+func TestRollupManager_Metrics(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(mockDB)
+	target := db.Target{ID: 1, Name: "Metrics", Timeout: 1.0}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 60 {
+		metrics := map[string]float64{"bytes": float64(i + 1)}
+		if i%2 == 0 {
+			metrics["answers"] = 2
+		}
+		mockDB.AddRawResults([]db.RawResult{{Time: start.Add(time.Duration(i) * time.Second), TargetID: 1, Latency: 100, Metrics: metrics}})
+	}
+	cutoff := start.Add(time.Hour)
+
+	for i := range 5 {
+		ws := start.Add(time.Duration(i) * time.Minute)
+		if agg := rm.aggregateWindow(target, 60, 0, ws, ws.Add(time.Minute), true); agg != nil {
+			mockDB.AddAggregatedResult(agg)
+		}
+	}
+	minute, _ := mockDB.GetAggregatedMetrics(1, 60, "", start, cutoff)
+	if len(minute) != 2 || minute[0].Name != "answers" || minute[0].SampleCount != 30 || minute[1].SampleCount != 60 || minute[1].Sum != 1830 {
+		t.Fatalf("Unexpected 60s metric rollups: %+v", minute)
+	}
+
+	// Each metric is rolled up on its own from the finer window.
+	agg := rm.aggregateWindow(target, 300, 60, start, start.Add(5*time.Minute), true)
+	if agg == nil || len(agg.Metrics) != 2 {
+		t.Fatalf("Expected 2 metric rollups for 300s, got %+v", agg)
+	}
+	bytes := agg.Metrics[1]
+	if bytes.Name != "bytes" || bytes.SampleCount != 60 || bytes.Sum != 1830 {
+		t.Errorf("Unexpected 300s bytes rollup: %+v", bytes)
+	}
+	td, err := db.DeserializeTDigest(bytes.TDigestData)
+	if err != nil || td.Count() != 60 || td.Quantile(1) != 60 {
+		t.Errorf("Expected the bytes digest to hold 60 values up to 60, got %v (%v)", td, err)
+	}
+}
+
+func TestGetRetentionPolicies_Inheritance(t *testing.T) {
+	defer SetGlobalRetentionPolicies(nil)
+
+	own := db.Target{RetentionPolicies: `[{"window": 0, "retention": 60}]`}
+	inheriting := db.Target{}
+
+	if p, err := GetRetentionPolicies(inheriting); err != nil || !slices.Equal(p, DefaultPolicies()) {
+		t.Errorf("Expected the built-in defaults without global policies, got %v (%v)", p, err)
+	}
+
+	global := []RetentionPolicy{{Window: 60, Retention: 3600}, {Window: 0, Retention: 600}}
+	SetGlobalRetentionPolicies(global)
+	want := []RetentionPolicy{{Window: 0, Retention: 600}, {Window: 60, Retention: 3600}}
+	for _, target := range []db.Target{inheriting, {RetentionPolicies: "[]"}} {
+		if p, err := GetRetentionPolicies(target); err != nil || !slices.Equal(p, want) {
+			t.Errorf("Expected the global policies for %q, got %v (%v)", target.RetentionPolicies, p, err)
+		}
+	}
+	if p, _ := GetRetentionPolicies(own); len(p) != 1 || p[0].Retention != 60 {
+		t.Errorf("Expected the target's own policies to take precedence, got %v", p)
+	}
+	if _, set := GlobalRetentionPolicies(); !set {
+		t.Error("Expected global policies to be reported as set")
+	}
+
+	SetGlobalRetentionPolicies(nil)
+	if p, set := GlobalRetentionPolicies(); set || !slices.Equal(p, DefaultPolicies()) {
+		t.Errorf("Expected the built-in defaults after a reset, got %v", p)
+	}
+}
+
+func TestLoadGlobalRetentionPolicies(t *testing.T) {
+	defer SetGlobalRetentionPolicies(nil)
+	d, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer d.Close()
+
+	d.SetSetting(db.SettingRetentionPolicies, `[{"window": 0, "retention": 600}]`)
+	if err := LoadGlobalRetentionPolicies(d); err != nil {
+		t.Fatalf("LoadGlobalRetentionPolicies failed: %v", err)
+	}
+	if p, set := GlobalRetentionPolicies(); !set || len(p) != 1 || p[0].Retention != 600 {
+		t.Errorf("Expected the stored policies, got %v", p)
+	}
+
+	d.SetSetting(db.SettingRetentionPolicies, `[{"window": 0}]`)
+	if err := LoadGlobalRetentionPolicies(d); err == nil {
+		t.Error("Expected invalid stored policies to be rejected")
+	}
+}
+
+func TestRollupManager_StoredPercentiles(t *testing.T) {
+	mockDB := NewMockStore()
+	s := New(mockDB)
+	if err := s.SetStoredPercentiles([]float64{50, 99}); err != nil {
+		t.Fatalf("SetStoredPercentiles failed: %v", err)
+	}
+	rm := s.rollupManager
+	target := db.Target{ID: 1, Name: "Stored", Timeout: 1.0}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 100 {
+		mockDB.AddRawResults([]db.RawResult{{Time: start.Add(time.Duration(i) * 100 * time.Millisecond), TargetID: 1, Latency: float64(i + 1)}})
+	}
+	agg := rm.aggregateWindow(target, 60, 0, start, start.Add(time.Minute), false)
+	td, _ := db.DeserializeTDigest(agg.TDigestData)
+	want := map[string]float64{"p50": td.Quantile(0.5), "p99": td.Quantile(0.99)}
+	if !maps.Equal(agg.Percentiles, want) {
+		t.Errorf("Expected stored percentiles %v, got %v", want, agg.Percentiles)
+	}
+
+	if empty := rm.aggregateWindow(target, 60, 0, start.Add(time.Minute), start.Add(2*time.Minute), false); empty.Percentiles != nil {
+		t.Errorf("Expected no stored percentiles without samples, got %v", empty.Percentiles)
+	}
+
+	for _, bad := range [][]float64{{-1}, {100.5}, {math.NaN()}, {50, 50}, make([]float64, MaxStoredPercentiles+1)} {
+		if err := s.SetStoredPercentiles(bad); err == nil {
+			t.Errorf("Expected %v to be rejected", bad)
+		}
+	}
+}
+
+func TestRollupManager_LatencyPrecision(t *testing.T) {
+	mockDB := NewMockStore()
+	s := New(mockDB)
+	if err := s.SetLatencyPrecision(time.Microsecond); err != nil {
+		t.Fatalf("SetLatencyPrecision failed: %v", err)
+	}
+	rm := s.rollupManager
+	target := db.Target{ID: 1, Name: "Rounded", Timeout: 1.0}
+
+	// 1000 distinct latencies within 10µs of each other.
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 1000 {
+		mockDB.AddRawResults([]db.RawResult{{Time: start.Add(time.Duration(i) * 50 * time.Millisecond), TargetID: 1, Latency: 100000 + float64(i*10) + 0.5}})
+	}
+	agg := rm.aggregateWindow(target, 60, 0, start, start.Add(time.Minute), false)
+	td, _ := db.DeserializeTDigest(agg.TDigestData)
+	centroids := 0
+	td.ForEachCentroid(func(mean float64, count uint64) bool {
+		if mean != math.Round(mean/1000)*1000 {
+			t.Errorf("Expected centroids on whole microseconds, got %v", mean)
+			return false
+		}
+		centroids++
+		return true
+	})
+	if centroids > 11 {
+		t.Errorf("Expected at most 11 centroids, one per microsecond, got %d", centroids)
+	}
+	if agg.MinNS != 100000.5 || agg.MaxNS != 109990.5 || agg.SampleCount != 1000 {
+		t.Errorf("Expected exact extremes and count, got min %v max %v count %d", agg.MinNS, agg.MaxNS, agg.SampleCount)
+	}
+
+	if err := s.SetLatencyPrecision(-time.Microsecond); err == nil {
+		t.Error("Expected a negative precision to be rejected")
+	}
 }
@@ -0,0 +1,174 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minutesPerWeek = 7 * 24 * 60
+
+// Schedule is when a target is probed, parsed from its Schedule by
+// ParseSchedule. A nil *Schedule is always active.
+type Schedule struct {
+	loc   *time.Location
+	spans []weekSpan
+}
+
+// weekSpan is an active span of the week, [start, end) in minutes counted
+// from Sunday 00:00.
+type weekSpan struct {
+	start, end int
+}
+
+var scheduleDays = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// ParseSchedule parses a target's Schedule: comma-separated rules of days
+// and a time range, such as "mon-fri 09:00-17:00, sat 10:00-14:00". Days
+// are a day ("mon"), a range of them ("mon-fri", which may wrap past
+// Sunday) or "daily". A range that ends before it starts runs past
+// midnight into the next day, as in "mon-fri 22:00-06:00", and "24:00"
+// ends at midnight. Times are UTC unless the schedule starts with a zone,
+// as in "TZ=Europe/Berlin mon-fri 09:00-17:00". An empty schedule returns
+// nil, which is always active.
+func ParseSchedule(s string) (*Schedule, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	sc := &Schedule{loc: time.UTC}
+	if rest, ok := strings.CutPrefix(s, "TZ="); ok {
+		zone, rules, _ := strings.Cut(rest, " ")
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", zone)
+		}
+		sc.loc, s = loc, strings.TrimSpace(rules)
+	}
+
+	var spans []weekSpan
+	for _, rule := range strings.Split(s, ",") {
+		fields := strings.Fields(rule)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid rule %q: expected days and a time range, such as \"mon-fri 09:00-17:00\"", strings.TrimSpace(rule))
+		}
+		days, err := parseScheduleDays(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		from, to, err := parseScheduleTimes(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, day := range days {
+			start, end := day*24*60+from, day*24*60+to
+			if to <= from {
+				end += 24 * 60 // Past midnight.
+			}
+			if end > minutesPerWeek {
+				// Saturday night into Sunday morning.
+				spans = append(spans, weekSpan{0, end - minutesPerWeek})
+				end = minutesPerWeek
+			}
+			spans = append(spans, weekSpan{start, end})
+		}
+	}
+	sc.spans = spans
+	return sc, nil
+}
+
+func parseScheduleDays(s string) ([]int, error) {
+	s = strings.ToLower(s)
+	if s == "daily" {
+		return []int{0, 1, 2, 3, 4, 5, 6}, nil
+	}
+	first, last, isRange := strings.Cut(s, "-")
+	from, ok1 := scheduleDays[first]
+	to, ok2 := from, true
+	if isRange {
+		to, ok2 = scheduleDays[last]
+	}
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("invalid days %q: expected a day such as \"mon\", a range such as \"mon-fri\", or \"daily\"", s)
+	}
+	var days []int
+	for d := from; ; d = (d + 1) % 7 {
+		days = append(days, d)
+		if d == to {
+			return days, nil
+		}
+	}
+}
+
+// parseScheduleTimes parses a range such as "09:00-17:00" into minutes of
+// the day.
+func parseScheduleTimes(s string) (int, int, error) {
+	invalid := fmt.Errorf("invalid time range %q: expected HH:MM-HH:MM, such as \"09:00-17:00\"", s)
+	first, last, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, invalid
+	}
+	from, ok1 := parseClock(first)
+	to, ok2 := parseClock(last)
+	if !ok1 || !ok2 || from == 24*60 || from == to {
+		return 0, 0, invalid
+	}
+	return from, to, nil
+}
+
+// parseClock parses "HH:MM", up to "24:00", into minutes of the day.
+func parseClock(s string) (int, bool) {
+	h, m, ok := strings.Cut(s, ":")
+	if !ok || len(h) != 2 || len(m) != 2 {
+		return 0, false
+	}
+	hours, err1 := strconv.Atoi(h)
+	minutes, err2 := strconv.Atoi(m)
+	if err1 != nil || err2 != nil || hours < 0 || minutes < 0 || minutes > 59 || hours > 24 || (hours == 24 && minutes > 0) {
+		return 0, false
+	}
+	return hours*60 + minutes, true
+}
+
+// Active reports whether the schedule is on at t.
+func (sc *Schedule) Active(t time.Time) bool {
+	if sc == nil {
+		return true
+	}
+	t = t.In(sc.loc)
+	minute := int(t.Weekday())*24*60 + t.Hour()*60 + t.Minute()
+	for _, span := range sc.spans {
+		if minute >= span.start && minute < span.end {
+			return true
+		}
+	}
+	return false
+}
+
+// OffDuring reports whether the schedule is off at any time in [start, end).
+// Schedules change state on whole minutes, so each minute is checked; a
+// range of a week or more repeats itself and only a week is checked.
+func (sc *Schedule) OffDuring(start, end time.Time) bool {
+	if sc == nil {
+		return false
+	}
+	if end.Sub(start) > 7*24*time.Hour {
+		end = start.Add(7 * 24 * time.Hour)
+	}
+	for t := start; t.Before(end); t = t.Truncate(time.Minute).Add(time.Minute) {
+		if !sc.Active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// targetSchedule parses a target's schedule, treating one that no longer
+// parses as always active; the probe loop reports it.
+func targetSchedule(schedule string) *Schedule {
+	sc, _ := ParseSchedule(schedule)
+	return sc
+}
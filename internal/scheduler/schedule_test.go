@@ -0,0 +1,168 @@
+package scheduler
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+	"vaportrail/internal/db"
+	"vaportrail/internal/probe"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func TestParseSchedule(t *testing.T) {
+	// 2024-01-01 is a Monday.
+	at := func(day int, hhmm string) time.Time {
+		clock, _ := time.Parse("15:04", hhmm)
+		return time.Date(2024, 1, day, clock.Hour(), clock.Minute(), 0, 0, time.UTC)
+	}
+	tests := []struct {
+		schedule string
+		active   []time.Time
+		inactive []time.Time
+	}{
+		{
+			schedule: "mon-fri 09:00-17:00",
+			active:   []time.Time{at(1, "09:00"), at(5, "16:59")},
+			inactive: []time.Time{at(1, "08:59"), at(1, "17:00"), at(6, "12:00"), at(7, "12:00")},
+		},
+		{
+			schedule: "mon-fri 22:00-06:00",
+			active:   []time.Time{at(1, "23:00"), at(2, "05:59"), at(6, "05:00")},
+			inactive: []time.Time{at(1, "05:00"), at(1, "12:00"), at(6, "23:00")},
+		},
+		{
+			// Saturday night runs into Sunday morning, at the start of the week.
+			schedule: "sat 20:00-02:00, sun 10:00-24:00",
+			active:   []time.Time{at(6, "21:00"), at(7, "01:00"), at(7, "23:59")},
+			inactive: []time.Time{at(7, "02:00"), at(8, "00:00")},
+		},
+		{
+			schedule: "fri-mon 12:00-13:00",
+			active:   []time.Time{at(5, "12:30"), at(7, "12:30"), at(8, "12:30")},
+			inactive: []time.Time{at(2, "12:30"), at(4, "12:30")},
+		},
+		{
+			schedule: "daily 00:00-24:00",
+			active:   []time.Time{at(1, "00:00"), at(3, "23:59")},
+		},
+		{
+			// 09:00 in Tokyo is 00:00 UTC.
+			schedule: "TZ=Asia/Tokyo DAILY 09:00-10:00",
+			active:   []time.Time{at(1, "00:30")},
+			inactive: []time.Time{at(1, "09:30")},
+		},
+	}
+	for _, tt := range tests {
+		sc, err := ParseSchedule(tt.schedule)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.schedule, err)
+			continue
+		}
+		for _, ts := range tt.active {
+			if !sc.Active(ts) {
+				t.Errorf("%q: expected active at %v", tt.schedule, ts)
+			}
+		}
+		for _, ts := range tt.inactive {
+			if sc.Active(ts) {
+				t.Errorf("%q: expected inactive at %v", tt.schedule, ts)
+			}
+		}
+	}
+
+	if sc, err := ParseSchedule("  "); err != nil || sc != nil || !sc.Active(time.Now()) || sc.OffDuring(at(1, "00:00"), at(8, "00:00")) {
+		t.Errorf("Expected an empty schedule to always be active, got %v, %v", sc, err)
+	}
+
+	for _, bad := range []string{
+		"mon",
+		"09:00-17:00",
+		"mon-fri 9:00-17:00",
+		"mon-fri 09:00-25:00",
+		"mon-fri 09:60-17:00",
+		"mon-fri 09:00-09:00",
+		"mon-fri 24:00-06:00",
+		"weekdays 09:00-17:00",
+		"mon-fri 09:00-17:00,",
+		"TZ=Mars/Olympus mon 09:00-17:00",
+	} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Errorf("ParseSchedule(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestSchedule_OffDuring(t *testing.T) {
+	sc, err := ParseSchedule("daily 08:00-20:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if sc.OffDuring(day.Add(8*time.Hour), day.Add(20*time.Hour)) {
+		t.Error("Expected the schedule to be on for all of 08:00-20:00")
+	}
+	if !sc.OffDuring(day.Add(19*time.Hour), day.Add(21*time.Hour)) {
+		t.Error("Expected the schedule to be off for part of 19:00-21:00")
+	}
+	if !sc.OffDuring(day.Add(19*time.Hour+59*time.Minute+30*time.Second), day.Add(20*time.Hour+30*time.Second)) {
+		t.Error("Expected the schedule to be off for part of a range across 20:00")
+	}
+}
+
+func TestScheduler_ProbesOnlyOnSchedule(t *testing.T) {
+	mockDB := NewMockStore()
+	// A Monday, an hour before the schedule starts.
+	fakeClock := clockwork.NewFakeClockAt(time.Date(2024, 1, 1, 8, 59, 50, 0, time.UTC))
+	s := New(mockDB)
+	s.Clock = fakeClock
+	var runs atomic.Int64
+	s.probeRunner = &MockRunner{
+		RunFn: func(cfg probe.Config) (float64, error) {
+			runs.Add(1)
+			return 500.0, nil
+		},
+	}
+	s.Start()
+	defer s.Stop()
+
+	target := db.Target{Name: "Scheduled", Address: "example.com", ProbeType: "http", ProbeInterval: 1, Schedule: "mon-fri 09:00-17:00"}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+	s.AddTarget(target)
+	time.Sleep(50 * time.Millisecond)
+
+	tick := func(n int) int64 {
+		before := runs.Load()
+		for range n {
+			fakeClock.Advance(time.Second)
+			time.Sleep(20 * time.Millisecond)
+		}
+		return runs.Load() - before
+	}
+
+	if n := tick(5); n != 0 {
+		t.Errorf("Expected no probes before the schedule starts, got %d", n)
+	}
+	tick(5) // Up to 09:00.
+	if n := tick(3); n == 0 {
+		t.Error("Expected probes once the schedule started")
+	}
+}
+
+func TestRollupManager_ScheduleGaps(t *testing.T) {
+	mockDB := NewMockStore()
+	rm := NewRollupManager(mockDB)
+	target := db.Target{ID: 1, Name: "Scheduled", Timeout: 1.0, Schedule: "daily 00:00-00:02"}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// No data at all: the first two minutes were scheduled and are missing
+	// data, the rest are off-schedule gaps.
+	for i, want := range []bool{false, false, true, true} {
+		ws := start.Add(time.Duration(i) * time.Minute)
+		agg := rm.aggregateWindow(target, 60, 0, ws, ws.Add(time.Minute), false)
+		if agg.Maintenance != want {
+			t.Errorf("Minute %d: expected Maintenance %v, got %v", i, want, agg.Maintenance)
+		}
+	}
+}
@@ -1,9 +1,15 @@
 package scheduler
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"vaportrail/internal/db"
 	"vaportrail/internal/probe"
@@ -11,35 +17,72 @@ import (
 	"github.com/jonboulle/clockwork"
 )
 
+// MinProbeInterval is the shortest probe interval, in seconds, that a target
+// may use. Anything faster would hammer the target and pile up goroutines.
+const MinProbeInterval = 0.01
+
 type Scheduler struct {
 	db          db.Store
 	probeRunner probe.Runner
 
 	mu            sync.Mutex
 	stopChans     map[int64]chan struct{}
+	targets       map[int64]db.Target // last known definition of each target, for hooks
+	slots         map[int64]*probeSlots
+	loops         map[int64]*loopState
+	hooks         []ResultHook
+	statusHooks   []StatusHook
+	hookChan      chan []db.RawResult
+	hookWG        sync.WaitGroup
 	stopped       bool
 	probeWG       sync.WaitGroup
 	Clock         clockwork.Clock
 	rawResultChan chan db.RawResult
+	// resultOverflow is the SetResultBuffer policy; droppedResults counts
+	// results it dropped.
+	resultOverflow string
+	droppedResults atomic.Int64
+	// rejectedSamples counts results dropped by checkLatency.
+	rejectedSamples atomic.Int64
+	// panics counts panics recovered by panicError, rollups' included.
+	panics        atomic.Int64
 	batchStopChan chan struct{}
 	batchWG       sync.WaitGroup
 	stopOnce      sync.Once
+	limiter       probeLimiter
+	// failureLogInterval is the SetFailureLogInterval interval.
+	failureLogInterval time.Duration
 
+	maintenance      *maintenance
 	rollupManager    *RollupManager
 	retentionManager *RetentionManager
+	diskGuard        *DiskGuard    // nil unless SetDiskGuard was called
+	checkpointer     *Checkpointer // nil unless SetCheckpointInterval enabled it
 }
 
 func New(database db.Store) *Scheduler {
-	return &Scheduler{
-		db:               database,
-		probeRunner:      probe.RealRunner{},
-		stopChans:        make(map[int64]chan struct{}),
-		Clock:            clockwork.NewRealClock(),
-		rawResultChan:    make(chan db.RawResult, 1000), // Buffer size 1000
-		batchStopChan:    make(chan struct{}),
-		rollupManager:    NewRollupManager(database),
-		retentionManager: NewRetentionManager(database),
+	pauses := &maintenance{}
+	rollups := NewRollupManager(database)
+	rollups.maintenance = pauses
+	s := &Scheduler{
+		db:                 database,
+		probeRunner:        probe.RealRunner{},
+		stopChans:          make(map[int64]chan struct{}),
+		targets:            make(map[int64]db.Target),
+		slots:              make(map[int64]*probeSlots),
+		loops:              make(map[int64]*loopState),
+		hookChan:           make(chan []db.RawResult, hookQueueSize),
+		Clock:              clockwork.NewRealClock(),
+		rawResultChan:      make(chan db.RawResult, DefaultResultBufferSize),
+		resultOverflow:     OverflowDropNewest,
+		failureLogInterval: DefaultFailureLogInterval,
+		batchStopChan:      make(chan struct{}),
+		maintenance:        pauses,
+		rollupManager:      rollups,
+		retentionManager:   NewRetentionManager(database),
 	}
+	rollups.panics = &s.panics
+	return s
 }
 
 func (s *Scheduler) Start() error {
@@ -49,14 +92,23 @@ func (s *Scheduler) Start() error {
 	}
 
 	log.Printf("Starting scheduler with %d targets", len(targets))
+	logMissingCommands(MissingCommands(targets))
 	for _, t := range targets {
-		s.AddTarget(t)
+		s.startTarget(t)
 	}
 
+	s.hookWG.Add(1)
+	go s.runHooks()
 	s.batchWG.Add(1)
 	go s.runBatchWriter()
 	s.rollupManager.Start()
 	s.retentionManager.Start()
+	if s.diskGuard != nil {
+		s.diskGuard.Start()
+	}
+	if s.checkpointer != nil {
+		s.checkpointer.Start()
+	}
 
 	return nil
 }
@@ -74,8 +126,16 @@ func (s *Scheduler) Stop() {
 		s.probeWG.Wait()
 		close(s.batchStopChan)
 		s.batchWG.Wait()
+		close(s.hookChan)
+		s.hookWG.Wait()
 		s.rollupManager.Stop()
 		s.retentionManager.Stop()
+		if s.diskGuard != nil {
+			s.diskGuard.Stop()
+		}
+		if s.checkpointer != nil {
+			s.checkpointer.Stop()
+		}
 	})
 }
 
@@ -90,10 +150,15 @@ func (s *Scheduler) runBatchWriter() {
 		if len(buffer) == 0 {
 			return
 		}
+		if s.diskGuard != nil && s.diskGuard.low() {
+			s.diskGuard.dropped(len(buffer))
+			buffer = buffer[:0]
+			return
+		}
 		if err := s.db.AddRawResults(buffer); err != nil {
 			log.Printf("Failed to flush raw results: %v", err)
 		} else {
-			// log.Printf("Flushed %d raw results", len(buffer))
+			s.queueHooks(buffer)
 		}
 		buffer = buffer[:0] // Reset buffer (reuse existing slice)
 	}
@@ -121,7 +186,14 @@ func (s *Scheduler) runBatchWriter() {
 	}
 }
 
+// AddTarget starts probing t, warning if the command its probe needs isn't
+// installed.
 func (s *Scheduler) AddTarget(t db.Target) {
+	logMissingCommands(MissingCommands([]db.Target{t}))
+	s.startTarget(t)
+}
+
+func (s *Scheduler) startTarget(t db.Target) {
 	s.mu.Lock()
 	if s.stopped {
 		s.mu.Unlock()
@@ -132,12 +204,66 @@ func (s *Scheduler) AddTarget(t db.Target) {
 		return // Already running
 	}
 	stopCh := make(chan struct{})
+	slots := newProbeSlots()
+	loop := &loopState{started: s.Clock.Now().UTC(), name: t.Name}
 	s.stopChans[t.ID] = stopCh
+	s.targets[t.ID] = t
+	s.slots[t.ID] = slots
+	s.loops[t.ID] = loop
 	s.probeWG.Add(1)
 	s.mu.Unlock()
 
 	log.Printf("Scheduler: Adding new target %s", t.Name)
-	go s.runProbeLoop(t, stopCh)
+	go s.runProbeLoop(t, stopCh, slots, loop)
+}
+
+// ActiveTargets returns the number of targets currently being probed.
+func (s *Scheduler) ActiveTargets() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.stopChans)
+}
+
+// UpdateTarget applies an edited target. An edit that leaves everything its
+// probes depend on alone, such as a rename or new retention policies, is
+// applied to the running loop, which keeps its schedule, warm-up and up/down
+// state; any other edit restarts the loop, as RemoveTarget and AddTarget
+// would. A target that isn't running is started.
+func (s *Scheduler) UpdateTarget(t db.Target) {
+	s.mu.Lock()
+	old := s.targets[t.ID]
+	loop, running := s.loops[t.ID]
+	if !running || !sameProbes(old, t) {
+		s.mu.Unlock()
+		s.RemoveTarget(t.ID)
+		s.AddTarget(t)
+		return
+	}
+	s.targets[t.ID] = t
+	s.mu.Unlock()
+
+	// observe takes s.mu while holding the tracker's lock, so the tracker
+	// is only locked once s.mu is released.
+	loop.mu.Lock()
+	loop.name = t.Name
+	status := loop.status
+	loop.mu.Unlock()
+	if status != nil {
+		status.mu.Lock()
+		status.target.Name = t.Name
+		status.mu.Unlock()
+	}
+	log.Printf("Scheduler: Updated target %s without restarting it", t.Name)
+}
+
+// sameProbes reports whether a and b probe the same way, differing at most
+// in the fields the probe loop doesn't use: the name, which it only logs,
+// and the retention policies and aggregation, which are read from the
+// database as rollups run.
+func sameProbes(a, b db.Target) bool {
+	a.Name, a.RetentionPolicies, a.Aggregation, a.Down = "", "", "", false
+	b.Name, b.RetentionPolicies, b.Aggregation, b.Down = "", "", "", false
+	return a == b
 }
 
 func (s *Scheduler) RemoveTarget(id int64) {
@@ -145,70 +271,145 @@ func (s *Scheduler) RemoveTarget(id int64) {
 	if ch, exists := s.stopChans[id]; exists {
 		close(ch)
 		delete(s.stopChans, id)
+		delete(s.slots, id)
+		delete(s.loops, id)
+		delete(s.targets, id)
 		log.Printf("Scheduler: Removed target %d", id)
 	}
 	s.mu.Unlock()
 }
 
-func (s *Scheduler) runProbeLoop(t db.Target, stopCh chan struct{}) {
+func (s *Scheduler) runProbeLoop(t db.Target, stopCh chan struct{}, slots *probeSlots, loop *loopState) {
 	defer s.probeWG.Done()
 
-	cfg, err := probe.GetConfig(t.ProbeType, t.Address)
+	cfg, interval, err := TargetProbeConfig(t)
+	var schedule *Schedule
+	if err == nil {
+		schedule, err = ParseSchedule(t.Schedule)
+	}
 	if err != nil {
 		log.Printf("Failed to get config for target %s: %v", t.Name, err)
+		loop.failed(s.Clock.Now().UTC(), err)
+		loop.mu.Lock()
+		loop.exited = true
+		loop.mu.Unlock()
 		return
 	}
 
-	// Default interval 1s
-	if t.ProbeInterval <= 0 {
-		t.ProbeInterval = 1.0
-	}
-	if t.Timeout <= 0 {
-		t.Timeout = 5.0
-	}
-	cfg.Timeout = time.Duration(t.Timeout*1000) * time.Millisecond
+	// Concurrency limiter: at most MaxConcurrentProbes overlap for this
+	// target; see ProbeConcurrency.
+	var wg sync.WaitGroup
 
-	probeTicker := s.Clock.NewTicker(time.Duration(t.ProbeInterval*1000) * time.Millisecond)
-	// No aggregation loop here anymore.
+	// The first WarmupProbes results of each run are dropped; cold DNS and
+	// ARP caches and connection setup would otherwise skew the percentiles.
+	var warmup atomic.Int64
+	warmup.Store(int64(t.WarmupProbes))
 
-	// Concurrency limiter: ensure no more than 5 probes overlap for this target
-	sem := make(chan struct{}, 5)
-	var wg sync.WaitGroup
+	status := s.newDownTracker(t)
+	failures := &failureLog{interval: s.failureLogInterval}
+	loop.mu.Lock()
+	loop.interval = interval
+	loop.status = status
+	loop.mu.Unlock()
 
 	runProbe := func() {
+		if s.MaintenanceStatus().Paused || !schedule.Active(s.Clock.Now()) {
+			return
+		}
 		select {
-		case sem <- struct{}{}:
+		case slots.sem <- struct{}{}:
+			if !s.limiter.allow(s.Clock.Now()) {
+				<-slots.sem // Over the global probe budget; skip this tick.
+				return
+			}
+			slots.started()
 			wg.Add(1)
 			// Acquired semaphore
 			go func() {
 				defer wg.Done()
-				defer func() { <-sem }() // Release
+				defer func() { <-slots.sem }() // Release
 
 				startTime := s.Clock.Now().UTC()
-				res, err := s.probeRunner.Run(cfg)
+				defer func() {
+					if r := recover(); r != nil {
+						err := panicError(&s.panics, "probe of "+loop.targetName(), r)
+						loop.failed(startTime, err)
+						failures.failed(s.Clock.Now(), loop.targetName(), err)
+					}
+				}()
+				loop.probed(startTime)
+				res, metrics, metadata, err := s.runWithRetries(cfg, t.RetryCount, interval)
+				if err != nil {
+					loop.failed(startTime, err)
+				}
 
 				raw := db.RawResult{
 					Time:     startTime,
 					TargetID: t.ID,
 					Latency:  res,
 				}
+				if len(metadata) > 0 {
+					if data, err := json.Marshal(metadata); err == nil {
+						raw.Metadata = string(data)
+					}
+				}
+				record := func() {
+					if warmup.Add(-1) >= 0 {
+						return // Still warming up; discard.
+					}
+					s.enqueueResult(raw)
+					s.observe(status, raw.Latency < 0)
+				}
 
 				if err != nil {
 					if strings.Contains(err.Error(), "probe timed out") {
 						raw.Latency = -1.0
-						s.rawResultChan <- raw
+						record()
+						return
+					}
+					if errors.Is(err, probe.ErrUnexpectedStatus) {
+						// The service answered, but with an error; count it
+						// as a failed probe rather than a latency sample.
+						failures.failed(s.Clock.Now(), loop.targetName(), err)
+						raw.Latency = -1.0
+						record()
 						return
 					}
-					log.Printf("Probe failed for %s: %v", t.Name, err)
+					failures.failed(s.Clock.Now(), loop.targetName(), err)
 					return
 				}
-				s.rawResultChan <- raw
+				if raw.Latency, err = checkLatency(raw.Latency); err != nil {
+					loop.failed(startTime, err)
+					s.rejectedSamples.Add(1)
+					failures.failed(s.Clock.Now(), loop.targetName(), err)
+					return
+				}
+				failures.recovered(loop.targetName())
+				loop.succeeded()
+				raw.Latency = applyLatencyLimit(t, raw.Latency)
+				if raw.Latency >= 0 {
+					raw.Metrics = metrics
+				}
+				record()
 			}()
 		default:
-			log.Printf("Skipping probe for %s due to overlapping limit", t.Name)
+			slots.skipped.Add(1)
+			log.Printf("Skipping probe for %s due to overlapping limit", loop.targetName())
 		}
 	}
 
+	if t.AlignProbes {
+		// Probe first at the next boundary; the ticker keeps to them.
+		now := s.Clock.Now()
+		select {
+		case <-stopCh:
+			return
+		case <-s.Clock.After(alignedStart(now, interval).Sub(now)):
+			runProbe()
+		}
+	}
+	probeTicker := s.Clock.NewTicker(interval)
+
 	for {
 		select {
 		case <-stopCh:
@@ -219,3 +420,163 @@ func (s *Scheduler) runProbeLoop(t db.Target, stopCh chan struct{}) {
 		}
 	}
 }
+
+// alignedStart returns the first multiple of interval, counted from the zero
+// time, at or after now. For intervals that divide a day those are wall-clock
+// boundaries in UTC, such as every whole second or minute.
+func alignedStart(now time.Time, interval time.Duration) time.Time {
+	start := now.Truncate(interval)
+	if start.Before(now) {
+		start = start.Add(interval)
+	}
+	return start
+}
+
+// retryBackoff is the pause before the first retry of a failed probe; it
+// doubles for each retry after that.
+const retryBackoff = 10 * time.Millisecond
+
+// runWithRetries runs a probe, retrying a failure up to retries times, and
+// reports the last attempt's metrics and metadata. All attempts and the
+// pauses between them share a budget of the timeout or the probe interval,
+// whichever is shorter, with the attempts splitting it evenly. That keeps a
+// retried probe from outlasting its tick, so retries never hold more
+// semaphore slots than the probe alone would. Retries run on the caller's
+// goroutine.
+func (s *Scheduler) runWithRetries(cfg probe.Config, retries int, interval time.Duration) (float64, probe.Metrics, probe.Metadata, error) {
+	if retries <= 0 {
+		return s.runOnce(cfg)
+	}
+	budget := min(cfg.Timeout, interval)
+	backoff := retryBackoff
+	totalBackoff := retryBackoff * time.Duration(1<<retries-1)
+	if totalBackoff > budget/2 {
+		// Too tight to pause; spend it all on the attempts.
+		backoff, totalBackoff = 0, 0
+	}
+	cfg.Timeout = (budget - totalBackoff) / time.Duration(retries+1)
+
+	for attempt := 0; ; attempt++ {
+		res, metrics, metadata, err := s.runOnce(cfg)
+		if err == nil || attempt == retries {
+			return res, metrics, metadata, err
+		}
+		if backoff > 0 {
+			s.Clock.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// runOnce runs a single probe, with its auxiliary metrics if the runner
+// reports them, and its metadata if cfg asks for it too.
+func (s *Scheduler) runOnce(cfg probe.Config) (float64, probe.Metrics, probe.Metadata, error) {
+	if mr, ok := s.probeRunner.(probe.MetadataRunner); ok && cfg.RecordMetadata {
+		return mr.RunWithMetadata(cfg)
+	}
+	if mr, ok := s.probeRunner.(probe.MetricsRunner); ok {
+		res, metrics, err := mr.RunWithMetrics(cfg)
+		return res, metrics, nil, err
+	}
+	res, err := s.probeRunner.Run(cfg)
+	return res, nil, nil, err
+}
+
+// TargetProbeConfig resolves the probe configuration and interval a target
+// is probed with, applying the default and minimum interval and timeout.
+func TargetProbeConfig(t db.Target) (probe.Config, time.Duration, error) {
+	cfg, err := probe.GetTargetConfig(t.ProbeType, t.Address, t.ProbeConfig)
+	if err != nil {
+		return probe.Config{}, 0, err
+	}
+
+	// Default interval 1s
+	if t.ProbeInterval <= 0 {
+		t.ProbeInterval = 1.0
+	}
+	if t.ProbeInterval < MinProbeInterval {
+		t.ProbeInterval = MinProbeInterval
+	}
+	if t.Timeout <= 0 {
+		t.Timeout = 5.0
+	}
+	cfg.Timeout = time.Duration(t.Timeout*1000) * time.Millisecond
+	cfg.RecordMetadata = t.RecordMetadata
+	return cfg, time.Duration(t.ProbeInterval*1000) * time.Millisecond, nil
+}
+
+// SetRollupFlushInterval makes rollups built from raw results also write
+// the window still open, as a partial rollup, every interval, so a new
+// target's data shows up before its first window closes. Partial rollups are
+// replaced once the window is complete. Rollups run every 10 seconds, so
+// shorter intervals act as 10 seconds. Zero, the default, disables them. Call
+// it before Start.
+func (s *Scheduler) SetRollupFlushInterval(interval time.Duration) {
+	s.rollupManager.flushInterval = interval
+}
+
+// SetLatencyPrecision makes rollups built from raw results round each
+// latency to the nearest multiple of precision, such as a microsecond,
+// before adding it to the rollup's digest, each distinct value once with
+// its count as weight. Latencies that differ by less than the probe can
+// really measure then share a centroid, so the digest is smaller. The raw
+// results, and the rollup's count, sum, minimum and maximum, keep full
+// precision. Zero, the default, rounds nothing. Call it before Start.
+//
+// The system ping prints round trips to a microsecond at best, and the http
+// and dns probes' sub-microsecond digits are scheduling noise, so a
+// microsecond loses nothing real for them. A probe whose finer digits are
+// real, such as one timed from kernel timestamps, needs a finer precision or
+// none, as a coarser one folds genuinely different latencies together.
+func (s *Scheduler) SetLatencyPrecision(precision time.Duration) error {
+	if precision < 0 {
+		return fmt.Errorf("latency precision cannot be negative, got %v", precision)
+	}
+	s.rollupManager.latencyPrecision = float64(precision)
+	return nil
+}
+
+// MaxStoredPercentiles bounds how many percentiles SetStoredPercentiles
+// accepts.
+const MaxStoredPercentiles = 21
+
+// SetStoredPercentiles makes every rollup written from now on also store the
+// given percentiles, from 0 to 100, computed from its digest, so the results
+// API can serve them without decoding it. The digest is still stored for
+// any other percentile. Rollups written before keep whatever they stored.
+// Nil, the default, stores none. Call it before Start.
+func (s *Scheduler) SetStoredPercentiles(percentiles []float64) error {
+	if len(percentiles) > MaxStoredPercentiles {
+		return fmt.Errorf("at most %d stored percentiles are allowed", MaxStoredPercentiles)
+	}
+	seen := make(map[float64]bool, len(percentiles))
+	for _, p := range percentiles {
+		if math.IsNaN(p) || p < 0 || p > 100 {
+			return fmt.Errorf("stored percentile %v must be between 0 and 100", p)
+		}
+		if seen[p] {
+			return fmt.Errorf("stored percentile %v is listed twice", p)
+		}
+		seen[p] = true
+	}
+	s.rollupManager.storedPercentiles = slices.Clone(percentiles)
+	return nil
+}
+
+// Runner returns the probe runner the scheduler uses.
+func (s *Scheduler) Runner() probe.Runner {
+	return s.probeRunner
+}
+
+// applyLatencyLimit enforces the target's MaxLatencyNS on a successful probe
+// so a single pathological sample can't distort the digest. Over-limit probes
+// are recorded as timeouts (-1) unless the target asks for clamping.
+func applyLatencyLimit(t db.Target, latency float64) float64 {
+	if t.MaxLatencyNS <= 0 || latency <= t.MaxLatencyNS {
+		return latency
+	}
+	if t.MaxLatencyAction == db.MaxLatencyActionClamp {
+		return t.MaxLatencyNS
+	}
+	return -1.0
+}
@@ -1,8 +1,14 @@
 package scheduler
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"os/exec"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 	"vaportrail/internal/db"
@@ -76,6 +82,12 @@ func TestScheduler_RunProbeLoop_WithMocks(t *testing.T) {
 	}
 
 	s.RemoveTarget(id)
+	s.mu.Lock()
+	_, kept := s.targets[id]
+	s.mu.Unlock()
+	if kept {
+		t.Error("Expected the removed target to be forgotten")
+	}
 }
 
 func TestTargetRemovalRace_WithMocks(t *testing.T) {
@@ -123,6 +135,91 @@ func TestTargetRemovalRace_WithMocks(t *testing.T) {
 	}
 }
 
+func TestScheduler_UpdateTarget(t *testing.T) {
+	mockDB := NewMockStore()
+	s := New(mockDB)
+	s.Clock = clockwork.NewFakeClock()
+	s.probeRunner = &MockRunner{RunFn: func(cfg probe.Config) (float64, error) { return 100, nil }}
+	target := db.Target{Name: "Before", Address: "example.com", ProbeType: "http", ProbeInterval: 1, DownAfter: 3}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+	s.Start()
+	defer s.Stop()
+	time.Sleep(20 * time.Millisecond)
+	loop := func() *loopState {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.loops[id]
+	}
+	first := loop()
+
+	// A rename and new retention policies keep the loop running.
+	renamed := target
+	renamed.Name = "After"
+	renamed.RetentionPolicies = `[{"window": 0, "retention": 3600}]`
+	s.UpdateTarget(renamed)
+	if loop() != first {
+		t.Fatal("Expected a rename not to restart the probe loop")
+	}
+	if states := s.ProbeLoops(); len(states) != 1 || states[0].Name != "After" || first.targetName() != "After" {
+		t.Errorf("Expected the running loop to be renamed, got %+v", states)
+	}
+	first.mu.Lock()
+	status := first.status
+	first.mu.Unlock()
+	status.mu.Lock()
+	if status.target.Name != "After" {
+		t.Errorf("Expected status hooks to get the new name, got %q", status.target.Name)
+	}
+	status.mu.Unlock()
+
+	// Anything the probes depend on restarts it.
+	moved := renamed
+	moved.Address = "example.org"
+	s.UpdateTarget(moved)
+	if loop() == first || s.ActiveTargets() != 1 {
+		t.Error("Expected a new address to restart the probe loop")
+	}
+
+	// A target that wasn't running is started.
+	s.RemoveTarget(id)
+	s.UpdateTarget(moved)
+	if loop() == nil || s.ActiveTargets() != 1 {
+		t.Error("Expected UpdateTarget to start a stopped target")
+	}
+}
+
+func TestSameProbes(t *testing.T) {
+	base := db.Target{ID: 1, Name: "a", Address: "example.com", ProbeType: "http", ProbeInterval: 1, Timeout: 5}
+	for _, edit := range []func(*db.Target){
+		func(t *db.Target) { t.Name = "b" },
+		func(t *db.Target) { t.RetentionPolicies = "[]" },
+		func(t *db.Target) { t.Aggregation = db.AggregationSummary },
+		func(t *db.Target) { t.Down = true },
+	} {
+		changed := base
+		edit(&changed)
+		if !sameProbes(base, changed) {
+			t.Errorf("Expected %+v to probe the same as %+v", changed, base)
+		}
+	}
+	for _, edit := range []func(*db.Target){
+		func(t *db.Target) { t.ProbeType = "dns" },
+		func(t *db.Target) { t.Address = "example.org" },
+		func(t *db.Target) { t.ProbeInterval = 2 },
+		func(t *db.Target) { t.Timeout = 1 },
+		func(t *db.Target) { t.ProbeConfig = `{"user_agent": "x"}` },
+		func(t *db.Target) { t.Schedule = "daily 09:00-17:00" },
+		func(t *db.Target) { t.DownAfter = 3 },
+	} {
+		changed := base
+		edit(&changed)
+		if sameProbes(base, changed) {
+			t.Errorf("Expected %+v to probe differently from %+v", changed, base)
+		}
+	}
+}
+
 func TestScheduler_TimeoutLogic(t *testing.T) {
 	mockDB := NewMockStore()
 	fakeClock := clockwork.NewFakeClock()
@@ -213,3 +310,458 @@ func TestScheduler_StopFlushesBufferedRawResults(t *testing.T) {
 		t.Fatalf("expected latency 123.4, got %v", results[0].Latency)
 	}
 }
+
+func TestApplyLatencyLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  db.Target
+		latency float64
+		want    float64
+	}{
+		{"No limit", db.Target{}, 30e9, 30e9},
+		{"Under limit", db.Target{MaxLatencyNS: 1e9}, 5e8, 5e8},
+		{"Over limit defaults to timeout", db.Target{MaxLatencyNS: 1e9}, 30e9, -1},
+		{"Over limit as timeout", db.Target{MaxLatencyNS: 1e9, MaxLatencyAction: db.MaxLatencyActionTimeout}, 30e9, -1},
+		{"Over limit clamped", db.Target{MaxLatencyNS: 1e9, MaxLatencyAction: db.MaxLatencyActionClamp}, 30e9, 1e9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := applyLatencyLimit(tt.target, tt.latency); got != tt.want {
+				t.Errorf("applyLatencyLimit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingCommands(t *testing.T) {
+	origLookPath := lookPath
+	defer func() { lookPath = origLookPath }()
+	lookPath = func(file string) (string, error) {
+		return "", exec.ErrNotFound
+	}
+
+	targets := []db.Target{
+		{Name: "router", Address: "192.168.1.1", ProbeType: "ping"},
+		{Name: "web", Address: "example.com", ProbeType: "http"},
+		{Name: "resolver", Address: "1.1.1.1", ProbeType: "dns"},
+		{Name: "gateway", Address: "10.0.0.1", ProbeType: "ping"},
+	}
+	missing := MissingCommands(targets)
+	if len(missing) != 1 {
+		t.Fatalf("Expected only ping to be reported, got %v", missing)
+	}
+	if got := strings.Join(missing["ping"], ","); got != "router,gateway" {
+		t.Errorf("Expected ping to affect router,gateway, got %s", got)
+	}
+
+	lookPath = func(file string) (string, error) {
+		return "/bin/" + file, nil
+	}
+	if missing := MissingCommands(targets); len(missing) != 0 {
+		t.Errorf("Expected no missing commands, got %v", missing)
+	}
+}
+
+func TestScheduler_ResultHooks(t *testing.T) {
+	mockDB := NewMockStore()
+	s := New(mockDB)
+
+	var mu sync.Mutex
+	var got []string
+	s.RegisterResultHook(func(target db.Target, r db.RawResult) {
+		mu.Lock()
+		got = append(got, fmt.Sprintf("%s:%v", target.Name, r.Latency))
+		mu.Unlock()
+	})
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	s.mu.Lock()
+	s.targets[7] = db.Target{ID: 7, Name: "hooked"}
+	s.mu.Unlock()
+
+	now := time.Now().UTC()
+	s.rawResultChan <- db.RawResult{Time: now, TargetID: 7, Latency: 250}
+	s.rawResultChan <- db.RawResult{Time: now, TargetID: 7, Latency: -1}
+	s.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(got, ",") != "hooked:250,hooked:-1" {
+		t.Errorf("Expected hooks to see both committed results, got %v", got)
+	}
+}
+
+func TestScheduler_WarmupProbesDiscarded(t *testing.T) {
+	mockDB := NewMockStore()
+	fakeClock := clockwork.NewFakeClock()
+	s := New(mockDB)
+	s.Clock = fakeClock
+	s.Start()
+
+	var calls atomic.Int64
+	s.probeRunner = &MockRunner{
+		RunFn: func(cfg probe.Config) (float64, error) {
+			calls.Add(1)
+			return 500.0, nil
+		},
+	}
+
+	target := db.Target{
+		Name:          "WarmupTarget",
+		Address:       "example.com",
+		ProbeType:     "http",
+		ProbeInterval: 0.1,
+		WarmupProbes:  3,
+	}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+	s.AddTarget(target)
+
+	for i := 0; i < 10; i++ {
+		fakeClock.Advance(100 * time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+	}
+	s.Stop()
+
+	results, _ := mockDB.GetRawResults(id, time.Time{}, time.Now().Add(24*time.Hour), 1000)
+	if calls.Load() <= 3 {
+		t.Fatalf("Expected more than 3 probes to run, got %d", calls.Load())
+	}
+	if int64(len(results)) != calls.Load()-3 {
+		t.Errorf("Expected the first 3 of %d probes to be discarded, got %d results", calls.Load(), len(results))
+	}
+}
+
+func TestScheduler_PathologicalLatencies(t *testing.T) {
+	mockDB := NewMockStore()
+	fakeClock := clockwork.NewFakeClock()
+	s := New(mockDB)
+	s.Clock = fakeClock
+	s.Start()
+
+	latencies := []float64{math.NaN(), -5, math.Inf(1), math.Inf(-1), 1e30, 500}
+	var calls atomic.Int64
+	s.probeRunner = &MockRunner{
+		RunFn: func(cfg probe.Config) (float64, error) {
+			n := calls.Add(1) - 1
+			if n >= int64(len(latencies)) {
+				return 500, nil
+			}
+			return latencies[n], nil
+		},
+	}
+
+	target := db.Target{Name: "Pathological", Address: "example.com", ProbeType: "http", ProbeInterval: 1}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+	s.AddTarget(target)
+
+	for range len(latencies) {
+		fakeClock.Advance(time.Second)
+		time.Sleep(20 * time.Millisecond)
+	}
+	s.Stop()
+
+	if got := s.RejectedSamples(); got != 4 {
+		t.Errorf("Expected 4 rejected samples, got %d", got)
+	}
+	results, _ := mockDB.GetRawResults(id, time.Time{}, time.Now().Add(24*time.Hour), 1000)
+	if int64(len(results)) != calls.Load()-4 {
+		t.Fatalf("Expected %d results, got %d", calls.Load()-4, len(results))
+	}
+	for _, r := range results {
+		if r.Latency < 0 || r.Latency > MaxSampleLatencyNS {
+			t.Errorf("Stored latency %v out of bounds", r.Latency)
+		}
+	}
+	if results[0].Latency != MaxSampleLatencyNS {
+		t.Errorf("Expected a huge latency to be capped at %v, got %v", MaxSampleLatencyNS, results[0].Latency)
+	}
+}
+
+func TestScheduler_AlignProbes(t *testing.T) {
+	mockDB := NewMockStore()
+	fakeClock := clockwork.NewFakeClockAt(time.Date(2024, 1, 1, 0, 0, 0, 350*int(time.Millisecond), time.UTC))
+	s := New(mockDB)
+	s.Clock = fakeClock
+	s.Start()
+	s.probeRunner = &MockRunner{
+		RunFn: func(cfg probe.Config) (float64, error) {
+			return 500.0, nil
+		},
+	}
+
+	target := db.Target{
+		Name:          "AlignedTarget",
+		Address:       "example.com",
+		ProbeType:     "http",
+		ProbeInterval: 1,
+		AlignProbes:   true,
+	}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+	s.AddTarget(target)
+	time.Sleep(50 * time.Millisecond) // Let the probe loop start waiting.
+
+	// Steps that land on every boundary, so probes read the clock there.
+	for i := 0; i < 80; i++ {
+		fakeClock.Advance(50 * time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Stop()
+
+	results, _ := mockDB.GetRawResults(id, time.Time{}, time.Now().Add(24*time.Hour), 1000)
+	if len(results) == 0 {
+		t.Fatal("Expected aligned probes to run")
+	}
+	for _, r := range results {
+		if !r.Time.Equal(r.Time.Truncate(time.Second)) {
+			t.Errorf("Expected probes on whole seconds, got one at %v", r.Time)
+		}
+	}
+	if first := results[0].Time; !first.Equal(time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC)) {
+		t.Errorf("Expected the first probe at the next boundary, got %v", first)
+	}
+}
+
+func TestAlignedStart(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		now      time.Time
+		interval time.Duration
+		want     time.Time
+	}{
+		{base, time.Second, base},
+		{base.Add(300 * time.Millisecond), time.Second, base.Add(time.Second)},
+		{base.Add(59 * time.Second), time.Minute, base.Add(time.Minute)},
+		{base.Add(10 * time.Millisecond), 100 * time.Millisecond, base.Add(100 * time.Millisecond)},
+	}
+	for _, tt := range tests {
+		if got := alignedStart(tt.now, tt.interval); !got.Equal(tt.want) {
+			t.Errorf("alignedStart(%v, %v) = %v, want %v", tt.now, tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestScheduler_DownAfterConsecutiveTimeouts(t *testing.T) {
+	mockDB := NewMockStore()
+	fakeClock := clockwork.NewFakeClock()
+	s := New(mockDB)
+	s.Clock = fakeClock
+	s.Start()
+
+	var calls atomic.Int64
+	s.probeRunner = &MockRunner{
+		RunFn: func(cfg probe.Config) (float64, error) {
+			if calls.Add(1) <= 4 {
+				return 0, errors.New("probe timed out")
+			}
+			return 500.0, nil
+		},
+	}
+
+	var mu sync.Mutex
+	var transitions []bool
+	s.RegisterStatusHook(func(target db.Target, down bool) {
+		mu.Lock()
+		transitions = append(transitions, down)
+		mu.Unlock()
+	})
+
+	target := db.Target{
+		Name:          "FlakyTarget",
+		Address:       "example.com",
+		ProbeType:     "http",
+		ProbeInterval: 0.1,
+		DownAfter:     3,
+		Down:          true, // Left over from a previous run.
+	}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+
+	var downAfterThree bool
+	s.AddTarget(target)
+	for i := 0; i < 8; i++ {
+		fakeClock.Advance(100 * time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		if calls.Load() == 3 {
+			downAfterThree = mockDB.Targets[id].Down
+		}
+	}
+	s.Stop()
+
+	if !downAfterThree {
+		t.Error("Expected the target to be marked down after 3 consecutive timeouts")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(transitions) != 2 || !transitions[0] || transitions[1] {
+		t.Errorf("Expected a down then an up transition, got %v", transitions)
+	}
+	if mockDB.Targets[id].Down {
+		t.Error("Expected the target to be up after a successful probe")
+	}
+}
+
+func TestScheduler_StatusHysteresis(t *testing.T) {
+	mockDB := NewMockStore()
+	fakeClock := clockwork.NewFakeClock()
+	s := New(mockDB)
+	s.Clock = fakeClock
+	s.Start()
+
+	// Probe outcomes in order: flapping, then down, flapping, and up.
+	outcomes := []bool{
+		false, true, false, true, true, false, false, true, // up throughout
+		false, false, false, // down
+		true, false, true, false, // still down
+		true, true, // up
+	}
+	var calls atomic.Int64
+	s.probeRunner = &MockRunner{
+		RunFn: func(cfg probe.Config) (float64, error) {
+			n := int(calls.Add(1)) - 1
+			if n < len(outcomes) && !outcomes[n] {
+				return 0, errors.New("probe timed out")
+			}
+			return 500.0, nil
+		},
+	}
+
+	var mu sync.Mutex
+	var transitions []int // call count at each transition, negative for down
+	s.RegisterStatusHook(func(target db.Target, down bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			transitions = append(transitions, -int(calls.Load()))
+		} else {
+			transitions = append(transitions, int(calls.Load()))
+		}
+	})
+
+	target := db.Target{
+		Name:          "FlappingTarget",
+		Address:       "example.com",
+		ProbeType:     "http",
+		ProbeInterval: 0.1,
+		DownAfter:     3,
+		UpAfter:       2,
+	}
+	id, _ := mockDB.AddTarget(&target)
+	target.ID = id
+	s.AddTarget(target)
+	for calls.Load() < int64(len(outcomes)) {
+		fakeClock.Advance(100 * time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+	}
+	s.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []int{-11, 17}; !slices.Equal(transitions, want) {
+		t.Errorf("Expected down at probe 11 and up at probe 17, got transitions %v", transitions)
+	}
+}
+
+func TestScheduler_RunWithRetries(t *testing.T) {
+	s := New(NewMockStore())
+	cfg := probe.Config{Type: "http", Timeout: 5 * time.Second}
+
+	var timeouts []time.Duration
+	failures := 0
+	s.probeRunner = &MockRunner{
+		RunFn: func(c probe.Config) (float64, error) {
+			timeouts = append(timeouts, c.Timeout)
+			if len(timeouts) <= failures {
+				return 0, errors.New("probe timed out")
+			}
+			return 500.0, nil
+		},
+	}
+
+	// Without retries the probe runs once with the target's own timeout.
+	failures = 1
+	if _, _, _, err := s.runWithRetries(cfg, 0, time.Second); err == nil || len(timeouts) != 1 || timeouts[0] != cfg.Timeout {
+		t.Errorf("Expected a single failed attempt with the full timeout, got %v (%v)", timeouts, err)
+	}
+
+	// Retries recover from transient failures, and every attempt fits in
+	// the interval, which is shorter than the timeout here.
+	timeouts, failures = nil, 2
+	res, _, _, err := s.runWithRetries(cfg, 2, time.Second)
+	if err != nil || res != 500.0 {
+		t.Fatalf("Expected the third attempt to succeed, got %v, %v", res, err)
+	}
+	var total time.Duration
+	for _, d := range timeouts {
+		total += d
+	}
+	if len(timeouts) != 3 || total+30*time.Millisecond > time.Second {
+		t.Errorf("Expected 3 attempts within the 1s interval, got %v", timeouts)
+	}
+
+	// Persistent failures give up after the configured retries.
+	timeouts, failures = nil, 10
+	if _, _, _, err := s.runWithRetries(cfg, 2, time.Second); err == nil || len(timeouts) != 3 {
+		t.Errorf("Expected 3 failed attempts, got %d (%v)", len(timeouts), err)
+	}
+}
+
+// metricsRunner is a MockRunner that also reports auxiliary metrics.
+type metricsRunner struct {
+	MockRunner
+	metrics probe.Metrics
+}
+
+func (m *metricsRunner) RunWithMetrics(cfg probe.Config) (float64, probe.Metrics, error) {
+	res, err := m.Run(cfg)
+	return res, m.metrics, err
+}
+
+func TestScheduler_RunOnceMetrics(t *testing.T) {
+	s := New(NewMockStore())
+	cfg := probe.Config{Type: "http", Timeout: time.Second}
+
+	s.probeRunner = &MockRunner{}
+	if res, metrics, _, err := s.runOnce(cfg); err != nil || res != 100.0 || metrics != nil {
+		t.Errorf("Expected plain runner to report no metrics, got %v, %v, %v", res, metrics, err)
+	}
+
+	s.probeRunner = &metricsRunner{metrics: probe.Metrics{probe.MetricHTTPBodyBytes: 42}}
+	res, metrics, _, err := s.runWithRetries(cfg, 1, time.Second)
+	if err != nil || res != 100.0 || metrics[probe.MetricHTTPBodyBytes] != 42 {
+		t.Errorf("Expected metrics from the runner, got %v, %v, %v", res, metrics, err)
+	}
+}
+
+// metadataRunner is a MockRunner that also reports probe metadata.
+type metadataRunner struct {
+	MockRunner
+	metadata probe.Metadata
+}
+
+func (m *metadataRunner) RunWithMetadata(cfg probe.Config) (float64, probe.Metrics, probe.Metadata, error) {
+	res, err := m.Run(cfg)
+	return res, nil, m.metadata, err
+}
+
+func TestScheduler_RunOnceMetadata(t *testing.T) {
+	s := New(NewMockStore())
+	s.probeRunner = &metadataRunner{metadata: probe.Metadata{probe.MetadataHTTPStatus: "200"}}
+
+	cfg, _, err := TargetProbeConfig(db.Target{ProbeType: "http", Address: "http://example.com"})
+	if err != nil {
+		t.Fatalf("TargetProbeConfig failed: %v", err)
+	}
+	if _, _, metadata, _ := s.runOnce(cfg); metadata != nil {
+		t.Errorf("Expected no metadata without RecordMetadata, got %v", metadata)
+	}
+
+	cfg, _, _ = TargetProbeConfig(db.Target{ProbeType: "http", Address: "http://example.com", RecordMetadata: true})
+	if _, _, metadata, err := s.runOnce(cfg); err != nil || metadata[probe.MetadataHTTPStatus] != "200" {
+		t.Errorf("Expected metadata from the runner, got %v, %v", metadata, err)
+	}
+}
@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"log"
+	"sync"
+	"vaportrail/internal/db"
+)
+
+// StatusHook is called when a target goes down or comes back up. It runs on
+// the probe goroutine that caused the transition, so it must return quickly.
+type StatusHook func(target db.Target, down bool)
+
+// RegisterStatusHook adds a hook to be called on every up/down transition.
+func (s *Scheduler) RegisterStatusHook(hook StatusHook) {
+	s.mu.Lock()
+	s.statusHooks = append(s.statusHooks, hook)
+	s.mu.Unlock()
+}
+
+// downTracker counts a target's consecutive timeouts and flips it down once
+// they reach DownAfter, and back up once UpAfter consecutive probes succeed.
+// Separate thresholds give the status hysteresis: a target that fails every
+// other probe neither goes down nor, once down, comes back up.
+type downTracker struct {
+	mu          sync.Mutex
+	target      db.Target
+	consecutive int // timeouts while up, successes while down
+	down        bool
+}
+
+// newDownTracker starts tracking t as up. The run of timeouts that led to a
+// persisted down state is lost on restart, so rather than guess, the target
+// is marked up until it earns its way back down.
+func (s *Scheduler) newDownTracker(t db.Target) *downTracker {
+	if t.Down {
+		if err := s.db.SetTargetDown(t.ID, false); err != nil {
+			log.Printf("Failed to reset status of %s: %v", t.Name, err)
+		}
+		t.Down = false
+	}
+	return &downTracker{target: t}
+}
+
+// observe records the outcome of a probe that was stored.
+func (s *Scheduler) observe(dt *downTracker, timedOut bool) {
+	if dt.target.DownAfter <= 0 {
+		return
+	}
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	// Count the probes that push toward the other state; one that agrees
+	// with the current state resets the count.
+	if timedOut != dt.down {
+		dt.consecutive++
+	} else {
+		dt.consecutive = 0
+	}
+	if !dt.down && dt.consecutive < dt.target.DownAfter {
+		return
+	}
+	if dt.down && dt.consecutive < max(dt.target.UpAfter, 1) {
+		return
+	}
+	dt.down = !dt.down
+	run := dt.consecutive
+	dt.consecutive = 0
+
+	t := dt.target
+	t.Down = dt.down
+	if t.Down {
+		log.Printf("Target %s is down after %d consecutive timeouts", t.Name, run)
+	} else {
+		log.Printf("Target %s is back up after %d consecutive successful probes", t.Name, run)
+	}
+	if err := s.db.SetTargetDown(t.ID, t.Down); err != nil {
+		log.Printf("Failed to record status of %s: %v", t.Name, err)
+	}
+
+	s.mu.Lock()
+	hooks := append([]StatusHook(nil), s.statusHooks...)
+	s.mu.Unlock()
+	for _, hook := range hooks {
+		hook(t, t.Down)
+	}
+}
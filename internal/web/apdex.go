@@ -0,0 +1,40 @@
+package web
+
+import (
+	"time"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+// digestApdex returns the Apdex score (see APIResult.Apdex) of a window from
+// its digest and timeout count, or nil if it had no probes at all. The
+// satisfied and tolerating counts are read off the digest's CDF, so they are
+// as approximate as its percentiles.
+func digestApdex(td *tdigest.TDigest, timeouts int64, threshold time.Duration) *float64 {
+	count := float64(td.Count())
+	total := count + float64(timeouts)
+	if total == 0 {
+		return nil
+	}
+	var satisfied, tolerating float64
+	if count > 0 {
+		t := float64(threshold.Nanoseconds())
+		satisfied = td.CDF(t) * count
+		tolerating = td.CDF(4*t)*count - satisfied
+	}
+	return ptr((satisfied + tolerating/2) / total)
+}
+
+// rawApdex returns the Apdex score of a single raw result, where a negative
+// latency is a timeout: 1 if satisfied, 0.5 if tolerating and 0 otherwise.
+func rawApdex(latency float64, threshold time.Duration) *float64 {
+	t := float64(threshold.Nanoseconds())
+	switch {
+	case latency < 0 || latency > 4*t:
+		return ptr(0.0)
+	case latency > t:
+		return ptr(0.5)
+	default:
+		return ptr(1.0)
+	}
+}
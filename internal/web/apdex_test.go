@@ -0,0 +1,112 @@
+package web
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func TestDigestApdex(t *testing.T) {
+	td, _ := tdigest.New(tdigest.Compression(100))
+	for ms := 1; ms <= 1000; ms++ {
+		td.Add(float64(time.Duration(ms) * time.Millisecond))
+	}
+
+	// 10% satisfied and 30% tolerating: (100 + 300/2) / 1000.
+	if got := digestApdex(td, 0, 100*time.Millisecond); got == nil || math.Abs(*got-0.25) > 0.01 {
+		t.Errorf("Expected an Apdex of about 0.25, got %v", got)
+	}
+	// Timeouts are frustrated: (100 + 300/2) / 1250.
+	if got := digestApdex(td, 250, 100*time.Millisecond); got == nil || math.Abs(*got-0.2) > 0.01 {
+		t.Errorf("Expected an Apdex of about 0.2 with timeouts, got %v", got)
+	}
+	if got := digestApdex(td, 0, time.Hour); got == nil || *got != 1 {
+		t.Errorf("Expected an Apdex of 1 when every probe is satisfied, got %v", got)
+	}
+
+	empty, _ := tdigest.New(tdigest.Compression(100))
+	if got := digestApdex(empty, 0, time.Second); got != nil {
+		t.Errorf("Expected no Apdex for a window without probes, got %v", *got)
+	}
+	if got := digestApdex(empty, 3, time.Second); got == nil || *got != 0 {
+		t.Errorf("Expected an Apdex of 0 for a window of timeouts, got %v", got)
+	}
+}
+
+func TestRawApdex(t *testing.T) {
+	threshold := 100 * time.Millisecond
+	tests := []struct {
+		latency time.Duration
+		want    float64
+	}{
+		{50 * time.Millisecond, 1},
+		{100 * time.Millisecond, 1},
+		{300 * time.Millisecond, 0.5},
+		{400 * time.Millisecond, 0.5},
+		{401 * time.Millisecond, 0},
+		{-1, 0}, // timeout
+	}
+	for _, tt := range tests {
+		if got := rawApdex(float64(tt.latency), threshold); *got != tt.want {
+			t.Errorf("rawApdex(%v) = %v, want %v", tt.latency, *got, tt.want)
+		}
+	}
+}
+
+func TestHandleGetResults_Apdex(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Apdex",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Minute)
+	td, _ := tdigest.New(tdigest.Compression(100))
+	td.Add(float64(50 * time.Millisecond))
+	data, _ := db.SerializeTDigest(td)
+	if err := database.AddAggregatedResult(&db.AggregatedResult{Time: now.Add(-10 * time.Minute), TargetID: id, WindowSeconds: 60, TDigestData: data, TimeoutCount: 1}); err != nil {
+		t.Fatalf("Failed to add result: %v", err)
+	}
+
+	get := func(query string) (int, []APIResult) {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/results/"+strconv.FormatInt(id, 10)+query, nil))
+		var results []APIResult
+		json.NewDecoder(rr.Body).Decode(&results)
+		return rr.Code, results
+	}
+
+	if _, results := get(""); len(results) != 1 || results[0].Apdex != nil {
+		t.Errorf("Expected no Apdex without a threshold, got %+v", results)
+	}
+	// Twice, so the second request is served from the digest cache.
+	for range 2 {
+		code, results := get("?apdex_threshold=100ms")
+		if code != http.StatusOK || len(results) != 1 {
+			t.Fatalf("Expected 1 result, got status %d and %d results", code, len(results))
+		}
+		// One satisfied probe and one timeout.
+		if a := results[0].Apdex; a == nil || *a != 0.5 {
+			t.Errorf("Expected an Apdex of 0.5, got %v", a)
+		}
+	}
+	for _, threshold := range []string{"100", "-1s", "0s"} {
+		if code, _ := get("?apdex_threshold=" + threshold); code != http.StatusBadRequest {
+			t.Errorf("apdex_threshold=%s: expected status 400, got %d", threshold, code)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package web
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireWriteToken rejects requests without the configured write token.
+// When no token is configured the request is let through, like the rest of
+// the API. See config.Config.WriteToken for the routes it guards.
+func (s *Server) requireWriteToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.WriteToken != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.WriteToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="vaportrail"`)
+				writeJSONError(w, http.StatusUnauthorized, "Unauthorized", CodeUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,71 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"vaportrail/internal/db"
+)
+
+func TestRequireWriteToken(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	s.cfg.WriteToken = "secret"
+
+	id, err := database.AddTarget(&db.Target{Name: "Demo", Address: "example.com", ProbeType: "http"})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	do := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	guarded := []struct{ method, path string }{
+		{"PUT", fmt.Sprintf("/api/targets/%d", id)},
+		{"DELETE", fmt.Sprintf("/api/targets/%d", id)},
+		{"POST", "/api/targets/import"},
+		{"POST", "/api/targets/bulk"},
+		{"POST", fmt.Sprintf("/api/targets/%d/dump", id)},
+		{"DELETE", fmt.Sprintf("/api/results/%d", id)},
+		{"POST", "/api/maintenance/rollup"},
+		{"POST", "/api/maintenance/recompute-stats"},
+		{"POST", "/api/maintenance/pause"},
+		{"POST", "/api/maintenance/resume"},
+		{"PUT", "/api/settings/retention"},
+		{"POST", "/api/probe-test"},
+		{"GET", "/api/debug/scheduler"},
+	}
+	for _, tc := range guarded {
+		for _, token := range []string{"", "wrong"} {
+			if rr := do(tc.method, tc.path, "{}", token); rr.Code != http.StatusUnauthorized {
+				t.Errorf("%s %s with token %q: expected status 401, got %d", tc.method, tc.path, token, rr.Code)
+			}
+		}
+	}
+	if _, err := database.GetTarget(id); err != nil {
+		t.Errorf("Expected the target to survive unauthorized requests: %v", err)
+	}
+
+	// Creating a target and reading stay open.
+	if rr := do("POST", "/api/targets", `{"Name": "New", "Address": "example.org", "ProbeType": "http"}`, ""); rr.Code == http.StatusUnauthorized {
+		t.Errorf("Expected creating a target not to need the token")
+	}
+	if rr := do("GET", "/api/targets", "", ""); rr.Code != http.StatusOK {
+		t.Errorf("GET /api/targets: expected status 200, got %d", rr.Code)
+	}
+
+	// With the token, the request reaches the handler.
+	if rr := do("DELETE", fmt.Sprintf("/api/targets/%d", id), "", "secret"); rr.Code == http.StatusUnauthorized {
+		t.Errorf("Expected the token to be accepted, got %d", rr.Code)
+	}
+}
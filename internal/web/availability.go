@@ -0,0 +1,124 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"vaportrail/internal/scheduler"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// AvailabilityReport is a target's availability over a time range, from
+// GET /api/results/{id}/availability. Start and End are the requested range
+// widened to whole windows of WindowSeconds.
+//
+// Windows counts the windows in the range and EmptyWindows those without a
+// single probe, whether they have no rollup or an empty one;
+// MaintenanceWindows are the empty windows spent paused for maintenance.
+// Empty windows don't count against availability, so a range with nothing
+// but empty windows has no Availability at all.
+type AvailabilityReport struct {
+	TargetID           int64     `json:"target_id"`
+	Start              time.Time `json:"start"`
+	End                time.Time `json:"end"`
+	WindowSeconds      int       `json:"window_seconds"`
+	Windows            int       `json:"windows"`
+	EmptyWindows       int       `json:"empty_windows"`
+	MaintenanceWindows int       `json:"maintenance_windows"`
+	ProbeCount         int64     `json:"probe_count"` // including timeouts
+	TimeoutCount       int64     `json:"timeout_count"`
+	// Availability is 1 - TimeoutCount/ProbeCount.
+	Availability *float64 `json:"availability,omitempty"`
+	// SLO is the availability objective. ErrorBudgetConsumed is the fraction
+	// of the timeouts it allows over ProbeCount probes that were used; above
+	// 1 the SLO was missed. Both are omitted without an SLO.
+	SLO                 float64  `json:"slo,omitempty"`
+	ErrorBudgetConsumed *float64 `json:"error_budget_consumed,omitempty"`
+}
+
+// handleGetAvailability reports a target's availability for SLA reporting.
+// Query parameters:
+//
+//	start, end RFC3339 range, both required
+//	slo        availability objective in (0, 1), defaulting to AvailabilitySLO
+//
+// The window is chosen as for the results API, so long ranges are read from
+// coarse rollups.
+func (s *Server) handleGetAvailability(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
+		return
+	}
+	q := r.URL.Query()
+	start, end, err := parseTimeRange(TimeRange{Start: q.Get("start"), End: q.Get("end")})
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidTimeRange)
+		return
+	}
+	slo := s.cfg.AvailabilitySLO
+	if str := q.Get("slo"); str != "" {
+		if slo, err = strconv.ParseFloat(str, 64); err != nil || slo <= 0 || slo >= 1 {
+			writeJSONError(w, http.StatusBadRequest, "slo must be a fraction between 0 and 1, such as 0.999", CodeInvalidRequest)
+			return
+		}
+	}
+
+	target, err := s.reader.GetTarget(id)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Target not found: "+err.Error(), CodeTargetNotFound)
+		return
+	}
+	policies, err := scheduler.GetRetentionPolicies(*target)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Target has no retention policies configured", CodeNoRetentionPolicies)
+		return
+	}
+	window := selectWindow(policies, start, end)
+	size := time.Duration(window) * time.Second
+
+	report := AvailabilityReport{TargetID: id, WindowSeconds: window, Start: start.Truncate(size), End: end.Truncate(size)}
+	if report.End.Before(end) {
+		report.End = report.End.Add(size)
+	}
+	report.Windows = int(report.End.Sub(report.Start) / size)
+
+	results, err := s.reader.GetAggregatedResults(id, window, report.Start, report.End)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	var probed int
+	for _, res := range results {
+		samples := res.SampleCount
+		if samples == 0 && len(res.TDigestData) > 0 {
+			samples = s.digestStats(res).ProbeCount // Written before sample counts were stored.
+		}
+		if samples+res.TimeoutCount == 0 {
+			if res.Maintenance {
+				report.MaintenanceWindows++
+			}
+			continue
+		}
+		probed++
+		report.ProbeCount += samples + res.TimeoutCount
+		report.TimeoutCount += res.TimeoutCount
+	}
+	report.EmptyWindows = report.Windows - probed
+
+	if slo > 0 && slo < 1 {
+		report.SLO = slo
+	}
+	if report.ProbeCount > 0 {
+		failed := float64(report.TimeoutCount) / float64(report.ProbeCount)
+		report.Availability = ptr(1 - failed)
+		if report.SLO > 0 {
+			report.ErrorBudgetConsumed = ptr(failed / (1 - report.SLO))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,127 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func TestHandleGetAvailability(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	s.cfg.AvailabilitySLO = 0.99
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Test Target",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	add := func(minute int, samples, timeouts int64, maintenance bool) {
+		res := &db.AggregatedResult{
+			Time:          start.Add(time.Duration(minute) * time.Minute),
+			TargetID:      id,
+			WindowSeconds: 60,
+			TimeoutCount:  timeouts,
+			SampleCount:   samples,
+			Maintenance:   maintenance,
+		}
+		if samples > 0 {
+			td, _ := tdigest.New(tdigest.Compression(100))
+			for range samples {
+				td.Add(100)
+			}
+			res.TDigestData, _ = db.SerializeTDigest(td)
+		}
+		if err := database.AddAggregatedResult(res); err != nil {
+			t.Fatalf("Failed to add result: %v", err)
+		}
+	}
+	add(0, 60, 0, false)
+	add(1, 55, 5, false)
+	add(2, 0, 0, true)  // Paused for maintenance.
+	add(3, 0, 0, false) // Empty rollup.
+	add(4, 40, 0, false)
+	// Minute 5 has no rollup at all.
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/api/results/%d/availability?%s", id, query), nil))
+		return rr
+	}
+	rangeQuery := func(from, to time.Time) string {
+		return "start=" + from.Format(time.RFC3339) + "&end=" + to.Format(time.RFC3339)
+	}
+
+	// The end is mid-window; it's widened to cover minute 5.
+	rr := get(rangeQuery(start, start.Add(5*time.Minute+30*time.Second)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var report AvailabilityReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if report.WindowSeconds != 60 || report.Windows != 6 || report.EmptyWindows != 3 || report.MaintenanceWindows != 1 {
+		t.Errorf("Unexpected window accounting: %+v", report)
+	}
+	if !report.End.Equal(start.Add(6 * time.Minute)) {
+		t.Errorf("Expected the range widened to %v, got %v", start.Add(6*time.Minute), report.End)
+	}
+	if report.ProbeCount != 160 || report.TimeoutCount != 5 {
+		t.Errorf("Expected 160 probes and 5 timeouts, got %d and %d", report.ProbeCount, report.TimeoutCount)
+	}
+	if report.Availability == nil || math.Abs(*report.Availability-(1-5.0/160)) > 1e-9 {
+		t.Errorf("Expected availability %v, got %v", 1-5.0/160, report.Availability)
+	}
+	// 1.6 probes may time out under a 99% SLO; 5 did.
+	if report.SLO != 0.99 || report.ErrorBudgetConsumed == nil || math.Abs(*report.ErrorBudgetConsumed-5/1.6) > 1e-9 {
+		t.Errorf("Expected budget consumed %v against 0.99, got %v against %v", 5/1.6, report.ErrorBudgetConsumed, report.SLO)
+	}
+
+	// The slo parameter overrides the configured one.
+	rr = get(rangeQuery(start, start.Add(6*time.Minute)) + "&slo=0.9")
+	report = AvailabilityReport{}
+	json.NewDecoder(rr.Body).Decode(&report)
+	if report.SLO != 0.9 || report.ErrorBudgetConsumed == nil || math.Abs(*report.ErrorBudgetConsumed-5/16.0) > 1e-9 {
+		t.Errorf("Expected budget consumed %v against 0.9, got %v against %v", 5/16.0, report.ErrorBudgetConsumed, report.SLO)
+	}
+
+	// A range of nothing but empty windows has no availability.
+	rr = get(rangeQuery(start.Add(2*time.Minute), start.Add(4*time.Minute)))
+	report = AvailabilityReport{}
+	json.NewDecoder(rr.Body).Decode(&report)
+	if report.Availability != nil || report.ErrorBudgetConsumed != nil || report.EmptyWindows != 2 || report.ProbeCount != 0 {
+		t.Errorf("Expected an empty report, got %+v", report)
+	}
+
+	for _, query := range []string{
+		"",
+		"start=yesterday&end=today",
+		rangeQuery(start.Add(time.Hour), start),
+		rangeQuery(start, start.Add(time.Hour)) + "&slo=1",
+		rangeQuery(start, start.Add(time.Hour)) + "&slo=abc",
+	} {
+		if rr := get(query); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %q, got %d", query, rr.Code)
+		}
+	}
+	rr = httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/results/999/availability?"+rangeQuery(start, start.Add(time.Hour)), nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing target, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,263 @@
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"vaportrail/internal/db"
+	"vaportrail/internal/scheduler"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// baselineLearnPeriod is the trailing span a baseline is learned from,
+	// as the median of its rollups' P50s.
+	baselineLearnPeriod = 24 * time.Hour
+	// baselineRefreshInterval is how often runBaselineRefresher learns
+	// baselines again.
+	baselineRefreshInterval = time.Hour
+	// baselineCurrentPeriod is the trailing span BaselineReport compares
+	// against the baseline.
+	baselineCurrentPeriod = time.Hour
+)
+
+// BaselineReport is a target's expected latency and how its last hour
+// compares to it, from GET /api/targets/{id}/baseline.
+type BaselineReport struct {
+	TargetID int64 `json:"target_id"`
+	// Source is "manual" for a baseline set with PUT, "learned" for one
+	// learned from the median P50 of the last day, or "none" until one has
+	// been learned.
+	Source     string     `json:"source"`
+	BaselineNS *float64   `json:"baseline_ns,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+	// CurrentP50NS is the median P50 of the last hour's rollups. Ratio is it
+	// over the baseline, so 2 is twice as slow as normal, and DeltaNS the
+	// difference.
+	CurrentP50NS *float64 `json:"current_p50_ns,omitempty"`
+	Ratio        *float64 `json:"ratio,omitempty"`
+	DeltaNS      *float64 `json:"delta_ns,omitempty"`
+}
+
+// BaselineRequest is the body of PUT /api/targets/{id}/baseline. A null
+// BaselineNS removes a manual baseline, so one is learned again.
+type BaselineRequest struct {
+	BaselineNS *float64 `json:"baseline_ns"`
+}
+
+// baseline returns a target's stored baseline, or nil if it has none yet.
+// It never learns one, so reads stay reads; runBaselineRefresher does that in
+// the background.
+func (s *Server) baseline(t db.Target) *db.Baseline {
+	b, err := s.reader.GetBaseline(t.ID)
+	if err != nil {
+		log.Printf("Failed to get baseline of target %d: %v", t.ID, err)
+		return nil
+	}
+	return b
+}
+
+// startBaselineRefresher starts learning baselines in the background, unless
+// the server is read-only. Shutdown stops it.
+func (s *Server) startBaselineRefresher() {
+	if s.cfg.ReadOnly {
+		return
+	}
+	s.baselineWG.Add(1)
+	go s.runBaselineRefresher()
+}
+
+// runBaselineRefresher learns every target's baseline at start and then
+// every baselineRefreshInterval.
+func (s *Server) runBaselineRefresher() {
+	defer s.baselineWG.Done()
+	ticker := time.NewTicker(baselineRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		s.refreshBaselines()
+		select {
+		case <-s.baselineStop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshBaselines learns the baseline of every target without a manual
+// one.
+func (s *Server) refreshBaselines() {
+	targets, err := s.db.GetTargets()
+	if err != nil {
+		log.Printf("Failed to get targets to learn baselines: %v", err)
+		return
+	}
+	now := time.Now().UTC()
+	for _, t := range targets {
+		s.learnBaseline(t, now)
+	}
+}
+
+// learnBaseline stores the median P50 of a target's last
+// baselineLearnPeriod as its baseline. Targets with summary aggregation have
+// no P50s, so their baselines are of the rollups' averages instead; see
+// typicalLatency. A manual baseline is left alone, as is the last learned one
+// while the target has no recent data.
+func (s *Server) learnBaseline(t db.Target, now time.Time) {
+	b, err := s.db.GetBaseline(t.ID)
+	if err != nil {
+		log.Printf("Failed to get baseline of target %d: %v", t.ID, err)
+		return
+	}
+	if b != nil && b.Manual {
+		return
+	}
+	p50, ok := s.medianP50(t, now.Add(-baselineLearnPeriod), now)
+	if !ok {
+		return
+	}
+	if err := s.db.SetBaseline(&db.Baseline{TargetID: t.ID, LatencyNS: p50, UpdatedAt: now}); err != nil {
+		log.Printf("Failed to store baseline of target %d: %v", t.ID, err)
+	}
+}
+
+// medianP50 returns the median P50 of a target's complete rollups in a time
+// range, read from the window the results API would serve it from. For
+// rollups without percentiles it is the median of their averages.
+func (s *Server) medianP50(t db.Target, start, end time.Time) (float64, bool) {
+	policies, err := scheduler.GetRetentionPolicies(t)
+	if err != nil {
+		return 0, false
+	}
+	results, err := s.reader.GetAggregatedResults(t.ID, selectWindow(policies, start, end), start, end)
+	if err != nil {
+		log.Printf("Failed to get results of target %d: %v", t.ID, err)
+		return 0, false
+	}
+	var p50s []float64
+	for _, res := range results {
+		if res.Partial {
+			continue
+		}
+		stats := s.digestStats(res)
+		if l := typicalLatency(&stats); l != nil {
+			p50s = append(p50s, *l)
+		}
+	}
+	if len(p50s) == 0 {
+		return 0, false
+	}
+	slices.Sort(p50s)
+	mid := len(p50s) / 2
+	if len(p50s)%2 == 0 {
+		return (p50s[mid-1] + p50s[mid]) / 2, true
+	}
+	return p50s[mid], true
+}
+
+// typicalLatency is the latency a result is compared to its baseline by: its
+// P50, or its average when it has no percentiles.
+func typicalLatency(r *APIResult) *float64 {
+	if r.P50 != nil {
+		return r.P50
+	}
+	if r.PercentilesUnavailable && r.AvgNS != nil {
+		return ptr(float64(*r.AvgNS))
+	}
+	return nil
+}
+
+// applyBaseline sets BaselineRatio and BaselineDelta on every result with a
+// typicalLatency.
+func applyBaseline(results []APIResult, b *db.Baseline) {
+	if b == nil || b.LatencyNS <= 0 {
+		return
+	}
+	for i := range results {
+		if l := typicalLatency(&results[i]); l != nil {
+			results[i].BaselineRatio = ptr(*l / b.LatencyNS)
+			results[i].BaselineDelta = ptr(*l - b.LatencyNS)
+		}
+	}
+}
+
+func (s *Server) baselineReport(t db.Target) BaselineReport {
+	report := BaselineReport{TargetID: t.ID, Source: "none"}
+	b := s.baseline(t)
+	if b == nil {
+		return report
+	}
+	report.Source = "learned"
+	if b.Manual {
+		report.Source = "manual"
+	}
+	report.BaselineNS = ptr(b.LatencyNS)
+	report.UpdatedAt = ptr(b.UpdatedAt)
+	now := time.Now().UTC()
+	if p50, ok := s.medianP50(t, now.Add(-baselineCurrentPeriod), now); ok {
+		report.CurrentP50NS = ptr(p50)
+		report.DeltaNS = ptr(p50 - b.LatencyNS)
+		if b.LatencyNS > 0 {
+			report.Ratio = ptr(p50 / b.LatencyNS)
+		}
+	}
+	return report
+}
+
+func (s *Server) handleGetBaseline(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
+		return
+	}
+	target, err := s.reader.GetTarget(id)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Target not found: "+err.Error(), CodeTargetNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.baselineReport(*target))
+}
+
+// handlePutBaseline sets a target's baseline by hand, or with a null
+// baseline_ns goes back to learning it, starting now.
+func (s *Server) handlePutBaseline(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
+		return
+	}
+	var req BaselineRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+		return
+	}
+	if v := req.BaselineNS; v != nil && (math.IsNaN(*v) || math.IsInf(*v, 0) || *v <= 0) {
+		writeJSONError(w, http.StatusBadRequest, "baseline_ns must be a positive number of nanoseconds", CodeInvalidRequest)
+		return
+	}
+	target, err := s.db.GetTarget(id)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Target not found: "+err.Error(), CodeTargetNotFound)
+		return
+	}
+
+	if req.BaselineNS == nil {
+		if err = s.db.DeleteBaseline(id); err == nil {
+			s.learnBaseline(*target, time.Now().UTC())
+		}
+	} else {
+		err = s.db.SetBaseline(&db.Baseline{TargetID: id, LatencyNS: *req.BaselineNS, Manual: true, UpdatedAt: time.Now().UTC()})
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.baselineReport(*target))
+}
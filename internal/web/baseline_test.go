@@ -0,0 +1,160 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func TestHandleBaseline(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Test Target",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	// Two hours ago the target ran at 1ms for 40 minutes; for the last 20 it
+	// has run at 2ms.
+	now := time.Now().UTC().Truncate(time.Minute)
+	add := func(at time.Time, latency float64) {
+		td, _ := tdigest.New(tdigest.Compression(100))
+		for range 10 {
+			td.Add(latency)
+		}
+		data, _ := db.SerializeTDigest(td)
+		if err := database.AddAggregatedResult(&db.AggregatedResult{
+			Time: at, TargetID: id, WindowSeconds: 60, TDigestData: data, SampleCount: 10, SumNS: 10 * latency,
+		}); err != nil {
+			t.Fatalf("Failed to add result: %v", err)
+		}
+	}
+	for i := range 40 {
+		add(now.Add(-2*time.Hour+time.Duration(i)*time.Minute), 1e6)
+	}
+	for i := range 20 {
+		add(now.Add(-time.Duration(i+1)*time.Minute), 2e6)
+	}
+
+	do := func(method, body string) (*httptest.ResponseRecorder, BaselineReport) {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest(method, fmt.Sprintf("/api/targets/%d/baseline", id), strings.NewReader(body)))
+		var report BaselineReport
+		if rr.Code == http.StatusOK {
+			json.NewDecoder(rr.Body).Decode(&report)
+		}
+		return rr, report
+	}
+	check := func(report BaselineReport, source string, baseline, ratio float64) {
+		t.Helper()
+		if report.Source != source || report.BaselineNS == nil || math.Abs(*report.BaselineNS-baseline) > 1 {
+			t.Errorf("Expected a %s baseline of %v, got %s %v", source, baseline, report.Source, report.BaselineNS)
+		}
+		if report.CurrentP50NS == nil || math.Abs(*report.CurrentP50NS-2e6) > 1 {
+			t.Errorf("Expected a current P50 of 2ms, got %v", report.CurrentP50NS)
+		}
+		if report.Ratio == nil || math.Abs(*report.Ratio-ratio) > 1e-6 {
+			t.Errorf("Expected ratio %v, got %v", ratio, report.Ratio)
+		}
+	}
+
+	// Reading doesn't learn a baseline.
+	rr, report := do("GET", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if report.Source != "none" {
+		t.Errorf("Expected no baseline before one is learned, got %s", report.Source)
+	}
+	if b, _ := database.GetBaseline(id); b != nil {
+		t.Errorf("Expected GET not to store a baseline, got %+v", b)
+	}
+
+	// The learned baseline is the median over the day, dominated by the
+	// 40 minutes at 1ms.
+	s.refreshBaselines()
+	_, report = do("GET", "")
+	check(report, "learned", 1e6, 2)
+
+	// The results API reports each window against it.
+	rr = httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/api/results/%d?start=%s&end=%s", id,
+		now.Add(-30*time.Minute).Format(time.RFC3339), now.Format(time.RFC3339)), nil))
+	var results []APIResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil || len(results) == 0 {
+		t.Fatalf("Failed to decode results (%v): %s", err, rr.Body.String())
+	}
+	for _, res := range results {
+		if res.BaselineRatio == nil || math.Abs(*res.BaselineRatio-2) > 1e-6 || res.BaselineDelta == nil || math.Abs(*res.BaselineDelta-1e6) > 1 {
+			t.Errorf("Expected ratio 2 and delta 1ms at %v, got %v and %v", res.Time, res.BaselineRatio, res.BaselineDelta)
+		}
+	}
+
+	rr, report = do("PUT", `{"baseline_ns": 4000000}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	check(report, "manual", 4e6, 0.5)
+	// Refreshing doesn't replace a manual baseline.
+	s.refreshBaselines()
+	_, report = do("GET", "")
+	check(report, "manual", 4e6, 0.5)
+
+	_, report = do("PUT", `{"baseline_ns": null}`)
+	check(report, "learned", 1e6, 2)
+
+	for _, body := range []string{`{"baseline_ns": -1}`, `{"baseline_ns": 0}`, `not json`} {
+		if rr, _ := do("PUT", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, rr.Code)
+		}
+	}
+	rr = httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/targets/999/baseline", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing target, got %d", rr.Code)
+	}
+}
+
+func TestBaseline_SummaryAggregation(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	id, start := addSummaryTarget(t, database, "summary")
+	s.refreshBaselines()
+
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/api/targets/%d/baseline", id), nil))
+	var report BaselineReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	// Learned as the median of the rollups' averages, 200 and 500.
+	if report.Source != "learned" || report.BaselineNS == nil || *report.BaselineNS != 350 {
+		t.Errorf("Expected a learned baseline of 350, got %s %v", report.Source, report.BaselineNS)
+	}
+
+	rr = httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/api/results/%d?start=%s&end=%s", id,
+		start.Format(time.RFC3339), start.Add(2*time.Minute).Format(time.RFC3339)), nil))
+	var results []APIResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil || len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d (%v)", len(results), err)
+	}
+	if r := results[1].BaselineRatio; r == nil || math.Abs(*r-500.0/350) > 1e-9 {
+		t.Errorf("Expected the average compared to the baseline, got %v", r)
+	}
+}
@@ -0,0 +1,147 @@
+package web
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// compressMinSize is the smallest response body worth gzipping. Below this the
+// gzip framing overhead eats most of the savings.
+const compressMinSize = 1024
+
+// compressibleTypes lists the content types that are gzipped. Anything else
+// (images, Prometheus text, event streams) is passed through untouched.
+var compressibleTypes = []string{
+	"application/json",
+	"text/html",
+	"text/css",
+	"application/javascript",
+}
+
+func isCompressible(contentType string) bool {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.TrimSpace(strings.ToLower(contentType))
+	for _, t := range compressibleTypes {
+		if contentType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// compressResponses gzips compressible responses of at least compressMinSize
+// bytes for clients that accept it. Unlike middleware.Compress it buffers the
+// start of the body so small JSON responses aren't compressed needlessly.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter holds back the status line and up to compressMinSize bytes of
+// body until it knows whether the response is large enough to compress.
+type compressWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.status == 0 {
+		cw.status = code
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.decided {
+		if cw.gz != nil {
+			return cw.gz.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= compressMinSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide commits the response headers, compressing if the body is large
+// enough and of a compressible type.
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	compressible := h.Get("Content-Encoding") == "" && isCompressible(h.Get("Content-Type"))
+	if compressible {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if cw.status == 0 {
+		if len(cw.buf) == 0 {
+			return nil // Nothing was written; let net/http send its default.
+		}
+		cw.status = http.StatusOK
+	}
+
+	if large && compressible {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		cw.ResponseWriter.WriteHeader(cw.status)
+		cw.gz = gzip.NewWriter(cw.ResponseWriter)
+		_, err := cw.gz.Write(cw.buf)
+		cw.buf = nil
+		return err
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	_, err := cw.ResponseWriter.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.gz != nil {
+		cw.gz.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+	if cw.gz != nil {
+		return cw.gz.Close()
+	}
+	return nil
+}
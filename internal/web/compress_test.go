@@ -0,0 +1,108 @@
+package web
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressResponses(t *testing.T) {
+	large := `{"data":"` + strings.Repeat("a", 4096) + `"}`
+	handler := compressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, large)
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"ok":true}`)
+		case "/metrics":
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			io.WriteString(w, strings.Repeat("metric 1\n", 500))
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+
+	t.Run("Large JSON is gzipped", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/large", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("Expected gzip encoding, got %q", rr.Header().Get("Content-Encoding"))
+		}
+		zr, err := gzip.NewReader(rr.Body)
+		if err != nil {
+			t.Fatalf("Invalid gzip body: %v", err)
+		}
+		body, _ := io.ReadAll(zr)
+		if string(body) != large {
+			t.Errorf("Decompressed body mismatch (len %d)", len(body))
+		}
+	})
+
+	t.Run("Small JSON is not gzipped", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/small", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Content-Encoding") != "" {
+			t.Errorf("Expected no encoding for small body, got %q", rr.Header().Get("Content-Encoding"))
+		}
+		if rr.Body.String() != `{"ok":true}` {
+			t.Errorf("Unexpected body %q", rr.Body.String())
+		}
+	})
+
+	t.Run("Non-compressible types pass through", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Content-Encoding") != "" {
+			t.Errorf("Expected metrics to be uncompressed, got %q", rr.Header().Get("Content-Encoding"))
+		}
+	})
+
+	t.Run("Status without body is preserved", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/created", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusCreated {
+			t.Errorf("Expected 201, got %d", rr.Code)
+		}
+	})
+}
+
+func TestHandleStaticETag(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	req := httptest.NewRequest("GET", "/static/vaportrail-charts.js", nil)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag on static assets")
+	}
+
+	req = httptest.NewRequest("GET", "/static/vaportrail-charts.js", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for matching ETag, got %d", rr.Code)
+	}
+}
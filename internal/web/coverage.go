@@ -0,0 +1,140 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"vaportrail/internal/scheduler"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxCoverageWindows caps the number of windows a coverage request may span,
+// and maxCoverageGaps the number of gaps it lists.
+const (
+	maxCoverageWindows = 100000
+	maxCoverageGaps    = 1000
+)
+
+// CoverageReport shows where a target's rollups of one window size have
+// holes, from GET /api/results/{id}/coverage. Start and End are the
+// requested range widened to whole windows of WindowSeconds, with End cut
+// back to the start of the window still open, which can't have a rollup yet.
+//
+// Windows counts the windows in the range and MissingWindows those with no
+// rollup at all; an empty rollup, written for a window without probes, still
+// covers its window. Consecutive missing windows are reported together as
+// one of Gaps, oldest first. Only the first maxCoverageGaps are listed, with
+// GapsTruncated set if there were more; MissingWindows still counts them all.
+type CoverageReport struct {
+	TargetID       int64         `json:"target_id"`
+	Start          time.Time     `json:"start"`
+	End            time.Time     `json:"end"`
+	WindowSeconds  int           `json:"window_seconds"`
+	Windows        int           `json:"windows"`
+	MissingWindows int           `json:"missing_windows"`
+	Coverage       *float64      `json:"coverage,omitempty"` // 1 - MissingWindows/Windows
+	Gaps           []CoverageGap `json:"gaps"`
+	GapsTruncated  bool          `json:"gaps_truncated,omitempty"`
+}
+
+// CoverageGap is a run of windows without rollups, starting at Start and
+// ending where the next rollup starts.
+type CoverageGap struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Windows int       `json:"windows"`
+}
+
+// handleGetCoverage reports whether a target's rollups are continuous, to
+// tell a gap in a chart from rollups that fell behind or never ran.
+// Query parameters:
+//
+//	start, end RFC3339 range, both required
+//	window     aggregated window in seconds, chosen as for the results API
+//	           if omitted
+func (s *Server) handleGetCoverage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
+		return
+	}
+	q := r.URL.Query()
+	start, end, err := parseTimeRange(TimeRange{Start: q.Get("start"), End: q.Get("end")})
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidTimeRange)
+		return
+	}
+
+	target, err := s.reader.GetTarget(id)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Target not found: "+err.Error(), CodeTargetNotFound)
+		return
+	}
+	policies, err := scheduler.GetRetentionPolicies(*target)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Target has no retention policies configured", CodeNoRetentionPolicies)
+		return
+	}
+	window := selectWindow(policies, start, end)
+	if windowStr := q.Get("window"); windowStr != "" {
+		window, err = strconv.Atoi(windowStr)
+		if err != nil || !hasWindow(policies, window) {
+			writeJSONError(w, http.StatusBadRequest, "window must be one of the target's aggregated windows", CodeInvalidRequest)
+			return
+		}
+	}
+	size := time.Duration(window) * time.Second
+
+	report := CoverageReport{TargetID: id, WindowSeconds: window, Start: start.Truncate(size), End: end.Truncate(size), Gaps: []CoverageGap{}}
+	if report.End.Before(end) {
+		report.End = report.End.Add(size)
+	}
+	if open := time.Now().UTC().Truncate(size); report.End.After(open) {
+		report.End = open
+	}
+	if !report.End.After(report.Start) {
+		report.End = report.Start
+	}
+	// Counted in seconds, since a Duration saturates after 292 years.
+	windows := (report.End.Unix() - report.Start.Unix()) / int64(window)
+	if windows > maxCoverageWindows {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("range spans %d windows of %ds; the most allowed is %d", windows, window, maxCoverageWindows), CodeInvalidTimeRange)
+		return
+	}
+	report.Windows = int(windows)
+
+	results, err := s.reader.GetAggregatedResults(id, window, report.Start, report.End)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	covered := make(map[int64]bool, len(results))
+	for _, res := range results {
+		covered[res.Time.Unix()] = true
+	}
+	for t := report.Start; t.Before(report.End); t = t.Add(size) {
+		if covered[t.Unix()] {
+			continue
+		}
+		report.MissingWindows++
+		if n := len(report.Gaps); n > 0 && report.Gaps[n-1].End.Equal(t) {
+			report.Gaps[n-1].End = t.Add(size)
+			report.Gaps[n-1].Windows++
+			continue
+		}
+		if len(report.Gaps) == maxCoverageGaps {
+			report.GapsTruncated = true
+			continue
+		}
+		report.Gaps = append(report.Gaps, CoverageGap{Start: t, End: t.Add(size), Windows: 1})
+	}
+	if report.Windows > 0 {
+		report.Coverage = ptr(1 - float64(report.MissingWindows)/float64(report.Windows))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
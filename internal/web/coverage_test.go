@@ -0,0 +1,132 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+)
+
+func TestHandleGetCoverage(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Test Target",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}, {"window": 300, "retention": 31536000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, minute := range []int{0, 1, 4, 6} {
+		// Minute 6 is an empty rollup, which still covers its window.
+		if err := database.AddAggregatedResult(&db.AggregatedResult{
+			Time:          start.Add(time.Duration(minute) * time.Minute),
+			TargetID:      id,
+			WindowSeconds: 60,
+		}); err != nil {
+			t.Fatalf("Failed to add result: %v", err)
+		}
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/api/results/%d/coverage?%s", id, query), nil))
+		return rr
+	}
+	rangeQuery := func(from, to time.Time) string {
+		return "start=" + from.Format(time.RFC3339) + "&end=" + to.Format(time.RFC3339)
+	}
+
+	// The end is mid-window; it's widened to cover minute 7.
+	rr := get(rangeQuery(start, start.Add(7*time.Minute+30*time.Second)) + "&window=60")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var report CoverageReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if report.WindowSeconds != 60 || report.Windows != 8 || report.MissingWindows != 4 {
+		t.Errorf("Unexpected window accounting: %+v", report)
+	}
+	if report.Coverage == nil || *report.Coverage != 0.5 {
+		t.Errorf("Expected coverage 0.5, got %v", report.Coverage)
+	}
+	want := []CoverageGap{
+		{Start: start.Add(2 * time.Minute), End: start.Add(4 * time.Minute), Windows: 2},
+		{Start: start.Add(5 * time.Minute), End: start.Add(6 * time.Minute), Windows: 1},
+		{Start: start.Add(7 * time.Minute), End: start.Add(8 * time.Minute), Windows: 1},
+	}
+	if len(report.Gaps) != len(want) {
+		t.Fatalf("Expected gaps %v, got %v", want, report.Gaps)
+	}
+	for i, gap := range report.Gaps {
+		if !gap.Start.Equal(want[i].Start) || !gap.End.Equal(want[i].End) || gap.Windows != want[i].Windows {
+			t.Errorf("Gap %d: expected %v, got %v", i, want[i], gap)
+		}
+	}
+
+	// Nothing was rolled up at 300s.
+	rr = get(rangeQuery(start, start.Add(time.Hour)) + "&window=300")
+	report = CoverageReport{}
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if report.Windows != 12 || report.MissingWindows != 12 || len(report.Gaps) != 1 || *report.Coverage != 0 {
+		t.Errorf("Expected one gap over all 12 windows, got %+v", report)
+	}
+
+	// The window still open has no rollup yet and isn't counted.
+	now := time.Now().UTC()
+	rr = get(rangeQuery(now.Add(-30*time.Second), now.Add(time.Hour)) + "&window=300")
+	report = CoverageReport{}
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if report.Windows > 1 || report.Gaps == nil {
+		t.Errorf("Expected at most the last closed window, got %+v", report)
+	}
+
+	// Only the first maxCoverageGaps gaps are listed.
+	later := start.Add(24 * time.Hour)
+	var covered []*db.AggregatedResult
+	for i := range maxCoverageGaps {
+		covered = append(covered, &db.AggregatedResult{Time: later.Add(time.Duration(2*i+1) * time.Minute), TargetID: id, WindowSeconds: 60})
+	}
+	if err := database.AddAggregatedResults(covered); err != nil {
+		t.Fatalf("Failed to add results: %v", err)
+	}
+	rr = get(rangeQuery(later, later.Add(time.Duration(2*maxCoverageGaps+1)*time.Minute)) + "&window=60")
+	report = CoverageReport{}
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if len(report.Gaps) != maxCoverageGaps || !report.GapsTruncated || report.MissingWindows != maxCoverageGaps+1 {
+		t.Errorf("Expected %d of %d gaps listed, got %d of %d (truncated %v)", maxCoverageGaps, maxCoverageGaps+1, len(report.Gaps), report.MissingWindows, report.GapsTruncated)
+	}
+
+	// Far more windows than allowed, over longer than a Duration can hold.
+	if rr := get("start=0001-01-01T00:00:00Z&end=" + now.Format(time.RFC3339) + "&window=60"); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "windows") {
+		t.Errorf("Expected the range to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	for _, query := range []string{
+		rangeQuery(start, start.Add(time.Hour)) + "&window=120",
+		rangeQuery(start, start.Add(time.Hour)) + "&window=0",
+		"start=" + start.Format(time.RFC3339),
+	} {
+		if rr := get(query); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rr.Code)
+		}
+	}
+}
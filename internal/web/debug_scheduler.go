@@ -0,0 +1,28 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"vaportrail/internal/scheduler"
+)
+
+// SchedulerDebug is the response of GET /api/debug/scheduler.
+type SchedulerDebug struct {
+	Maintenance scheduler.MaintenanceStatus `json:"maintenance"`
+	Loops       []scheduler.LoopState       `json:"loops"`
+}
+
+// handleDebugScheduler lists every target's probe loop and what it last did,
+// for working out why a target isn't probing as expected. It needs the write
+// token, since errors can reveal internal addresses.
+func (s *Server) handleDebugScheduler(w http.ResponseWriter, r *http.Request) {
+	if s.scheduler == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Scheduler is not running", CodeInternal)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SchedulerDebug{
+		Maintenance: s.scheduler.MaintenanceStatus(),
+		Loops:       s.scheduler.ProbeLoops(),
+	})
+}
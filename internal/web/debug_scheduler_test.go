@@ -0,0 +1,60 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"vaportrail/internal/db"
+	"vaportrail/internal/scheduler"
+)
+
+func TestHandleDebugScheduler(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	s.cfg.WriteToken = "s3cret"
+
+	get := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/debug/scheduler", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := get("s3cret"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a scheduler, got %d", rr.Code)
+	}
+
+	s.scheduler = scheduler.New(database)
+	defer s.scheduler.Stop()
+	// An unknown probe type never probes, so the loop exits straight away.
+	target := db.Target{Name: "Broken", Address: "example.com", ProbeType: "carrier-pigeon"}
+	id, err := database.AddTarget(&target)
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	target.ID = id
+	s.scheduler.AddTarget(target)
+
+	if rr := get(""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the token, got %d", rr.Code)
+	}
+	rr := get("s3cret")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var debug SchedulerDebug
+	if err := json.NewDecoder(rr.Body).Decode(&debug); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(debug.Loops) != 1 || debug.Loops[0].TargetID != id || debug.Loops[0].Name != "Broken" {
+		t.Errorf("Expected the one target's loop, got %+v", debug.Loops)
+	}
+	if debug.Maintenance.Paused {
+		t.Errorf("Expected probing not to be paused, got %+v", debug.Maintenance)
+	}
+}
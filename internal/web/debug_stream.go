@@ -0,0 +1,83 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"vaportrail/internal/probe"
+	"vaportrail/internal/scheduler"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DebugProbeEvent is the data of each "probe" event streamed by
+// GET /api/targets/{id}/debug.
+type DebugProbeEvent struct {
+	Time      time.Time `json:"time"`
+	LatencyNS float64   `json:"latency_ns,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// handleDebugTarget probes a target at its interval for as long as the client
+// stays connected and streams every result as a Server-Sent Event. Results
+// are not stored, so this doesn't affect the target's data, but the probes
+// are real and add to the load on the target.
+func (s *Server) handleDebugTarget(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
+		return
+	}
+	target, err := s.db.GetTarget(id)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Target not found: "+err.Error(), CodeTargetNotFound)
+		return
+	}
+	cfg, interval, err := scheduler.TargetProbeConfig(*target)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid target configuration: "+err.Error(), CodeInvalidTarget)
+		return
+	}
+	var runner probe.Runner = probe.RealRunner{}
+	if s.scheduler != nil {
+		runner = s.scheduler.Runner()
+	}
+
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's WriteTimeout.
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Probes run one at a time; ticks that arrive while one is in
+		// flight are dropped by the ticker.
+		ev := DebugProbeEvent{Time: time.Now().UTC()}
+		latency, err := runner.Run(cfg)
+		if err != nil {
+			ev.Error = err.Error()
+		} else {
+			ev.LatencyNS = latency
+		}
+		data, _ := json.Marshal(ev)
+		if _, err := fmt.Fprintf(w, "event: probe\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+)
+
+func TestHandleDebugTarget(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:          "Debug",
+		Address:       backend.URL,
+		ProbeType:     "http",
+		ProbeInterval: 0.05,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	srv := httptest.NewServer(s.router)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/api/targets/"+strconv.FormatInt(id, 10)+"/debug", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+
+	// Read a few events, then disconnect.
+	scanner := bufio.NewScanner(resp.Body)
+	events := 0
+	for events < 3 && scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev DebugProbeEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("Failed to decode event %q: %v", line, err)
+		}
+		if ev.Error != "" || ev.LatencyNS <= 0 {
+			t.Errorf("Expected a successful probe, got %+v", ev)
+		}
+		events++
+	}
+	if events != 3 {
+		t.Fatalf("Expected 3 events, got %d (%v)", events, scanner.Err())
+	}
+	cancel()
+
+	raw, _ := database.GetRawResults(id, time.Time{}, time.Now().Add(time.Hour), 10)
+	if len(raw) != 0 {
+		t.Errorf("Expected debug probes not to be stored, got %d raw results", len(raw))
+	}
+
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/targets/9999/debug", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a missing target, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,125 @@
+package web
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// digestKey identifies one aggregated result row.
+type digestKey struct {
+	targetID int64
+	time     int64 // Unix nanoseconds
+	window   int
+}
+
+type digestCacheEntry struct {
+	key digestKey
+	sum uint64    // hash of the stored digest the stats were computed from
+	res APIResult // only the fields fillDigestStats sets
+}
+
+// digestCache is an LRU of the stats fillDigestStats computes from each
+// aggregated result's t-digest. Entries remember a hash of the digest they
+// were computed from and a lookup with a different digest misses, so a row
+// rewritten by a rollup, backfill or another process is never served stale
+// however it was written. A nil *digestCache caches nothing.
+type digestCache struct {
+	mu      sync.Mutex
+	size    int
+	seed    maphash.Seed
+	order   *list.List // front is most recently used
+	entries map[digestKey]*list.Element
+}
+
+func newDigestCache(size int) *digestCache {
+	if size <= 0 {
+		return nil
+	}
+	return &digestCache{
+		size:    size,
+		seed:    maphash.MakeSeed(),
+		order:   list.New(),
+		entries: make(map[digestKey]*list.Element),
+	}
+}
+
+func newDigestKey(targetID int64, t time.Time, window int) digestKey {
+	return digestKey{targetID: targetID, time: t.UnixNano(), window: window}
+}
+
+// get copies the cached stats for key onto apiRes if they were computed from
+// data.
+func (c *digestCache) get(key digestKey, data []byte, apiRes *APIResult) bool {
+	if c == nil {
+		return false
+	}
+	sum := maphash.Bytes(c.seed, data)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	e := el.Value.(*digestCacheEntry)
+	if e.sum != sum {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return false
+	}
+	c.order.MoveToFront(el)
+	copyDigestStats(apiRes, &e.res)
+	return true
+}
+
+// put caches the stats in apiRes, computed from data, for key.
+func (c *digestCache) put(key digestKey, data []byte, apiRes *APIResult) {
+	if c == nil {
+		return
+	}
+	e := &digestCacheEntry{key: key, sum: maphash.Bytes(c.seed, data)}
+	copyDigestStats(&e.res, apiRes)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(e)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*digestCacheEntry).key)
+	}
+}
+
+// len reports the number of cached entries.
+func (c *digestCache) len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// copyDigestStats copies the fields set by fillDigestStats. The pointers are
+// shared, which is safe since results are never modified through them.
+func copyDigestStats(dst, src *APIResult) {
+	dst.ProbeCount = src.ProbeCount
+	dst.empty = src.empty
+	dst.AvgNS = src.AvgNS
+	dst.MinNS = src.MinNS
+	dst.MaxNS = src.MaxNS
+	dst.InsufficientSamples = src.InsufficientSamples
+	dst.P0 = src.P0
+	dst.P1 = src.P1
+	dst.P25 = src.P25
+	dst.P50 = src.P50
+	dst.P75 = src.P75
+	dst.P99 = src.P99
+	dst.P100 = src.P100
+	dst.Percentiles = src.Percentiles
+}
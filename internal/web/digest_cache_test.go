@@ -0,0 +1,116 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func TestDigestCache(t *testing.T) {
+	c := newDigestCache(2)
+	now := time.Now()
+	k1, k2, k3 := newDigestKey(1, now, 60), newDigestKey(2, now, 60), newDigestKey(1, now, 300)
+
+	c.put(k1, []byte("a"), &APIResult{ProbeCount: 1})
+	c.put(k2, []byte("b"), &APIResult{ProbeCount: 2})
+
+	var res APIResult
+	if !c.get(k1, []byte("a"), &res) || res.ProbeCount != 1 {
+		t.Fatalf("Expected hit for k1 with ProbeCount 1, got %+v", res)
+	}
+	// k2 is now least recently used and is evicted.
+	c.put(k3, []byte("c"), &APIResult{ProbeCount: 3})
+	if c.get(k2, []byte("b"), &res) {
+		t.Errorf("Expected k2 to have been evicted")
+	}
+	if c.len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", c.len())
+	}
+
+	// A different digest for the same key misses and drops the entry.
+	if c.get(k1, []byte("changed"), &res) {
+		t.Errorf("Expected miss for rewritten digest")
+	}
+	if c.get(k1, []byte("a"), &res) {
+		t.Errorf("Expected stale entry to have been dropped")
+	}
+
+	disabled := newDigestCache(0)
+	disabled.put(k1, []byte("a"), &APIResult{ProbeCount: 1})
+	if disabled.get(k1, []byte("a"), &res) {
+		t.Errorf("Expected disabled cache to miss")
+	}
+}
+
+func TestHandleGetResults_DigestCacheRollupOverwrite(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	s.digests = newDigestCache(10)
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Test Target",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	at := time.Now().UTC().Truncate(time.Minute).Add(-10 * time.Minute)
+	write := func(latencies ...float64) {
+		td, _ := tdigest.New(tdigest.Compression(100))
+		for _, l := range latencies {
+			td.Add(l)
+		}
+		data, _ := db.SerializeTDigest(td)
+		if err := database.AddAggregatedResult(&db.AggregatedResult{
+			Time:          at,
+			TargetID:      id,
+			WindowSeconds: 60,
+			TDigestData:   data,
+		}); err != nil {
+			t.Fatalf("Failed to add result: %v", err)
+		}
+	}
+	get := func() APIResult {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/results/"+strconv.FormatInt(id, 10), nil)
+		s.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+		}
+		var results []APIResult
+		if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("Expected 1 result, got %d", len(results))
+		}
+		return results[0]
+	}
+
+	write(100, 100)
+	if res := get(); res.ProbeCount != 2 || res.P50 == nil || *res.P50 != 100 {
+		t.Fatalf("Unexpected first result: %+v", res)
+	}
+	if s.digests.len() != 1 {
+		t.Fatalf("Expected the result to be cached, got %d entries", s.digests.len())
+	}
+	if res := get(); res.ProbeCount != 2 || res.P50 == nil || *res.P50 != 100 {
+		t.Errorf("Unexpected cached result: %+v", res)
+	}
+
+	// A rollup rewriting the window must not be answered from the cache.
+	write(100, 100, 500, 500, 500)
+	if res := get(); res.ProbeCount != 5 || res.P50 == nil || *res.P50 != 500 {
+		t.Errorf("Expected rewritten window to be recomputed, got %+v", res)
+	}
+}
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ErrorResponse is the body of every API error response. Message is meant
+// for people and may change; Code is stable, for clients to branch on.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// API error codes.
+const (
+	CodeInvalidRequest      = "invalid_request"
+	CodeInvalidID           = "invalid_id"
+	CodeInvalidTimeRange    = "invalid_time_range"
+	CodeInvalidTarget       = "invalid_target"
+	CodeInvalidProbeType    = "invalid_probe_type"
+	CodeInvalidProbeConfig  = "invalid_probe_config"
+	CodeInvalidAddress      = "invalid_address"
+	CodeInvalidDump         = "invalid_dump"
+	CodeUnsupportedVersion  = "unsupported_version"
+	CodeTargetNotFound      = "target_not_found"
+	CodeGraphNotFound       = "graph_not_found"
+	CodeNotFound            = "not_found"
+	CodeTargetLimit         = "target_limit_reached"
+	CodeNoRetentionPolicies = "no_retention_policies"
+	CodeUnauthorized        = "unauthorized"
+	CodeReadOnly            = "read_only"
+	CodeInternal            = "internal_error"
+)
+
+// writeJSONError replies with status and an ErrorResponse. Like http.Error,
+// it doesn't end the handler, and the reply shouldn't be written to further.
+func writeJSONError(w http.ResponseWriter, status int, message, code string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message, Code: code})
+}
+
+// targetError is a target validation error, carrying the code it is
+// reported under.
+type targetError struct {
+	code string
+	msg  string
+}
+
+func (e *targetError) Error() string { return e.msg }
+
+// targetErrorCode returns the code for an error from normalizeTarget.
+func targetErrorCode(err error) string {
+	var te *targetError
+	if errors.As(err, &te) {
+		return te.code
+	}
+	return CodeInvalidTarget
+}
@@ -0,0 +1,67 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteJSONError(t *testing.T) {
+	rr := httptest.NewRecorder()
+	writeJSONError(rr, http.StatusNotFound, `Target "x" not found`, CodeTargetNotFound)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected a JSON content type, got %q", ct)
+	}
+	var resp ErrorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode error: %v", err)
+	}
+	if resp.Error != `Target "x" not found` || resp.Code != CodeTargetNotFound {
+		t.Errorf("Unexpected error body: %+v", resp)
+	}
+}
+
+func TestAPIErrorCodes(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{"invalid id", "GET", "/api/results/abc", "", http.StatusBadRequest, CodeInvalidID},
+		{"missing target", "GET", "/api/results/999", "", http.StatusNotFound, CodeTargetNotFound},
+		{"bad json", "POST", "/api/targets", "{", http.StatusBadRequest, CodeInvalidRequest},
+		{"probe type", "POST", "/api/targets", `{"Name": "x", "Address": "example.com", "ProbeType": "smoke-signal"}`, http.StatusBadRequest, CodeInvalidProbeType},
+		{"probe config", "POST", "/api/targets", `{"Name": "x", "Address": "example.com", "ProbeType": "http", "ProbeConfig": "{\"bogus\": 1}"}`, http.StatusBadRequest, CodeInvalidProbeConfig},
+		{"metric mapping", "POST", "/api/targets", `{"Name": "x", "Address": "1.1.1.1", "ProbeType": "ping", "ProbeConfig": "{\"metric_mappings\": {\"ttl\": {\"metric\": \"ttl\"}}}"}`, http.StatusBadRequest, CodeInvalidProbeConfig},
+		{"address", "POST", "/api/targets", `{"Name": "x", "Address": "https://example.com", "ProbeType": "ping"}`, http.StatusBadRequest, CodeInvalidAddress},
+		{"other validation", "POST", "/api/targets", `{"Name": "x", "Address": "example.com", "ProbeType": "http", "RetryCount": -1}`, http.StatusBadRequest, CodeInvalidTarget},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			s.router.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, bytes.NewReader([]byte(tt.body))))
+			if rr.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("Expected a JSON error body: %v", err)
+			}
+			if resp.Code != tt.code || resp.Error == "" {
+				t.Errorf("Expected code %q with a message, got %+v", tt.code, resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"vaportrail/internal/db"
+	"vaportrail/internal/influx"
+	"vaportrail/internal/scheduler"
+)
+
+// influxExportChunk is how many windows are loaded from the database at a
+// time while streaming an export.
+const influxExportChunk = 1000
+
+// handleExportInflux writes a target's aggregated results as InfluxDB line
+// protocol, one "latency" point per window. Query parameters:
+//
+//	target_id  required
+//	start, end RFC3339 range, defaulting to the last hour
+//	window     rollup window in seconds; defaults to the one the results API would pick
+//	precision  ns (default), us, ms or s
+//
+// The range is read and written in chunks so large exports don't have to be
+// held in memory.
+func (s *Server) handleExportInflux(w http.ResponseWriter, r *http.Request) {
+	// The export outlives the server's WriteTimeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	q := r.URL.Query()
+	id, err := strconv.ParseInt(q.Get("target_id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid target_id", CodeInvalidID)
+		return
+	}
+	precision, ok := influx.ParsePrecision(q.Get("precision"))
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "precision must be one of ns, us, ms or s", CodeInvalidRequest)
+		return
+	}
+
+	target, err := s.reader.GetTarget(id)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Target not found: "+err.Error(), CodeTargetNotFound)
+		return
+	}
+
+	end := time.Now().UTC()
+	start := end.Add(-1 * time.Hour)
+	if q.Get("start") != "" || q.Get("end") != "" {
+		if start, err = time.Parse(time.RFC3339, q.Get("start")); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid start time", CodeInvalidTimeRange)
+			return
+		}
+		if end, err = time.Parse(time.RFC3339, q.Get("end")); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid end time", CodeInvalidTimeRange)
+			return
+		}
+	}
+
+	policies, err := scheduler.GetRetentionPolicies(*target)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Target has no retention policies configured", CodeNoRetentionPolicies)
+		return
+	}
+	window := selectWindow(policies, start, end)
+	if windowStr := q.Get("window"); windowStr != "" {
+		window, err = strconv.Atoi(windowStr)
+		if err != nil || !hasWindow(policies, window) {
+			writeJSONError(w, http.StatusBadRequest, "window must be one of the target's aggregated windows", CodeInvalidRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	flusher, _ := w.(http.Flusher)
+	tags := []influx.Tag{
+		{Key: "target", Value: target.Name},
+		{Key: "probe_type", Value: target.ProbeType},
+		{Key: "window", Value: strconv.Itoa(window) + "s"},
+	}
+	chunk := time.Duration(window) * influxExportChunk * time.Second
+
+	var buf []byte
+	for from := start; from.Before(end); from = from.Add(chunk) {
+		to := from.Add(chunk)
+		if to.After(end) {
+			to = end
+		}
+		results, err := s.reader.GetAggregatedResults(id, window, from, to)
+		if err != nil {
+			// Headers are likely already sent, so all we can do is stop.
+			log.Printf("Influx export for target %d failed: %v", id, err)
+			return
+		}
+		buf = buf[:0]
+		for _, res := range results {
+			buf = influxPoint(res, tags, s.cfg.MinSamplesForPercentiles).AppendTo(buf, precision)
+		}
+		if _, err := w.Write(buf); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func hasWindow(policies []scheduler.RetentionPolicy, window int) bool {
+	for _, p := range policies {
+		if p.Window > 0 && p.Window == window {
+			return true
+		}
+	}
+	return false
+}
+
+// influxPoint converts an aggregated window to a point. Latency fields are
+// left out when the window has an unreadable digest, and the percentiles when
+// it has fewer than minSamples probes or no digest at all.
+func influxPoint(res db.AggregatedResult, tags []influx.Tag, minSamples int) influx.Point {
+	var stats APIResult
+	if len(res.TDigestData) > 0 {
+		td, err := db.DeserializeTDigest(res.TDigestData)
+		if err != nil {
+			log.Printf("Warning: unreadable t-digest for target %d window %ds at %s: %v", res.TargetID, res.WindowSeconds, res.Time.Format(time.RFC3339), err)
+		} else {
+			fillDigestStats(&stats, td, minSamples)
+		}
+	} else if res.SampleCount > 0 {
+		fillSummaryStats(&stats, res)
+	}
+
+	var fields []influx.Field
+	if stats.P50 != nil {
+		fields = append(fields,
+			influx.Field{Key: "p50", Value: *stats.P50},
+			influx.Field{Key: "p99", Value: *stats.P99},
+		)
+	}
+	if stats.AvgNS != nil {
+		fields = append(fields, influx.Field{Key: "avg", Value: float64(*stats.AvgNS)})
+	}
+	fields = append(fields,
+		influx.Field{Key: "timeouts", Value: res.TimeoutCount},
+		influx.Field{Key: "count", Value: stats.ProbeCount},
+	)
+	return influx.Point{Measurement: "latency", Tags: tags, Fields: fields, Time: res.Time}
+}
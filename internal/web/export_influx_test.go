@@ -0,0 +1,91 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func TestHandleExportInflux(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "web server",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	td, _ := tdigest.New(tdigest.Compression(100))
+	td.Add(1000)
+	data, _ := db.SerializeTDigest(td)
+	for i, r := range []db.AggregatedResult{
+		{Time: ts, TDigestData: data, TimeoutCount: 2},
+		{Time: ts.Add(time.Minute), TimeoutCount: 5},
+	} {
+		r.TargetID, r.WindowSeconds = id, 60
+		if err := database.AddAggregatedResult(&r); err != nil {
+			t.Fatalf("Failed to add result %d: %v", i, err)
+		}
+	}
+
+	export := func(params string) *httptest.ResponseRecorder {
+		url := "/api/export/influx?target_id=" + strconv.FormatInt(id, 10) +
+			"&start=" + ts.Add(-time.Hour).Format(time.RFC3339) + "&end=" + ts.Add(time.Hour).Format(time.RFC3339) + params
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		return rr
+	}
+
+	rr := export("&precision=s")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	want := `latency,target=web\ server,probe_type=http,window=60s p50=1000,p99=1000,avg=1000,timeouts=2i,count=1i 1704110400` + "\n" +
+		`latency,target=web\ server,probe_type=http,window=60s timeouts=5i,count=0i 1704110460` + "\n"
+	if got := rr.Body.String(); got != want {
+		t.Errorf("Unexpected export:\n%s\nwant\n%s", got, want)
+	}
+
+	if rr := export(""); !strings.Contains(rr.Body.String(), " 1704110400000000000\n") {
+		t.Errorf("Expected nanosecond timestamps by default, got %s", rr.Body.String())
+	}
+	if rr := export("&precision=h"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid precision, got %d", rr.Code)
+	}
+	if rr := export("&window=300"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a window the target doesn't keep, got %d", rr.Code)
+	}
+}
+
+func TestHandleExportInflux_SummaryAggregation(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	id, start := addSummaryTarget(t, database, "summary")
+
+	url := "/api/export/influx?precision=s&target_id=" + strconv.FormatInt(id, 10) +
+		"&start=" + start.Format(time.RFC3339) + "&end=" + start.Add(2*time.Minute).Format(time.RFC3339)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	tags := `latency,target=summary,probe_type=http,window=60s `
+	want := tags + `avg=200,timeouts=0i,count=2i ` + strconv.FormatInt(start.Unix(), 10) + "\n" +
+		tags + `avg=500,timeouts=1i,count=2i ` + strconv.FormatInt(start.Add(time.Minute).Unix(), 10) + "\n"
+	if got := rr.Body.String(); got != want {
+		t.Errorf("Unexpected export:\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"math"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+// maxHistogramBuckets bounds the histogram query parameter.
+const maxHistogramBuckets = 200
+
+// Histogram is a window's latency distribution in buckets, for drawing
+// histograms and heatmaps. Counts[i] is the approximate number of probes
+// between Edges[i] and Edges[i+1], in nanoseconds; timeouts aren't counted.
+//
+// The edges are spaced logarithmically from the fastest to the slowest probe
+// of the whole response, so every point of a response shares them and each
+// bucket spans the same ratio, which keeps microsecond and second latencies
+// readable on one scale.
+//
+// The counts are read off the t-digest's CDF at the edges, not counted from
+// raw probes. Between centroids the CDF is interpolated linearly, so a
+// bucket narrower than the gap between two centroids gets a share of their
+// mass rather than its true count, and a sharp peak is smeared over its
+// neighbours. At the default compression of 100 a centroid holds at most
+// about 1% of a window's probes in the middle of the distribution, and far
+// fewer in the tails, so each count can be off by roughly that much. Counts
+// are fractional and sum to about ProbeCount.
+type Histogram struct {
+	Edges  []float64
+	Counts []float64
+}
+
+// fillHistograms sets the histogram of every result with a digest, where
+// digests[i] is that of results[i] or nil.
+func fillHistograms(results []APIResult, digests []*tdigest.TDigest, buckets int) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i, td := range digests {
+		if td == nil || td.Count() == 0 {
+			continue
+		}
+		lo = math.Min(lo, float64(*results[i].MinNS))
+		hi = math.Max(hi, float64(*results[i].MaxNS))
+	}
+	if math.IsInf(lo, 0) {
+		return // Nothing to bucket.
+	}
+	edges := logEdges(lo, hi, buckets)
+	for i, td := range digests {
+		if td == nil || td.Count() == 0 {
+			continue
+		}
+		results[i].Histogram = &Histogram{Edges: edges, Counts: digestCounts(td, edges)}
+	}
+}
+
+// logEdges returns buckets+1 edges spaced logarithmically from lo to hi.
+func logEdges(lo, hi float64, buckets int) []float64 {
+	lo = math.Max(lo, 1) // A log scale can't reach 0ns.
+	if hi <= lo {
+		hi = lo + 1
+	}
+	edges := make([]float64, buckets+1)
+	ratio := math.Log(hi / lo)
+	for i := range edges {
+		edges[i] = lo * math.Exp(ratio*float64(i)/float64(buckets))
+	}
+	edges[buckets] = hi // Exactly, despite rounding.
+	return edges
+}
+
+// digestCounts returns the approximate number of values of td between each
+// pair of adjacent edges.
+func digestCounts(td *tdigest.TDigest, edges []float64) []float64 {
+	count := float64(td.Count())
+	counts := make([]float64, len(edges)-1)
+	prev := td.CDF(edges[0])
+	for i := range counts {
+		next := td.CDF(edges[i+1])
+		counts[i] = (next - prev) * count
+		prev = next
+	}
+	// The fastest probes sit at or just below the first edge.
+	counts[0] += td.CDF(edges[0]) * count
+	return counts
+}
@@ -0,0 +1,140 @@
+package web
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func TestLogEdges(t *testing.T) {
+	edges := logEdges(1e3, 1e9, 6)
+	want := []float64{1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9}
+	for i := range want {
+		if math.Abs(edges[i]-want[i])/want[i] > 1e-9 {
+			t.Errorf("Expected edges %v, got %v", want, edges)
+			break
+		}
+	}
+	if edges := logEdges(0, 0, 2); edges[0] != 1 || edges[2] <= edges[0] {
+		t.Errorf("Expected a degenerate range to be widened from 1ns, got %v", edges)
+	}
+}
+
+func TestDigestCounts(t *testing.T) {
+	td, _ := tdigest.New(tdigest.Compression(100))
+	// 1000 probes at 1ms and 1000 at 100ms, two decades apart.
+	for i := 0; i < 1000; i++ {
+		td.Add(float64(time.Millisecond) * (1 + float64(i%10)/100))
+		td.Add(float64(100*time.Millisecond) * (1 + float64(i%10)/100))
+	}
+	edges := logEdges(float64(time.Millisecond), float64(110*time.Millisecond), 4)
+	counts := digestCounts(td, edges)
+
+	var sum float64
+	for _, c := range counts {
+		sum += c
+	}
+	if math.Abs(sum-2000) > 1 {
+		t.Errorf("Expected counts to sum to 2000, got %v (%v)", sum, counts)
+	}
+	if counts[0] < 900 || counts[3] < 900 {
+		t.Errorf("Expected the probes in the first and last buckets, got %v", counts)
+	}
+}
+
+func TestHandleGetResults_Histogram(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Histogram",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Minute)
+	for i, latency := range []time.Duration{time.Millisecond, time.Second} {
+		td, _ := tdigest.New(tdigest.Compression(100))
+		for range 10 {
+			td.Add(float64(latency))
+		}
+		data, _ := db.SerializeTDigest(td)
+		if err := database.AddAggregatedResult(&db.AggregatedResult{Time: now.Add(time.Duration(i-10) * time.Minute), TargetID: id, WindowSeconds: 60, TDigestData: data}); err != nil {
+			t.Fatalf("Failed to add result: %v", err)
+		}
+	}
+
+	get := func(query string) (int, []APIResult) {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/results/"+strconv.FormatInt(id, 10)+query, nil))
+		var results []APIResult
+		json.NewDecoder(rr.Body).Decode(&results)
+		return rr.Code, results
+	}
+
+	code, results := get("?histogram=3")
+	if code != http.StatusOK || len(results) != 2 {
+		t.Fatalf("Expected 2 results, got status %d and %d results", code, len(results))
+	}
+	first, second := results[0].Histogram, results[1].Histogram
+	if first == nil || second == nil {
+		t.Fatalf("Expected histograms on both results")
+	}
+	// The edges span the whole response, 1ms to 1s.
+	if !slices.Equal(first.Edges, second.Edges) || len(first.Edges) != 4 {
+		t.Fatalf("Expected both results to share 4 edges, got %v and %v", first.Edges, second.Edges)
+	}
+	if first.Edges[0] != 1e6 || first.Edges[3] != 1e9 {
+		t.Errorf("Expected edges from 1ms to 1s, got %v", first.Edges)
+	}
+	if first.Counts[0] != 10 || second.Counts[2] != 10 {
+		t.Errorf("Expected the 1ms probes in the first bucket and the 1s ones in the last, got %v and %v", first.Counts, second.Counts)
+	}
+
+	_, scaled := get("?histogram=3&unit=ms")
+	if len(scaled) != 2 || scaled[0].Histogram == nil || scaled[0].Histogram.Edges[0] != 1 || scaled[0].Histogram.Edges[3] != 1000 {
+		t.Errorf("Expected edges in ms, got %+v", scaled)
+	}
+
+	if _, results := get(""); len(results) != 2 || results[0].Histogram != nil {
+		t.Errorf("Expected no histogram without the parameter")
+	}
+	for _, query := range []string{"?histogram=0", "?histogram=201", "?histogram=x", "?histogram=10&raw=true"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, code)
+		}
+	}
+}
+
+func TestHandleGetResults_HistogramSummaryAggregation(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	id, start := addSummaryTarget(t, database, "summary")
+
+	rr := httptest.NewRecorder()
+	path := "/api/results/" + strconv.FormatInt(id, 10) + "?start=" + start.Format(time.RFC3339) + "&end=" + start.Add(2*time.Minute).Format(time.RFC3339) + "&histogram=10"
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+	var results []APIResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil || len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d (%v)", len(results), err)
+	}
+	// Without digests there is nothing to build histograms from, but the
+	// summaries are still there.
+	for _, res := range results {
+		if res.Histogram != nil || res.ProbeCount != 2 || res.AvgNS == nil || !res.PercentilesUnavailable {
+			t.Errorf("Expected a summary without a histogram, got %+v", res)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+	"vaportrail/internal/scheduler"
+)
+
+// MaintenancePauseRequest is the optional body of POST
+// /api/maintenance/pause. Until, in RFC3339, resumes probing by itself.
+type MaintenancePauseRequest struct {
+	Until string `json:"until,omitempty"`
+}
+
+// handlePauseProbing pauses every target's probes for a maintenance window,
+// replying with the scheduler's MaintenanceStatus. Pausing again while
+// paused only changes the resume time.
+func (s *Server) handlePauseProbing(w http.ResponseWriter, r *http.Request) {
+	if s.scheduler == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Scheduler is not running", CodeInternal)
+		return
+	}
+	var req MaintenancePauseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+		return
+	}
+	var until time.Time
+	if req.Until != "" {
+		var err error
+		if until, err = time.Parse(time.RFC3339, req.Until); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid until time", CodeInvalidTimeRange)
+			return
+		}
+		if !until.After(time.Now()) {
+			writeJSONError(w, http.StatusBadRequest, "until must be in the future", CodeInvalidTimeRange)
+			return
+		}
+	}
+
+	s.scheduler.PauseProbing(until.UTC())
+	writeMaintenanceStatus(w, s.scheduler.MaintenanceStatus())
+}
+
+// handleResumeProbing ends a pause started with handlePauseProbing.
+func (s *Server) handleResumeProbing(w http.ResponseWriter, r *http.Request) {
+	if s.scheduler == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Scheduler is not running", CodeInternal)
+		return
+	}
+	s.scheduler.ResumeProbing()
+	writeMaintenanceStatus(w, s.scheduler.MaintenanceStatus())
+}
+
+func writeMaintenanceStatus(w http.ResponseWriter, status scheduler.MaintenanceStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
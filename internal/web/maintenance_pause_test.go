@@ -0,0 +1,77 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"vaportrail/internal/scheduler"
+)
+
+func TestHandlePauseProbing(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return rr
+	}
+
+	if rr := post("/api/maintenance/pause", ""); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a scheduler, got %d", rr.Code)
+	}
+
+	s.scheduler = scheduler.New(database)
+
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	rr := post("/api/maintenance/pause", `{"until": "`+until.Format(time.RFC3339)+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var status scheduler.MaintenanceStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !status.Paused || !status.Until.Equal(until) {
+		t.Errorf("Expected a pause until %v, got %+v", until, status)
+	}
+
+	rr = httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	var health HealthStatus
+	if err := json.NewDecoder(rr.Body).Decode(&health); err != nil {
+		t.Fatalf("Failed to decode health: %v", err)
+	}
+	if health.Maintenance == nil || !health.Maintenance.Paused {
+		t.Errorf("Expected /healthz to report the pause, got %+v", health.Maintenance)
+	}
+
+	rr = post("/api/maintenance/resume", "")
+	status = scheduler.MaintenanceStatus{}
+	json.NewDecoder(rr.Body).Decode(&status)
+	if rr.Code != http.StatusOK || status.Paused {
+		t.Errorf("Expected resume to end the pause, got %d %+v", rr.Code, status)
+	}
+
+	// Without a body, the pause lasts until resumed.
+	if rr := post("/api/maintenance/pause", ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for an open-ended pause, got %d", rr.Code)
+	}
+	if st := s.scheduler.MaintenanceStatus(); !st.Paused || !st.Until.IsZero() {
+		t.Errorf("Expected an open-ended pause, got %+v", st)
+	}
+
+	for _, body := range []string{
+		`{"until": "tomorrow"}`,
+		`{"until": "` + time.Now().Add(-time.Hour).Format(time.RFC3339) + `"}`,
+		`{`,
+	} {
+		if rr := post("/api/maintenance/pause", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"vaportrail/internal/scheduler"
+)
+
+// labelEscaper escapes Prometheus label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetric writes one metric in the Prometheus text exposition format.
+func writeMetric(w io.Writer, name, kind, help string, value any) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+// handleMetrics serves scheduler metrics for Prometheus to scrape. Rollup
+// lag is computed from the database on each scrape.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if lags, err := scheduler.RollupLags(s.db, time.Now()); err != nil {
+		log.Printf("Failed to compute rollup lag: %v", err)
+	} else if len(lags) > 0 {
+		fmt.Fprint(w, "# HELP vaportrail_rollup_lag_seconds How far the newest rollup trails the newest data that could have been rolled up.\n# TYPE vaportrail_rollup_lag_seconds gauge\n")
+		for _, l := range lags {
+			fmt.Fprintf(w, "vaportrail_rollup_lag_seconds{target_id=\"%d\",target=\"%s\",window=\"%d\"} %v\n",
+				l.TargetID, labelEscaper.Replace(l.TargetName), l.WindowSeconds, l.Lag.Seconds())
+		}
+	}
+
+	if s.scheduler == nil {
+		return
+	}
+
+	stats := s.scheduler.ProbeStats()
+	writeMetric(w, "vaportrail_active_targets", "gauge", "Targets currently being probed.", s.scheduler.ActiveTargets())
+	writeMetric(w, "vaportrail_probe_rate", "gauge", "Probes started in the last full second, across all targets.", stats.Rate)
+	writeMetric(w, "vaportrail_probe_rate_limit", "gauge", "Configured maximum probes per second; 0 means unlimited.", stats.RateLimit)
+	writeMetric(w, "vaportrail_probes_started_total", "counter", "Probes started.", stats.Started)
+	writeMetric(w, "vaportrail_probes_rate_limited_total", "counter", "Probes skipped because the global probe rate limit was reached.", stats.RateLimited)
+	writeMetric(w, "vaportrail_raw_results_dropped_total", "counter", "Probe results dropped because the queue to the database writer was full.", s.scheduler.DroppedResults())
+	writeMetric(w, "vaportrail_probe_samples_rejected_total", "counter", "Probe results dropped because the probe returned an invalid latency.", s.scheduler.RejectedSamples())
+	writeMetric(w, "vaportrail_scheduler_panics_total", "counter", "Panics in probes and rollups recovered by the scheduler.", s.scheduler.Panics())
+
+	concurrency := s.scheduler.ProbeConcurrency()
+	if len(concurrency) == 0 {
+		return
+	}
+	names := make(map[int64]string, len(concurrency))
+	if targets, err := s.db.GetTargets(); err != nil {
+		log.Printf("Failed to list targets for metrics: %v", err)
+	} else {
+		for _, t := range targets {
+			names[t.ID] = t.Name
+		}
+	}
+	ids := make([]int64, 0, len(concurrency))
+	for id := range concurrency {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, m := range []struct {
+		name, kind, help string
+		value            func(scheduler.ProbeConcurrency) any
+	}{
+		{"vaportrail_target_probes_in_flight", "gauge", "Probes of the target currently running.", func(c scheduler.ProbeConcurrency) any { return c.InFlight }},
+		{"vaportrail_target_probes_in_flight_max", "gauge", "Most probes of the target that have run at once since it started.", func(c scheduler.ProbeConcurrency) any { return c.MaxInFlight }},
+		{"vaportrail_target_probes_overlap_skipped_total", "counter", "Probes of the target skipped because too many were still running.", func(c scheduler.ProbeConcurrency) any { return c.Skipped }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, id := range ids {
+			fmt.Fprintf(w, "%s{target_id=\"%d\",target=\"%s\"} %v\n", m.name, id, labelEscaper.Replace(names[id]), m.value(concurrency[id]))
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+	"vaportrail/internal/scheduler"
+)
+
+func TestHandleMetrics(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	s.scheduler = scheduler.New(database)
+	s.scheduler.SetMaxProbesPerSecond(50)
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              `Lagging "target"`,
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	if err := database.AddAggregatedResult(&db.AggregatedResult{
+		Time:          time.Now().UTC().Add(-time.Hour).Truncate(time.Minute),
+		TargetID:      id,
+		WindowSeconds: 60,
+	}); err != nil {
+		t.Fatalf("Failed to add result: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Expected Prometheus text format, got %q", ct)
+	}
+	body := rr.Body.String()
+	for _, want := range []string{
+		"# TYPE vaportrail_probe_rate gauge\nvaportrail_probe_rate 0\n",
+		"vaportrail_probe_rate_limit 50\n",
+		"# TYPE vaportrail_probes_rate_limited_total counter\nvaportrail_probes_rate_limited_total 0\n",
+		"# TYPE vaportrail_raw_results_dropped_total counter\nvaportrail_raw_results_dropped_total 0\n",
+		fmt.Sprintf(`vaportrail_rollup_lag_seconds{target_id="%d",target="Lagging \"target\"",window="60"} `, id),
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestHandleMetrics_ProbeConcurrency(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	s.scheduler = scheduler.New(database)
+	defer s.scheduler.Stop()
+
+	target := db.Target{Name: "Idle", Address: "http://example.com", ProbeType: "http", ProbeInterval: 3600}
+	id, err := database.AddTarget(&target)
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	target.ID = id
+	s.scheduler.AddTarget(target)
+
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+	for _, want := range []string{
+		fmt.Sprintf("# TYPE vaportrail_target_probes_in_flight gauge\nvaportrail_target_probes_in_flight{target_id=\"%d\",target=\"Idle\"} 0\n", id),
+		fmt.Sprintf("vaportrail_target_probes_in_flight_max{target_id=\"%d\",target=\"Idle\"} 0\n", id),
+		fmt.Sprintf("# TYPE vaportrail_target_probes_overlap_skipped_total counter\nvaportrail_target_probes_overlap_skipped_total{target_id=\"%d\",target=\"Idle\"} 0\n", id),
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/targets", nil))
+	var targets []APITarget
+	if err := json.NewDecoder(rr.Body).Decode(&targets); err != nil {
+		t.Fatalf("Failed to decode targets: %v", err)
+	}
+	if len(targets) != 1 || targets[0].Name != "Idle" || targets[0].Concurrency == nil || targets[0].Concurrency.Limit != scheduler.MaxConcurrentProbes {
+		t.Errorf("Expected the target with its concurrency, got %+v", targets)
+	}
+}
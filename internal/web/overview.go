@@ -0,0 +1,109 @@
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+	"vaportrail/internal/db"
+)
+
+// OverviewEntry is one target's row in GET /api/overview: the target and the
+// stats of its newest complete rollup, from its finest aggregated window.
+// The result fields are omitted for targets that haven't been rolled up yet.
+type OverviewEntry struct {
+	TargetID      int64      `json:"target_id"`
+	Name          string     `json:"name"`
+	Address       string     `json:"address"`
+	ProbeType     string     `json:"probe_type"`
+	Down          bool       `json:"down"`
+	Time          *time.Time `json:"time,omitempty"`
+	WindowSeconds int        `json:"window_seconds,omitempty"`
+	P50NS         *float64   `json:"p50_ns,omitempty"`
+	P99NS         *float64   `json:"p99_ns,omitempty"`
+	ProbeCount    int64      `json:"probe_count"`
+	TimeoutCount  int64      `json:"timeout_count"`
+	// Availability is the fraction of the window's probes that didn't time
+	// out.
+	Availability *float64 `json:"availability,omitempty"`
+	// LastResultTime is when the latest raw result was taken, and Stale is
+	// set once that's too long ago to pass the stats off as current.
+	LastResultTime *time.Time `json:"last_result_time,omitempty"`
+	Stale          bool       `json:"stale"`
+}
+
+// handleOverview returns the latest result of every target in one call, for
+// fleet overview dashboards.
+func (s *Server) handleOverview(w http.ResponseWriter, r *http.Request) {
+	targets, err := s.reader.GetTargets()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	latest, err := s.reader.GetLatestResults()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	lastTimes, err := s.reader.GetLastRawResultTimes()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	byTarget := make(map[int64]db.AggregatedResult, len(latest))
+	for _, res := range latest {
+		byTarget[res.TargetID] = res
+	}
+
+	now := time.Now()
+	entries := make([]OverviewEntry, len(targets))
+	for i, t := range targets {
+		entries[i] = OverviewEntry{TargetID: t.ID, Name: t.Name, Address: t.Address, ProbeType: t.ProbeType, Down: t.Down}
+		if last, ok := lastTimes[t.ID]; ok {
+			entries[i].LastResultTime = &last
+			entries[i].Stale = s.isStale(t, last, now)
+		}
+		res, ok := byTarget[t.ID]
+		if !ok {
+			continue
+		}
+		stats := s.digestStats(res)
+		entry := &entries[i]
+		entry.Time = &res.Time
+		entry.WindowSeconds = res.WindowSeconds
+		entry.P50NS = stats.P50
+		entry.P99NS = stats.P99
+		entry.ProbeCount = stats.ProbeCount + res.TimeoutCount
+		entry.TimeoutCount = res.TimeoutCount
+		if entry.ProbeCount > 0 {
+			entry.Availability = ptr(float64(stats.ProbeCount) / float64(entry.ProbeCount))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// digestStats returns the latency stats of an aggregated result, through the
+// digest cache.
+func (s *Server) digestStats(res db.AggregatedResult) APIResult {
+	var stats APIResult
+	if len(res.TDigestData) == 0 {
+		if res.SampleCount > 0 {
+			fillSummaryStats(&stats, res)
+		}
+		return stats
+	}
+	key := newDigestKey(res.TargetID, res.Time, res.WindowSeconds)
+	if s.digests.get(key, res.TDigestData, &stats) {
+		return stats
+	}
+	td, err := db.DeserializeTDigest(res.TDigestData)
+	if err != nil {
+		log.Printf("Warning: unreadable t-digest for target %d window %ds at %s: %v", res.TargetID, res.WindowSeconds, res.Time.Format(time.RFC3339), err)
+		return stats
+	}
+	fillDigestStats(&stats, td, s.cfg.MinSamplesForPercentiles)
+	s.digests.put(key, res.TDigestData, &stats)
+	return stats
+}
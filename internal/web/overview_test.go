@@ -0,0 +1,123 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func TestHandleOverview(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	up, _ := database.AddTarget(&db.Target{Name: "Up", Address: "http://up.example", ProbeType: "http"})
+	database.AddTarget(&db.Target{Name: "New", Address: "http://new.example", ProbeType: "http"})
+
+	td, _ := tdigest.New(tdigest.Compression(100))
+	for i := 1; i <= 9; i++ {
+		td.Add(float64(i) * 1e6)
+	}
+	data, err := db.SerializeTDigest(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Minute)
+	database.AddAggregatedResult(&db.AggregatedResult{Time: now, TargetID: up, WindowSeconds: 60, TDigestData: data, TimeoutCount: 1})
+
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/overview", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var entries []OverviewEntry
+	if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected an entry per target, got %+v", entries)
+	}
+
+	e := entries[0]
+	if e.Name != "Up" || e.Time == nil || !e.Time.Equal(now) || e.WindowSeconds != 60 {
+		t.Errorf("Unexpected entry for Up: %+v", e)
+	}
+	if e.P50NS == nil || *e.P50NS != 5e6 || e.P99NS == nil {
+		t.Errorf("Expected p50 5ms and a p99, got %v and %v", e.P50NS, e.P99NS)
+	}
+	if e.ProbeCount != 10 || e.TimeoutCount != 1 || e.Availability == nil || *e.Availability != 0.9 {
+		t.Errorf("Expected 10 probes, 1 timeout and 0.9 availability, got %+v", e)
+	}
+	if e := entries[1]; e.Name != "New" || e.Time != nil || e.Availability != nil {
+		t.Errorf("Expected no result for a target without rollups, got %+v", e)
+	}
+}
+
+func TestHandleOverview_Stale(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	s.cfg.StaleAfterIntervals = 5
+
+	fresh, _ := database.AddTarget(&db.Target{Name: "Fresh", Address: "http://fresh.example", ProbeType: "http", ProbeInterval: 1, Timeout: 2})
+	stale, _ := database.AddTarget(&db.Target{Name: "Stale", Address: "http://stale.example", ProbeType: "http", ProbeInterval: 1, Timeout: 2})
+	database.AddTarget(&db.Target{Name: "New", Address: "http://new.example", ProbeType: "http", ProbeInterval: 1, Timeout: 2})
+	now := time.Now().UTC()
+	database.AddRawResults([]db.RawResult{
+		// Within 5 timeouts, the longer of interval and timeout.
+		{Time: now.Add(-8 * time.Second), TargetID: fresh, Latency: 100},
+		{Time: now.Add(-time.Minute), TargetID: stale, Latency: 100},
+	})
+
+	get := func(path string, v any) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 from %s, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+
+	var entries []OverviewEntry
+	get("/api/overview", &entries)
+	if len(entries) != 3 {
+		t.Fatalf("Expected an entry per target, got %+v", entries)
+	}
+	if e := entries[0]; e.Stale || e.LastResultTime == nil {
+		t.Errorf("Expected Fresh not to be stale, got %+v", e)
+	}
+	if e := entries[1]; !e.Stale || e.LastResultTime == nil || !e.LastResultTime.Equal(now.Add(-time.Minute)) {
+		t.Errorf("Expected Stale to be stale, got %+v", e)
+	}
+	if e := entries[2]; e.Stale || e.LastResultTime != nil {
+		t.Errorf("Expected a target without results not to be stale, got %+v", e)
+	}
+
+	var targets []APITarget
+	get("/api/targets", &targets)
+	if len(targets) != 3 || targets[0].Stale || !targets[1].Stale || targets[2].Stale {
+		t.Errorf("Expected only Stale to be stale in /api/targets, got %+v", targets)
+	}
+
+	// Nor is a target whose schedule stopped its probes within the limit.
+	scheduled := db.Target{ProbeInterval: 1, Timeout: 2, Schedule: "daily 09:00-17:00"}
+	at := time.Date(2024, 1, 1, 17, 0, 5, 0, time.UTC)
+	if s.isStale(scheduled, at.Add(-time.Minute), at) {
+		t.Error("Expected a target off its schedule not to be stale")
+	}
+	if !s.isStale(scheduled, at.Add(-2*time.Hour), at.Add(-time.Hour)) {
+		t.Error("Expected a target on its schedule to be stale")
+	}
+
+	s.cfg.StaleAfterIntervals = 0
+	get("/api/overview", &entries)
+	if entries[1].Stale {
+		t.Error("Expected no stale targets with StaleAfterIntervals 0")
+	}
+}
@@ -0,0 +1,83 @@
+package web
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"vaportrail/internal/db"
+	"vaportrail/internal/scheduler"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+// parsePercentiles reads the percentiles parameter of the results API: a
+// comma-separated list of up to scheduler.MaxStoredPercentiles percentiles
+// from 0 to 100, such as "50,95,99.9".
+func parsePercentiles(s string) ([]float64, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) > scheduler.MaxStoredPercentiles {
+		return nil, fmt.Errorf("percentiles may list at most %d values", scheduler.MaxStoredPercentiles)
+	}
+	percentiles := make([]float64, 0, len(fields))
+	for _, field := range fields {
+		p, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || math.IsNaN(p) || p < 0 || p > 100 {
+			return nil, fmt.Errorf(`percentiles must be a comma-separated list of numbers from 0 to 100, such as "50,95,99"`)
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
+
+// fillStoredPercentiles populates apiRes from the percentiles stored with a
+// rollup and its exact moments, without decoding its digest. It reports
+// false, leaving apiRes alone, unless the rollup stored all of percentiles.
+func fillStoredPercentiles(apiRes *APIResult, res db.AggregatedResult, percentiles []float64, minSamples int) bool {
+	if res.SampleCount == 0 {
+		return false
+	}
+	quantiles := make(map[string]float64, len(percentiles))
+	for _, p := range percentiles {
+		name := db.PercentileName(p)
+		v, ok := res.Percentiles[name]
+		if !ok {
+			return false
+		}
+		quantiles[name] = sanitizeFloat(v)
+	}
+	apiRes.ProbeCount = res.SampleCount
+	apiRes.MinNS = ptr(latencyNS(res.MinNS))
+	apiRes.MaxNS = ptr(latencyNS(res.MaxNS))
+	apiRes.AvgNS = ptr(latencyNS(res.SumNS / float64(res.SampleCount)))
+	if res.SampleCount < int64(minSamples) {
+		apiRes.InsufficientSamples = true
+		return true
+	}
+	setQuantiles(apiRes, quantiles)
+	return true
+}
+
+// selectPercentiles replaces the percentiles fillDigestStats set on apiRes
+// with just those requested, computed from td.
+func selectPercentiles(apiRes *APIResult, td *tdigest.TDigest, percentiles []float64) {
+	apiRes.P0, apiRes.P1, apiRes.P25, apiRes.P50, apiRes.P75, apiRes.P99, apiRes.P100 = nil, nil, nil, nil, nil, nil, nil
+	apiRes.Percentiles = nil
+	if apiRes.empty || apiRes.InsufficientSamples {
+		return
+	}
+	quantiles := make(map[string]float64, len(percentiles))
+	for _, p := range percentiles {
+		quantiles[db.PercentileName(p)] = sanitizeFloat(td.Quantile(p / 100))
+	}
+	setQuantiles(apiRes, quantiles)
+}
+
+// setQuantiles sets apiRes's Quantiles, and P50 if they include it, which
+// moving averages and baselines are computed from.
+func setQuantiles(apiRes *APIResult, quantiles map[string]float64) {
+	apiRes.Quantiles = quantiles
+	if p50, ok := quantiles[db.PercentileName(50)]; ok {
+		apiRes.P50 = ptr(p50)
+	}
+}
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func TestHandleGetResults_Percentiles(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Percentiles",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Minute)
+	td, _ := tdigest.New(tdigest.Compression(100))
+	for range 10 {
+		td.Add(float64(time.Second))
+	}
+	data, _ := db.SerializeTDigest(td)
+	for _, res := range []*db.AggregatedResult{
+		// The stored percentiles are served without reading the digest,
+		// which here is unreadable.
+		{
+			Time: now.Add(-10 * time.Minute), TargetID: id, WindowSeconds: 60, TDigestData: []byte("not a digest"),
+			SampleCount: 10, SumNS: 10 * 2e6, MinNS: 1e6, MaxNS: 3e6,
+			Percentiles: map[string]float64{"p50": 2e6, "p99": 3e6},
+		},
+		{Time: now.Add(-9 * time.Minute), TargetID: id, WindowSeconds: 60, TDigestData: data, SampleCount: 10, SumNS: 10 * 1e9, MinNS: 1e9, MaxNS: 1e9},
+	} {
+		if err := database.AddAggregatedResult(res); err != nil {
+			t.Fatalf("Failed to add result: %v", err)
+		}
+	}
+
+	get := func(query string) (int, []APIResult) {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/results/"+strconv.FormatInt(id, 10)+query, nil))
+		var results []APIResult
+		json.NewDecoder(rr.Body).Decode(&results)
+		return rr.Code, results
+	}
+
+	code, results := get("?percentiles=50,99")
+	if code != http.StatusOK || len(results) != 2 {
+		t.Fatalf("Expected 2 results, got status %d and %d results", code, len(results))
+	}
+	stored, computed := results[0], results[1]
+	if stored.DigestCorrupt || !maps.Equal(stored.Quantiles, map[string]float64{"p50": 2e6, "p99": 3e6}) {
+		t.Errorf("Expected the stored percentiles, got %+v", stored)
+	}
+	if stored.P50 == nil || *stored.P50 != 2e6 || stored.P99 != nil || stored.Percentiles != nil {
+		t.Errorf("Expected only P50 besides the requested percentiles, got %+v", stored)
+	}
+	if stored.ProbeCount != 10 || *stored.MinNS != 1e6 || *stored.AvgNS != 2e6 || *stored.MaxNS != 3e6 {
+		t.Errorf("Expected the stats from the stored moments, got %+v", stored)
+	}
+	if !maps.Equal(computed.Quantiles, map[string]float64{"p50": 1e9, "p99": 1e9}) || computed.P0 != nil {
+		t.Errorf("Expected percentiles computed from the digest, got %+v", computed)
+	}
+
+	// A percentile that wasn't stored needs the digest.
+	if _, results := get("?percentiles=50,95"); len(results) != 2 || !results[0].DigestCorrupt || results[0].Quantiles != nil {
+		t.Errorf("Expected the unreadable digest to be reported, got %+v", results)
+	}
+
+	if _, results := get("?percentiles=50&unit=ms"); len(results) != 2 || results[1].Quantiles["p50"] != 1000 {
+		t.Errorf("Expected percentiles in ms, got %+v", results)
+	}
+
+	if _, results := get(""); len(results) != 2 || results[1].Quantiles != nil || results[1].P99 == nil {
+		t.Errorf("Expected the usual percentiles without the parameter, got %+v", results)
+	}
+	for _, query := range []string{"?percentiles=101", "?percentiles=x", "?percentiles=50,", "?percentiles=50&raw=true"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, code)
+		}
+	}
+}
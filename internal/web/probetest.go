@@ -0,0 +1,105 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"vaportrail/internal/db"
+	"vaportrail/internal/probe"
+	"vaportrail/internal/scheduler"
+)
+
+// ProbeTestRequest is a proposed probe configuration for
+// POST /api/probe-test. Timeout is in seconds, and defaults to the probe
+// type's like a new target's.
+type ProbeTestRequest struct {
+	ProbeType   string          `json:"probe_type"`
+	Address     string          `json:"address"`
+	ProbeConfig json.RawMessage `json:"probe_config,omitempty"`
+	Timeout     float64         `json:"timeout,omitempty"`
+}
+
+// ProbeTestResult is the outcome of a test probe. Error is the probe's own
+// error, such as a command's output when its pattern didn't match.
+//
+// For a command probe, RawValue is the number its pattern extracted and
+// Multiplier what that was multiplied by to get LatencyNS, so the units can
+// be checked by hand. Warning is set when LatencyNS falls outside the
+// configured PlausibleLatencyMin and PlausibleLatencyMax, which usually
+// means the multiplier is off by some power of 1000.
+type ProbeTestResult struct {
+	Address    string   `json:"address"` // as normalized for a target
+	LatencyNS  float64  `json:"latency_ns,omitempty"`
+	RawValue   *float64 `json:"raw_value,omitempty"`
+	Multiplier float64  `json:"multiplier,omitempty"`
+	Warning    string   `json:"warning,omitempty"`
+	TimedOut   bool     `json:"timed_out,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// handleProbeTest validates a probe configuration the way creating a target
+// would and runs a single probe with it, without storing anything. A probe
+// that fails still answers 200, with the error in the result; a
+// configuration that is invalid answers 400.
+func (s *Server) handleProbeTest(w http.ResponseWriter, r *http.Request) {
+	var req ProbeTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+		return
+	}
+	t := db.Target{
+		Name:      "probe-test", // Only to pass validation.
+		Address:   req.Address,
+		ProbeType: req.ProbeType,
+		Timeout:   req.Timeout,
+	}
+	if len(req.ProbeConfig) > 0 && string(req.ProbeConfig) != "null" {
+		t.ProbeConfig = string(req.ProbeConfig)
+	}
+	if err := normalizeTarget(&t); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), targetErrorCode(err))
+		return
+	}
+	cfg, _, err := scheduler.TargetProbeConfig(t)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid probe config: "+err.Error(), CodeInvalidProbeConfig)
+		return
+	}
+	var runner probe.Runner = probe.RealRunner{}
+	if s.scheduler != nil {
+		runner = s.scheduler.Runner()
+	}
+
+	result := ProbeTestResult{Address: t.Address}
+	latency, err := runner.Run(cfg)
+	if err != nil {
+		result.Error = err.Error()
+		result.TimedOut = strings.Contains(result.Error, "probe timed out")
+	} else {
+		result.LatencyNS = latency
+		checkPlausibleLatency(&result, cfg, s.cfg.PlausibleLatencyMin, s.cfg.PlausibleLatencyMax)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// checkPlausibleLatency fills in a successful result's RawValue and
+// Multiplier, for a command probe, and warns if its latency is outside
+// [lo, hi]. A zero bound is no bound.
+func checkPlausibleLatency(result *ProbeTestResult, cfg probe.Config, lo, hi time.Duration) {
+	if cfg.Command != "" && cfg.Multiplier != 0 {
+		raw := result.LatencyNS / cfg.Multiplier
+		result.RawValue = &raw
+		result.Multiplier = cfg.Multiplier
+	}
+	latency := time.Duration(result.LatencyNS)
+	if (lo <= 0 || latency >= lo) && (hi <= 0 || latency <= hi) {
+		return
+	}
+	result.Warning = fmt.Sprintf("Latency of %v is outside the plausible range of %v to %v", latency, lo, hi)
+	if result.RawValue != nil {
+		result.Warning += fmt.Sprintf("; the multiplier of %g applied to the extracted value %g is likely wrong", result.Multiplier, *result.RawValue)
+	}
+}
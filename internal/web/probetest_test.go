@@ -0,0 +1,101 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"vaportrail/internal/probe"
+)
+
+func TestHandleProbeTest(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+	}))
+	defer backend.Close()
+
+	post := func(body string) (*httptest.ResponseRecorder, ProbeTestResult) {
+		req := httptest.NewRequest("POST", "/api/probe-test", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, req)
+		var result ProbeTestResult
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatalf("Failed to decode result: %v", err)
+			}
+		}
+		return rr, result
+	}
+
+	rr, result := post(`{"probe_type": "http", "address": "` + backend.URL + `"}`)
+	if rr.Code != http.StatusOK || result.Error != "" || result.LatencyNS <= 0 {
+		t.Errorf("Expected a successful probe, got %d %+v", rr.Code, result)
+	}
+
+	rr, result = post(`{"probe_type": "http", "address": "` + backend.URL + `/slow", "timeout": 0.1}`)
+	if rr.Code != http.StatusOK || !result.TimedOut || result.Error == "" {
+		t.Errorf("Expected a timed out probe, got %d %+v", rr.Code, result)
+	}
+
+	rr, result = post(`{"probe_type": "http", "address": "` + backend.URL + `", "probe_config": {"expected_status": "500"}}`)
+	if rr.Code != http.StatusOK || result.TimedOut || !strings.Contains(result.Error, "unexpected_status") {
+		t.Errorf("Expected an unexpected status error, got %d %+v", rr.Code, result)
+	}
+
+	s.cfg.PlausibleLatencyMin = time.Hour
+	rr, result = post(`{"probe_type": "http", "address": "` + backend.URL + `"}`)
+	if rr.Code != http.StatusOK || !strings.Contains(result.Warning, "plausible") || result.RawValue != nil {
+		t.Errorf("Expected an implausible latency warning, got %d %+v", rr.Code, result)
+	}
+	s.cfg.PlausibleLatencyMin = 0
+
+	// Invalid configurations are rejected without probing.
+	for _, body := range []string{
+		`{"probe_type": "carrier-pigeon", "address": "example.com"}`,
+		`{"probe_type": "http", "address": "` + backend.URL + `", "probe_config": {"nope": true}}`,
+		`{"probe_type": "http"}`,
+	} {
+		if rr, _ := post(body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, rr.Code)
+		}
+	}
+
+	// It runs probes, so it needs the write token.
+	s.cfg.WriteToken = "secret"
+	if rr, _ := post(`{"probe_type": "http", "address": "` + backend.URL + `"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the write token, got %d", rr.Code)
+	}
+}
+
+func TestCheckPlausibleLatency(t *testing.T) {
+	cfg, err := probe.GetConfig("ping", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := ProbeTestResult{LatencyNS: 1.5e6}
+	checkPlausibleLatency(&result, cfg, time.Microsecond, time.Minute)
+	if result.Warning != "" || result.RawValue == nil || *result.RawValue != 1.5 || result.Multiplier != 1e6 {
+		t.Errorf("Expected 1.5ms to be plausible, with its raw value, got %+v", result)
+	}
+
+	// A value in seconds read as milliseconds.
+	result = ProbeTestResult{LatencyNS: 120e9}
+	checkPlausibleLatency(&result, cfg, time.Microsecond, time.Minute)
+	if !strings.Contains(result.Warning, "multiplier") || *result.RawValue != 120000 {
+		t.Errorf("Expected a multiplier warning, got %+v", result)
+	}
+
+	result = ProbeTestResult{LatencyNS: 120e9}
+	checkPlausibleLatency(&result, cfg, 0, 0)
+	if result.Warning != "" {
+		t.Errorf("Expected no warning without bounds, got %+v", result)
+	}
+}
@@ -0,0 +1,52 @@
+package web
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+	"vaportrail/internal/db"
+)
+
+// maxRawBuckets caps the number of buckets a bucket= request may span.
+const maxRawBuckets = 1000
+
+// parseRawBucket reads the bucket parameter of a raw results request over
+// [start, end): a duration of whole seconds, such as "30s" or "5m", that
+// splits the range into at most maxRawBuckets buckets. Buckets are
+// summarized rather than paged, so bucket can't be combined with the paging
+// parameters, nor with apdex_threshold, which needs every sample.
+func parseRawBucket(q url.Values, start, end time.Time) (time.Duration, error) {
+	bucket, err := time.ParseDuration(q.Get("bucket"))
+	if err != nil || bucket < time.Second || bucket%time.Second != 0 {
+		return 0, fmt.Errorf(`bucket must be a whole number of seconds, such as "30s" or "5m"`)
+	}
+	if n := (end.Sub(start) + bucket - 1) / bucket; n > maxRawBuckets {
+		return 0, fmt.Errorf("bucket %v splits the range into %d buckets; the most allowed is %d", bucket, n, maxRawBuckets)
+	}
+	for _, param := range []string{"after", "limit", "order", "apdex_threshold"} {
+		if q.Get(param) != "" {
+			return 0, fmt.Errorf("bucket can't be combined with %s", param)
+		}
+	}
+	return bucket, nil
+}
+
+// rawBucketResult reports a bucket of raw results like a rollup window
+// without a digest: min, average and max, but no percentiles.
+func rawBucketResult(b db.RawBucket, targetID int64, bucket time.Duration) APIResult {
+	apiRes := APIResult{
+		Time:          b.Time,
+		TargetID:      targetID,
+		ProbeCount:    b.Count,
+		TimeoutCount:  b.TimeoutCount,
+		WindowSeconds: int(bucket / time.Second),
+	}
+	if b.Count == 0 {
+		apiRes.empty = true // Nothing but timeouts.
+		return apiRes
+	}
+	apiRes.MinNS = ptr(latencyNS(b.MinNS))
+	apiRes.AvgNS = ptr(latencyNS(b.AvgNS))
+	apiRes.MaxNS = ptr(latencyNS(b.MaxNS))
+	return apiRes
+}
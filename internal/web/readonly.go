@@ -0,0 +1,45 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// readOnlyQueries are the routes that take a POST body but only read, so
+// they keep working in read-only mode.
+var readOnlyQueries = map[string]bool{
+	"/api/results/merge":        true,
+	"/api/results/{id}/compare": true,
+}
+
+// rejectWritesWhenReadOnly answers every POST, PUT, PATCH and DELETE with 403
+// while cfg.ReadOnly is set, except for readOnlyQueries. Unlike
+// requireWriteToken, no credentials let a request through.
+func (s *Server) rejectWritesWhenReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.ReadOnly && isWriteMethod(r.Method) && !s.isReadOnlyQuery(r) {
+			writeJSONError(w, http.StatusForbidden, "This server is read-only; changes are disabled", CodeReadOnly)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isReadOnlyQuery reports whether r is routed to one of readOnlyQueries.
+// Middleware runs before routing, so the route is matched here.
+func (s *Server) isReadOnlyQuery(r *http.Request) bool {
+	rctx := chi.NewRouteContext()
+	if !s.router.Match(rctx, r.Method, r.URL.Path) {
+		return false
+	}
+	return readOnlyQueries[rctx.RoutePattern()]
+}
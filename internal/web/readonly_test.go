@@ -0,0 +1,71 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"vaportrail/internal/db"
+)
+
+func TestReadOnlyMode(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	s.cfg.ReadOnly = true
+	s.cfg.WriteToken = "secret"
+
+	id, err := database.AddTarget(&db.Target{Name: "Demo", Address: "example.com", ProbeType: "http"})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	writes := []struct{ method, path, body string }{
+		{"POST", "/api/targets", `{"Name": "New", "Address": "example.org", "ProbeType": "http"}`},
+		{"PUT", fmt.Sprintf("/api/targets/%d", id), `{"Name": "Renamed", "Address": "example.com", "ProbeType": "http"}`},
+		{"DELETE", fmt.Sprintf("/api/targets/%d", id), ""},
+		{"DELETE", fmt.Sprintf("/api/results/%d", id), ""},
+		{"POST", "/api/dashboards", `{"name": "Demo"}`},
+		{"PATCH", "/api/targets", ""},
+	}
+	for _, tc := range writes {
+		rr := do(tc.method, tc.path, tc.body)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected status 403, got %d", tc.method, tc.path, rr.Code)
+			continue
+		}
+		var resp ErrorResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || resp.Code != CodeReadOnly {
+			t.Errorf("%s %s: expected code %q, got %+v (%v)", tc.method, tc.path, CodeReadOnly, resp, err)
+		}
+	}
+	target, err := database.GetTarget(id)
+	if err != nil || target.Name != "Demo" {
+		t.Errorf("Expected the target to be unchanged, got %+v (%v)", target, err)
+	}
+
+	for _, path := range []string{"/api/targets", "/api/targets/export", "/api/dashboards", "/api/overview"} {
+		if rr := do("GET", path, ""); rr.Code != http.StatusOK {
+			t.Errorf("GET %s: expected status 200, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+	}
+	// Queries that take a POST body still work.
+	if rr := do("POST", "/api/results/merge", fmt.Sprintf(`{"target_ids": [%d]}`, id)); rr.Code == http.StatusForbidden {
+		t.Errorf("Expected merge to be allowed in read-only mode, got 403")
+	}
+
+	s.cfg.ReadOnly = false
+	if rr := do("DELETE", fmt.Sprintf("/api/targets/%d", id), ""); rr.Code == http.StatusForbidden {
+		t.Errorf("Expected writes to be allowed outside read-only mode, got 403")
+	}
+}
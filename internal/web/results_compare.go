@@ -0,0 +1,213 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"vaportrail/internal/db"
+	"vaportrail/internal/scheduler"
+
+	"github.com/caio/go-tdigest/v4"
+	"github.com/go-chi/chi/v5"
+)
+
+// TimeRange is an RFC3339 start and end time.
+type TimeRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// CompareResultsRequest is the body of POST /api/results/{id}/compare.
+type CompareResultsRequest struct {
+	A TimeRange `json:"a"`
+	B TimeRange `json:"b"`
+}
+
+// RangeStats summarizes the latency distribution of one compared range.
+// Percentiles are in nanoseconds and are omitted when the range has fewer
+// probes than MinSamplesForPercentiles.
+//
+// A target with summary aggregation has no digests; its ranges have
+// PercentilesUnavailable set, only the exact p0 and p100, and AvgNS.
+type RangeStats struct {
+	Start                  time.Time          `json:"start"`
+	End                    time.Time          `json:"end"`
+	ProbeCount             int64              `json:"probe_count"`
+	TimeoutCount           int64              `json:"timeout_count"`
+	Percentiles            map[string]float64 `json:"percentiles,omitempty"`
+	AvgNS                  *float64           `json:"avg_ns,omitempty"`
+	PercentilesUnavailable bool               `json:"percentiles_unavailable,omitempty"`
+	InsufficientSamples    bool               `json:"insufficient_samples,omitempty"`
+	DigestCorrupt          bool               `json:"digest_corrupt,omitempty"`
+}
+
+// CompareResultsResponse holds both ranges side by side. Delta is B minus A
+// for each percentile both ranges report, so a positive delta means B was
+// slower.
+type CompareResultsResponse struct {
+	WindowSeconds int                `json:"window_seconds"`
+	A             RangeStats         `json:"a"`
+	B             RangeStats         `json:"b"`
+	Delta         map[string]float64 `json:"delta"`
+}
+
+// compareQuantiles are the percentiles reported by the compare endpoint.
+var compareQuantiles = []struct {
+	name string
+	q    float64
+}{
+	{"p0", 0}, {"p1", 0.01}, {"p25", 0.25}, {"p50", 0.5}, {"p75", 0.75},
+	{"p90", 0.9}, {"p95", 0.95}, {"p99", 0.99}, {"p100", 1},
+}
+
+// handleCompareResults merges a target's digests over each of two time
+// ranges, for before/after comparisons such as around a deploy. Both ranges
+// are read from the same rollup window, chosen for the longer of the two, and
+// are widened to whole windows so they're summarized the same way.
+func (s *Server) handleCompareResults(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
+		return
+	}
+	var req CompareResultsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+		return
+	}
+	aStart, aEnd, err := parseTimeRange(req.A)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Range a: "+err.Error(), CodeInvalidTimeRange)
+		return
+	}
+	bStart, bEnd, err := parseTimeRange(req.B)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Range b: "+err.Error(), CodeInvalidTimeRange)
+		return
+	}
+
+	target, err := s.reader.GetTarget(id)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Target not found: "+err.Error(), CodeTargetNotFound)
+		return
+	}
+	policies, err := scheduler.GetRetentionPolicies(*target)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Target has no retention policies configured", CodeNoRetentionPolicies)
+		return
+	}
+	window := selectWindow(policies, aStart, aEnd)
+	if bEnd.Sub(bStart) > aEnd.Sub(aStart) {
+		window = selectWindow(policies, bStart, bEnd)
+	}
+
+	resp := CompareResultsResponse{WindowSeconds: window, Delta: map[string]float64{}}
+	if resp.A, err = s.rangeStats(id, window, aStart, aEnd); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	if resp.B, err = s.rangeStats(id, window, bStart, bEnd); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	for name, a := range resp.A.Percentiles {
+		if b, ok := resp.B.Percentiles[name]; ok {
+			resp.Delta[name] = b - a
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func parseTimeRange(tr TimeRange) (time.Time, time.Time, error) {
+	start, err := time.Parse(time.RFC3339, tr.Start)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("invalid start time")
+	}
+	end, err := time.Parse(time.RFC3339, tr.End)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("invalid end time")
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, errors.New("end must be after start")
+	}
+	return start.UTC(), end.UTC(), nil
+}
+
+// rangeStats merges every digest of the given window size that starts in
+// [start, end), after widening the range to whole windows.
+func (s *Server) rangeStats(id int64, window int, start, end time.Time) (RangeStats, error) {
+	size := time.Duration(window) * time.Second
+	stats := RangeStats{Start: start.Truncate(size), End: end.Truncate(size)}
+	if stats.End.Before(end) {
+		stats.End = stats.End.Add(size)
+	}
+
+	results, err := s.reader.GetAggregatedResults(id, window, stats.Start, stats.End)
+	if err != nil {
+		return stats, err
+	}
+	var merged *tdigest.TDigest
+	var moments summaryMoments
+	var summary bool
+	for _, res := range results {
+		stats.TimeoutCount += res.TimeoutCount
+		moments.add(res)
+		if len(res.TDigestData) == 0 {
+			summary = summary || res.SampleCount > 0
+			continue
+		}
+		td, err := db.DeserializeTDigest(res.TDigestData)
+		if err != nil {
+			log.Printf("Warning: unreadable t-digest for target %d window %ds at %s: %v", res.TargetID, res.WindowSeconds, res.Time.Format(time.RFC3339), err)
+			stats.DigestCorrupt = true
+			continue
+		}
+		if td.Count() == 0 {
+			continue // An empty rollup; merging is weighted by count anyway.
+		}
+		if merged == nil {
+			merged = td
+		} else {
+			merged.Merge(td)
+		}
+	}
+	if summary {
+		// The digests, if any, only cover part of the range.
+		return summaryRangeStats(stats, moments.merged()), nil
+	}
+	if merged == nil || merged.Count() == 0 {
+		return stats, nil
+	}
+
+	stats.ProbeCount = int64(merged.Count())
+	if stats.ProbeCount < int64(s.cfg.MinSamplesForPercentiles) {
+		stats.InsufficientSamples = true
+		return stats, nil
+	}
+	stats.Percentiles = make(map[string]float64, len(compareQuantiles))
+	for _, cq := range compareQuantiles {
+		stats.Percentiles[cq.name] = sanitizeFloat(merged.Quantile(cq.q))
+	}
+	return stats, nil
+}
+
+// summaryRangeStats fills stats from the merged moments of a range without
+// digests.
+func summaryRangeStats(stats RangeStats, res db.AggregatedResult) RangeStats {
+	var summary APIResult
+	fillSummaryStats(&summary, res)
+	stats.ProbeCount = summary.ProbeCount
+	stats.PercentilesUnavailable = true
+	if summary.AvgNS != nil {
+		stats.AvgNS = ptr(float64(*summary.AvgNS))
+	}
+	if summary.MinNS != nil {
+		stats.Percentiles = map[string]float64{"p0": float64(*summary.MinNS), "p100": float64(*summary.MaxNS)}
+	}
+	return stats
+}
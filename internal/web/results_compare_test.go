@@ -0,0 +1,134 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func TestHandleCompareResults(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Test Target",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	addResult := func(at time.Time, latencies ...float64) {
+		td, _ := tdigest.New(tdigest.Compression(100))
+		for _, l := range latencies {
+			td.Add(l)
+		}
+		data, _ := db.SerializeTDigest(td)
+		if err := database.AddAggregatedResult(&db.AggregatedResult{
+			Time:          at,
+			TargetID:      id,
+			WindowSeconds: 60,
+			TDigestData:   data,
+			TimeoutCount:  1,
+		}); err != nil {
+			t.Fatalf("Failed to add result: %v", err)
+		}
+	}
+	// "Yesterday": two windows at 100ns.
+	yesterday := now.Add(-24 * time.Hour)
+	addResult(yesterday.Add(-30*time.Minute), 100, 100)
+	addResult(yesterday.Add(-10*time.Minute), 100)
+	// Today: 300ns.
+	addResult(now.Add(-20*time.Minute), 300, 300, 300)
+
+	compare := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/results/"+strconv.FormatInt(id, 10)+"/compare", strings.NewReader(body))
+		s.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Range b starts mid-window; it is widened to the window's start.
+	body := fmt.Sprintf(`{"a": {"start": %q, "end": %q}, "b": {"start": %q, "end": %q}}`,
+		yesterday.Add(-time.Hour).Format(time.RFC3339), yesterday.Format(time.RFC3339),
+		now.Add(-time.Hour+30*time.Second).Format(time.RFC3339), now.Format(time.RFC3339))
+	rr := compare(body)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var resp CompareResultsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if resp.WindowSeconds != 60 {
+		t.Errorf("Expected window 60, got %d", resp.WindowSeconds)
+	}
+	if !resp.B.Start.Equal(now.Add(-time.Hour)) {
+		t.Errorf("Expected range b to start at %s, got %s", now.Add(-time.Hour), resp.B.Start)
+	}
+	if resp.A.ProbeCount != 3 || resp.A.TimeoutCount != 2 {
+		t.Errorf("Range a: expected 3 probes and 2 timeouts, got %+v", resp.A)
+	}
+	if resp.B.ProbeCount != 3 || resp.B.TimeoutCount != 1 {
+		t.Errorf("Range b: expected 3 probes and 1 timeout, got %+v", resp.B)
+	}
+	if resp.A.Percentiles["p50"] != 100 || resp.B.Percentiles["p50"] != 300 {
+		t.Errorf("Expected p50s of 100 and 300, got %v and %v", resp.A.Percentiles["p50"], resp.B.Percentiles["p50"])
+	}
+	if resp.Delta["p50"] != 200 {
+		t.Errorf("Expected a p50 delta of 200, got %v", resp.Delta["p50"])
+	}
+
+	for _, bad := range []string{
+		`{"a": {"start": "yesterday", "end": "today"}}`,
+		fmt.Sprintf(`{"a": {"start": %q, "end": %q}, "b": {"start": %q, "end": %q}}`,
+			now.Format(time.RFC3339), now.Add(-time.Hour).Format(time.RFC3339),
+			now.Add(-time.Hour).Format(time.RFC3339), now.Format(time.RFC3339)),
+	} {
+		if rr := compare(bad); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %v", bad, rr.Code)
+		}
+	}
+}
+
+func TestHandleCompareResults_SummaryAggregation(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	id, start := addSummaryTarget(t, database, "summary")
+
+	body := fmt.Sprintf(`{"a": {"start": %q, "end": %q}, "b": {"start": %q, "end": %q}}`,
+		start.Format(time.RFC3339), start.Add(time.Minute).Format(time.RFC3339),
+		start.Add(time.Minute).Format(time.RFC3339), start.Add(2*time.Minute).Format(time.RFC3339))
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/results/"+strconv.FormatInt(id, 10)+"/compare", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var resp CompareResultsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	a, b := resp.A, resp.B
+	if a.ProbeCount != 2 || !a.PercentilesUnavailable || a.AvgNS == nil || *a.AvgNS != 200 || a.Percentiles["p0"] != 100 || a.Percentiles["p100"] != 300 {
+		t.Errorf("Unexpected summary of range a: %+v", a)
+	}
+	if b.ProbeCount != 2 || b.TimeoutCount != 1 || *b.AvgNS != 500 || len(b.Percentiles) != 2 {
+		t.Errorf("Unexpected summary of range b: %+v", b)
+	}
+	if resp.Delta["p100"] != 200 || resp.Delta["p0"] != 400 {
+		t.Errorf("Expected deltas of the extremes, got %v", resp.Delta)
+	}
+}
@@ -0,0 +1,72 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DeleteResultsResponse reports how many results a range delete removed.
+type DeleteResultsResponse struct {
+	RawResults        int64 `json:"raw_results"`
+	AggregatedResults int64 `json:"aggregated_results"`
+}
+
+// handleDeleteResults purges a target's results in a time range, e.g. data
+// recorded while a collector was misconfigured, without waiting for
+// retention. Query parameters:
+//
+//	start, end required RFC3339 range; results in [start, end) are deleted
+//	window     optional; 0 deletes only raw results, and a rollup window in
+//	           seconds deletes only that window's aggregated results
+//
+// Without window, raw results and every window's aggregated results in the
+// range are deleted.
+func (s *Server) handleDeleteResults(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("start") == "" || q.Get("end") == "" {
+		writeJSONError(w, http.StatusBadRequest, "start and end are required", CodeInvalidTimeRange)
+		return
+	}
+	start, end, err := parseTimeRange(TimeRange{Start: q.Get("start"), End: q.Get("end")})
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+		return
+	}
+	window := -1
+	if str := q.Get("window"); str != "" {
+		if window, err = strconv.Atoi(str); err != nil || window < 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid window", CodeInvalidRequest)
+			return
+		}
+	}
+
+	if _, err := s.db.GetTarget(id); err != nil {
+		writeJSONError(w, http.StatusNotFound, "Target not found: "+err.Error(), CodeTargetNotFound)
+		return
+	}
+
+	var resp DeleteResultsResponse
+	if window <= 0 {
+		if resp.RawResults, err = s.db.DeleteRawResultsRange(id, start, end); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+			return
+		}
+	}
+	if window != 0 {
+		if resp.AggregatedResults, err = s.db.DeleteAggregatedResultsRange(id, max(window, 0), start, end); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,80 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+)
+
+func TestHandleDeleteResults(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{Name: "Test Target", Address: "http://example.com", ProbeType: "http"})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 3 {
+		ts := base.Add(time.Duration(i) * time.Minute)
+		database.AddRawResults([]db.RawResult{{Time: ts, TargetID: id, Latency: 1e6}})
+		database.AddAggregatedResult(&db.AggregatedResult{Time: ts, TargetID: id, WindowSeconds: 60})
+		database.AddAggregatedResult(&db.AggregatedResult{Time: ts, TargetID: id, WindowSeconds: 300})
+	}
+
+	del := func(query, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", "/api/results/"+strconv.FormatInt(id, 10)+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder) DeleteResultsResponse {
+		t.Helper()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+		}
+		var resp DeleteResultsResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	if rr := del("?start=2024-01-01T00:00:00Z", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without an end, got %v", rr.Code)
+	}
+	if rr := del("?start=2024-01-01T00:02:00Z&end=2024-01-01T00:00:00Z", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a reversed range, got %v", rr.Code)
+	}
+
+	// Only the 5m window's first result.
+	resp := decode(del("?start=2024-01-01T00:00:00Z&end=2024-01-01T00:01:00Z&window=300", ""))
+	if resp.RawResults != 0 || resp.AggregatedResults != 1 {
+		t.Errorf("Unexpected window delete: %+v", resp)
+	}
+	// Only raw results.
+	resp = decode(del("?start=2024-01-01T00:00:00Z&end=2024-01-01T00:01:00Z&window=0", ""))
+	if resp.RawResults != 1 || resp.AggregatedResults != 0 {
+		t.Errorf("Unexpected raw delete: %+v", resp)
+	}
+
+	s.cfg.WriteToken = "s3cret"
+	if rr := del("?start=2024-01-01T00:00:00Z&end=2024-01-01T01:00:00Z", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without the token, got %v", rr.Code)
+	}
+	if rr := del("?start=2024-01-01T00:00:00Z&end=2024-01-01T01:00:00Z", "wrong"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 with a wrong token, got %v", rr.Code)
+	}
+	resp = decode(del("?start=2024-01-01T00:00:00Z&end=2024-01-01T01:00:00Z", "s3cret"))
+	if resp.RawResults != 2 || resp.AggregatedResults != 5 {
+		t.Errorf("Unexpected full delete: %+v", resp)
+	}
+}
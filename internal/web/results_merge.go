@@ -0,0 +1,166 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"vaportrail/internal/db"
+	"vaportrail/internal/scheduler"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+// MergeResultsRequest is the body of POST /api/results/merge.
+type MergeResultsRequest struct {
+	TargetIDs []int64 `json:"target_ids"`
+	Start     string  `json:"start,omitempty"` // RFC3339, defaults to one hour before End
+	End       string  `json:"end,omitempty"`   // RFC3339, defaults to now
+}
+
+// handleMergeResults returns the combined latency distribution of several
+// targets. Each target's aggregated digests are merged per time bucket, so
+// all targets must resolve to the same rollup window for the range.
+func (s *Server) handleMergeResults(w http.ResponseWriter, r *http.Request) {
+	var req MergeResultsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+		return
+	}
+	if len(req.TargetIDs) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "target_ids is required", CodeInvalidRequest)
+		return
+	}
+
+	end := time.Now().UTC()
+	start := end.Add(-1 * time.Hour)
+	if req.Start != "" || req.End != "" {
+		var err error
+		if start, err = time.Parse(time.RFC3339, req.Start); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid start time", CodeInvalidTimeRange)
+			return
+		}
+		if end, err = time.Parse(time.RFC3339, req.End); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid end time", CodeInvalidTimeRange)
+			return
+		}
+	}
+
+	// Resolve every target's window first so mismatches fail before any
+	// digests are loaded.
+	windows := make(map[int64]int, len(req.TargetIDs))
+	window := -1
+	for _, id := range req.TargetIDs {
+		target, err := s.reader.GetTarget(id)
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, fmt.Sprintf("Target %d not found: %v", id, err), CodeTargetNotFound)
+			return
+		}
+		policies, err := scheduler.GetRetentionPolicies(*target)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Target %d has no retention policies configured", id), CodeNoRetentionPolicies)
+			return
+		}
+		windows[id] = selectWindow(policies, start, end)
+		if window == -1 {
+			window = windows[id]
+		}
+	}
+	for _, id := range req.TargetIDs {
+		if windows[id] != window {
+			var parts []string
+			for _, id := range req.TargetIDs {
+				parts = append(parts, fmt.Sprintf("%d=%ds", id, windows[id]))
+			}
+			writeJSONError(w, http.StatusBadRequest, "Targets have mismatched windows over this range: "+strings.Join(parts, ", "), CodeInvalidRequest)
+			return
+		}
+	}
+
+	type bucket struct {
+		digest       *tdigest.TDigest
+		timeoutCount int64
+		corrupt      bool
+		// moments accumulates the sample count and sums for stddev; it is
+		// only reported if every merged row carried complete moments.
+		moments        summaryMoments
+		momentsMissing bool
+		// summary is set when a row had samples but no digest, as summary
+		// aggregation writes. The digests then cover only some of the
+		// samples, so the bucket is summarized from its moments instead.
+		summary bool
+	}
+	buckets := make(map[int64]*bucket)
+	for _, id := range req.TargetIDs {
+		results, err := s.reader.GetAggregatedResults(id, window, start, end)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+			return
+		}
+		for _, res := range results {
+			key := res.Time.Unix()
+			b, ok := buckets[key]
+			if !ok {
+				b = &bucket{}
+				buckets[key] = b
+			}
+			b.timeoutCount += res.TimeoutCount
+			b.moments.add(res)
+			if len(res.TDigestData) == 0 {
+				b.summary = b.summary || res.SampleCount > 0
+				continue
+			}
+			td, err := db.DeserializeTDigest(res.TDigestData)
+			if err != nil {
+				log.Printf("Warning: unreadable t-digest for target %d window %ds at %s: %v", res.TargetID, res.WindowSeconds, res.Time.Format(time.RFC3339), err)
+				b.corrupt = true
+				continue
+			}
+			if int64(td.Count()) != res.SampleCount {
+				b.momentsMissing = true
+			}
+			if td.Count() == 0 {
+				continue // An empty rollup; merging is weighted by count anyway.
+			}
+			if b.digest == nil {
+				b.digest = td
+			} else {
+				b.digest.Merge(td)
+			}
+		}
+	}
+
+	keys := make([]int64, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	apiResults := make([]APIResult, 0, len(keys))
+	for _, k := range keys {
+		b := buckets[k]
+		apiRes := APIResult{
+			Time:          time.Unix(k, 0).UTC(),
+			TimeoutCount:  b.timeoutCount,
+			WindowSeconds: window,
+			DigestCorrupt: b.corrupt,
+		}
+		if b.summary {
+			if !b.momentsMissing {
+				fillSummaryStats(&apiRes, b.moments.merged())
+			}
+		} else if b.digest != nil {
+			fillDigestStats(&apiRes, b.digest, s.cfg.MinSamplesForPercentiles)
+		}
+		if sd, ok := b.moments.StdDevNS(); ok && !b.momentsMissing {
+			apiRes.StdDevNS = ptr(sd)
+		}
+		apiResults = append(apiResults, apiRes)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiResults)
+}
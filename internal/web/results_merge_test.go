@@ -0,0 +1,128 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func TestHandleMergeResults(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	addTarget := func(name, policies string) int64 {
+		id, err := database.AddTarget(&db.Target{
+			Name:              name,
+			Address:           "example.com",
+			ProbeType:         "http",
+			RetentionPolicies: policies,
+		})
+		if err != nil {
+			t.Fatalf("Failed to add target: %v", err)
+		}
+		return id
+	}
+	minutePolicies := `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`
+	a := addTarget("A", minutePolicies)
+	b := addTarget("B", minutePolicies)
+	c := addTarget("C", `[{"window": 0, "retention": 604800}, {"window": 300, "retention": 15768000}]`)
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	addResult := func(id int64, at time.Time, latencies ...float64) {
+		td, _ := tdigest.New(tdigest.Compression(100))
+		for _, l := range latencies {
+			td.Add(l)
+		}
+		data, _ := db.SerializeTDigest(td)
+		if err := database.AddAggregatedResult(&db.AggregatedResult{
+			Time:          at,
+			TargetID:      id,
+			WindowSeconds: 60,
+			TDigestData:   data,
+			TimeoutCount:  1,
+		}); err != nil {
+			t.Fatalf("Failed to add result: %v", err)
+		}
+	}
+	addResult(a, now.Add(-10*time.Minute), 100, 200)
+	addResult(b, now.Add(-10*time.Minute), 300)
+	addResult(b, now.Add(-5*time.Minute), 400)
+
+	merge := func(ids ...int64) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"target_ids": %s, "start": %q, "end": %q}`,
+			strings.Join(strings.Fields(fmt.Sprint(ids)), ","),
+			now.Add(-time.Hour).Format(time.RFC3339), now.Format(time.RFC3339))
+		req := httptest.NewRequest("POST", "/api/results/merge", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := merge(a, b)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var results []APIResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 merged buckets, got %d", len(results))
+	}
+	if results[0].ProbeCount != 3 || results[0].TimeoutCount != 2 {
+		t.Errorf("Expected first bucket to combine 3 probes and 2 timeouts, got %d and %d", results[0].ProbeCount, results[0].TimeoutCount)
+	}
+	if results[0].P100 == nil || *results[0].P100 != 300 {
+		t.Errorf("Expected merged max 300, got %v", results[0].P100)
+	}
+	if results[1].ProbeCount != 1 || results[1].P50 == nil || *results[1].P50 != 400 {
+		t.Errorf("Expected second bucket to hold only B's sample, got count %d p50 %v", results[1].ProbeCount, results[1].P50)
+	}
+
+	if rr := merge(a, c); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for mismatched windows, got %d", rr.Code)
+	}
+	if rr := merge(); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for empty target list, got %d", rr.Code)
+	}
+}
+
+func TestHandleMergeResults_SummaryAggregation(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	a, start := addSummaryTarget(t, database, "A")
+	b, _ := addSummaryTarget(t, database, "B")
+
+	body := fmt.Sprintf(`{"target_ids": [%d, %d], "start": %q, "end": %q}`,
+		a, b, start.Format(time.RFC3339), start.Add(2*time.Minute).Format(time.RFC3339))
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/results/merge", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var results []APIResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 buckets, got %d", len(results))
+	}
+	first := results[0]
+	if first.ProbeCount != 4 || *first.MinNS != 100 || *first.MaxNS != 300 || *first.AvgNS != 200 || !first.PercentilesUnavailable {
+		t.Errorf("Expected the merged summaries of both targets, got %+v", first)
+	}
+	if first.StdDevNS == nil || *first.StdDevNS != 100 {
+		t.Errorf("Expected stddev 100, got %v", first.StdDevNS)
+	}
+	if second := results[1]; second.ProbeCount != 4 || second.TimeoutCount != 2 || *second.AvgNS != 500 {
+		t.Errorf("Unexpected second bucket: %+v", second)
+	}
+}
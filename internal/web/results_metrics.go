@@ -0,0 +1,112 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"vaportrail/internal/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// MetricRollupPoint is one window's rollup of an auxiliary metric. The
+// percentiles are estimated from the window's t-digest.
+type MetricRollupPoint struct {
+	Time  time.Time `json:"time"`
+	Count int64     `json:"count"`
+	Mean  float64   `json:"mean"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	P50   float64   `json:"p50"`
+	P90   float64   `json:"p90"`
+	P99   float64   `json:"p99"`
+}
+
+// handleGetMetrics returns the stored values of one of a target's auxiliary
+// probe metrics, such as probe.MetricHTTPThroughput. Query parameters:
+//
+//	name       required metric name
+//	start, end RFC3339 range, defaulting to the last hour
+//	window     rollup window in seconds; 0, the default, returns raw values
+//
+// Like raw results, at most the latest maxRawResults raw values are
+// returned, in ascending order. With a window, every rollup in the range is
+// returned as a MetricRollupPoint.
+func (s *Server) handleGetMetrics(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
+		return
+	}
+	q := r.URL.Query()
+	name := q.Get("name")
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, "name is required", CodeInvalidRequest)
+		return
+	}
+
+	end := time.Now().UTC()
+	start := end.Add(-1 * time.Hour)
+	if q.Get("start") != "" || q.Get("end") != "" {
+		if start, err = time.Parse(time.RFC3339, q.Get("start")); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid start time", CodeInvalidTimeRange)
+			return
+		}
+		if end, err = time.Parse(time.RFC3339, q.Get("end")); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid end time", CodeInvalidTimeRange)
+			return
+		}
+	}
+
+	window := 0
+	if str := q.Get("window"); str != "" {
+		if window, err = strconv.Atoi(str); err != nil || window < 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid window", CodeInvalidRequest)
+			return
+		}
+	}
+
+	if _, err := s.reader.GetTarget(id); err != nil {
+		writeJSONError(w, http.StatusNotFound, "Target not found: "+err.Error(), CodeTargetNotFound)
+		return
+	}
+	if window > 0 {
+		s.writeMetricRollups(w, id, window, name, start, end)
+		return
+	}
+	points, err := s.reader.GetRawMetrics(id, name, start, end, maxRawResults)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	if points == nil {
+		points = []db.MetricPoint{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(points)
+}
+
+func (s *Server) writeMetricRollups(w http.ResponseWriter, id int64, window int, name string, start, end time.Time) {
+	rollups, err := s.reader.GetAggregatedMetrics(id, window, name, start, end)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	points := make([]MetricRollupPoint, 0, len(rollups))
+	for _, m := range rollups {
+		p := MetricRollupPoint{Time: m.Time, Count: m.SampleCount}
+		if m.SampleCount > 0 {
+			p.Mean = m.Sum / float64(m.SampleCount)
+		}
+		if td, err := db.DeserializeTDigest(m.TDigestData); err == nil && td.Count() > 0 {
+			p.Min, p.Max = td.Quantile(0), td.Quantile(1)
+			p.P50, p.P90, p.P99 = td.Quantile(0.5), td.Quantile(0.9), td.Quantile(0.99)
+		}
+		points = append(points, p)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(points)
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+	"vaportrail/internal/probe"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func TestHandleGetMetrics(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{Name: "Test Target", Address: "http://example.com", ProbeType: "http"})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	now := time.Now().UTC()
+	database.AddRawResults([]db.RawResult{
+		{Time: now.Add(-2 * time.Minute), TargetID: id, Latency: 1e6, Metrics: probe.Metrics{probe.MetricHTTPThroughput: 5000}},
+		{Time: now.Add(-time.Minute), TargetID: id, Latency: 2e6, Metrics: probe.Metrics{probe.MetricHTTPThroughput: 2500}},
+	})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/results/"+strconv.FormatInt(id, 10)+"/metrics"+query, nil))
+		return rr
+	}
+
+	rr := get("?name=" + probe.MetricHTTPThroughput)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var points []db.MetricPoint
+	if err := json.NewDecoder(rr.Body).Decode(&points); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(points) != 2 || points[0].Value != 5000 || points[1].Value != 2500 {
+		t.Errorf("Unexpected points: %+v", points)
+	}
+
+	if rr := get("?name=unknown"); rr.Code != http.StatusOK || rr.Body.String() != "[]\n" {
+		t.Errorf("Expected an empty list for an unknown metric, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := get(""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a name, got %v", rr.Code)
+	}
+}
+
+func TestHandleGetMetrics_Window(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{Name: "Test Target", Address: "http://example.com", ProbeType: "http"})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	td, _ := tdigest.New()
+	for _, v := range []float64{1000, 2000, 3000, 4000} {
+		td.Add(v)
+	}
+	data, _ := db.SerializeTDigest(td)
+	windowStart := time.Now().UTC().Truncate(time.Minute).Add(-5 * time.Minute)
+	database.AddAggregatedResult(&db.AggregatedResult{
+		Time: windowStart, TargetID: id, WindowSeconds: 60,
+		Metrics: []db.AggregatedMetric{{Time: windowStart, TargetID: id, WindowSeconds: 60, Name: probe.MetricHTTPThroughput, TDigestData: data, SampleCount: 4, Sum: 10000}},
+	})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/results/"+strconv.FormatInt(id, 10)+"/metrics"+query, nil))
+		return rr
+	}
+
+	rr := get("?name=" + probe.MetricHTTPThroughput + "&window=60")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var points []MetricRollupPoint
+	if err := json.NewDecoder(rr.Body).Decode(&points); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(points) != 1 || points[0].Count != 4 || points[0].Mean != 2500 || points[0].Min != 1000 || points[0].Max != 4000 {
+		t.Errorf("Unexpected rollup points: %+v", points)
+	}
+
+	if rr := get("?name=" + probe.MetricHTTPThroughput + "&window=300"); rr.Code != http.StatusOK || rr.Body.String() != "[]\n" {
+		t.Errorf("Expected an empty list for a window without rollups, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := get("?name=" + probe.MetricHTTPThroughput + "&window=-1"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a negative window, got %v", rr.Code)
+	}
+}
@@ -0,0 +1,62 @@
+package web
+
+import (
+	"time"
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+// maxMergeWindows bounds the merge_windows query parameter.
+const maxMergeWindows = 100
+
+// mergeTrailingWindows replaces the latency statistics of each result with
+// those of its own digest merged with the digests of the windows in the k-1
+// window lengths before it, where digests[i] is that of results[i] or nil.
+// Windows missing from that span, or without probes, aren't made up for,
+// so the first results of the data, and those after gaps, merge fewer;
+// MergedWindows says how many each did. Each result keeps its own
+// ProbeCount and TimeoutCount.
+//
+// Results of rows without a digest, as summary aggregation writes, are
+// merged from the moments of the rows in the span instead, where rows[i] is
+// that of results[i].
+func mergeTrailingWindows(results []APIResult, rows []db.AggregatedResult, digests []*tdigest.TDigest, k, minSamples int, percentiles []float64) {
+	for i := range results {
+		from := results[i].Time.Add(-time.Duration(k*results[i].WindowSeconds) * time.Second)
+		summary := len(rows[i].TDigestData) == 0
+		var merged *tdigest.TDigest
+		var moments summaryMoments
+		if !summary {
+			merged, _ = tdigest.New(tdigest.Compression(100))
+		}
+		n := 0
+		for j := i; j >= 0 && results[j].Time.After(from); j-- {
+			switch {
+			case summary && len(rows[j].TDigestData) == 0 && rows[j].SampleCount > 0:
+				moments.add(rows[j])
+			case !summary && digests[j] != nil && digests[j].Count() > 0:
+				merged.Merge(digests[j])
+			default:
+				continue
+			}
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		var stats APIResult
+		if summary {
+			fillSummaryStats(&stats, moments.merged())
+		} else {
+			fillDigestStats(&stats, merged, minSamples)
+		}
+		probeCount := results[i].ProbeCount
+		copyDigestStats(&results[i], &stats)
+		results[i].ProbeCount = probeCount
+		results[i].MergedWindows = n
+		if merged != nil && percentiles != nil {
+			selectPercentiles(&results[i], merged, percentiles)
+		}
+	}
+}
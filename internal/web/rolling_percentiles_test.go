@@ -0,0 +1,111 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func TestHandleGetResults_MergeWindows(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Rolling",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	end := time.Now().UTC().Truncate(time.Minute)
+	start := end.Add(-time.Hour)
+	// Each window holds 10 probes of one latency, in milliseconds. The
+	// first two are before the range, and there is a gap at start+2m.
+	for minute, ms := range map[int]float64{-2: 1, -1: 2, 0: 3, 1: 4, 3: 5} {
+		td, _ := tdigest.New(tdigest.Compression(100))
+		for range 10 {
+			td.Add(ms * 1e6)
+		}
+		data, _ := db.SerializeTDigest(td)
+		if err := database.AddAggregatedResult(&db.AggregatedResult{
+			Time: start.Add(time.Duration(minute) * time.Minute), TargetID: id, WindowSeconds: 60, TDigestData: data,
+		}); err != nil {
+			t.Fatalf("Failed to add result: %v", err)
+		}
+	}
+
+	get := func(query string) (int, []APIResult) {
+		rr := httptest.NewRecorder()
+		path := "/api/results/" + strconv.FormatInt(id, 10) + "?start=" + start.Format(time.RFC3339) + "&end=" + end.Format(time.RFC3339) + query
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		var results []APIResult
+		json.NewDecoder(rr.Body).Decode(&results)
+		return rr.Code, results
+	}
+
+	code, results := get("&merge_windows=3")
+	if code != http.StatusOK || len(results) != 3 {
+		t.Fatalf("Expected the 3 windows in the range, got status %d and %+v", code, results)
+	}
+	for i, want := range []struct {
+		lo, hi float64
+		merged int
+	}{
+		{1e6, 3e6, 3}, // Merged with the two windows before the range.
+		{2e6, 4e6, 3},
+		{4e6, 5e6, 2}, // The gap leaves only start+1m.
+	} {
+		res := results[i]
+		if res.MergedWindows != want.merged || *res.P0 != want.lo || *res.P100 != want.hi {
+			t.Errorf("Result %d: expected %d windows from %g to %g, got %d from %v to %v", i, want.merged, want.lo, want.hi, res.MergedWindows, *res.P0, *res.P100)
+		}
+		if res.ProbeCount != 10 {
+			t.Errorf("Result %d: expected the window's own ProbeCount of 10, got %d", i, res.ProbeCount)
+		}
+	}
+
+	if _, results := get("&merge_windows=2&percentiles=100"); len(results) != 3 || results[0].Quantiles["p100"] != 3e6 || results[0].P0 != nil {
+		t.Errorf("Expected requested percentiles from the merged digests, got %+v", results)
+	}
+	if _, results := get("&merge_windows=1"); len(results) != 3 || results[0].MergedWindows != 0 || *results[0].P0 != 3e6 {
+		t.Errorf("Expected merge_windows=1 to leave windows unmerged, got %+v", results)
+	}
+
+	for _, query := range []string{"&merge_windows=0", "&merge_windows=101", "&merge_windows=x", "&merge_windows=3&raw=true"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, code)
+		}
+	}
+}
+
+func TestHandleGetResults_MergeWindowsSummaryAggregation(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	id, start := addSummaryTarget(t, database, "summary")
+
+	rr := httptest.NewRecorder()
+	path := "/api/results/" + strconv.FormatInt(id, 10) + "?start=" + start.Format(time.RFC3339) + "&end=" + start.Add(2*time.Minute).Format(time.RFC3339) + "&merge_windows=2"
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+	var results []APIResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil || len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d (%v)", len(results), err)
+	}
+	if first := results[0]; first.MergedWindows != 1 || *first.AvgNS != 200 {
+		t.Errorf("Expected the first window alone, got %+v", first)
+	}
+	second := results[1]
+	if second.MergedWindows != 2 || *second.MinNS != 100 || *second.MaxNS != 500 || *second.AvgNS != 350 || !second.PercentilesUnavailable {
+		t.Errorf("Expected both windows' summaries merged, got %+v", second)
+	}
+	if second.ProbeCount != 2 {
+		t.Errorf("Expected the window's own ProbeCount of 2, got %d", second.ProbeCount)
+	}
+}
@@ -0,0 +1,68 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"vaportrail/internal/scheduler"
+)
+
+// RollupBackfillRequest is the body of POST /api/maintenance/rollup.
+type RollupBackfillRequest struct {
+	TargetID int64  `json:"target_id"`
+	Window   int    `json:"window"` // seconds; coarser windows are rebuilt too
+	Start    string `json:"start"`  // RFC3339
+	End      string `json:"end"`    // RFC3339
+}
+
+// RollupBackfillResponse reports how many windows were written.
+type RollupBackfillResponse struct {
+	WindowsRebuilt int `json:"windows_rebuilt"`
+}
+
+// handleBackfillRollups rebuilds a target's rollups over a past range, e.g.
+// after importing raw data or changing its retention policies.
+func (s *Server) handleBackfillRollups(w http.ResponseWriter, r *http.Request) {
+	var req RollupBackfillRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+		return
+	}
+	start, err := time.Parse(time.RFC3339, req.Start)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid start time", CodeInvalidTimeRange)
+		return
+	}
+	end, err := time.Parse(time.RFC3339, req.End)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid end time", CodeInvalidTimeRange)
+		return
+	}
+	if !start.Before(end) {
+		writeJSONError(w, http.StatusBadRequest, "start must be before end", CodeInvalidTimeRange)
+		return
+	}
+	if _, err := s.db.GetTarget(req.TargetID); err != nil {
+		writeJSONError(w, http.StatusNotFound, "Target not found: "+err.Error(), CodeTargetNotFound)
+		return
+	}
+
+	// A long range's backfill outlives the server's WriteTimeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	// Backfill only writes through UPSERTs, so it can run alongside the
+	// scheduler's own rollup manager.
+	built, err := scheduler.NewRollupManager(s.db).Backfill(req.TargetID, req.Window, start.UTC(), end.UTC())
+	if errors.Is(err, scheduler.ErrWindowNotConfigured) {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RollupBackfillResponse{WindowsRebuilt: built})
+}
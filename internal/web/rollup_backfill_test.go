@@ -0,0 +1,65 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+)
+
+func TestHandleBackfillRollups(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Backfill",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	start := time.Now().UTC().Add(-time.Hour).Truncate(time.Minute)
+	var batch []db.RawResult
+	for i := 0; i < 180; i++ {
+		batch = append(batch, db.RawResult{Time: start.Add(time.Duration(i) * time.Second), TargetID: id, Latency: 100})
+	}
+	if err := database.AddRawResults(batch); err != nil {
+		t.Fatalf("Failed to add raw results: %v", err)
+	}
+
+	backfill := func(window int) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"target_id": %d, "window": %d, "start": %q, "end": %q}`,
+			id, window, start.Format(time.RFC3339), start.Add(10*time.Minute).Format(time.RFC3339))
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/maintenance/rollup", strings.NewReader(body)))
+		return rr
+	}
+
+	rr := backfill(60)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp RollupBackfillResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.WindowsRebuilt != 3 {
+		t.Errorf("Expected 3 windows rebuilt, got %d", resp.WindowsRebuilt)
+	}
+	results, _ := database.GetAggregatedResults(id, 60, start, start.Add(time.Hour))
+	if len(results) != 3 {
+		t.Errorf("Expected 3 stored 1m rollups, got %d", len(results))
+	}
+
+	if rr := backfill(300); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a window the target doesn't keep, got %d", rr.Code)
+	}
+}
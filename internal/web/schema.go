@@ -0,0 +1,91 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"vaportrail/internal/db"
+	"vaportrail/internal/probe"
+	"vaportrail/internal/scheduler"
+)
+
+// SchemaProperty is a property of a JSON Schema object.
+type SchemaProperty struct {
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	Minimum     *float64 `json:"minimum,omitempty"`
+	Maximum     *float64 `json:"maximum,omitempty"`
+	ReadOnly    bool     `json:"readOnly,omitempty"`
+}
+
+// Schema is a JSON Schema (draft 2020-12) document for an object.
+type Schema struct {
+	Schema     string                    `json:"$schema"`
+	Title      string                    `json:"title"`
+	Type       string                    `json:"type"`
+	Required   []string                  `json:"required"`
+	Properties map[string]SchemaProperty `json:"properties"`
+}
+
+// targetRequired are the fields normalizeTarget rejects a target without.
+var targetRequired = []string{"Name", "Address", "ProbeType"}
+
+// targetSchemaProperties describes the db.Target fields whose type alone
+// doesn't say enough. Every key must be a field of db.Target; the test
+// checks this, and targetSchema takes the list of fields and their types
+// from the struct itself, so the schema follows it.
+func targetSchemaProperties() map[string]SchemaProperty {
+	zero, retries := 0.0, float64(maxRetryCount)
+	var probeTypes []string
+	for _, info := range probe.Types() {
+		probeTypes = append(probeTypes, info.Name)
+	}
+	return map[string]SchemaProperty{
+		"ID":                {Description: "Assigned when the target is created", ReadOnly: true},
+		"Address":           {Description: "Host, URL or resolver to probe, depending on ProbeType"},
+		"ProbeType":         {Enum: probeTypes, Description: "See GET /api/probe-types"},
+		"ProbeConfig":       {Description: "JSON object of the probe type's options; see GET /api/probe-types"},
+		"ProbeInterval":     {Description: fmt.Sprintf("Seconds between probes; 0 uses the probe type's default, otherwise at least %g", scheduler.MinProbeInterval)},
+		"Timeout":           {Description: "Seconds before a probe counts as a timeout; 0 uses the probe type's default"},
+		"RetentionPolicies": {Description: "JSON array of retention policies; empty inherits GET /api/settings/retention"},
+		"MaxLatencyNS":      {Minimum: &zero, Description: "Cap on a single probe's latency in nanoseconds; 0 disables it"},
+		"MaxLatencyAction":  {Enum: []string{"", db.MaxLatencyActionTimeout, db.MaxLatencyActionClamp}},
+		"Aggregation":       {Enum: []string{"", db.AggregationFull, db.AggregationSummary}, Description: "How rollups summarize latencies; summary keeps no percentiles but is far smaller"},
+		"Schedule":          {Description: `When to probe, such as "mon-fri 09:00-17:00, sat 10:00-14:00", optionally prefixed by "TZ=<zone> "; empty probes all the time`},
+		"WarmupProbes":      {Minimum: &zero},
+		"DownAfter":         {Minimum: &zero, Description: "Consecutive timeouts that mark the target down; 0 disables up/down tracking"},
+		"UpAfter":           {Minimum: &zero, Description: "Consecutive successes that bring a down target back up"},
+		"RetryCount":        {Minimum: &zero, Maximum: &retries},
+		"Down":              {Description: "Set by the scheduler while the target is down", ReadOnly: true},
+	}
+}
+
+// targetSchema builds the JSON Schema of db.Target as the API reads and
+// writes it.
+func targetSchema() Schema {
+	schema := Schema{
+		Schema:     "https://json-schema.org/draft/2020-12/schema",
+		Title:      "Target",
+		Type:       "object",
+		Required:   targetRequired,
+		Properties: make(map[string]SchemaProperty),
+	}
+	details := targetSchemaProperties()
+	t := reflect.TypeFor[db.Target]()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		prop := details[f.Name]
+		prop.Type = probe.JSONType(f.Type)
+		schema.Properties[f.Name] = prop
+	}
+	return schema
+}
+
+func (s *Server) handleTargetSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(targetSchema())
+}
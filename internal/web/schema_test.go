@@ -0,0 +1,74 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+
+	"vaportrail/internal/db"
+	"vaportrail/internal/probe"
+)
+
+func TestHandleTargetSchema(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/schema/target", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var schema Schema
+	if err := json.NewDecoder(rr.Body).Decode(&schema); err != nil {
+		t.Fatalf("Failed to decode schema: %v", err)
+	}
+
+	fields := reflect.TypeFor[db.Target]()
+	if len(schema.Properties) != fields.NumField() {
+		t.Errorf("Expected %d properties, got %d", fields.NumField(), len(schema.Properties))
+	}
+	for i := 0; i < fields.NumField(); i++ {
+		f := fields.Field(i)
+		if prop, ok := schema.Properties[f.Name]; !ok || prop.Type != probe.JSONType(f.Type) {
+			t.Errorf("Expected property %s of type %s, got %+v", f.Name, probe.JSONType(f.Type), prop)
+		}
+	}
+	for name := range targetSchemaProperties() {
+		if _, ok := fields.FieldByName(name); !ok {
+			t.Errorf("Schema describes %s, which isn't a field of db.Target", name)
+		}
+	}
+
+	var types []string
+	for _, info := range probe.Types() {
+		types = append(types, info.Name)
+	}
+	if enum := schema.Properties["ProbeType"].Enum; !slices.Equal(enum, types) {
+		t.Errorf("Expected ProbeType to be one of %v, got %v", types, enum)
+	}
+}
+
+// The required fields are exactly those normalizeTarget insists on.
+func TestTargetSchemaRequired(t *testing.T) {
+	minimal := db.Target{Name: "web", Address: "http://127.0.0.1", ProbeType: "http"}
+	if target := minimal; normalizeTarget(&target) != nil {
+		t.Fatal("Expected a target with only the required fields to be valid")
+	}
+	fields := reflect.TypeFor[db.Target]()
+	for i := 0; i < fields.NumField(); i++ {
+		name := fields.Field(i).Name
+		target := minimal
+		v := reflect.ValueOf(&target).Elem().Field(i)
+		if v.IsZero() {
+			continue
+		}
+		v.SetZero()
+		err := normalizeTarget(&target)
+		if required := slices.Contains(targetRequired, name); required != (err != nil) {
+			t.Errorf("%s: listed as required %v, but normalizeTarget without it returned %v", name, required, err)
+		}
+	}
+}
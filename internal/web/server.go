@@ -1,9 +1,12 @@
 package web
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,8 +14,11 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"vaportrail/internal/config"
 	"vaportrail/internal/db"
@@ -21,6 +27,7 @@ import (
 	"vaportrail/internal/probe"
 	"vaportrail/internal/scheduler"
 
+	"github.com/caio/go-tdigest/v4"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -34,13 +41,36 @@ var staticFS embed.FS
 type Server struct {
 	cfg       *config.ServerConfig
 	db        *db.DB
+	reader    *db.DB // serves result queries; same as db unless a read replica is set
 	scheduler *scheduler.Scheduler
 	router    *chi.Mux
 	templates *template.Template
+	httpSrv   *http.Server
+	// createMu serializes target creation so MaxTargets can't be exceeded
+	// by concurrent requests.
+	createMu sync.Mutex
+	certs    *certReloader // set by LoadTLS when serving HTTPS
+	digests  *digestCache  // nil when cfg.DigestCacheSize is zero
+
+	baselineStop chan struct{}
+	baselineWG   sync.WaitGroup
 }
 
 func New(cfg *config.ServerConfig, database *db.DB, sched *scheduler.Scheduler) *Server {
+	loc := cfg.DisplayLocation()
 	funcMap := template.FuncMap{
+		// localTime formats a (UTC) time in the configured display zone.
+		"localTime": func(t time.Time) string {
+			if t.IsZero() {
+				return "-"
+			}
+			return t.In(loc).Format("2006-01-02 15:04:05 MST")
+		},
+		// displayTimezone is the zone the dashboards' scripts format times
+		// in; empty leaves them in the browser's zone.
+		"displayTimezone": func() string {
+			return cfg.DisplayTimezone
+		},
 		"byteSize": func(b int64) string {
 			const unit = 1024
 			if b < unit {
@@ -110,26 +140,76 @@ func New(cfg *config.ServerConfig, database *db.DB, sched *scheduler.Scheduler)
 	s := &Server{
 		cfg:       cfg,
 		db:        database,
+		reader:    database,
 		scheduler: sched,
 		router:    chi.NewRouter(),
 		templates: tmpl,
+		digests:   newDigestCache(cfg.DigestCacheSize),
+
+		baselineStop: make(chan struct{}),
 	}
 	s.routes()
+	s.httpSrv = &http.Server{
+		Addr:              ":" + strconv.Itoa(cfg.HTTPPort),
+		Handler:           s.router,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
 	return s
 }
 
+// SetReadDB routes the result-reading API handlers to a separate
+// (typically read-only) connection. Writes keep using the primary database.
+func (s *Server) SetReadDB(reader *db.DB) {
+	s.reader = reader
+}
+
 func (s *Server) routes() {
 	s.router.Use(middleware.Logger)
 	s.router.Use(middleware.Recoverer)
+	s.router.Use(compressResponses)
+	s.router.Use(s.rejectWritesWhenReadOnly)
 	s.router.Get("/", s.handleDashboard)
 	s.router.Get("/api/targets", s.handleGetTargets)
+	s.router.Get("/api/targets/search", s.handleSearchTargets)
+	s.router.Get("/api/probe-types", s.handleGetProbeTypes)
+	s.router.Get("/api/schema/target", s.handleTargetSchema)
+	s.router.Post("/api/probe-test", s.requireWriteToken(s.handleProbeTest))
+	s.router.Get("/api/overview", s.handleOverview)
 	s.router.Post("/api/targets", s.handleCreateTarget)
-	s.router.Put("/api/targets/{id}", s.handleUpdateTarget)
-	s.router.Delete("/api/targets/{id}", s.handleDeleteTarget)
+	s.router.Get("/api/targets/export", s.handleExportTargets)
+	s.router.Post("/api/targets/import", s.requireWriteToken(s.handleImportTargets))
+	s.router.Post("/api/targets/bulk", s.requireWriteToken(s.handleBulkCreateTargets))
+	s.router.Put("/api/targets/{id}", s.requireWriteToken(s.handleUpdateTarget))
+	s.router.Delete("/api/targets/{id}", s.requireWriteToken(s.handleDeleteTarget))
+	s.router.Get("/api/targets/{id}/debug", s.handleDebugTarget)
+	s.router.Get("/api/targets/{id}/dump", s.handleDumpTarget)
+	s.router.Get("/api/targets/{id}/baseline", s.handleGetBaseline)
+	s.router.Put("/api/targets/{id}/baseline", s.handlePutBaseline)
+	s.router.Post("/api/targets/{id}/dump", s.requireWriteToken(s.handleRestoreTarget))
 	s.router.Get("/api/results/{id}", s.handleGetResults)
+	s.router.Delete("/api/results/{id}", s.requireWriteToken(s.handleDeleteResults))
+	s.router.Get("/api/results/{id}/metrics", s.handleGetMetrics)
+	s.router.Get("/api/results/{id}/availability", s.handleGetAvailability)
+	s.router.Get("/api/results/{id}/coverage", s.handleGetCoverage)
+	s.router.Post("/api/results/merge", s.handleMergeResults)
+	s.router.Post("/api/results/{id}/compare", s.handleCompareResults)
+	s.router.Get("/api/export/influx", s.handleExportInflux)
 	s.router.Get("/graph/{id}", s.handleGraph)
 	s.router.Get("/status", s.handleStatus)
+	s.router.Get("/healthz", s.handleHealthz)
+	s.router.Get("/metrics", s.handleMetrics)
 	s.router.Post("/status/cleanup-orphaned-data", s.handleStatusCleanupOrphanedData)
+	s.router.Post("/api/maintenance/rollup", s.requireWriteToken(s.handleBackfillRollups))
+	s.router.Post("/api/maintenance/recompute-stats", s.requireWriteToken(s.handleRecomputeStats))
+	s.router.Post("/api/maintenance/pause", s.requireWriteToken(s.handlePauseProbing))
+	s.router.Post("/api/maintenance/resume", s.requireWriteToken(s.handleResumeProbing))
+	s.router.Get("/api/debug/scheduler", s.requireWriteToken(s.handleDebugScheduler))
+	s.router.Get("/api/settings/retention", s.handleGetRetentionSettings)
+	s.router.Put("/api/settings/retention", s.requireWriteToken(s.handlePutRetentionSettings))
 	s.router.Get("/favicon.png", s.handleFavicon)
 	s.router.Get("/static/*", s.handleStatic)
 
@@ -154,30 +234,118 @@ func (s *Server) routes() {
 	s.router.Post("/api/dashboards/{id}/regenerate-slug", s.handleRegenerateDashboardSlug)
 }
 
+// Start serves HTTP, or HTTPS once LoadTLS has loaded a certificate, until
+// Shutdown is called, after which it returns nil.
 func (s *Server) Start() error {
-	return http.ListenAndServe(":"+strconv.Itoa(s.cfg.HTTPPort), s.router)
+	s.startBaselineRefresher()
+	var err error
+	if s.certs != nil {
+		// The certificate comes from TLSConfig.GetCertificate so it can be
+		// reloaded.
+		err = s.httpSrv.ListenAndServeTLS("", "")
+	} else {
+		err = s.httpSrv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections and learning baselines, and waits for
+// in-flight requests to finish, or for ctx to expire.
+func (s *Server) Shutdown(ctx context.Context) error {
+	close(s.baselineStop)
+	s.baselineWG.Wait()
+	return s.httpSrv.Shutdown(ctx)
 }
 
 func (s *Server) handleCreateTarget(w http.ResponseWriter, r *http.Request) {
 	var t db.Target
 	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+		return
+	}
+
+	if err := normalizeTarget(&t); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), targetErrorCode(err))
+		return
+	}
+
+	id, err := s.addTarget(&t)
+	if errors.Is(err, errTargetLimit) {
+		writeJSONError(w, http.StatusConflict, err.Error(), CodeTargetLimit)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 
-	// Basic validation
+	t.ID = id
+	// Notify scheduler
+	if s.scheduler != nil {
+		s.scheduler.AddTarget(t)
+		s.warnNearTargetLimit()
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// errTargetLimit is returned by addTarget once MaxTargets targets exist.
+var errTargetLimit = errors.New("target limit reached")
+
+// addTarget stores a new target, enforcing the configured MaxTargets.
+func (s *Server) addTarget(t *db.Target) (int64, error) {
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
+	if err := s.checkTargetLimit(1); err != nil {
+		return 0, err
+	}
+	return s.db.AddTarget(t)
+}
+
+// checkTargetLimit returns errTargetLimit if adding n targets would exceed
+// MaxTargets. createMu must be held.
+func (s *Server) checkTargetLimit(n int) error {
+	if s.cfg.MaxTargets <= 0 {
+		return nil
+	}
+	targets, err := s.db.GetTargets()
+	if err != nil {
+		return err
+	}
+	if len(targets)+n > s.cfg.MaxTargets {
+		return fmt.Errorf("%w: this server allows at most %d targets", errTargetLimit, s.cfg.MaxTargets)
+	}
+	return nil
+}
+
+// warnNearTargetLimit logs when the number of running probe loops reaches
+// 90% of MaxTargets.
+func (s *Server) warnNearTargetLimit() {
+	if s.cfg.MaxTargets <= 0 {
+		return
+	}
+	if active := s.scheduler.ActiveTargets(); active*10 >= s.cfg.MaxTargets*9 {
+		log.Printf("Warning: %d of at most %d targets are being probed", active, s.cfg.MaxTargets)
+	}
+}
 
+// normalizeTarget validates a target submitted through the API and fills in
+// defaults. Retention policies are validated and re-serialized in sorted order.
+// The returned error is suitable for showing to the client.
+func normalizeTarget(t *db.Target) error {
 	if t.RetentionPolicies != "" {
 		var policies []scheduler.RetentionPolicy
 		// First unmarshal to check JSON validity
 		if err := json.Unmarshal([]byte(t.RetentionPolicies), &policies); err != nil {
-			http.Error(w, "Invalid retention policies JSON", http.StatusBadRequest)
-			return
+			return errors.New("Invalid retention policies JSON")
 		}
 		// Then validate policies logic (this also sorts them)
 		if err := scheduler.ValidateRetentionPolicies(policies); err != nil {
-			http.Error(w, "Invalid retention policies: "+err.Error(), http.StatusBadRequest)
-			return
+			return errors.New("Invalid retention policies: " + err.Error())
 		}
 		// Re-serialize sorted policies
 		sortedJSON, _ := json.Marshal(policies)
@@ -185,57 +353,118 @@ func (s *Server) handleCreateTarget(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if t.Name == "" || t.Address == "" || t.ProbeType == "" {
-		http.Error(w, "Missing required fields", http.StatusBadRequest)
-		return
+		return errors.New("Missing required fields")
+	}
+
+	for _, f := range []struct {
+		name  string
+		value float64
+	}{
+		{"ProbeInterval", t.ProbeInterval},
+		{"Timeout", t.Timeout},
+		{"MaxLatencyNS", t.MaxLatencyNS},
+	} {
+		if math.IsNaN(f.value) || math.IsInf(f.value, 0) {
+			return fmt.Errorf("%s must be a finite number", f.name)
+		}
 	}
 
+	// Unset intervals and timeouts get the probe type's defaults. Unknown
+	// types are rejected below.
+	interval, timeout, ok := probe.TypeDefaults(t.ProbeType)
+	if !ok {
+		interval, timeout = 1.0, 5.0
+	}
 	if t.ProbeInterval <= 0 {
-		t.ProbeInterval = 1.0
+		t.ProbeInterval = interval
+	}
+	if t.ProbeInterval < scheduler.MinProbeInterval {
+		return fmt.Errorf("ProbeInterval must be at least %g seconds", scheduler.MinProbeInterval)
 	}
 	if t.Timeout <= 0 {
-		t.Timeout = 5.0
+		t.Timeout = timeout
+	}
+
+	if t.MaxLatencyNS < 0 {
+		return errors.New("MaxLatencyNS cannot be negative")
+	}
+	switch t.MaxLatencyAction {
+	case "", db.MaxLatencyActionTimeout, db.MaxLatencyActionClamp:
+	default:
+		return fmt.Errorf("Invalid MaxLatencyAction %q (expected %q or %q)", t.MaxLatencyAction, db.MaxLatencyActionTimeout, db.MaxLatencyActionClamp)
+	}
+	switch t.Aggregation {
+	case "", db.AggregationFull, db.AggregationSummary:
+	default:
+		return fmt.Errorf("Invalid Aggregation %q (expected %q or %q)", t.Aggregation, db.AggregationFull, db.AggregationSummary)
+	}
+	t.Schedule = strings.TrimSpace(t.Schedule)
+	if _, err := scheduler.ParseSchedule(t.Schedule); err != nil {
+		return fmt.Errorf("Invalid Schedule: %v", err)
+	}
+	if t.WarmupProbes < 0 {
+		return errors.New("WarmupProbes cannot be negative")
+	}
+	if t.DownAfter < 0 {
+		return errors.New("DownAfter cannot be negative")
+	}
+	if t.UpAfter < 0 {
+		return errors.New("UpAfter cannot be negative")
+	}
+	if t.RetryCount < 0 || t.RetryCount > maxRetryCount {
+		return fmt.Errorf("RetryCount must be between 0 and %d", maxRetryCount)
 	}
 
 	// Check for valid probe type
 	if _, err := probe.GetConfig(t.ProbeType, t.Address); err != nil {
-		http.Error(w, "Invalid probe type", http.StatusBadRequest)
-		return
+		return &targetError{CodeInvalidProbeType, "Invalid probe type"}
 	}
-
-	// Drop any user provided config
-	t.ProbeConfig = ""
-
-	// Apply default retention policies if not provided
-	if t.RetentionPolicies == "" {
-		t.RetentionPolicies = scheduler.DefaultPoliciesJSON()
+	cfg, err := probe.GetTargetConfig(t.ProbeType, t.Address, t.ProbeConfig)
+	if err != nil {
+		return &targetError{CodeInvalidProbeConfig, "Invalid probe config: " + err.Error()}
 	}
-
-	id, err := s.db.AddTarget(&t)
+	if err := probe.CheckSourceAddress(cfg); err != nil {
+		return &targetError{CodeInvalidProbeConfig, "Invalid probe config: " + err.Error()}
+	}
+	address, err := probe.NormalizeAddress(t.ProbeType, t.Address, cfg.Resolver)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return &targetError{CodeInvalidAddress, "Invalid address: " + err.Error()}
 	}
+	t.Address = address
+	return nil
+}
 
-	t.ID = id
-	// Notify scheduler
-	if s.scheduler != nil {
-		s.scheduler.AddTarget(t)
+// dropRemovedWindows deletes aggregated data for rollup windows that were
+// present in the existing target's policies but are absent from the new ones.
+func (s *Server) dropRemovedWindows(existing db.Target, newPolicies []scheduler.RetentionPolicy) {
+	oldPolicies, _ := scheduler.GetRetentionPolicies(existing)
+	newWindowSet := make(map[int]bool)
+	for _, p := range newPolicies {
+		newWindowSet[p.Window] = true
+	}
+	for _, oldP := range oldPolicies {
+		if !newWindowSet[oldP.Window] {
+			// This window was removed, delete its aggregated data
+			if oldP.Window == 0 {
+				// Raw data - we could delete it, but typically raw is retained if any policy exists
+				// For now, we skip raw data deletion on policy removal
+				continue
+			}
+			s.db.DeleteAggregatedResultsByWindow(existing.ID, oldP.Window)
+		}
 	}
-
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(t)
 }
 
 func (s *Server) handleDeleteTarget(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
 		return
 	}
 
 	if err := s.db.DeleteTarget(id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 
@@ -250,122 +479,309 @@ func (s *Server) handleUpdateTarget(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
 		return
 	}
 
 	// Fetch existing target to compare retention policies
 	existingTarget, err := s.db.GetTarget(id)
 	if err != nil {
-		http.Error(w, "Target not found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "Target not found", CodeTargetNotFound)
 		return
 	}
 
 	var t db.Target
 	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
 		return
 	}
 	t.ID = id
+	t.Down = existingTarget.Down // Owned by the scheduler.
 
-	var newPolicies []scheduler.RetentionPolicy
-	if t.RetentionPolicies != "" {
-		if err := json.Unmarshal([]byte(t.RetentionPolicies), &newPolicies); err != nil {
-			http.Error(w, "Invalid retention policies JSON", http.StatusBadRequest)
-			return
-		}
-		// Validate (this also sorts them)
-		if err := scheduler.ValidateRetentionPolicies(newPolicies); err != nil {
-			http.Error(w, "Invalid retention policies: "+err.Error(), http.StatusBadRequest)
-			return
-		}
-		// Re-serialize sorted policies
-		sortedJSON, _ := json.Marshal(newPolicies)
-		t.RetentionPolicies = string(sortedJSON)
-	}
-
-	if t.Name == "" || t.Address == "" || t.ProbeType == "" {
-		http.Error(w, "Missing required fields", http.StatusBadRequest)
-		return
-	}
-	if t.ProbeInterval == 0 {
-		t.ProbeInterval = 1.0
-	}
-	if t.Timeout == 0 {
-		t.Timeout = 5.0
-	}
-
-	if _, err := probe.GetConfig(t.ProbeType, t.Address); err != nil {
-		http.Error(w, "Invalid probe type", http.StatusBadRequest)
+	if err := normalizeTarget(&t); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), targetErrorCode(err))
 		return
 	}
 
 	// Detect removed retention policies and delete their data
-	oldPolicies, _ := scheduler.GetRetentionPolicies(*existingTarget)
-	newWindowSet := make(map[int]bool)
-	for _, p := range newPolicies {
-		newWindowSet[p.Window] = true
-	}
-	for _, oldP := range oldPolicies {
-		if !newWindowSet[oldP.Window] {
-			// This window was removed, delete its aggregated data
-			if oldP.Window == 0 {
-				// Raw data - we could delete it, but typically raw is retained if any policy exists
-				// For now, we skip raw data deletion on policy removal
-				continue
-			}
-			s.db.DeleteAggregatedResultsByWindow(id, oldP.Window)
-		}
-	}
+	newPolicies, _ := scheduler.GetRetentionPolicies(t)
+	s.dropRemovedWindows(*existingTarget, newPolicies)
 
 	if err := s.db.UpdateTarget(&t); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 
 	// Update scheduler
 	if s.scheduler != nil {
-		s.scheduler.RemoveTarget(id)
-		s.scheduler.AddTarget(t)
+		s.scheduler.UpdateTarget(t)
 	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(t)
 }
 
+// DashboardPageData is the template data for the main dashboard.
+type DashboardPageData struct {
+	Name            string
+	DisplayTimezone string
+	LoadedAt        time.Time
+}
+
 func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
-	if err := s.templates.ExecuteTemplate(w, "dashboard.html", nil); err != nil {
+	data := DashboardPageData{
+		DisplayTimezone: s.cfg.DisplayTimezone,
+		LoadedAt:        time.Now().UTC(),
+	}
+	if err := s.templates.ExecuteTemplate(w, "dashboard.html", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// APITarget is a target as listed by GET /api/targets. Concurrency is set
+// while the scheduler is probing it.
+type APITarget struct {
+	db.Target
+	Concurrency *scheduler.ProbeConcurrency `json:",omitempty"`
+	// LastResultTime is when the target's latest raw result was taken, and
+	// Stale is set once that's too long ago; see config StaleAfterIntervals.
+	LastResultTime *time.Time `json:",omitempty"`
+	Stale          bool
+}
+
 func (s *Server) handleGetTargets(w http.ResponseWriter, r *http.Request) {
 	targets, err := s.db.GetTargets()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	var concurrency map[int64]scheduler.ProbeConcurrency
+	if s.scheduler != nil {
+		concurrency = s.scheduler.ProbeConcurrency()
+	}
+	lastTimes, err := s.reader.GetLastRawResultTimes()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
+	now := time.Now()
+	resp := make([]APITarget, len(targets))
+	for i, t := range targets {
+		resp[i].Target = t
+		if c, ok := concurrency[t.ID]; ok {
+			resp[i].Concurrency = &c
+		}
+		if last, ok := lastTimes[t.ID]; ok {
+			resp[i].LastResultTime = &last
+			resp[i].Stale = s.isStale(t, last, now)
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(targets)
+	json.NewEncoder(w).Encode(resp)
 }
 
+// APIResult is one datapoint returned by the results API. The latency fields
+// are nil (and omitted from the JSON) when there is nothing to report, either
+// because the window had no successful probes or because its digest could not
+// be read, so clients never see phantom 0ns latencies. A window whose digest
+// is empty, such as one rolled up while the target had no successful probes,
+// reports them as explicit nulls with a ProbeCount of 0.
 type APIResult struct {
-	Time          time.Time
-	TargetID      int64
-	MinNS         int64
-	MaxNS         int64
-	AvgNS         int64
-	P0            float64
-	P1            float64
-	P25           float64
-	P50           float64
-	P75           float64
-	P99           float64
-	P100          float64
-	Percentiles   []float64 // 0th, 5th, 10th... 100th
-	TimeoutCount  int64
-	ProbeCount    int64
-	WindowSeconds int
+	Time        time.Time
+	TargetID    int64
+	MinNS       *int64    `json:",omitempty"`
+	MaxNS       *int64    `json:",omitempty"`
+	AvgNS       *int64    `json:",omitempty"`
+	P0          *float64  `json:",omitempty"`
+	P1          *float64  `json:",omitempty"`
+	P25         *float64  `json:",omitempty"`
+	P50         *float64  `json:",omitempty"`
+	P75         *float64  `json:",omitempty"`
+	P99         *float64  `json:",omitempty"`
+	P100        *float64  `json:",omitempty"`
+	Percentiles []float64 `json:",omitempty"` // 0th, 5th, 10th... 100th
+	StdDevNS    *float64  `json:",omitempty"` // exact, from the window's running moments
+	P50MA       *float64  `json:",omitempty"` // trailing mean of P50, only with ma=K
+
+	// MergedWindows is only set when the request passes merge_windows=K.
+	// The latency fields are then computed from the digests of this window
+	// and those of the K-1 windows before it merged, a steadier tail than
+	// any one window's, and MergedWindows is how many windows with a digest
+	// that was: fewer than K where the data starts or has gaps.
+	MergedWindows int `json:",omitempty"`
+
+	// Quantiles is only set when the request passes percentiles=, such as
+	// "50,95,99", and holds just those, keyed by name ("p50"), in place of
+	// P0 through P100 and Percentiles. P50 is still set if 50 is one of
+	// them. Rollups that stored all of them (see the stored_percentiles
+	// setting) are served without decoding their digests.
+	Quantiles map[string]float64 `json:",omitempty"`
+
+	// BaselineRatio is P50 over the target's baseline, so 2 is twice as
+	// slow as normal, and BaselineDelta the difference. Both are omitted
+	// while the target has no baseline; see BaselineReport.
+	BaselineRatio *float64 `json:",omitempty"`
+	BaselineDelta *float64 `json:",omitempty"`
+
+	// Apdex is only set when the request passes apdex_threshold=T, a
+	// duration such as "250ms". It scores the window's probes as
+	//
+	//	(satisfied + tolerating/2) / total
+	//
+	// where satisfied probes took at most T, tolerating ones at most 4T,
+	// and the rest, timeouts included, are frustrated: 1 means every probe
+	// was satisfied and 0 that none were even tolerable.
+	Apdex *float64 `json:",omitempty"`
+
+	// Histogram is only set when the request passes histogram=N; see
+	// Histogram.
+	Histogram *Histogram `json:",omitempty"`
+
+	// InsufficientSamples is set when the window has fewer probes than the
+	// configured MinSamplesForPercentiles. The percentile fields are then
+	// omitted; min, max and average are still reported.
+	InsufficientSamples bool `json:",omitempty"`
+	// PercentilesUnavailable is set on rollups of a target with summary
+	// aggregation, which keep no digest to compute percentiles from; only
+	// min, max, average and standard deviation are reported.
+	PercentilesUnavailable bool `json:",omitempty"`
+	TimeoutCount           int64
+	ProbeCount             int64
+	WindowSeconds          int
+
+	// DigestCorrupt is set when the stored digest for this window exists but
+	// could not be deserialized.
+	DigestCorrupt bool `json:",omitempty"`
+
+	// Partial is set on a window that was still open when it was rolled
+	// up. A later query returns the complete window in its place.
+	Partial bool `json:",omitempty"`
+
+	// Maintenance is set on a window without data because probing was
+	// paused for maintenance, or the target's schedule was off; it isn't an
+	// outage.
+	Maintenance bool `json:",omitempty"`
+
+	// Completeness is the fraction of its source rollups a rollup was
+	// merged from, so dashboards can dim windows with gaps. It is 1 for
+	// windows rolled up from raw results and nil for raw results.
+	Completeness *float64 `json:",omitempty"`
+
+	// Metadata is a raw result's probe metadata, such as the HTTP status,
+	// for targets with RecordMetadata.
+	Metadata json.RawMessage `json:",omitempty"`
+
+	// ID is a raw result's ID. Pass the last one of a page as after= to get
+	// the next.
+	ID int64 `json:",omitempty"`
+
+	// TDigest is only set when the request passes raw_digest=true. It holds
+	// the stored digest in the uncompressed go-tdigest "small" encoding,
+	// base64-encoded by encoding/json:
+	//
+	//	int32   encoding version (2)
+	//	float64 compression
+	//	int32   number of centroids n
+	//	n x float32 centroid means, each stored as the delta from the previous mean
+	//	n x uvarint centroid counts
+	//
+	// All fixed-width fields are big-endian. Clients can rebuild the digest
+	// from this to compute arbitrary quantiles or merge windows.
+	TDigest []byte `json:",omitempty"`
+
+	empty bool   // the window's digest was read but had no samples
+	unit  string // latency unit requested with unit=; "" for nanoseconds
+}
+
+// latencyUnits are the values of the results API's unit parameter, with the
+// number of nanoseconds in each.
+var latencyUnits = map[string]float64{
+	"ns": 1,
+	"us": 1e3,
+	"ms": 1e6,
+}
+
+// MarshalJSON writes the latency fields of an empty window as nulls.
+//
+// With a unit other than nanoseconds, every latency (the percentiles, P50MA
+// and the digest-derived fields) is scaled to it, and MinNS, MaxNS, AvgNS and
+// StdDevNS become the fractional Min, Max, Avg and StdDev. A Unit field names
+// the unit. The TDigest blob is always in nanoseconds.
+func (a APIResult) MarshalJSON() ([]byte, error) {
+	type plain APIResult // without the MarshalJSON method
+	if a.unit != "" {
+		return a.marshalInUnit()
+	}
+	if !a.empty {
+		return json.Marshal(plain(a))
+	}
+	// The shallower fields shadow the embedded ones and aren't omitempty.
+	return json.Marshal(struct {
+		plain
+		MinNS, MaxNS, AvgNS              *int64
+		P0, P1, P25, P50, P75, P99, P100 *float64
+		Percentiles                      []float64
+	}{plain: plain(a)})
+}
+
+func (a APIResult) marshalInUnit() ([]byte, error) {
+	type plain APIResult
+	div := latencyUnits[a.unit]
+	scale := func(f *float64) *float64 {
+		if f == nil {
+			return nil
+		}
+		return ptr(*f / div)
+	}
+	scaleInt := func(i *int64) *float64 {
+		if i == nil {
+			return nil
+		}
+		return ptr(float64(*i) / div)
+	}
+
+	p := plain(a)
+	stdDev := scale(a.StdDevNS)
+	p.MinNS, p.MaxNS, p.AvgNS, p.StdDevNS = nil, nil, nil, nil
+	for _, f := range []**float64{&p.P0, &p.P1, &p.P25, &p.P50, &p.P75, &p.P99, &p.P100, &p.P50MA, &p.BaselineDelta} {
+		*f = scale(*f)
+	}
+	if a.Histogram != nil {
+		h := Histogram{Edges: make([]float64, len(a.Histogram.Edges)), Counts: a.Histogram.Counts}
+		for i, e := range a.Histogram.Edges {
+			h.Edges[i] = e / div
+		}
+		p.Histogram = &h
+	}
+	if a.Percentiles != nil {
+		// A copy, since the digest cache may share the slice.
+		p.Percentiles = make([]float64, len(a.Percentiles))
+		for i, v := range a.Percentiles {
+			p.Percentiles[i] = v / div
+		}
+	}
+	if a.Quantiles != nil {
+		p.Quantiles = make(map[string]float64, len(a.Quantiles))
+		for name, v := range a.Quantiles {
+			p.Quantiles[name] = v / div
+		}
+	}
+
+	if !a.empty {
+		return json.Marshal(struct {
+			plain
+			Unit                  string
+			Min, Max, Avg, StdDev *float64 `json:",omitempty"`
+		}{p, a.unit, scaleInt(a.MinNS), scaleInt(a.MaxNS), scaleInt(a.AvgNS), stdDev})
+	}
+	return json.Marshal(struct {
+		plain
+		Unit                             string
+		Min, Max, Avg                    *float64
+		StdDev                           *float64 `json:",omitempty"`
+		P0, P1, P25, P50, P75, P99, P100 *float64
+		Percentiles                      []float64
+	}{plain: p, Unit: a.unit, StdDev: stdDev})
 }
 
 func sanitizeFloat(f float64) float64 {
@@ -375,11 +791,193 @@ func sanitizeFloat(f float64) float64 {
 	return f
 }
 
+// latencyNS converts a latency to whole nanoseconds, saturating rather than
+// overflowing; NaN and infinities become 0 as in sanitizeFloat.
+func latencyNS(f float64) int64 {
+	f = sanitizeFloat(f)
+	if f >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	if f <= math.MinInt64 {
+		return math.MinInt64
+	}
+	return int64(f)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// selectWindow picks the rollup window to serve for a time range: the smallest
+// window in the target's policies that keeps the response under ~1000
+// datapoints, or the largest available window if none is coarse enough.
+// Disabled windows aren't available, since they have no recent rollups.
+func selectWindow(policies []scheduler.RetentionPolicy, start, end time.Time) int {
+	// Dynamic Window Selection
+	// Goal: < 1000 datapoints
+	durationSeconds := end.Sub(start).Seconds()
+	desiredWindow := max(int(durationSeconds/1000.0), 1)
+
+	var availableWindows []int
+	for _, p := range policies {
+		if p.Window > 0 && p.IsEnabled() {
+			availableWindows = append(availableWindows, p.Window)
+		}
+	}
+	sort.Ints(availableWindows)
+
+	// Pick the smallest window >= desiredWindow
+	for _, w := range availableWindows {
+		if w >= desiredWindow {
+			return w
+		}
+	}
+
+	// desiredWindow is larger than every available window; pick the largest.
+	if len(availableWindows) > 0 {
+		return availableWindows[len(availableWindows)-1]
+	}
+
+	// No aggregated windows configured, default to 60
+	return 60
+}
+
+// maxMovingAverage bounds the ma query parameter.
+const maxMovingAverage = 1000
+
+// maxRetryCount bounds a target's RetryCount. Retries share one probe's
+// time budget, so more than a few leave each attempt too little time.
+const maxRetryCount = 5
+
+// maxRawResults caps the number of raw results a single request returns.
+const maxRawResults = 1000
+
+// fillSummaryStats populates the latency fields of apiRes from a rollup
+// without a digest, as summary aggregation writes, using its exact moments
+// and extremes. There are no percentiles, which PercentilesUnavailable
+// tells clients.
+func fillSummaryStats(apiRes *APIResult, res db.AggregatedResult) {
+	apiRes.PercentilesUnavailable = true
+	apiRes.ProbeCount = res.SampleCount
+	if res.SampleCount == 0 {
+		apiRes.empty = true
+		return
+	}
+	if res.MaxNS > 0 { // Zero in rows written before extremes were stored.
+		apiRes.MinNS = ptr(latencyNS(res.MinNS))
+		apiRes.MaxNS = ptr(latencyNS(res.MaxNS))
+	}
+	apiRes.AvgNS = ptr(latencyNS(res.SumNS / float64(res.SampleCount)))
+}
+
+// summaryMoments merges the moments and extremes of several rollups, for
+// summarizing them together with fillSummaryStats when they have no digests
+// to merge.
+type summaryMoments struct {
+	db.AggregatedResult
+	sampled, extremesMissing bool
+}
+
+func (m *summaryMoments) add(res db.AggregatedResult) {
+	m.SampleCount += res.SampleCount
+	m.SumNS += res.SumNS
+	m.SumSqNS += res.SumSqNS
+	switch {
+	case res.SampleCount == 0:
+	case res.MaxNS == 0:
+		m.extremesMissing = true
+	case !m.sampled:
+		m.MinNS, m.MaxNS, m.sampled = res.MinNS, res.MaxNS, true
+	default:
+		m.MinNS = min(m.MinNS, res.MinNS)
+		m.MaxNS = max(m.MaxNS, res.MaxNS)
+	}
+}
+
+// merged returns the merged rollup, whose extremes are unknown (0) if any
+// rollup's were.
+func (m *summaryMoments) merged() db.AggregatedResult {
+	r := m.AggregatedResult
+	if m.extremesMissing {
+		r.MinNS, r.MaxNS = 0, 0
+	}
+	return r
+}
+
+// applyMovingAverage sets P50MA on each result to the mean P50 of it and the
+// k-1 results before it. Results without a P50 (all timeouts, or a corrupt
+// digest) don't contribute, and the first points average over however many
+// are available.
+func applyMovingAverage(results []APIResult, k int) {
+	var sum float64
+	var n int
+	for i := range results {
+		if p := results[i].P50; p != nil {
+			sum += *p
+			n++
+		}
+		if i >= k {
+			if p := results[i-k].P50; p != nil {
+				sum -= *p
+				n--
+			}
+		}
+		if n > 0 {
+			results[i].P50MA = ptr(sum / float64(n))
+		}
+	}
+}
+
+// fillDigestStats populates the latency fields of apiRes from a t-digest.
+// An empty digest leaves them nil and marks apiRes empty. With fewer than
+// minSamples probes only min, max and average are filled in and
+// InsufficientSamples is set.
+func fillDigestStats(apiRes *APIResult, td *tdigest.TDigest, minSamples int) {
+	apiRes.ProbeCount = int64(td.Count())
+	if td.Count() == 0 {
+		apiRes.empty = true
+		return
+	}
+
+	// Compute average from centroids
+	var totalMass, weightedSum float64
+	td.ForEachCentroid(func(mean float64, count uint64) bool {
+		totalMass += float64(count)
+		weightedSum += mean * float64(count)
+		return true
+	})
+	if totalMass > 0 {
+		apiRes.AvgNS = ptr(latencyNS(weightedSum / totalMass))
+	}
+
+	apiRes.MinNS = ptr(latencyNS(td.Quantile(0.0)))
+	apiRes.MaxNS = ptr(latencyNS(td.Quantile(1.0)))
+	if apiRes.ProbeCount < int64(minSamples) {
+		apiRes.InsufficientSamples = true
+		return
+	}
+
+	apiRes.P0 = ptr(sanitizeFloat(td.Quantile(0.0)))
+	apiRes.P1 = ptr(sanitizeFloat(td.Quantile(0.01)))
+	apiRes.P25 = ptr(sanitizeFloat(td.Quantile(0.25)))
+	apiRes.P50 = ptr(sanitizeFloat(td.Quantile(0.5)))
+	apiRes.P75 = ptr(sanitizeFloat(td.Quantile(0.75)))
+	apiRes.P99 = ptr(sanitizeFloat(td.Quantile(0.99)))
+	apiRes.P100 = ptr(sanitizeFloat(td.Quantile(1.0)))
+
+	// Calculate every 5th percentile
+	apiRes.Percentiles = make([]float64, 21)
+	for i := 0; i <= 20; i++ {
+		p := float64(i) * 0.05
+		apiRes.Percentiles[i] = sanitizeFloat(td.Quantile(p))
+	}
+}
+
 func (s *Server) handleGetResults(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
 		return
 	}
 
@@ -389,23 +987,23 @@ func (s *Server) handleGetResults(w http.ResponseWriter, r *http.Request) {
 	var start, end time.Time
 	var window int
 	// Fetch target to get retention policies
-	target, err := s.db.GetTarget(id)
+	target, err := s.reader.GetTarget(id)
 	if err != nil {
 		// If target not found, we can't really determine policies.
 		// Return 404 or just fail? The ID validation passed int parsing but DB check might fail.
-		http.Error(w, "Target not found: "+err.Error(), http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "Target not found: "+err.Error(), CodeTargetNotFound)
 		return
 	}
 
 	if startStr != "" && endStr != "" {
 		start, err = time.Parse(time.RFC3339, startStr)
 		if err != nil {
-			http.Error(w, "Invalid start time", http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, "Invalid start time", CodeInvalidTimeRange)
 			return
 		}
 		end, err = time.Parse(time.RFC3339, endStr)
 		if err != nil {
-			http.Error(w, "Invalid end time", http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, "Invalid end time", CodeInvalidTimeRange)
 			return
 		}
 	} else {
@@ -414,138 +1012,317 @@ func (s *Server) handleGetResults(w http.ResponseWriter, r *http.Request) {
 		start = end.Add(-1 * time.Hour)
 	}
 
-	// Dynamic Window Selection
-	// Goal: < 1000 datapoints
-	durationSeconds := end.Sub(start).Seconds()
-	desiredWindow := max(int(durationSeconds/1000.0), 1)
-
 	policies, err := scheduler.GetRetentionPolicies(*target)
 	if err != nil {
-		http.Error(w, "Target has no retention policies configured", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "Target has no retention policies configured", CodeNoRetentionPolicies)
 		return
 	}
+	window = selectWindow(policies, start, end)
+
+	var movingAverage int
+	if maStr := r.URL.Query().Get("ma"); maStr != "" {
+		movingAverage, err = strconv.Atoi(maStr)
+		if err != nil || movingAverage < 1 || movingAverage > maxMovingAverage {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("ma must be an integer between 1 and %d", maxMovingAverage), CodeInvalidRequest)
+			return
+		}
+	}
 
-	// Collect available windows from policies (and 0 for raw if 0 exists)
-	// Actually policies usually define what we HAVE.
-	// We want to pick the smallest window >= desiredWindow
-	// availableWindows should include those defined in policies.
+	limit, order, err := parseResultPaging(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+		return
+	}
 
-	var availableWindows []int
-	for _, p := range policies {
-		if p.Window > 0 {
-			availableWindows = append(availableWindows, p.Window)
+	var apdexThreshold time.Duration
+	if thresholdStr := r.URL.Query().Get("apdex_threshold"); thresholdStr != "" {
+		apdexThreshold, err = time.ParseDuration(thresholdStr)
+		if err != nil || apdexThreshold <= 0 {
+			writeJSONError(w, http.StatusBadRequest, `apdex_threshold must be a positive duration, such as "250ms"`, CodeInvalidRequest)
+			return
 		}
 	}
-	sort.Ints(availableWindows)
 
-	// Pick best window
-	window = -1
-	for _, w := range availableWindows {
-		if w >= desiredWindow {
-			window = w
-			break
+	var histogramBuckets int
+	if histStr := r.URL.Query().Get("histogram"); histStr != "" {
+		histogramBuckets, err = strconv.Atoi(histStr)
+		if err != nil || histogramBuckets < 1 || histogramBuckets > maxHistogramBuckets {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("histogram must be an integer between 1 and %d", maxHistogramBuckets), CodeInvalidRequest)
+			return
+		}
+		if r.URL.Query().Get("raw") == "true" {
+			writeJSONError(w, http.StatusBadRequest, "histogram is only available for rollups, not raw results", CodeInvalidRequest)
+			return
+		}
+	}
+
+	var mergeWindows int
+	if mergeStr := r.URL.Query().Get("merge_windows"); mergeStr != "" {
+		mergeWindows, err = strconv.Atoi(mergeStr)
+		if err != nil || mergeWindows < 1 || mergeWindows > maxMergeWindows {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("merge_windows must be an integer between 1 and %d", maxMergeWindows), CodeInvalidRequest)
+			return
+		}
+		if r.URL.Query().Get("raw") == "true" {
+			writeJSONError(w, http.StatusBadRequest, "merge_windows is only available for rollups, not raw results", CodeInvalidRequest)
+			return
+		}
+		if mergeWindows == 1 {
+			mergeWindows = 0 // Each window on its own, as without it.
 		}
 	}
 
-	// If no window found (all smaller than desired? Or desired is huge?)
-	// If window is still -1, it means desiredWindow > all available windows.
-	// Pick the largest one.
-	if window == -1 && len(availableWindows) > 0 {
-		window = availableWindows[len(availableWindows)-1]
+	var percentiles []float64
+	if pStr := r.URL.Query().Get("percentiles"); pStr != "" {
+		percentiles, err = parsePercentiles(pStr)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+			return
+		}
+		if r.URL.Query().Get("raw") == "true" {
+			writeJSONError(w, http.StatusBadRequest, "percentiles is only available for rollups, not raw results", CodeInvalidRequest)
+			return
+		}
 	}
 
-	// If we still don't have a window (e.g. policies empty?), default to 60
-	if window == -1 {
-		window = 60
+	unit := r.URL.Query().Get("unit")
+	if _, ok := latencyUnits[unit]; unit != "" && !ok {
+		writeJSONError(w, http.StatusBadRequest, `unit must be "ns", "us" or "ms"`, CodeInvalidRequest)
+		return
+	}
+	if unit == "ns" {
+		unit = "" // The default, with the original field names.
 	}
 
 	var apiResults []APIResult
 
+	if r.URL.Query().Get("bucket") != "" {
+		if r.URL.Query().Get("raw") != "true" {
+			writeJSONError(w, http.StatusBadRequest, "bucket is only available for raw results", CodeInvalidRequest)
+			return
+		}
+		bucket, err := parseRawBucket(r.URL.Query(), start, end)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+			return
+		}
+		buckets, err := s.reader.GetRawBuckets(id, start, end, bucket)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to get raw results: "+err.Error(), CodeInternal)
+			return
+		}
+		for _, b := range buckets {
+			apiResults = append(apiResults, rawBucketResult(b, id, bucket))
+		}
+		apiResults = pageResults(apiResults, movingAverage, 0, "", unit)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(apiResults)
+		return
+	}
+
 	if r.URL.Query().Get("raw") == "true" {
-		// User: "render the first 1000"
-		// Just pull 1000. DB query is ordered by time ASC, so this gives first 1000.
-		rawResults, err := s.db.GetRawResults(id, start, end, 1000)
+		var rawResults []db.RawResult
+		if afterStr := r.URL.Query().Get("after"); afterStr != "" {
+			// Keyset paging: the results after a cursor, in ID order.
+			after, parseErr := strconv.ParseInt(afterStr, 10, 64)
+			if parseErr != nil || after < 0 {
+				writeJSONError(w, http.StatusBadRequest, "after must be a raw result ID", CodeInvalidRequest)
+				return
+			}
+			if order == db.OrderDesc {
+				writeJSONError(w, http.StatusBadRequest, "after can't be combined with descending order", CodeInvalidRequest)
+				return
+			}
+			if limit == 0 {
+				limit = maxRawResults
+			}
+			if limit > maxRawResults {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("limit cannot exceed %d for raw results", maxRawResults), CodeInvalidRequest)
+				return
+			}
+			rawResults, err = s.reader.GetRawResultsAfter(id, after, start, end, limit)
+		} else if limit == 0 && order == "" {
+			// Without paging parameters, the latest maxRawResults of the
+			// range in ascending order.
+			rawResults, err = s.reader.GetRawResults(id, start, end, maxRawResults)
+		} else {
+			if limit == 0 {
+				limit = maxRawResults
+			}
+			if limit > maxRawResults {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("limit cannot exceed %d for raw results", maxRawResults), CodeInvalidRequest)
+				return
+			}
+			rawResults, err = s.reader.GetRawResultsPage(id, start, end, limit, order)
+			if order == db.OrderDesc {
+				slices.Reverse(rawResults) // Back to ascending; pageResults restores the order.
+			}
+		}
 		if err != nil {
-			http.Error(w, "Failed to get raw results: "+err.Error(), http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to get raw results: "+err.Error(), CodeInternal)
 			return
 		}
-		// No longer erroring on > 1000, just returning what we got (capped at 1000 by query limit)
 
 		for _, rr := range rawResults {
 			apiRes := APIResult{
+				ID:         rr.ID,
 				Time:       rr.Time,
 				TargetID:   rr.TargetID,
 				ProbeCount: 1,
-				MinNS:      int64(rr.Latency),
-				MaxNS:      int64(rr.Latency),
-				AvgNS:      int64(rr.Latency), // Set Avg to latency for simple display usually
-				P0:         rr.Latency,
-				P100:       rr.Latency,
-				P50:        rr.Latency, // Median is the value itself
+				MinNS:      ptr(latencyNS(rr.Latency)),
+				MaxNS:      ptr(latencyNS(rr.Latency)),
+				AvgNS:      ptr(latencyNS(rr.Latency)), // Set Avg to latency for simple display usually
+				P0:         ptr(rr.Latency),
+				P100:       ptr(rr.Latency),
+				P50:        ptr(rr.Latency), // Median is the value itself
+			}
+			if rr.Metadata != "" {
+				apiRes.Metadata = json.RawMessage(rr.Metadata)
+			}
+			if apdexThreshold > 0 {
+				apiRes.Apdex = rawApdex(rr.Latency, apdexThreshold)
 			}
 			apiResults = append(apiResults, apiRes)
 		}
+		applyBaseline(apiResults, s.baseline(*target))
+		apiResults = pageResults(apiResults, movingAverage, limit, order, unit)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(apiResults)
 		return
 	}
 
-	results, err := s.db.GetAggregatedResults(id, window, start, end)
+	// Merging windows needs the K-1 windows before the range too, so its
+	// first points merge as many as the rest.
+	queryStart := start
+	if mergeWindows > 0 {
+		queryStart = start.Add(-time.Duration((mergeWindows-1)*window) * time.Second)
+	}
+	results, err := s.reader.GetAggregatedResults(id, window, queryStart, end)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 
-	for _, res := range results {
+	rawDigest := r.URL.Query().Get("raw_digest") == "true"
+	var digests []*tdigest.TDigest // by result, for histograms and merging
+	if histogramBuckets > 0 || mergeWindows > 0 {
+		digests = make([]*tdigest.TDigest, len(results))
+	}
+	for i, res := range results {
 		apiRes := APIResult{
 			Time:          res.Time,
 			TargetID:      res.TargetID,
 			TimeoutCount:  res.TimeoutCount,
 			ProbeCount:    0, // Will be populated from TDigest if available
 			WindowSeconds: res.WindowSeconds,
+			Partial:       res.Partial,
+			Maintenance:   res.Maintenance,
+			Completeness:  ptr(res.Completeness()),
+		}
+		if rawDigest {
+			apiRes.TDigest, _ = db.DecompressTDigest(res.TDigestData)
 		}
 
-		if len(res.TDigestData) > 0 {
-			td, err := db.DeserializeTDigest(res.TDigestData)
-			if err == nil {
-				// Compute average from centroids
-				var totalMass, weightedSum float64
-				td.ForEachCentroid(func(mean float64, count uint64) bool {
-					totalMass += float64(count)
-					weightedSum += mean * float64(count)
-					return true
-				})
-				if totalMass > 0 {
-					apiRes.AvgNS = int64(weightedSum / totalMass)
-				}
-
-				apiRes.ProbeCount = int64(td.Count())
-				apiRes.P0 = sanitizeFloat(td.Quantile(0.0))
-				apiRes.P1 = sanitizeFloat(td.Quantile(0.01))
-				apiRes.P25 = sanitizeFloat(td.Quantile(0.25))
-				apiRes.P50 = sanitizeFloat(td.Quantile(0.5))
-				apiRes.P75 = sanitizeFloat(td.Quantile(0.75))
-				apiRes.P99 = sanitizeFloat(td.Quantile(0.99))
-				apiRes.P100 = sanitizeFloat(td.Quantile(1.0))
-
-				apiRes.MinNS = int64(apiRes.P0)
-				apiRes.MaxNS = int64(apiRes.P100)
-
-				// Calculate every 5th percentile
-				apiRes.Percentiles = make([]float64, 21)
-				for i := 0; i <= 20; i++ {
-					p := float64(i) * 0.05
-					apiRes.Percentiles[i] = sanitizeFloat(td.Quantile(p))
+		// Apdex, histograms and merging need the digest itself.
+		stored := len(res.TDigestData) > 0 && percentiles != nil && apdexThreshold == 0 && histogramBuckets == 0 && mergeWindows == 0 &&
+			fillStoredPercentiles(&apiRes, res, percentiles, s.cfg.MinSamplesForPercentiles)
+		// Stored percentiles are served without decoding the digest.
+		if !stored && len(res.TDigestData) > 0 {
+			key := newDigestKey(res.TargetID, res.Time, res.WindowSeconds)
+			var td *tdigest.TDigest
+			if !s.digests.get(key, res.TDigestData, &apiRes) {
+				var err error
+				td, err = db.DeserializeTDigest(res.TDigestData)
+				if err != nil {
+					log.Printf("Warning: unreadable t-digest for target %d window %ds at %s: %v", res.TargetID, res.WindowSeconds, res.Time.Format(time.RFC3339), err)
+					apiRes.DigestCorrupt = true
+				} else {
+					fillDigestStats(&apiRes, td, s.cfg.MinSamplesForPercentiles)
+					s.digests.put(key, res.TDigestData, &apiRes)
 				}
+			} else if apdexThreshold > 0 || histogramBuckets > 0 || percentiles != nil || mergeWindows > 0 {
+				// The cache holds the digest's stats, not the digest.
+				td, _ = db.DeserializeTDigest(res.TDigestData)
 			}
+			if td != nil && percentiles != nil {
+				selectPercentiles(&apiRes, td, percentiles)
+			}
+			if td != nil && apdexThreshold > 0 {
+				apiRes.Apdex = digestApdex(td, res.TimeoutCount, apdexThreshold)
+			}
+			if digests != nil {
+				digests[i] = td
+			}
+		} else if res.SampleCount > 0 || target.Aggregation == db.AggregationSummary {
+			fillSummaryStats(&apiRes, res)
+		} else if apdexThreshold > 0 && res.TimeoutCount > 0 {
+			apiRes.Apdex = ptr(0.0) // Nothing but timeouts.
+		}
+		if sd, ok := res.StdDevNS(); ok {
+			apiRes.StdDevNS = ptr(sd)
 		}
 		apiResults = append(apiResults, apiRes)
 	}
+	if mergeWindows > 0 {
+		mergeTrailingWindows(apiResults, results, digests, mergeWindows, s.cfg.MinSamplesForPercentiles, percentiles)
+		// Drop the windows before the range, only fetched to merge.
+		first := 0
+		for first < len(apiResults) && apiResults[first].Time.Before(start) {
+			first++
+		}
+		apiResults, digests = apiResults[first:], digests[first:]
+	}
+	if histogramBuckets > 0 {
+		fillHistograms(apiResults, digests, histogramBuckets)
+	}
+	applyBaseline(apiResults, s.baseline(*target))
+	apiResults = pageResults(apiResults, movingAverage, limit, order, unit)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(apiResults)
 }
 
+// parseResultPaging reads the limit and order query parameters of
+// handleGetResults. A zero limit and empty order mean neither was given.
+func parseResultPaging(q url.Values) (int, string, error) {
+	var limit int
+	if limitStr := q.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			return 0, "", errors.New("limit must be a positive integer")
+		}
+	}
+	order := q.Get("order")
+	switch order {
+	case "", db.OrderAsc, db.OrderDesc:
+	default:
+		return 0, "", fmt.Errorf("order must be %q or %q", db.OrderAsc, db.OrderDesc)
+	}
+	return limit, order, nil
+}
+
+// pageResults applies the moving average to results, which must be in
+// ascending order, then puts them in the requested order and keeps the first
+// limit. As with the database queries, a limit in ascending order keeps the
+// earliest results, so ask for descending order to get the most recent.
+// Every result is marked to be written in unit.
+func pageResults(results []APIResult, movingAverage, limit int, order, unit string) []APIResult {
+	if movingAverage > 0 {
+		applyMovingAverage(results, movingAverage)
+	}
+	for i := range results {
+		results[i].unit = unit
+	}
+	if order == db.OrderDesc {
+		slices.Reverse(results)
+	}
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
 func (s *Server) handleGraph(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
@@ -714,6 +1491,84 @@ func (s *Server) statusPageData(cleanupReport *db.OrphanedDataCleanupReport) (St
 	}, nil
 }
 
+// HealthStatus is the body of GET /healthz.
+type HealthStatus struct {
+	Status          string              `json:"status"` // "ok" or "unhealthy"
+	Database        string              `json:"database"`
+	MissingCommands map[string][]string `json:"missing_commands,omitempty"`
+	// MaxRollupLagSeconds is the largest rollup lag of any target and
+	// window; see scheduler.RollupLags. It doesn't affect Status.
+	MaxRollupLagSeconds float64 `json:"max_rollup_lag_seconds"`
+	// Disk is the scheduler's disk guard status. Status is "unhealthy"
+	// while free space is below the minimum and raw results are dropped.
+	Disk *scheduler.DiskStatus `json:"disk,omitempty"`
+	// WALSizeBytes is the size of the database's write-ahead log file, and
+	// Checkpoint the scheduler's latest checkpoint of it, if it runs them.
+	// Neither affects Status.
+	WALSizeBytes int64                       `json:"wal_size_bytes"`
+	Checkpoint   *scheduler.CheckpointStatus `json:"checkpoint,omitempty"`
+	// Maintenance reports whether probing is paused; it doesn't affect
+	// Status.
+	Maintenance *scheduler.MaintenanceStatus `json:"maintenance,omitempty"`
+}
+
+// handleHealthz reports whether the database is reachable, every probe
+// command the configured targets need is installed and there is enough disk
+// space to keep writing results. It returns 503 when
+// anything is wrong so orchestration can flag a broken deployment.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	health := HealthStatus{Status: "ok", Database: "ok"}
+	if err := s.db.PingContext(r.Context()); err != nil {
+		health.Status = "unhealthy"
+		health.Database = err.Error()
+	} else if targets, err := s.db.GetTargets(); err != nil {
+		health.Status = "unhealthy"
+		health.Database = err.Error()
+	} else if missing := scheduler.MissingCommands(targets); len(missing) > 0 {
+		health.Status = "unhealthy"
+		health.MissingCommands = missing
+	}
+	if health.Database == "ok" {
+		lags, err := scheduler.RollupLags(s.db, time.Now())
+		if err != nil {
+			log.Printf("Failed to compute rollup lag: %v", err)
+		}
+		for _, l := range lags {
+			health.MaxRollupLagSeconds = max(health.MaxRollupLagSeconds, l.Lag.Seconds())
+		}
+		if health.WALSizeBytes, err = s.db.GetWALSizeBytes(); err != nil {
+			log.Printf("Failed to get WAL size: %v", err)
+		}
+	}
+	if s.scheduler != nil {
+		maintenance := s.scheduler.MaintenanceStatus()
+		health.Maintenance = &maintenance
+		if disk, ok := s.scheduler.DiskStatus(); ok {
+			health.Disk = &disk
+			if disk.Low {
+				health.Status = "unhealthy"
+			}
+		}
+		if checkpoint, ok := s.scheduler.CheckpointStatus(); ok {
+			health.Checkpoint = &checkpoint
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if health.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
+
+// handleGetProbeTypes lists the probe types targets can use and the
+// ProbeConfig options each accepts.
+func (s *Server) handleGetProbeTypes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(probe.Types())
+}
+
 func (s *Server) handleFavicon(w http.ResponseWriter, r *http.Request) {
 	data, err := staticFS.ReadFile("static/favicon.png")
 	if err != nil {
@@ -751,8 +1606,13 @@ func (s *Server) handleStatic(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "image/svg+xml")
 	}
 
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate") // No caching for development
-	w.Write(data)
+	// Assets are embedded in the binary, so a content hash is a stable ETag.
+	// Browsers revalidate on every load (so upgrades show up immediately) but
+	// get a cheap 304 when nothing changed.
+	sum := sha256.Sum256(data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	w.Header().Set("Cache-Control", "public, no-cache")
+	http.ServeContent(w, r, path, time.Time{}, bytes.NewReader(data))
 }
 
 // Dashboard API handlers
@@ -760,7 +1620,7 @@ func (s *Server) handleStatic(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleGetDashboards(w http.ResponseWriter, r *http.Request) {
 	dashboards, err := s.db.GetDashboards()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -770,18 +1630,18 @@ func (s *Server) handleGetDashboards(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleCreateDashboard(w http.ResponseWriter, r *http.Request) {
 	var dash db.Dashboard
 	if err := json.NewDecoder(r.Body).Decode(&dash); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
 		return
 	}
 
 	if dash.Name == "" {
-		http.Error(w, "Name is required", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Name is required", CodeInvalidRequest)
 		return
 	}
 
 	id, err := s.db.AddDashboard(&dash)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 
@@ -794,19 +1654,19 @@ func (s *Server) handleUpdateDashboard(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
 		return
 	}
 
 	var dash db.Dashboard
 	if err := json.NewDecoder(r.Body).Decode(&dash); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
 		return
 	}
 	dash.ID = id
 
 	if dash.Name == "" {
-		http.Error(w, "Name is required", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Name is required", CodeInvalidRequest)
 		return
 	}
 
@@ -814,14 +1674,14 @@ func (s *Server) handleUpdateDashboard(w http.ResponseWriter, r *http.Request) {
 	if dash.IsPublic && dash.PublicSlug == "" {
 		slug, err := s.db.RegenerateDashboardSlug(id)
 		if err != nil {
-			http.Error(w, "Failed to generate public URL: "+err.Error(), http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to generate public URL: "+err.Error(), CodeInternal)
 			return
 		}
 		dash.PublicSlug = slug
 	}
 
 	if err := s.db.UpdateDashboard(&dash); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 
@@ -833,12 +1693,12 @@ func (s *Server) handleDeleteDashboard(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
 		return
 	}
 
 	if err := s.db.DeleteDashboard(id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 
@@ -849,13 +1709,13 @@ func (s *Server) handleGetDashboardGraphs(w http.ResponseWriter, r *http.Request
 	idStr := chi.URLParam(r, "id")
 	dashboardID, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid dashboard ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid dashboard ID", CodeInvalidID)
 		return
 	}
 
 	graphs, err := s.db.GetDashboardGraphs(dashboardID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 
@@ -873,18 +1733,18 @@ func (s *Server) handleCreateDashboardGraph(w http.ResponseWriter, r *http.Reque
 	idStr := chi.URLParam(r, "id")
 	dashboardID, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid dashboard ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid dashboard ID", CodeInvalidID)
 		return
 	}
 
 	var req CreateGraphRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
 		return
 	}
 
 	if req.Title == "" {
-		http.Error(w, "Title is required", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Title is required", CodeInvalidRequest)
 		return
 	}
 
@@ -896,14 +1756,14 @@ func (s *Server) handleCreateDashboardGraph(w http.ResponseWriter, r *http.Reque
 
 	graphID, err := s.db.AddDashboardGraph(graph)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 
 	// Set targets if provided
 	if len(req.TargetIDs) > 0 {
 		if err := s.db.SetGraphTargets(graphID, req.TargetIDs); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 			return
 		}
 	}
@@ -918,25 +1778,25 @@ func (s *Server) handleUpdateDashboardGraph(w http.ResponseWriter, r *http.Reque
 	dashboardIdStr := chi.URLParam(r, "dashboardId")
 	dashboardID, err := strconv.ParseInt(dashboardIdStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid dashboard ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid dashboard ID", CodeInvalidID)
 		return
 	}
 
 	graphIdStr := chi.URLParam(r, "graphId")
 	graphID, err := strconv.ParseInt(graphIdStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid graph ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid graph ID", CodeInvalidID)
 		return
 	}
 
 	var req CreateGraphRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
 		return
 	}
 
 	if req.Title == "" {
-		http.Error(w, "Title is required", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Title is required", CodeInvalidRequest)
 		return
 	}
 
@@ -949,16 +1809,16 @@ func (s *Server) handleUpdateDashboardGraph(w http.ResponseWriter, r *http.Reque
 
 	if err := s.db.UpdateDashboardGraph(graph); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "Graph not found", http.StatusNotFound)
+			writeJSONError(w, http.StatusNotFound, "Graph not found", CodeGraphNotFound)
 			return
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 
 	// Update targets
 	if err := s.db.SetGraphTargets(graphID, req.TargetIDs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 
@@ -971,23 +1831,23 @@ func (s *Server) handleDeleteDashboardGraph(w http.ResponseWriter, r *http.Reque
 	dashboardIdStr := chi.URLParam(r, "dashboardId")
 	dashboardID, err := strconv.ParseInt(dashboardIdStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid dashboard ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid dashboard ID", CodeInvalidID)
 		return
 	}
 
 	graphIdStr := chi.URLParam(r, "graphId")
 	graphID, err := strconv.ParseInt(graphIdStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid graph ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid graph ID", CodeInvalidID)
 		return
 	}
 
 	if err := s.db.DeleteDashboardGraph(graphID, dashboardID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "Graph not found", http.StatusNotFound)
+			writeJSONError(w, http.StatusNotFound, "Graph not found", CodeGraphNotFound)
 			return
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 
@@ -1032,13 +1892,13 @@ func (s *Server) handleRegenerateDashboardSlug(w http.ResponseWriter, r *http.Re
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
 		return
 	}
 
 	slug, err := s.db.RegenerateDashboardSlug(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 
@@ -1049,19 +1909,19 @@ func (s *Server) handleRegenerateDashboardSlug(w http.ResponseWriter, r *http.Re
 func (s *Server) handlePublicDashboardGraphs(w http.ResponseWriter, r *http.Request) {
 	slug := chi.URLParam(r, "slug")
 	if slug == "" {
-		http.Error(w, "Not Found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "Not Found", CodeNotFound)
 		return
 	}
 
 	dash, err := s.db.GetDashboardBySlug(slug)
 	if err != nil {
-		http.Error(w, "Not Found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "Not Found", CodeNotFound)
 		return
 	}
 
 	graphs, err := s.db.GetDashboardGraphs(dash.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
 		return
 	}
 
@@ -1074,27 +1934,27 @@ func (s *Server) handlePublicDashboardResults(w http.ResponseWriter, r *http.Req
 	targetIdStr := chi.URLParam(r, "targetId")
 
 	if slug == "" {
-		http.Error(w, "Not Found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "Not Found", CodeNotFound)
 		return
 	}
 
 	targetId, err := strconv.ParseInt(targetIdStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid target ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid target ID", CodeInvalidID)
 		return
 	}
 
 	// Verify the dashboard exists and is public
 	dash, err := s.db.GetDashboardBySlug(slug)
 	if err != nil {
-		http.Error(w, "Not Found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "Not Found", CodeNotFound)
 		return
 	}
 
 	// Verify the target is part of this dashboard
 	graphs, err := s.db.GetDashboardGraphs(dash.ID)
 	if err != nil {
-		http.Error(w, "Not Found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "Not Found", CodeNotFound)
 		return
 	}
 
@@ -1112,7 +1972,7 @@ func (s *Server) handlePublicDashboardResults(w http.ResponseWriter, r *http.Req
 	}
 
 	if !targetAllowed {
-		http.Error(w, "Not Found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "Not Found", CodeNotFound)
 		return
 	}
 
@@ -1,9 +1,14 @@
 package web
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"html/template"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
@@ -11,6 +16,8 @@ import (
 
 	"vaportrail/internal/config"
 	"vaportrail/internal/db"
+	"vaportrail/internal/probe"
+	"vaportrail/internal/scheduler"
 
 	"github.com/caio/go-tdigest/v4"
 )
@@ -141,6 +148,29 @@ func TestHandleGetResults(t *testing.T) {
 	if rr.Code != http.StatusBadRequest {
 		t.Errorf("Expected status 400 for invalid start time, got %v", rr.Code)
 	}
+
+	// Test 4: Digests are only included when asked for
+	if results[0].TDigest != nil {
+		t.Errorf("Expected no TDigest without raw_digest, got %d bytes", len(results[0].TDigest))
+	}
+	req = httptest.NewRequest("GET", "/api/results/1?raw_digest=true&start="+start+"&end="+end, nil)
+	rr = httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+
+	results = nil
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results))
+	}
+	got, err := db.DeserializeTDigest(results[0].TDigest)
+	if err != nil {
+		t.Fatalf("Failed to deserialize returned digest: %v", err)
+	}
+	if got.Count() != 1 || got.Quantile(0.5) != 100 {
+		t.Errorf("Expected digest with a single 100 sample, got count %d p50 %v", got.Count(), got.Quantile(0.5))
+	}
 }
 
 func TestDashboardGraphRoutesRequireMatchingDashboard(t *testing.T) {
@@ -299,6 +329,157 @@ func TestHandleGetResults_Raw(t *testing.T) {
 	}
 }
 
+func TestHandleGetResults_RawMetadata(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{Name: "Meta", Address: "example.com", ProbeType: "http", RetentionPolicies: `[{"window": 0, "retention": 604800}]`, RecordMetadata: true})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	database.AddRawResults([]db.RawResult{
+		{Time: now.Add(-2 * time.Minute), TargetID: id, Latency: -1, Metadata: `{"http_status":"503"}`},
+		{Time: now.Add(-1 * time.Minute), TargetID: id, Latency: 1e6},
+	})
+
+	req := httptest.NewRequest("GET", "/api/results/"+strconv.Itoa(int(id))+"?raw=true", nil)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v body: %s", rr.Code, rr.Body.String())
+	}
+	var results []APIResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(results) != 2 || string(results[0].Metadata) != `{"http_status":"503"}` || results[1].Metadata != nil {
+		t.Errorf("Expected metadata on the first raw result only, got %+v", results)
+	}
+}
+
+func TestHandleGetResults_RawAfter(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{Name: "Cursor", Address: "example.com", ProbeType: "http", RetentionPolicies: `[{"window": 0, "retention": 604800}]`})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	var batch []db.RawResult
+	for i := range 5 {
+		// Pairs of results at the same time.
+		batch = append(batch, db.RawResult{Time: now.Add(time.Duration(i/2-10) * time.Second), TargetID: id, Latency: float64(i)})
+	}
+	if err := database.AddRawResults(batch); err != nil {
+		t.Fatalf("Failed to add raw results: %v", err)
+	}
+
+	get := func(query string) (int, []APIResult) {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/results/"+strconv.FormatInt(id, 10)+"?raw=true&"+query, nil))
+		var results []APIResult
+		json.NewDecoder(rr.Body).Decode(&results)
+		return rr.Code, results
+	}
+
+	var latencies []float64
+	var after int64
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatalf("Paging didn't end")
+		}
+		code, page := get("limit=2&after=" + strconv.FormatInt(after, 10))
+		if code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", code)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, r := range page {
+			if r.ID == 0 {
+				t.Fatalf("Expected raw results to carry their ID")
+			}
+			latencies = append(latencies, *r.P50)
+		}
+		after = page[len(page)-1].ID
+	}
+	if want := []float64{0, 1, 2, 3, 4}; !slices.Equal(latencies, want) {
+		t.Errorf("Expected pages to hold %v, got %v", want, latencies)
+	}
+
+	for _, query := range []string{"after=-1", "after=x", "after=0&order=desc", "after=0&limit=1001"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, code)
+		}
+	}
+}
+
+func TestHandleGetResults_RawBuckets(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{Name: "Buckets", Address: "example.com", ProbeType: "http", RetentionPolicies: `[{"window": 0, "retention": 604800}]`})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	var batch []db.RawResult
+	for i, latency := range []float64{1e6, 3e6, 2e6, -1, -1, -1} {
+		batch = append(batch, db.RawResult{Time: start.Add(time.Duration(i) * 20 * time.Second), TargetID: id, Latency: latency})
+	}
+	if err := database.AddRawResults(batch); err != nil {
+		t.Fatalf("Failed to add raw results: %v", err)
+	}
+
+	rangeQuery := "start=" + start.Format(time.RFC3339) + "&end=" + start.Add(time.Hour).Format(time.RFC3339)
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/results/"+strconv.FormatInt(id, 10)+"?"+rangeQuery+"&"+query, nil))
+		return rr
+	}
+
+	rr := get("raw=true&bucket=1m")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	body := rr.Body.String()
+	var results []APIResult
+	if err := json.Unmarshal([]byte(body), &results); err != nil {
+		t.Fatalf("Failed to decode results: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 buckets, got %d: %+v", len(results), results)
+	}
+	first := results[0]
+	if !first.Time.Equal(start) || first.WindowSeconds != 60 || first.ProbeCount != 3 || first.TimeoutCount != 0 ||
+		first.MinNS == nil || *first.MinNS != 1e6 || *first.AvgNS != 2e6 || *first.MaxNS != 3e6 || first.P50 != nil {
+		t.Errorf("Unexpected first bucket: %+v", first)
+	}
+	// A bucket of nothing but timeouts has null latencies.
+	if second := results[1]; second.ProbeCount != 0 || second.TimeoutCount != 3 || second.MinNS != nil {
+		t.Errorf("Unexpected second bucket: %+v", second)
+	}
+	if !strings.Contains(body, `"MinNS":null`) {
+		t.Errorf("Expected the timeouts-only bucket to have null latencies: %s", body)
+	}
+
+	for _, query := range []string{
+		"bucket=1m",
+		"raw=true&bucket=0s",
+		"raw=true&bucket=1500ms",
+		"raw=true&bucket=abc",
+		"raw=true&bucket=1s", // 3600 buckets
+		"raw=true&bucket=1m&limit=10",
+		"raw=true&bucket=1m&after=1",
+	} {
+		if rr := get(query); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, rr.Code)
+		}
+	}
+}
+
 func TestHandleGraph(t *testing.T) {
 	s, database := setupTestServer(t)
 	defer database.Close()
@@ -445,6 +626,35 @@ func TestHandleDashboard(t *testing.T) {
 	}
 }
 
+func TestHandleDashboard_DisplayTimezone(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	s.cfg.DisplayTimezone = "Asia/Tokyo"
+	s = New(s.cfg, database, nil)
+
+	tmpl := template.Must(template.Must(s.templates.Clone()).New("t").Parse("{{localTime .}}"))
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if got := buf.String(); got != "2024-01-03 00:04:05 JST" {
+		t.Errorf("Expected UTC time converted to Tokyo, got %q", got)
+	}
+
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", rr.Code)
+	}
+	body := rr.Body.String()
+	if !contains(body, `VaporTrail.setDisplayTimezone("Asia/Tokyo")`) {
+		t.Errorf("Expected the display zone to be passed to the charts script, got:\n%s", body)
+	}
+	if !contains(body, "Times in Asia/Tokyo") || !contains(body, " JST") {
+		t.Errorf("Expected times labelled in Asia/Tokyo, got:\n%s", body)
+	}
+}
+
 func TestPublicDashboard(t *testing.T) {
 	s, database := setupTestServer(t)
 	defer database.Close()
@@ -629,3 +839,728 @@ func TestPublicDashboard(t *testing.T) {
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && s[0:len(substr)] == substr || len(s) > len(substr) && contains(s[1:], substr)
 }
+
+func TestNormalizeTargetIntervals(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval float64
+		timeout  float64
+		wantErr  string
+		want     float64
+	}{
+		{"Default", 0, 0, "", 1.0},
+		{"Sub-second", 0.5, 0, "", 0.5},
+		{"At floor", 0.01, 0, "", 0.01},
+		{"Below floor", 0.001, 0, "ProbeInterval", 0},
+		{"NaN interval", math.NaN(), 0, "ProbeInterval", 0},
+		{"Inf timeout", 1, math.Inf(1), "Timeout", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := db.Target{Name: "t", Address: "127.0.0.1", ProbeType: "ping", ProbeInterval: tt.interval, Timeout: tt.timeout}
+			err := normalizeTarget(&target)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error mentioning %s, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if target.ProbeInterval != tt.want {
+				t.Errorf("Expected ProbeInterval %v, got %v", tt.want, target.ProbeInterval)
+			}
+		})
+	}
+}
+
+func TestHandleGetResults_CorruptDigest(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Corrupt",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	if err := database.AddAggregatedResult(&db.AggregatedResult{
+		Time:          now.Add(-10 * time.Minute),
+		TargetID:      id,
+		WindowSeconds: 60,
+		TDigestData:   []byte("definitely not a digest"),
+		TimeoutCount:  2,
+	}); err != nil {
+		t.Fatalf("Failed to add result: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/api/results/"+strconv.FormatInt(id, 10), nil)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", rr.Code)
+	}
+
+	var raw []map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(raw) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(raw))
+	}
+	for _, field := range []string{"P0", "P50", "P100", "MinNS", "MaxNS", "AvgNS", "Percentiles"} {
+		if v, ok := raw[0][field]; ok {
+			t.Errorf("Expected %s to be omitted for a corrupt digest, got %v", field, v)
+		}
+	}
+	if raw[0]["DigestCorrupt"] != true {
+		t.Errorf("Expected DigestCorrupt to be true, got %v", raw[0]["DigestCorrupt"])
+	}
+	if raw[0]["TimeoutCount"] != float64(2) {
+		t.Errorf("Expected TimeoutCount 2 to be preserved, got %v", raw[0]["TimeoutCount"])
+	}
+}
+
+func TestHandleGetResults_EmptyDigest(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Empty",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	// The same digest the rollup manager stores for a window without
+	// successful probes, next to a window that has one.
+	now := time.Now().UTC().Truncate(time.Minute)
+	empty, _ := tdigest.New(tdigest.Compression(100))
+	emptyData, _ := db.SerializeTDigest(empty)
+	full, _ := tdigest.New(tdigest.Compression(100))
+	full.Add(1000)
+	fullData, _ := db.SerializeTDigest(full)
+	for i, data := range [][]byte{emptyData, fullData} {
+		if err := database.AddAggregatedResult(&db.AggregatedResult{
+			Time:          now.Add(time.Duration(i-10) * time.Minute),
+			TargetID:      id,
+			WindowSeconds: 60,
+			TDigestData:   data,
+			TimeoutCount:  3,
+		}); err != nil {
+			t.Fatalf("Failed to add result: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/results/"+strconv.FormatInt(id, 10), nil)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", rr.Code)
+	}
+
+	var raw []map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(raw) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(raw))
+	}
+	for _, field := range []string{"P0", "P50", "P100", "MinNS", "MaxNS", "AvgNS", "Percentiles"} {
+		if v, ok := raw[0][field]; !ok || v != nil {
+			t.Errorf("Expected %s to be an explicit null for an empty window, got %v (present: %v)", field, v, ok)
+		}
+		if v := raw[1][field]; v == nil {
+			t.Errorf("Expected %s to be set for a window with data", field)
+		}
+	}
+	if raw[0]["ProbeCount"] != float64(0) || raw[0]["TimeoutCount"] != float64(3) {
+		t.Errorf("Expected ProbeCount 0 and TimeoutCount 3, got %v and %v", raw[0]["ProbeCount"], raw[0]["TimeoutCount"])
+	}
+	if _, ok := raw[0]["DigestCorrupt"]; ok {
+		t.Errorf("Expected an empty digest not to be reported as corrupt")
+	}
+}
+
+func TestHandleGetResults_SummaryAggregation(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Summary",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+		Aggregation:       db.AggregationSummary,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	// Two probes of 100 and 300ns, and a window of nothing but timeouts.
+	now := time.Now().UTC().Truncate(time.Minute)
+	database.AddAggregatedResults([]*db.AggregatedResult{
+		{Time: now.Add(-10 * time.Minute), TargetID: id, WindowSeconds: 60, TimeoutCount: 1,
+			SampleCount: 2, SumNS: 400, SumSqNS: 100000, MinNS: 100, MaxNS: 300},
+		{Time: now.Add(-9 * time.Minute), TargetID: id, WindowSeconds: 60, TimeoutCount: 3},
+	})
+
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/results/"+strconv.FormatInt(id, 10), nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", rr.Code)
+	}
+	var raw []map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(raw) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(raw))
+	}
+	want := map[string]any{"MinNS": 100.0, "MaxNS": 300.0, "AvgNS": 200.0, "StdDevNS": 100.0, "ProbeCount": 2.0, "PercentilesUnavailable": true}
+	for field, v := range want {
+		if raw[0][field] != v {
+			t.Errorf("Expected %s %v, got %v", field, v, raw[0][field])
+		}
+	}
+	for _, field := range []string{"P50", "P99", "Percentiles"} {
+		if _, ok := raw[0][field]; ok {
+			t.Errorf("Expected no %s in summary mode, got %v", field, raw[0][field])
+		}
+	}
+	if raw[1]["PercentilesUnavailable"] != true || raw[1]["ProbeCount"] != 0.0 || raw[1]["AvgNS"] != nil {
+		t.Errorf("Unexpected window of timeouts: %v", raw[1])
+	}
+
+	target := db.Target{Name: "t", Address: "127.0.0.1", ProbeType: "ping", Aggregation: "median"}
+	if err := normalizeTarget(&target); err == nil || !strings.Contains(err.Error(), "Aggregation") {
+		t.Errorf("Expected an invalid Aggregation to be rejected, got %v", err)
+	}
+	target = db.Target{Name: "t", Address: "127.0.0.1", ProbeType: "ping", Schedule: "weekdays 09:00-17:00"}
+	if err := normalizeTarget(&target); err == nil || !strings.Contains(err.Error(), "Schedule") {
+		t.Errorf("Expected an invalid Schedule to be rejected, got %v", err)
+	}
+}
+
+func TestHandleGetResults_Unit(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Units",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Minute)
+	empty, _ := tdigest.New(tdigest.Compression(100))
+	emptyData, _ := db.SerializeTDigest(empty)
+	full, _ := tdigest.New(tdigest.Compression(100))
+	full.Add(1.5e6)
+	fullData, _ := db.SerializeTDigest(full)
+	for i, data := range [][]byte{emptyData, fullData} {
+		if err := database.AddAggregatedResult(&db.AggregatedResult{
+			Time:          now.Add(time.Duration(i-10) * time.Minute),
+			TargetID:      id,
+			WindowSeconds: 60,
+			TDigestData:   data,
+		}); err != nil {
+			t.Fatalf("Failed to add result: %v", err)
+		}
+	}
+	if err := database.AddRawResults([]db.RawResult{{Time: now.Add(-5 * time.Minute), TargetID: id, Latency: 2.5e6}}); err != nil {
+		t.Fatalf("Failed to add raw result: %v", err)
+	}
+
+	get := func(query string) (int, []map[string]any) {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/results/"+strconv.FormatInt(id, 10)+query, nil))
+		var raw []map[string]any
+		json.Unmarshal(rr.Body.Bytes(), &raw)
+		return rr.Code, raw
+	}
+
+	// The default, and an explicit ns, keep the original fields.
+	for _, query := range []string{"", "?unit=ns"} {
+		code, raw := get(query)
+		if code != http.StatusOK || len(raw) != 2 {
+			t.Fatalf("%q: expected 2 results, got status %d and %d results", query, code, len(raw))
+		}
+		if raw[1]["MinNS"] != 1.5e6 || raw[1]["P50"] != 1.5e6 {
+			t.Errorf("%q: expected latencies in ns, got %v", query, raw[1])
+		}
+		if _, ok := raw[1]["Unit"]; ok {
+			t.Errorf("%q: expected no Unit field", query)
+		}
+	}
+
+	code, raw := get("?unit=ms&ma=1")
+	if code != http.StatusOK || len(raw) != 2 {
+		t.Fatalf("Expected 2 results, got status %d and %d results", code, len(raw))
+	}
+	for _, field := range []string{"Min", "Max", "Avg", "P0", "P50", "P100", "P50MA"} {
+		if raw[1][field] != 1.5 {
+			t.Errorf("Expected %s 1.5ms, got %v", field, raw[1][field])
+		}
+	}
+	if pcts, _ := raw[1]["Percentiles"].([]any); len(pcts) != 21 || pcts[10] != 1.5 {
+		t.Errorf("Expected percentiles in ms, got %v", raw[1]["Percentiles"])
+	}
+	if raw[1]["Unit"] != "ms" {
+		t.Errorf("Expected Unit ms, got %v", raw[1]["Unit"])
+	}
+	for _, field := range []string{"MinNS", "MaxNS", "AvgNS"} {
+		if _, ok := raw[1][field]; ok {
+			t.Errorf("Expected %s to be replaced when a unit is given", field)
+		}
+	}
+	for _, field := range []string{"Min", "P50", "Percentiles"} {
+		if v, ok := raw[0][field]; !ok || v != nil {
+			t.Errorf("Expected %s to be an explicit null for an empty window, got %v (present: %v)", field, v, ok)
+		}
+	}
+
+	code, raw = get("?unit=us&raw=true")
+	if code != http.StatusOK || len(raw) != 1 || raw[0]["Min"] != 2500.0 || raw[0]["P50"] != 2500.0 {
+		t.Errorf("Expected a raw result of 2500us, got status %d: %v", code, raw)
+	}
+
+	if code, _ := get("?unit=s"); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown unit, got %d", code)
+	}
+
+	// Scaling must not leak into the cached digest stats.
+	if _, raw := get(""); len(raw) != 2 || raw[1]["P50"] != 1.5e6 {
+		t.Errorf("Expected ns after a scaled request, got %v", raw)
+	}
+}
+
+// addSummaryTarget adds a target with summary aggregation and two minute
+// rollups, starting at the returned time two minutes ago: probes of 100 and
+// 300ns, then two of 500ns and a timeout.
+func addSummaryTarget(t *testing.T, database *db.DB, name string) (int64, time.Time) {
+	t.Helper()
+	id, err := database.AddTarget(&db.Target{
+		Name:              name,
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+		Aggregation:       db.AggregationSummary,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	start := time.Now().UTC().Truncate(time.Minute).Add(-2 * time.Minute)
+	if err := database.AddAggregatedResults([]*db.AggregatedResult{
+		{Time: start, TargetID: id, WindowSeconds: 60, SampleCount: 2, SumNS: 400, SumSqNS: 100000, MinNS: 100, MaxNS: 300},
+		{Time: start.Add(time.Minute), TargetID: id, WindowSeconds: 60, TimeoutCount: 1, SampleCount: 2, SumNS: 1000, SumSqNS: 500000, MinNS: 500, MaxNS: 500},
+	}); err != nil {
+		t.Fatalf("Failed to add results: %v", err)
+	}
+	return id, start
+}
+
+func TestHandleHealthz(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	if _, err := database.AddTarget(&db.Target{Name: "resolver", Address: "1.1.1.1", ProbeType: "dns"}); err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var health HealthStatus
+	if err := json.NewDecoder(rr.Body).Decode(&health); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if health.Status != "ok" || len(health.MissingCommands) != 0 {
+		t.Errorf("Expected healthy status with no missing commands, got %+v", health)
+	}
+	if health.Checkpoint != nil {
+		t.Errorf("Expected no checkpoint status without a scheduler, got %+v", health.Checkpoint)
+	}
+
+	s.scheduler = scheduler.New(database)
+	s.scheduler.SetCheckpointInterval(time.Minute, 1<<20)
+	rr = httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	health = HealthStatus{}
+	if err := json.NewDecoder(rr.Body).Decode(&health); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if rr.Code != http.StatusOK || health.Checkpoint == nil || health.Checkpoint.TruncateBytes != 1<<20 {
+		t.Errorf("Expected a healthy status reporting checkpoints, got %d and %+v", rr.Code, health.Checkpoint)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	database, err := db.New("file::memory:?cache=shared")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer database.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.HTTPPort = 0
+	s := New(cfg, database, nil)
+	if s.httpSrv.ReadHeaderTimeout != cfg.ReadHeaderTimeout || s.httpSrv.MaxHeaderBytes != cfg.MaxHeaderBytes {
+		t.Errorf("Expected server limits to come from config")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Start() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected Start to return nil after Shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Shutdown")
+	}
+}
+
+func TestHandleGetResults_MovingAverage(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Test Target",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var batch []db.RawResult
+	for i, latency := range []float64{10, 20, 30, 40} {
+		batch = append(batch, db.RawResult{
+			Time:     now.Add(time.Duration(i-4) * time.Minute),
+			TargetID: id,
+			Latency:  latency,
+		})
+	}
+	if err := database.AddRawResults(batch); err != nil {
+		t.Fatalf("Failed to add raw results: %v", err)
+	}
+
+	get := func(ma string) *httptest.ResponseRecorder {
+		url := "/api/results/" + strconv.Itoa(int(id)) + "?raw=true&ma=" + ma +
+			"&start=" + now.Add(-time.Hour).Format(time.RFC3339) + "&end=" + now.Format(time.RFC3339)
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		return rr
+	}
+
+	rr := get("3")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var results []APIResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := []float64{10, 15, 20, 30}
+	if len(results) != len(want) {
+		t.Fatalf("Expected %d results, got %d", len(want), len(results))
+	}
+	for i, w := range want {
+		if results[i].P50MA == nil || *results[i].P50MA != w {
+			t.Errorf("Point %d: expected moving average %v, got %v", i, w, results[i].P50MA)
+		}
+	}
+
+	for _, bad := range []string{"0", "-2", "abc"} {
+		if rr := get(bad); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for ma=%s, got %d", bad, rr.Code)
+		}
+	}
+}
+
+func TestHandleGetProbeTypes(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/probe-types", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", rr.Code)
+	}
+	var types []probe.TypeInfo
+	if err := json.NewDecoder(rr.Body).Decode(&types); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	var names []string
+	for _, pt := range types {
+		names = append(names, pt.Name)
+	}
+	if strings.Join(names, ",") != "ping,http,dns" {
+		t.Errorf("Expected ping,http,dns, got %v", names)
+	}
+	for _, pt := range types {
+		if pt.DefaultProbeInterval <= 0 || pt.DefaultTimeout <= 0 {
+			t.Errorf("Expected %s to advertise a default interval and timeout, got %v/%v", pt.Name, pt.DefaultProbeInterval, pt.DefaultTimeout)
+		}
+	}
+}
+
+func TestHandleGetResults_MinSamplesForPercentiles(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	s.cfg.MinSamplesForPercentiles = 3
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Sparse",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	for i, latencies := range [][]float64{{100, 900}, {100, 200, 300}} {
+		td, _ := tdigest.New(tdigest.Compression(100))
+		for _, l := range latencies {
+			td.Add(l)
+		}
+		data, _ := db.SerializeTDigest(td)
+		if err := database.AddAggregatedResult(&db.AggregatedResult{
+			Time:          now.Add(time.Duration(i-10) * time.Minute),
+			TargetID:      id,
+			WindowSeconds: 60,
+			TDigestData:   data,
+		}); err != nil {
+			t.Fatalf("Failed to add result: %v", err)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/results/"+strconv.FormatInt(id, 10), nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", rr.Code)
+	}
+	var raw []map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(raw) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(raw))
+	}
+
+	sparse := raw[0]
+	if sparse["InsufficientSamples"] != true {
+		t.Errorf("Expected the 2-sample window to be flagged, got %v", sparse["InsufficientSamples"])
+	}
+	for _, field := range []string{"P0", "P50", "P99", "P100", "Percentiles"} {
+		if v, ok := sparse[field]; ok {
+			t.Errorf("Expected %s to be omitted for a sparse window, got %v", field, v)
+		}
+	}
+	if sparse["MinNS"] != float64(100) || sparse["MaxNS"] != float64(900) || sparse["AvgNS"] != float64(500) {
+		t.Errorf("Expected min/max/avg to still be reported, got %v/%v/%v", sparse["MinNS"], sparse["MaxNS"], sparse["AvgNS"])
+	}
+
+	if _, ok := raw[1]["InsufficientSamples"]; ok {
+		t.Errorf("Expected the 3-sample window not to be flagged")
+	}
+	if raw[1]["P50"] != float64(200) {
+		t.Errorf("Expected P50 200 for the 3-sample window, got %v", raw[1]["P50"])
+	}
+}
+
+func TestHandleCreateTarget_MaxTargets(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	s.cfg.MaxTargets = 2
+
+	create := func(name string) int {
+		body := `{"Name": "` + name + `", "Address": "example.com", "ProbeType": "http"}`
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/targets", strings.NewReader(body)))
+		return rr.Code
+	}
+
+	for _, name := range []string{"first", "second"} {
+		if code := create(name); code != http.StatusCreated {
+			t.Fatalf("Expected %s to be created, got status %d", name, code)
+		}
+	}
+	if code := create("third"); code != http.StatusConflict {
+		t.Errorf("Expected status 409 once the limit is reached, got %d", code)
+	}
+	targets, _ := database.GetTargets()
+	if len(targets) != 2 {
+		t.Errorf("Expected 2 targets to exist, got %d", len(targets))
+	}
+
+	s.cfg.MaxTargets = 0
+	if code := create("third"); code != http.StatusCreated {
+		t.Errorf("Expected no limit when MaxTargets is 0, got status %d", code)
+	}
+}
+
+func TestHandleGetResults_LimitAndOrder(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{
+		Name:              "Test Target",
+		Address:           "example.com",
+		ProbeType:         "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var batch []db.RawResult
+	for i, latency := range []float64{10, 20, 30, 40, 50} {
+		batch = append(batch, db.RawResult{
+			Time:     now.Add(time.Duration(i-5) * time.Minute),
+			TargetID: id,
+			Latency:  latency,
+		})
+	}
+	if err := database.AddRawResults(batch); err != nil {
+		t.Fatalf("Failed to add raw results: %v", err)
+	}
+
+	get := func(params string) *httptest.ResponseRecorder {
+		url := "/api/results/" + strconv.Itoa(int(id)) + "?raw=true&" + params +
+			"&start=" + now.Add(-time.Hour).Format(time.RFC3339) + "&end=" + now.Format(time.RFC3339)
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		return rr
+	}
+
+	tests := []struct {
+		params string
+		want   []float64
+	}{
+		{"limit=2", []float64{10, 20}},
+		{"limit=2&order=asc", []float64{10, 20}},
+		{"limit=2&order=desc", []float64{50, 40}},
+		{"order=desc", []float64{50, 40, 30, 20, 10}},
+	}
+	for _, tt := range tests {
+		rr := get(tt.params)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %v: %s", tt.params, rr.Code, rr.Body.String())
+		}
+		var results []APIResult
+		if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", tt.params, err)
+		}
+		var got []float64
+		for _, r := range results {
+			got = append(got, *r.P50)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.params, tt.want, got)
+		}
+	}
+
+	// The moving average runs oldest to newest whatever the order.
+	rr := get("order=desc&limit=2&ma=2")
+	var results []APIResult
+	json.NewDecoder(rr.Body).Decode(&results)
+	if len(results) != 2 || results[0].P50MA == nil || *results[0].P50MA != 45 {
+		t.Errorf("Expected the newest point first with a moving average of 45, got %+v", results)
+	}
+
+	for _, params := range []string{"limit=0", "limit=abc", "order=sideways", "limit=1001"} {
+		if rr := get(params); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %v", params, rr.Code)
+		}
+	}
+}
+
+func TestHandleCreateTarget_NormalizesAddress(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	create := func(address, probeType string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"Name": address, "Address": address, "ProbeType": probeType})
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/targets", bytes.NewReader(body)))
+		return rr
+	}
+
+	rr := create("  example.com/health ", "http")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created db.Target
+	json.NewDecoder(rr.Body).Decode(&created)
+	if stored, _ := database.GetTarget(created.ID); stored == nil || stored.Address != "http://example.com/health" {
+		t.Errorf("Expected the address to be stored normalized, got %+v", stored)
+	}
+
+	rr = create("https://example.com", "ping")
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "Invalid address") {
+		t.Errorf("Expected a 400 explaining the address is invalid, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandleCreateTarget_ProbeTypeDefaults(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	create := func(target map[string]any) db.Target {
+		t.Helper()
+		body, _ := json.Marshal(target)
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/targets", bytes.NewReader(body)))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var created db.Target
+		json.NewDecoder(rr.Body).Decode(&created)
+		stored, err := database.GetTarget(created.ID)
+		if err != nil {
+			t.Fatalf("GetTarget failed: %v", err)
+		}
+		return *stored
+	}
+
+	interval, timeout, _ := probe.TypeDefaults("http")
+	got := create(map[string]any{"Name": "defaults", "Address": "http://example.com", "ProbeType": "http"})
+	if got.ProbeInterval != interval || got.Timeout != timeout {
+		t.Errorf("Expected http defaults %v/%v, got %v/%v", interval, timeout, got.ProbeInterval, got.Timeout)
+	}
+
+	got = create(map[string]any{"Name": "explicit", "Address": "http://example.com", "ProbeType": "http", "ProbeInterval": 2.5, "Timeout": 1})
+	if got.ProbeInterval != 2.5 || got.Timeout != 1 {
+		t.Errorf("Expected explicit values to be kept, got %v/%v", got.ProbeInterval, got.Timeout)
+	}
+}
@@ -0,0 +1,69 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"vaportrail/internal/db"
+	"vaportrail/internal/scheduler"
+)
+
+// RetentionSettings is the body of GET and PUT /api/settings/retention: the
+// retention policies inherited by targets without their own. Precedence is
+// a target's own policies, then these, then the built-in defaults, which
+// Default reports are in use. PUT ignores Default, and empty Policies
+// restore the built-in defaults.
+type RetentionSettings struct {
+	Policies []scheduler.RetentionPolicy `json:"policies"`
+	Default  bool                        `json:"default"`
+}
+
+func currentRetentionSettings() RetentionSettings {
+	policies, set := scheduler.GlobalRetentionPolicies()
+	return RetentionSettings{Policies: policies, Default: !set}
+}
+
+func (s *Server) handleGetRetentionSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentRetentionSettings())
+}
+
+// handlePutRetentionSettings replaces the global retention policies. Like
+// editing a target's policies, it deletes the rollups of windows that
+// inheriting targets no longer keep.
+func (s *Server) handlePutRetentionSettings(w http.ResponseWriter, r *http.Request) {
+	var req RetentionSettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+		return
+	}
+	value := ""
+	newPolicies := scheduler.DefaultPolicies()
+	if len(req.Policies) > 0 {
+		if err := scheduler.ValidateRetentionPolicies(req.Policies); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid retention policies: "+err.Error(), CodeInvalidRequest)
+			return
+		}
+		data, _ := json.Marshal(req.Policies)
+		value = string(data)
+		newPolicies = req.Policies
+	}
+
+	targets, err := s.db.GetTargets()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	if err := s.db.SetSetting(db.SettingRetentionPolicies, value); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	for _, t := range targets {
+		if scheduler.InheritsRetentionPolicies(t) {
+			s.dropRemovedWindows(t, newPolicies)
+		}
+	}
+	scheduler.SetGlobalRetentionPolicies(req.Policies)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentRetentionSettings())
+}
@@ -0,0 +1,79 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+	"vaportrail/internal/scheduler"
+)
+
+func TestRetentionSettings(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+	defer scheduler.SetGlobalRetentionPolicies(nil)
+
+	do := func(method, body string) (*httptest.ResponseRecorder, RetentionSettings) {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest(method, "/api/settings/retention", strings.NewReader(body)))
+		var settings RetentionSettings
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&settings); err != nil {
+				t.Fatalf("Failed to decode settings: %v", err)
+			}
+		}
+		return rr, settings
+	}
+
+	if rr, settings := do("GET", ""); rr.Code != http.StatusOK || !settings.Default || len(settings.Policies) != len(scheduler.DefaultPolicies()) {
+		t.Fatalf("Expected the built-in defaults, got %d %+v", rr.Code, settings)
+	}
+
+	// An inheriting target loses the rollups of windows the new global
+	// policies drop; a target with its own policies keeps them.
+	inheriting, _ := database.AddTarget(&db.Target{Name: "Inheriting", Address: "example.com", ProbeType: "http"})
+	own, _ := database.AddTarget(&db.Target{Name: "Own", Address: "example.org", ProbeType: "http",
+		RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 300, "retention": 604800}]`})
+	windowStart := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	for _, id := range []int64{inheriting, own} {
+		database.AddAggregatedResult(&db.AggregatedResult{Time: windowStart, TargetID: id, WindowSeconds: 300})
+	}
+
+	rr, settings := do("PUT", `{"policies": [{"window": 60, "retention": 86400}, {"window": 0, "retention": 3600}]}`)
+	if rr.Code != http.StatusOK || settings.Default || len(settings.Policies) != 2 || settings.Policies[0].Window != 0 {
+		t.Fatalf("Expected the new global policies, got %d %+v", rr.Code, settings)
+	}
+	if stored, _ := database.GetSetting(db.SettingRetentionPolicies); stored == "" {
+		t.Error("Expected the global policies to be stored")
+	}
+	target, _ := database.GetTarget(inheriting)
+	if policies, _ := scheduler.GetRetentionPolicies(*target); len(policies) != 2 || policies[1].Window != 60 {
+		t.Errorf("Expected the target to inherit the global policies, got %v", policies)
+	}
+	if results, _ := database.GetAggregatedResults(inheriting, 300, windowStart, windowStart.Add(time.Hour)); len(results) != 0 {
+		t.Errorf("Expected the inheriting target's 300s rollups to be dropped, got %d", len(results))
+	}
+	if results, _ := database.GetAggregatedResults(own, 300, windowStart, windowStart.Add(time.Hour)); len(results) != 1 {
+		t.Errorf("Expected the other target's 300s rollups to be kept, got %d", len(results))
+	}
+
+	if rr, _ := do("PUT", `{"policies": [{"window": 0}]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid policies, got %d", rr.Code)
+	}
+
+	if rr, settings := do("PUT", `{"policies": []}`); rr.Code != http.StatusOK || !settings.Default {
+		t.Errorf("Expected empty policies to restore the defaults, got %d %+v", rr.Code, settings)
+	}
+	if stored, _ := database.GetSetting(db.SettingRetentionPolicies); stored != "" {
+		t.Errorf("Expected the stored policies to be removed, got %s", stored)
+	}
+
+	s.cfg.WriteToken = "secret"
+	if rr, _ := do("PUT", `{"policies": []}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the write token, got %d", rr.Code)
+	}
+}
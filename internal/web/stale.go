@@ -0,0 +1,37 @@
+package web
+
+import (
+	"time"
+	"vaportrail/internal/db"
+	"vaportrail/internal/scheduler"
+)
+
+// staleAfter returns how old a target's latest result may get before the
+// target is stale: StaleAfterIntervals probe intervals, or timeouts if
+// those are longer, since a probe's result is stamped with its start. Zero
+// means never.
+func (s *Server) staleAfter(t db.Target) time.Duration {
+	if s.cfg.StaleAfterIntervals <= 0 {
+		return 0
+	}
+	period := max(t.ProbeInterval, t.Timeout*float64(t.RetryCount+1))
+	return time.Duration(s.cfg.StaleAfterIntervals * period * float64(time.Second))
+}
+
+// isStale reports whether a target whose latest result is at last has
+// stopped producing results. A target without any results yet isn't stale,
+// nor is any target while probing is paused for maintenance, nor one whose
+// schedule was off at any point within the limit.
+func (s *Server) isStale(t db.Target, last, now time.Time) bool {
+	limit := s.staleAfter(t)
+	if limit <= 0 || last.IsZero() {
+		return false
+	}
+	if s.scheduler != nil && s.scheduler.MaintenanceStatus().Paused {
+		return false
+	}
+	if sc, err := scheduler.ParseSchedule(t.Schedule); err == nil && sc.OffDuring(now.Add(-limit), now) {
+		return false
+	}
+	return now.Sub(last) > limit
+}
@@ -9,14 +9,58 @@ const VaporTrail = (function () {
     // TIME UTILITIES
     // ============================================
 
+    // IANA zone times are shown in; null uses the browser's zone.
+    let displayZone = null;
+
+    /**
+     * Show times in the given IANA zone (the server's display_timezone).
+     * An empty zone keeps the browser's zone.
+     */
+    function setDisplayTimezone(zone) {
+        displayZone = zone || null;
+        if (displayZone && typeof luxon !== 'undefined') {
+            luxon.Settings.defaultZone = displayZone;
+        }
+    }
+
+    /**
+     * Format a Date or timestamp for display in the display zone
+     */
+    function formatTime(t) {
+        const opts = displayZone ? { timeZone: displayZone, timeZoneName: 'short' } : undefined;
+        return new Date(t).toLocaleString(undefined, opts);
+    }
+
     /**
      * Convert a Date to local ISO string for datetime-local input
      */
     function toLocalISO(d) {
+        if (displayZone && typeof luxon !== 'undefined') {
+            return luxon.DateTime.fromJSDate(d).setZone(displayZone).toFormat("yyyy-MM-dd'T'HH:mm");
+        }
         const offset = d.getTimezoneOffset() * 60000;
         return new Date(d.getTime() - offset).toISOString().slice(0, 16);
     }
 
+    /**
+     * Parse a datetime-local input value, the inverse of toLocalISO
+     */
+    function parseLocalISO(s) {
+        if (displayZone && typeof luxon !== 'undefined') {
+            return luxon.DateTime.fromISO(s, { zone: displayZone }).toJSDate();
+        }
+        return new Date(s);
+    }
+
+    /**
+     * Format a nanosecond latency as milliseconds. Latency fields are omitted
+     * by the API when a window has no readable data, so show a dash instead.
+     */
+    function formatMs(ns) {
+        if (ns === undefined || ns === null) return '-';
+        return `${(ns / 1e6).toFixed(2)} ms`;
+    }
+
     /**
      * Standard time display formats for Chart.js time axis
      */
@@ -164,11 +208,11 @@ const VaporTrail = (function () {
                 ctx.lineWidth = 3;
 
                 data.forEach((d, idx) => {
-                    if (!d || d.ProbeCount === 0) return;
+                    if (!d || d.ProbeCount === 0 || d.P50 == null) return;
                     const bar = meta.data[idx];
                     if (!bar) return;
 
-                    const medianVal = (d.P50 || 0) / 1000000;
+                    const medianVal = d.P50 / 1000000;
                     const medianY = scales.y.getPixelForValue(medianVal);
                     const width = bar.width;
                     const x = bar.x - width / 2;
@@ -370,7 +414,7 @@ const VaporTrail = (function () {
 
                 let content = '';
                 if (barData.originalTime) {
-                    content += `<div style="font-weight:bold; margin-bottom:5px;">${new Date(barData.originalTime).toLocaleString()}</div>`;
+                    content += `<div style="font-weight:bold; margin-bottom:5px;">${formatTime(barData.originalTime)}</div>`;
                 }
 
                 if (originalData.Percentiles && originalData.Percentiles.length === 21) {
@@ -380,10 +424,14 @@ const VaporTrail = (function () {
                         const val = originalData.Percentiles[idx] / 1e6;
                         content += `<div>P${p}: ${val.toFixed(2)} ms</div>`;
                     }
+                } else if (originalData.InsufficientSamples) {
+                    content += `<div>Max: ${formatMs(originalData.MaxNS)}</div>`;
+                    content += `<div>Min: ${formatMs(originalData.MinNS)}</div>`;
+                    content += `<div style="font-style: italic;">Too few samples for percentiles</div>`;
                 } else {
-                    content += `<div>Max: ${(originalData.P100 / 1e6).toFixed(2)} ms</div>`;
-                    content += `<div>Median: ${(originalData.P50 / 1e6).toFixed(2)} ms</div>`;
-                    content += `<div>Min: ${(originalData.P0 / 1e6).toFixed(2)} ms</div>`;
+                    content += `<div>Max: ${formatMs(originalData.P100)}</div>`;
+                    content += `<div>Median: ${formatMs(originalData.P50)}</div>`;
+                    content += `<div>Min: ${formatMs(originalData.P0)}</div>`;
                 }
                 content += `<hr style="border: 0; border-top: 1px solid #555; margin: 5px 0;">`;
                 content += `<div>Success: ${originalData.ProbeCount}</div>`;
@@ -430,7 +478,7 @@ const VaporTrail = (function () {
 
                 let content = '';
                 if (barData && barData.originalTime) {
-                    content += `<div style="font-weight:bold; margin-bottom:5px;">${new Date(barData.originalTime).toLocaleString()}</div>`;
+                    content += `<div style="font-weight:bold; margin-bottom:5px;">${formatTime(barData.originalTime)}</div>`;
                 }
 
                 for (const dp of tooltipModel.dataPoints) {
@@ -441,9 +489,9 @@ const VaporTrail = (function () {
                     const tname = targetsMap[tid] || `Target ${tid}`;
 
                     content += `<div style="font-weight:bold; margin-top:5px;">${tname}</div>`;
-                    content += `<div>  Max: ${(d.P100 / 1e6).toFixed(2)} ms</div>`;
-                    content += `<div>  Median: ${(d.P50 / 1e6).toFixed(2)} ms</div>`;
-                    content += `<div>  Min: ${(d.P0 / 1e6).toFixed(2)} ms</div>`;
+                    content += `<div>  Max: ${formatMs(d.P100)}</div>`;
+                    content += `<div>  Median: ${formatMs(d.P50)}</div>`;
+                    content += `<div>  Min: ${formatMs(d.P0)}</div>`;
                     content += `<div>  Success: ${d.ProbeCount} | Timeout: ${d.TimeoutCount}</div>`;
                 }
 
@@ -739,7 +787,7 @@ const VaporTrail = (function () {
                     title: function (context) {
                         // Use originalTime (window start) instead of centered x value
                         if (context.length > 0 && context[0].raw.originalTime) {
-                            return new Date(context[0].raw.originalTime).toLocaleString();
+                            return formatTime(context[0].raw.originalTime);
                         }
                         return '';
                     },
@@ -750,9 +798,9 @@ const VaporTrail = (function () {
                         if (!d) return '';
                         return [
                             `${tname}:`,
-                            `  Max: ${(d.P100 / 1e6).toFixed(2)} ms`,
-                            `  Median: ${(d.P50 / 1e6).toFixed(2)} ms`,
-                            `  Min: ${(d.P0 / 1e6).toFixed(2)} ms`,
+                            `  Max: ${formatMs(d.P100)}`,
+                            `  Median: ${formatMs(d.P50)}`,
+                            `  Min: ${formatMs(d.P0)}`,
                             `  Success: ${d.ProbeCount}`,
                             `  Timeout: ${d.TimeoutCount}`
                         ];
@@ -766,7 +814,7 @@ const VaporTrail = (function () {
                 callbacks: {
                     title: function (context) {
                         if (context.length > 0 && context[0].raw && context[0].raw.originalTime) {
-                            return new Date(context[0].raw.originalTime).toLocaleString();
+                            return formatTime(context[0].raw.originalTime);
                         }
                         return '';
                     }
@@ -802,7 +850,7 @@ const VaporTrail = (function () {
                 const color = colors[i % colors.length];
                 const targetName = targetsMap[targetId] || `Target ${targetId}`;
 
-                const p50Data = targetData.map(d => ({ x: d.Time, y: (d.P50) / 1000000 }));
+                const p50Data = targetData.map(d => ({ x: d.Time, y: d.P50 == null ? null : d.P50 / 1000000 }));
 
                 datasets.push({
                     label: `${targetName} P50`,
@@ -818,7 +866,7 @@ const VaporTrail = (function () {
         } else {
             // Single target - full line chart
             const p0Data = data.map(d => ({ x: d.Time, y: (d.P0 || d.MinNS) / 1000000 }));
-            const p50Data = data.map(d => ({ x: d.Time, y: (d.P50) / 1000000 }));
+            const p50Data = data.map(d => ({ x: d.Time, y: d.P50 == null ? null : d.P50 / 1000000 }));
             const p100Data = data.map(d => ({ x: d.Time, y: (d.P100 || d.MaxNS) / 1000000 }));
             const timeoutPercentageData = data.map(d => {
                 const total = d.ProbeCount + d.TimeoutCount;
@@ -940,7 +988,10 @@ const VaporTrail = (function () {
     // ============================================
 
     return {
+        setDisplayTimezone,
+        formatTime,
         toLocalISO,
+        parseLocalISO,
         TIME_DISPLAY_FORMATS,
         calculateSuggestedYMax,
         transformToBarData,
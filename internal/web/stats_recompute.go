@@ -0,0 +1,19 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// handleRecomputeStats rebuilds the row and byte counts shown on the status
+// page from the result tables, for when they no longer match them, e.g.
+// after a crash. It replies with the corrected db.StatsTotals.
+func (s *Server) handleRecomputeStats(w http.ResponseWriter, r *http.Request) {
+	totals, err := s.db.RecomputeStats()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(totals)
+}
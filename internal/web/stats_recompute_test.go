@@ -0,0 +1,43 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+)
+
+func TestHandleRecomputeStats(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	id, err := database.AddTarget(&db.Target{Name: "Stats", Address: "example.com", ProbeType: "http"})
+	if err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+	if err := database.AddRawResults([]db.RawResult{{Time: time.Now().UTC(), TargetID: id, Latency: 100}}); err != nil {
+		t.Fatalf("Failed to add raw results: %v", err)
+	}
+	if _, err := database.Exec(`UPDATE data_stats SET row_count = 42 WHERE stat_key = 'raw_count'`); err != nil {
+		t.Fatalf("Failed to desync stats: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/maintenance/recompute-stats", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var totals db.StatsTotals
+	if err := json.NewDecoder(rr.Body).Decode(&totals); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if totals.RawCount != 1 || totals.AggregatedCount != 0 {
+		t.Errorf("Expected 1 raw and 0 aggregated results, got %+v", totals)
+	}
+	if stats, _ := database.GetRawStats(); stats.Count != 1 {
+		t.Errorf("Expected raw stats count 1 after recompute, got %d", stats.Count)
+	}
+}
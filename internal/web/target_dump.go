@@ -0,0 +1,424 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	"vaportrail/internal/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// dumpVersion is bumped whenever the dump format changes in a way that older
+// importers can't read.
+const dumpVersion = 1
+
+// Record types in a dump.
+const (
+	dumpHeader     = "header"
+	dumpRaw        = "raw"
+	dumpAggregated = "aggregated"
+)
+
+const (
+	// dumpRawChunk is the span of raw results loaded from the database at a
+	// time while streaming a dump; aggregated results are read
+	// influxExportChunk windows at a time.
+	dumpRawChunk = time.Hour
+	// dumpBatchSize is how many records an import inserts per transaction.
+	dumpBatchSize = 1000
+)
+
+// DumpRecord is one line of a target dump. The first line is a header
+// carrying the format version and the target's definition; it is followed
+// by every raw result, oldest first, and then every aggregated result,
+// window by window.
+type DumpRecord struct {
+	Type string `json:"type"`
+
+	// Header fields.
+	Version int               `json:"version,omitempty"`
+	Target  *TargetDefinition `json:"target,omitempty"`
+
+	// Result fields. Latency and Metadata are set for raw results, with
+	// Latency -1 for a timeout; the rest, but for Metrics, are set for
+	// aggregated results. TDigest is the stored blob, in whatever codec it
+	// was written with, and TDigestCRC32 its IEEE CRC-32, so corruption in
+	// transit is caught on import.
+	Time          time.Time       `json:"time,omitzero"`
+	Latency       *float64        `json:"latency,omitempty"`
+	Metadata      json.RawMessage `json:"metadata,omitempty"`
+	WindowSeconds int             `json:"window_seconds,omitempty"`
+	TDigest       []byte          `json:"tdigest,omitempty"`
+	TDigestCRC32  uint32          `json:"tdigest_crc32,omitempty"`
+	TimeoutCount  int64           `json:"timeout_count,omitempty"`
+	SampleCount   int64           `json:"sample_count,omitempty"`
+	SumNS         float64         `json:"sum_ns,omitempty"`
+	SumSqNS       float64         `json:"sum_sq_ns,omitempty"`
+	MinNS         float64         `json:"min_ns,omitempty"`
+	MaxNS         float64         `json:"max_ns,omitempty"`
+	Maintenance   bool            `json:"maintenance,omitempty"`
+
+	SourceWindows         int `json:"source_windows,omitempty"`
+	ExpectedSourceWindows int `json:"expected_source_windows,omitempty"`
+
+	Percentiles map[string]float64 `json:"percentiles,omitempty"`
+	Metrics     []DumpMetric       `json:"metrics,omitempty"`
+}
+
+// DumpMetric is one auxiliary metric of a DumpRecord: its Value in a raw
+// record, or its rollup in an aggregated one, with the t-digest checksummed
+// like the record's.
+type DumpMetric struct {
+	Name         string  `json:"name"`
+	Value        float64 `json:"value,omitempty"`
+	TDigest      []byte  `json:"tdigest,omitempty"`
+	TDigestCRC32 uint32  `json:"tdigest_crc32,omitempty"`
+	SampleCount  int64   `json:"sample_count,omitempty"`
+	Sum          float64 `json:"sum,omitempty"`
+}
+
+// DumpImportResult reports how many results an import stored.
+type DumpImportResult struct {
+	RawResults        int `json:"raw_results"`
+	AggregatedResults int `json:"aggregated_results"`
+}
+
+// handleDumpTarget streams a target's entire result history as
+// newline-delimited JSON DumpRecords, for moving it to another instance with
+// handleRestoreTarget. It is read in chunks, so the history never has to fit
+// in memory.
+func (s *Server) handleDumpTarget(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
+		return
+	}
+	target, err := s.reader.GetTarget(id)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Target not found: "+err.Error(), CodeTargetNotFound)
+		return
+	}
+	windows, err := s.reader.GetAggregatedWindows(id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+
+	// The dump outlives the server's WriteTimeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="vaportrail-target-%d.ndjson"`, id))
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	def := targetToDefinition(*target)
+	if err := enc.Encode(DumpRecord{Type: dumpHeader, Version: dumpVersion, Target: &def}); err != nil {
+		return
+	}
+	for _, window := range append([]int{0}, windows...) {
+		if err := s.dumpWindow(enc, id, window, flusher); err != nil {
+			// Headers are likely already sent, so all we can do is stop.
+			log.Printf("Dump of target %d failed: %v", id, err)
+			return
+		}
+	}
+}
+
+// dumpWindow writes every result of one window, where 0 means raw results.
+func (s *Server) dumpWindow(enc *json.Encoder, id int64, window int, flusher http.Flusher) error {
+	first, last, err := s.reader.GetResultTimeRange(id, window)
+	if err != nil || first.IsZero() {
+		return err
+	}
+	chunk := dumpRawChunk
+	if window > 0 {
+		chunk = time.Duration(window) * influxExportChunk * time.Second
+	}
+	end := last.Add(time.Nanosecond)
+	for from := first; from.Before(end); from = from.Add(chunk) {
+		to := from.Add(chunk)
+		if to.After(end) {
+			to = end
+		}
+		if window == 0 {
+			results, err := s.reader.GetRawResults(id, from, to, 0)
+			if err != nil {
+				return err
+			}
+			points, err := s.reader.GetRawMetrics(id, "", from, to, 0)
+			if err != nil {
+				return err
+			}
+			// Metrics are stored by time, not by result; they go with the
+			// first result at their time.
+			byTime := make(map[int64][]DumpMetric)
+			for _, p := range points {
+				byTime[p.Time.UnixNano()] = append(byTime[p.Time.UnixNano()], DumpMetric{Name: p.Name, Value: p.Value})
+			}
+			for _, res := range results {
+				latency := res.Latency
+				rec := DumpRecord{Type: dumpRaw, Time: res.Time, Latency: &latency, Metrics: byTime[res.Time.UnixNano()]}
+				delete(byTime, res.Time.UnixNano())
+				if res.Metadata != "" {
+					rec.Metadata = json.RawMessage(res.Metadata)
+				}
+				if err := enc.Encode(rec); err != nil {
+					return err
+				}
+			}
+		} else {
+			results, err := s.reader.GetAggregatedResults(id, window, from, to)
+			if err != nil {
+				return err
+			}
+			metrics, err := s.reader.GetAggregatedMetrics(id, window, "", from, to)
+			if err != nil {
+				return err
+			}
+			byTime := make(map[int64][]DumpMetric)
+			for _, m := range metrics {
+				byTime[m.Time.Unix()] = append(byTime[m.Time.Unix()], DumpMetric{
+					Name:         m.Name,
+					TDigest:      m.TDigestData,
+					TDigestCRC32: crc32.ChecksumIEEE(m.TDigestData),
+					SampleCount:  m.SampleCount,
+					Sum:          m.Sum,
+				})
+			}
+			for _, res := range results {
+				if res.Partial {
+					continue // Rebuilt from the raw results once restored.
+				}
+				if err := enc.Encode(DumpRecord{
+					Type:          dumpAggregated,
+					Time:          res.Time,
+					WindowSeconds: res.WindowSeconds,
+					TDigest:       res.TDigestData,
+					TDigestCRC32:  crc32.ChecksumIEEE(res.TDigestData),
+					TimeoutCount:  res.TimeoutCount,
+					SampleCount:   res.SampleCount,
+					SumNS:         res.SumNS,
+					SumSqNS:       res.SumSqNS,
+					MinNS:         res.MinNS,
+					MaxNS:         res.MaxNS,
+					Maintenance:   res.Maintenance,
+
+					SourceWindows:         res.SourceWindows,
+					ExpectedSourceWindows: res.ExpectedSourceWindows,
+
+					Percentiles: res.Percentiles,
+					Metrics:     byTime[res.Time.Unix()],
+				}); err != nil {
+					return err
+				}
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	return nil
+}
+
+// handleRestoreTarget imports a dump written by handleDumpTarget into the
+// target named in the URL, which need not have the ID or name the dump was
+// taken from. Aggregated results replace any stored for the same window,
+// while raw results are always added, so a dump should only be restored once
+// and into a target without overlapping raw history.
+//
+// Records are validated and stored dumpBatchSize at a time. On an invalid
+// record the import stops with 400; the batches before it stay stored and are
+// counted in the error message.
+func (s *Server) handleRestoreTarget(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID", CodeInvalidID)
+		return
+	}
+	if _, err := s.db.GetTarget(id); err != nil {
+		writeJSONError(w, http.StatusNotFound, "Target not found: "+err.Error(), CodeTargetNotFound)
+		return
+	}
+
+	var result DumpImportResult
+	var raw []db.RawResult
+	var aggregated []*db.AggregatedResult
+	flush := func() error {
+		if err := s.db.AddRawResults(raw); err != nil {
+			return err
+		}
+		result.RawResults += len(raw)
+		raw = raw[:0]
+		if err := s.db.AddAggregatedResults(aggregated); err != nil {
+			return err
+		}
+		result.AggregatedResults += len(aggregated)
+		aggregated = aggregated[:0]
+		return nil
+	}
+	fail := func(status int, msg, code string) {
+		writeJSONError(w, status, fmt.Sprintf("%s (imported %d raw and %d aggregated results before it)", msg, result.RawResults, result.AggregatedResults), code)
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r.Body))
+	for line := 1; ; line++ {
+		var rec DumpRecord
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				if line == 1 {
+					fail(http.StatusBadRequest, "Empty dump", CodeInvalidDump)
+					return
+				}
+				break
+			}
+			fail(http.StatusBadRequest, fmt.Sprintf("Record %d: %v", line, err), CodeInvalidDump)
+			return
+		}
+		if line == 1 {
+			if rec.Type != dumpHeader {
+				fail(http.StatusBadRequest, "Dump must start with a header record", CodeInvalidDump)
+				return
+			}
+			if rec.Version > dumpVersion {
+				fail(http.StatusBadRequest, fmt.Sprintf("Unsupported dump version %d", rec.Version), CodeUnsupportedVersion)
+				return
+			}
+			continue
+		}
+
+		if err := validateDumpRecord(rec); err != nil {
+			fail(http.StatusBadRequest, fmt.Sprintf("Record %d: %v", line, err), CodeInvalidDump)
+			return
+		}
+		switch rec.Type {
+		case dumpRaw:
+			var metrics map[string]float64
+			for _, m := range rec.Metrics {
+				if metrics == nil {
+					metrics = make(map[string]float64, len(rec.Metrics))
+				}
+				metrics[m.Name] = m.Value
+			}
+			raw = append(raw, db.RawResult{Time: rec.Time, TargetID: id, Latency: *rec.Latency, Metrics: metrics, Metadata: string(rec.Metadata)})
+		case dumpAggregated:
+			var metrics []db.AggregatedMetric
+			for _, m := range rec.Metrics {
+				metrics = append(metrics, db.AggregatedMetric{
+					Time:          rec.Time,
+					TargetID:      id,
+					WindowSeconds: rec.WindowSeconds,
+					Name:          m.Name,
+					TDigestData:   m.TDigest,
+					SampleCount:   m.SampleCount,
+					Sum:           m.Sum,
+				})
+			}
+			aggregated = append(aggregated, &db.AggregatedResult{
+				Time:          rec.Time,
+				TargetID:      id,
+				WindowSeconds: rec.WindowSeconds,
+				TDigestData:   rec.TDigest,
+				TimeoutCount:  rec.TimeoutCount,
+				SampleCount:   rec.SampleCount,
+				SumNS:         rec.SumNS,
+				SumSqNS:       rec.SumSqNS,
+				MinNS:         rec.MinNS,
+				MaxNS:         rec.MaxNS,
+				Maintenance:   rec.Maintenance,
+
+				SourceWindows:         rec.SourceWindows,
+				ExpectedSourceWindows: rec.ExpectedSourceWindows,
+
+				Percentiles: rec.Percentiles,
+				Metrics:     metrics,
+			})
+		}
+		if len(raw)+len(aggregated) >= dumpBatchSize {
+			if err := flush(); err != nil {
+				fail(http.StatusInternalServerError, err.Error(), CodeInternal)
+				return
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		fail(http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// validateDumpRecord checks a result record, including that an aggregated
+// result's t-digest matches its checksum and can be decoded.
+func validateDumpRecord(rec DumpRecord) error {
+	if rec.Time.IsZero() {
+		return errors.New("missing time")
+	}
+	switch rec.Type {
+	case dumpRaw:
+		if rec.Latency == nil {
+			return errors.New("raw result without latency")
+		}
+		if math.IsNaN(*rec.Latency) || math.IsInf(*rec.Latency, 0) {
+			return errors.New("latency must be a finite number")
+		}
+		if len(rec.Metadata) > 0 && rec.Metadata[0] != '{' {
+			return errors.New("metadata must be a JSON object")
+		}
+		for _, m := range rec.Metrics {
+			if m.Name == "" {
+				return errors.New("metric without a name")
+			}
+			if math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+				return fmt.Errorf("metric %s must be a finite number", m.Name)
+			}
+		}
+	case dumpAggregated:
+		if rec.WindowSeconds <= 0 {
+			return errors.New("aggregated result without a window")
+		}
+		if rec.TimeoutCount < 0 || rec.SampleCount < 0 || rec.SourceWindows < 0 || rec.ExpectedSourceWindows < 0 {
+			return errors.New("counts cannot be negative")
+		}
+		if crc32.ChecksumIEEE(rec.TDigest) != rec.TDigestCRC32 {
+			return errors.New("t-digest checksum mismatch")
+		}
+		if len(rec.TDigest) > 0 {
+			if _, err := db.DeserializeTDigest(rec.TDigest); err != nil {
+				return fmt.Errorf("unreadable t-digest: %w", err)
+			}
+		}
+		for name, v := range rec.Percentiles {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("percentile %s must be a finite number", name)
+			}
+		}
+		for _, m := range rec.Metrics {
+			if m.Name == "" || m.SampleCount < 0 {
+				return errors.New("metric without a name or with a negative count")
+			}
+			if crc32.ChecksumIEEE(m.TDigest) != m.TDigestCRC32 {
+				return fmt.Errorf("t-digest checksum mismatch for metric %s", m.Name)
+			}
+			if len(m.TDigest) > 0 {
+				if _, err := db.DeserializeTDigest(m.TDigest); err != nil {
+					return fmt.Errorf("unreadable t-digest for metric %s: %w", m.Name, err)
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("unknown record type %q", rec.Type)
+	}
+	return nil
+}
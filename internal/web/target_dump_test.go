@@ -0,0 +1,166 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"vaportrail/internal/db"
+
+	"github.com/caio/go-tdigest/v4"
+)
+
+func TestDumpAndRestoreTarget(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	addTarget := func(name string) int64 {
+		id, err := database.AddTarget(&db.Target{
+			Name:              name,
+			Address:           "example.com",
+			ProbeType:         "http",
+			RetentionPolicies: `[{"window": 0, "retention": 604800}, {"window": 60, "retention": 15768000}]`,
+		})
+		if err != nil {
+			t.Fatalf("Failed to add target: %v", err)
+		}
+		return id
+	}
+	src, dst := addTarget("Source"), addTarget("Destination")
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Raw results over more than one dump chunk, with sub-second timestamps.
+	var raw []db.RawResult
+	for i := 0; i < 5; i++ {
+		raw = append(raw, db.RawResult{Time: base.Add(time.Duration(i)*40*time.Minute + 123456789), TargetID: src, Latency: float64(i * 1000)})
+	}
+	raw[2].Latency = -1
+	raw[3].Metadata = `{"status_code":200}`
+	raw[1].Metrics = map[string]float64{"ttfb": 42, "throughput": 1.5e6}
+	if err := database.AddRawResults(raw); err != nil {
+		t.Fatalf("Failed to add raw results: %v", err)
+	}
+	td, _ := tdigest.New(tdigest.Compression(100))
+	td.Add(100)
+	td.Add(200)
+	data, _ := db.SerializeTDigest(td)
+	var agg []*db.AggregatedResult
+	for _, window := range []int{60, 3600} {
+		agg = append(agg, &db.AggregatedResult{
+			Time: base, TargetID: src, WindowSeconds: window, TDigestData: data, TimeoutCount: 1, SampleCount: 2, SumNS: 300, SumSqNS: 50000,
+			Percentiles: map[string]float64{"p50": 150},
+			Metrics:     []db.AggregatedMetric{{Time: base, TargetID: src, WindowSeconds: window, Name: "ttfb", TDigestData: data, SampleCount: 2, Sum: 300}},
+		})
+	}
+	if err := database.AddAggregatedResults(agg); err != nil {
+		t.Fatalf("Failed to add aggregated results: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/targets/"+strconv.FormatInt(src, 10)+"/dump", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+	dump := rr.Body.Bytes()
+
+	var records []DumpRecord
+	sc := bufio.NewScanner(bytes.NewReader(dump))
+	for sc.Scan() {
+		var rec DumpRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("Invalid dump line %q: %v", sc.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 1+len(raw)+len(agg) {
+		t.Fatalf("Expected %d records, got %d", 1+len(raw)+len(agg), len(records))
+	}
+	if records[0].Type != dumpHeader || records[0].Target == nil || records[0].Target.Name != "Source" {
+		t.Errorf("Unexpected header: %+v", records[0])
+	}
+
+	restore := func(id int64, body []byte) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/targets/"+strconv.FormatInt(id, 10)+"/dump", bytes.NewReader(body)))
+		return rr
+	}
+	rr = restore(dst, dump)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var result DumpImportResult
+	json.NewDecoder(rr.Body).Decode(&result)
+	if result.RawResults != len(raw) || result.AggregatedResults != len(agg) {
+		t.Errorf("Unexpected import result: %+v", result)
+	}
+
+	gotRaw, _ := database.GetRawResults(dst, base, base.Add(24*time.Hour), 0)
+	if len(gotRaw) != len(raw) {
+		t.Fatalf("Expected %d raw results, got %d", len(raw), len(gotRaw))
+	}
+	for i, r := range gotRaw {
+		if !r.Time.Equal(raw[i].Time) || r.Latency != raw[i].Latency || r.Metadata != raw[i].Metadata {
+			t.Errorf("Raw result %d: got %v %v %q, want %v %v %q", i, r.Time, r.Latency, r.Metadata, raw[i].Time, raw[i].Latency, raw[i].Metadata)
+		}
+	}
+	for name, want := range raw[1].Metrics {
+		points, _ := database.GetRawMetrics(dst, name, base, base.Add(24*time.Hour), 0)
+		if len(points) != 1 || !points[0].Time.Equal(raw[1].Time) || points[0].Value != want {
+			t.Errorf("Raw metric %s not restored exactly: %+v", name, points)
+		}
+	}
+	for _, want := range agg {
+		got, _ := database.GetAggregatedResults(dst, want.WindowSeconds, base, base.Add(time.Second))
+		if len(got) != 1 {
+			t.Fatalf("Expected 1 result for window %d, got %d", want.WindowSeconds, len(got))
+		}
+		if !bytes.Equal(got[0].TDigestData, data) || got[0].TimeoutCount != 1 || got[0].SampleCount != 2 || got[0].SumNS != 300 || got[0].SumSqNS != 50000 {
+			t.Errorf("Window %d not restored exactly: %+v", want.WindowSeconds, got[0])
+		}
+		if got[0].Percentiles["p50"] != 150 {
+			t.Errorf("Window %d: expected stored percentiles, got %v", want.WindowSeconds, got[0].Percentiles)
+		}
+		metrics, _ := database.GetAggregatedMetrics(dst, want.WindowSeconds, "", base, base.Add(time.Second))
+		if len(metrics) != 1 || metrics[0].Name != "ttfb" || !bytes.Equal(metrics[0].TDigestData, data) || metrics[0].SampleCount != 2 || metrics[0].Sum != 300 {
+			t.Errorf("Window %d: metrics not restored exactly: %+v", want.WindowSeconds, metrics)
+		}
+	}
+
+	// A corrupted blob is rejected.
+	lines := strings.Split(strings.TrimSpace(string(dump)), "\n")
+	var rec DumpRecord
+	json.Unmarshal([]byte(lines[len(lines)-1]), &rec)
+	rec.TDigest[len(rec.TDigest)-1] ^= 0xff
+	corrupt, _ := json.Marshal(rec)
+	body := lines[0] + "\n" + string(corrupt) + "\n"
+	if rr := restore(dst, []byte(body)); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "checksum") {
+		t.Errorf("Expected checksum error, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	// So is a dump without a header.
+	if rr := restore(dst, []byte(lines[1]+"\n")); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without header, got %v", rr.Code)
+	}
+	if rr := restore(999, dump); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown target, got %v", rr.Code)
+	}
+
+	// Restoring overwrites rollups, so it needs the write token.
+	s.cfg.WriteToken = "s3cret"
+	if rr := restore(dst, dump); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without the token, got %v", rr.Code)
+	}
+	req := httptest.NewRequest("POST", "/api/targets/"+strconv.FormatInt(dst, 10)+"/dump", bytes.NewReader(dump))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rr = httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 with the token, got %v: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,97 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"vaportrail/internal/db"
+)
+
+// maxBulkTargets is the most targets one POST /api/targets/bulk may create.
+const maxBulkTargets = 1000
+
+// BulkTargetResult reports one target of a bulk creation, in the order
+// they were submitted: its ID once created, or why it was rejected.
+type BulkTargetResult struct {
+	Name  string `json:"name"`
+	ID    int64  `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+}
+
+// handleBulkCreateTargets creates a JSON array of targets, each in the form
+// POST /api/targets accepts, in a single transaction. The batch is all or
+// nothing: every target is validated first, and if any is invalid none is
+// created and the reply is 400 with the results of all of them, the invalid
+// ones carrying their error. Otherwise the reply is 201 with each target's
+// new ID. Unlike POST /api/targets/import, targets aren't matched by name,
+// so submitting a batch twice creates it twice.
+func (s *Server) handleBulkCreateTargets(w http.ResponseWriter, r *http.Request) {
+	var targets []db.Target
+	if err := json.NewDecoder(r.Body).Decode(&targets); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+		return
+	}
+	if len(targets) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "No targets to create", CodeInvalidRequest)
+		return
+	}
+	if len(targets) > maxBulkTargets {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("At most %d targets may be created at once", maxBulkTargets), CodeInvalidRequest)
+		return
+	}
+
+	results := make([]BulkTargetResult, len(targets))
+	invalid := false
+	for i := range targets {
+		results[i].Name = targets[i].Name
+		targets[i].ID = 0
+		if err := normalizeTarget(&targets[i]); err != nil {
+			results[i].Error = err.Error()
+			results[i].Code = targetErrorCode(err)
+			invalid = true
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if invalid {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(results)
+		return
+	}
+
+	batch := make([]*db.Target, len(targets))
+	for i := range targets {
+		batch[i] = &targets[i]
+	}
+	if err := s.addTargets(batch); errors.Is(err, errTargetLimit) {
+		writeJSONError(w, http.StatusConflict, err.Error(), CodeTargetLimit)
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+
+	for i, t := range targets {
+		results[i].ID = t.ID
+		if s.scheduler != nil {
+			s.scheduler.AddTarget(t)
+		}
+	}
+	if s.scheduler != nil {
+		s.warnNearTargetLimit()
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(results)
+}
+
+// addTargets stores new targets in one transaction, enforcing the configured
+// MaxTargets for the batch as a whole.
+func (s *Server) addTargets(targets []*db.Target) error {
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
+	if err := s.checkTargetLimit(len(targets)); err != nil {
+		return err
+	}
+	return s.db.AddTargets(targets)
+}
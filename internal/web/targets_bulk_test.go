@@ -0,0 +1,73 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func bulkCreateTargets(t *testing.T, s *Server, body string) (int, []BulkTargetResult) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/targets/bulk", strings.NewReader(body)))
+	var results []BulkTargetResult
+	if rr.Code == http.StatusCreated || rr.Code == http.StatusBadRequest {
+		json.Unmarshal(rr.Body.Bytes(), &results)
+	}
+	return rr.Code, results
+}
+
+func TestHandleBulkCreateTargets(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	// One invalid target rejects the whole batch.
+	code, results := bulkCreateTargets(t, s, `[
+		{"Name": "A", "Address": "http://a.example", "ProbeType": "http"},
+		{"Name": "B", "Address": "x", "ProbeType": "bogus"}
+	]`)
+	if code != http.StatusBadRequest || len(results) != 2 {
+		t.Fatalf("Expected 400 with a result per target, got %d: %+v", code, results)
+	}
+	if results[0].Error != "" || results[1].Error == "" || results[1].Code != CodeInvalidProbeType {
+		t.Errorf("Expected only B to be rejected, got %+v", results)
+	}
+	if targets, _ := database.GetTargets(); len(targets) != 0 {
+		t.Fatalf("Expected nothing created from an invalid batch, got %+v", targets)
+	}
+
+	code, results = bulkCreateTargets(t, s, `[
+		{"Name": "A", "Address": "http://a.example", "ProbeType": "http"},
+		{"Name": "B", "Address": "http://b.example", "ProbeType": "http", "ProbeInterval": 5}
+	]`)
+	if code != http.StatusCreated || len(results) != 2 {
+		t.Fatalf("Expected 201 with a result per target, got %d: %+v", code, results)
+	}
+	for _, res := range results {
+		target, err := database.GetTarget(res.ID)
+		if err != nil || target.Name != res.Name {
+			t.Errorf("Expected %s stored as %d, got %+v, %v", res.Name, res.ID, target, err)
+		}
+	}
+
+	for _, body := range []string{`[]`, `{"Name": "A"}`} {
+		if code, _ := bulkCreateTargets(t, s, body); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, code)
+		}
+	}
+
+	// The target limit applies to the batch as a whole.
+	s.cfg.MaxTargets = 3
+	code, _ = bulkCreateTargets(t, s, `[
+		{"Name": "C", "Address": "http://c.example", "ProbeType": "http"},
+		{"Name": "D", "Address": "http://d.example", "ProbeType": "http"}
+	]`)
+	if code != http.StatusConflict {
+		t.Errorf("Expected 409 past MaxTargets, got %d", code)
+	}
+	if targets, _ := database.GetTargets(); len(targets) != 2 {
+		t.Errorf("Expected no targets created past MaxTargets, got %d", len(targets))
+	}
+}
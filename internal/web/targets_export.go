@@ -0,0 +1,235 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"vaportrail/internal/db"
+	"vaportrail/internal/probe"
+	"vaportrail/internal/scheduler"
+)
+
+// targetsDocumentVersion is bumped whenever the export format changes in a
+// way that older importers can't read.
+const targetsDocumentVersion = 1
+
+// TargetsDocument is the portable form of the target list used by
+// GET /api/targets/export and POST /api/targets/import.
+type TargetsDocument struct {
+	Version int                `json:"version"`
+	Targets []TargetDefinition `json:"targets"`
+}
+
+// TargetDefinition describes a single target without any instance-specific
+// state such as its database ID. Targets are matched by Name on import, so
+// re-importing the same document is idempotent. RetentionPolicies is empty
+// for a target that inherits the global policies.
+type TargetDefinition struct {
+	Name              string                      `json:"name"`
+	Address           string                      `json:"address"`
+	ProbeType         string                      `json:"probe_type"`
+	ProbeInterval     float64                     `json:"probe_interval"`
+	Timeout           float64                     `json:"timeout"`
+	RetentionPolicies []scheduler.RetentionPolicy `json:"retention_policies,omitempty"`
+	ProbeConfig       json.RawMessage             `json:"probe_config,omitempty"`
+	MaxLatencyNS      float64                     `json:"max_latency_ns,omitempty"`
+	MaxLatencyAction  string                      `json:"max_latency_action,omitempty"`
+	WarmupProbes      int                         `json:"warmup_probes,omitempty"`
+	DownAfter         int                         `json:"down_after,omitempty"`
+	UpAfter           int                         `json:"up_after,omitempty"`
+	RetryCount        int                         `json:"retry_count,omitempty"`
+	RecordMetadata    bool                        `json:"record_metadata,omitempty"`
+	AlignProbes       bool                        `json:"align_probes,omitempty"`
+	Aggregation       string                      `json:"aggregation,omitempty"`
+	Schedule          string                      `json:"schedule,omitempty"`
+}
+
+// TargetImportResult reports the outcome of importing a single target.
+type TargetImportResult struct {
+	Name   string `json:"name"`
+	ID     int64  `json:"id,omitempty"`
+	Action string `json:"action"` // "created", "updated", "unchanged" or "failed"
+	Error  string `json:"error,omitempty"`
+}
+
+func targetToDefinition(t db.Target) TargetDefinition {
+	def := TargetDefinition{
+		Name:             t.Name,
+		Address:          t.Address,
+		ProbeType:        t.ProbeType,
+		ProbeInterval:    t.ProbeInterval,
+		Timeout:          t.Timeout,
+		MaxLatencyNS:     t.MaxLatencyNS,
+		MaxLatencyAction: t.MaxLatencyAction,
+		WarmupProbes:     t.WarmupProbes,
+		DownAfter:        t.DownAfter,
+		UpAfter:          t.UpAfter,
+		RetryCount:       t.RetryCount,
+		RecordMetadata:   t.RecordMetadata,
+		AlignProbes:      t.AlignProbes,
+		Aggregation:      t.Aggregation,
+		Schedule:         t.Schedule,
+	}
+	if !scheduler.InheritsRetentionPolicies(t) {
+		if policies, err := scheduler.GetRetentionPolicies(t); err == nil {
+			def.RetentionPolicies = policies
+		}
+	}
+	if t.ProbeConfig != "" && json.Valid([]byte(t.ProbeConfig)) {
+		def.ProbeConfig = json.RawMessage(t.ProbeConfig)
+	}
+	return def
+}
+
+func definitionToTarget(def TargetDefinition) (db.Target, error) {
+	t := db.Target{
+		Name:             def.Name,
+		Address:          def.Address,
+		ProbeType:        def.ProbeType,
+		ProbeInterval:    def.ProbeInterval,
+		Timeout:          def.Timeout,
+		MaxLatencyNS:     def.MaxLatencyNS,
+		MaxLatencyAction: def.MaxLatencyAction,
+		WarmupProbes:     def.WarmupProbes,
+		DownAfter:        def.DownAfter,
+		UpAfter:          def.UpAfter,
+		RetryCount:       def.RetryCount,
+		RecordMetadata:   def.RecordMetadata,
+		AlignProbes:      def.AlignProbes,
+		Aggregation:      def.Aggregation,
+		Schedule:         def.Schedule,
+	}
+	if len(def.RetentionPolicies) > 0 {
+		data, err := json.Marshal(def.RetentionPolicies)
+		if err != nil {
+			return db.Target{}, err
+		}
+		t.RetentionPolicies = string(data)
+	}
+	if len(def.ProbeConfig) > 0 && string(def.ProbeConfig) != "null" {
+		t.ProbeConfig = string(def.ProbeConfig)
+	}
+	return t, nil
+}
+
+func (s *Server) handleExportTargets(w http.ResponseWriter, r *http.Request) {
+	targets, err := s.db.GetTargets()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+
+	doc := TargetsDocument{
+		Version: targetsDocumentVersion,
+		Targets: make([]TargetDefinition, 0, len(targets)),
+	}
+	for _, t := range targets {
+		doc.Targets = append(doc.Targets, targetToDefinition(t))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="vaportrail-targets.json"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(doc)
+}
+
+func (s *Server) handleImportTargets(w http.ResponseWriter, r *http.Request) {
+	var doc TargetsDocument
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), CodeInvalidRequest)
+		return
+	}
+	if doc.Version > targetsDocumentVersion {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported document version %d", doc.Version), CodeUnsupportedVersion)
+		return
+	}
+
+	existing, err := s.db.GetTargets()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	byName := make(map[string]db.Target, len(existing))
+	for _, t := range existing {
+		if _, ok := byName[t.Name]; !ok {
+			byName[t.Name] = t
+		}
+	}
+
+	seen := make(map[string]bool, len(doc.Targets))
+	results := make([]TargetImportResult, 0, len(doc.Targets))
+	for _, def := range doc.Targets {
+		res := TargetImportResult{Name: def.Name}
+		if seen[def.Name] {
+			res.Action = "failed"
+			res.Error = "Duplicate target name in import"
+			results = append(results, res)
+			continue
+		}
+		seen[def.Name] = true
+
+		id, action, err := s.importTarget(def, byName)
+		res.ID = id
+		res.Action = action
+		if err != nil {
+			res.Action = "failed"
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// importTarget validates and upserts a single target definition, returning
+// the target ID and whether it was "created", "updated" or "unchanged".
+func (s *Server) importTarget(def TargetDefinition, byName map[string]db.Target) (int64, string, error) {
+	t, err := definitionToTarget(def)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := normalizeTarget(&t); err != nil {
+		return 0, "", err
+	}
+
+	current, exists := byName[t.Name]
+	if !exists {
+		id, err := s.addTarget(&t)
+		if err != nil {
+			return 0, "", err
+		}
+		t.ID = id
+		if s.scheduler != nil {
+			s.scheduler.AddTarget(t)
+			s.warnNearTargetLimit()
+		}
+		return id, "created", nil
+	}
+
+	t.ID = current.ID
+	t.Down = current.Down // Runtime state, not part of the definition.
+	if current.Address != t.Address {
+		// Targets stored before addresses were normalized are unchanged if
+		// they normalize to the same thing.
+		cfg, _ := probe.GetTargetConfig(current.ProbeType, current.Address, current.ProbeConfig)
+		if addr, err := probe.NormalizeAddress(current.ProbeType, current.Address, cfg.Resolver); err == nil {
+			current.Address = addr
+		}
+	}
+	if t == current {
+		return t.ID, "unchanged", nil
+	}
+
+	newPolicies, _ := scheduler.GetRetentionPolicies(t)
+	s.dropRemovedWindows(current, newPolicies)
+
+	if err := s.db.UpdateTarget(&t); err != nil {
+		return t.ID, "", err
+	}
+	if s.scheduler != nil {
+		s.scheduler.UpdateTarget(t)
+	}
+	return t.ID, "updated", nil
+}
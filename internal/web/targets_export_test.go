@@ -0,0 +1,108 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"vaportrail/internal/db"
+)
+
+func TestTargetsExportImport(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	if _, err := database.AddTarget(&db.Target{
+		Name:              "Existing",
+		Address:           "example.com",
+		ProbeType:         "http",
+		ProbeInterval:     2,
+		Timeout:           3,
+		RetentionPolicies: `[{"window":0,"retention":3600},{"window":60,"retention":86400}]`,
+	}); err != nil {
+		t.Fatalf("Failed to add target: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/api/targets/export", nil)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected export status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var doc TargetsDocument
+	if err := json.NewDecoder(rr.Body).Decode(&doc); err != nil {
+		t.Fatalf("Failed to decode export: %v", err)
+	}
+	if doc.Version != targetsDocumentVersion || len(doc.Targets) != 1 {
+		t.Fatalf("Unexpected export document: %+v", doc)
+	}
+	if got := doc.Targets[0]; got.Name != "Existing" || got.ProbeInterval != 2 || len(got.RetentionPolicies) != 2 {
+		t.Fatalf("Unexpected exported target: %+v", got)
+	}
+
+	// Re-importing the export must not change anything.
+	body, _ := json.Marshal(doc)
+	results := importTargets(t, s, string(body))
+	if len(results) != 1 || results[0].Action != "unchanged" {
+		t.Fatalf("Expected re-import to be unchanged, got %+v", results)
+	}
+
+	// Modify one target, add a new one, include an invalid one and a duplicate.
+	doc.Targets[0].Timeout = 4
+	doc.Targets = append(doc.Targets,
+		TargetDefinition{Name: "New", Address: "8.8.8.8", ProbeType: "dns"},
+		TargetDefinition{Name: "Broken", Address: "x", ProbeType: "bogus"},
+		TargetDefinition{Name: "New", Address: "8.8.4.4", ProbeType: "dns"},
+	)
+	body, _ = json.Marshal(doc)
+	results = importTargets(t, s, string(body))
+	want := []string{"updated", "created", "failed", "failed"}
+	if len(results) != len(want) {
+		t.Fatalf("Expected %d results, got %+v", len(want), results)
+	}
+	for i, action := range want {
+		if results[i].Action != action {
+			t.Errorf("Result %d (%s): expected %s, got %s (%s)", i, results[i].Name, action, results[i].Action, results[i].Error)
+		}
+	}
+
+	targets, err := database.GetTargets()
+	if err != nil {
+		t.Fatalf("GetTargets failed: %v", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets after import, got %d", len(targets))
+	}
+	for _, target := range targets {
+		if target.Name == "Existing" && target.Timeout != 4 {
+			t.Errorf("Expected updated timeout 4, got %v", target.Timeout)
+		}
+		if target.Name == "New" && target.RetentionPolicies != "" {
+			t.Errorf("Expected created target to inherit the global retention policies, got %s", target.RetentionPolicies)
+		}
+	}
+
+	// Importing the same document again is idempotent.
+	results = importTargets(t, s, string(body))
+	if results[0].Action != "unchanged" || results[1].Action != "unchanged" {
+		t.Fatalf("Expected second import to be unchanged, got %+v", results)
+	}
+}
+
+func importTargets(t *testing.T, s *Server, body string) []TargetImportResult {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/targets/import", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected import status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var results []TargetImportResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatalf("Failed to decode import results: %v", err)
+	}
+	return results
+}
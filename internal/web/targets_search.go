@@ -0,0 +1,55 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"vaportrail/internal/db"
+)
+
+const (
+	// defaultSearchResults and maxSearchResults are the default and largest
+	// limit of GET /api/targets/search.
+	defaultSearchResults = 20
+	maxSearchResults     = 100
+)
+
+// handleSearchTargets finds targets by name or address, for fleets too big
+// to scroll through. Query parameters:
+//
+//	q      required text to look for, matched case-insensitively anywhere
+//	       in the name or address
+//	limit  most targets to return, defaulting to defaultSearchResults
+//
+// Exact matches are listed first, then prefix matches; see
+// db.SearchTargets.
+func (s *Server) handleSearchTargets(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeJSONError(w, http.StatusBadRequest, "q is required", CodeInvalidRequest)
+		return
+	}
+	limit := defaultSearchResults
+	if str := r.URL.Query().Get("limit"); str != "" {
+		n, err := strconv.Atoi(str)
+		if err != nil || n < 1 || n > maxSearchResults {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("limit must be an integer between 1 and %d", maxSearchResults), CodeInvalidRequest)
+			return
+		}
+		limit = n
+	}
+
+	targets, err := s.db.SearchTargets(q, limit)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), CodeInternal)
+		return
+	}
+	if targets == nil {
+		targets = []db.Target{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(targets)
+}
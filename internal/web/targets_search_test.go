@@ -0,0 +1,80 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"vaportrail/internal/db"
+)
+
+func TestHandleSearchTargets(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	for _, target := range []db.Target{
+		{Name: "Web frontend", Address: "https://www.example.com", ProbeType: "http"},
+		{Name: "example.com", Address: "example.com", ProbeType: "ping"},
+		{Name: "Resolver", Address: "1.1.1.1", ProbeType: "dns"},
+		{Name: "Example API", Address: "https://api.test", ProbeType: "http"},
+		{Name: "100%_up", Address: "10.0.0.1", ProbeType: "ping"},
+	} {
+		if _, err := database.AddTarget(&target); err != nil {
+			t.Fatalf("Failed to add target: %v", err)
+		}
+	}
+
+	search := func(query string) ([]db.Target, int) {
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/targets/search?"+query, nil))
+		var targets []db.Target
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&targets); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return targets, rr.Code
+	}
+	names := func(targets []db.Target) []string {
+		var n []string
+		for _, t := range targets {
+			n = append(n, t.Name)
+		}
+		return n
+	}
+
+	// The exact match first, then the prefix match, then the rest.
+	targets, code := search("q=EXAMPLE.com")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if got := names(targets); len(got) != 2 || got[0] != "example.com" || got[1] != "Web frontend" {
+		t.Errorf("Unexpected matches for EXAMPLE.com: %v", got)
+	}
+	targets, _ = search("q=example")
+	if got := names(targets); len(got) != 3 || got[0] != "Example API" || got[1] != "example.com" || got[2] != "Web frontend" {
+		t.Errorf("Unexpected matches for example: %v", got)
+	}
+	targets, _ = search("q=example&limit=1")
+	if len(targets) != 1 {
+		t.Errorf("Expected limit to cap the results, got %v", names(targets))
+	}
+
+	// LIKE wildcards are matched literally.
+	targets, _ = search("q=" + url.QueryEscape("0%_"))
+	if got := names(targets); len(got) != 1 || got[0] != "100%_up" {
+		t.Errorf("Unexpected matches for 0%%_: %v", got)
+	}
+	targets, code = search("q=nothing")
+	if code != http.StatusOK || targets == nil || len(targets) != 0 {
+		t.Errorf("Expected an empty list, got %d %v", code, targets)
+	}
+
+	for _, query := range []string{"", "q=+", "q=a&limit=0", "q=a&limit=1000"} {
+		if _, code := search(query); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %q, got %d", query, code)
+		}
+	}
+}
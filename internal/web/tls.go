@@ -0,0 +1,76 @@
+package web
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// certReloader serves a certificate loaded from disk and can re-read it, so
+// a renewed certificate takes effect without restarting the server.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the certificate and key again. On failure the previously
+// loaded certificate stays in use.
+func (c *certReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate %q and key %q: %w", c.certFile, c.keyFile, err)
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// LoadTLS loads the configured certificate and key and switches Start to
+// HTTPS. It does nothing when neither file is configured, and fails if only
+// one of them is or if they can't be loaded.
+func (s *Server) LoadTLS() error {
+	if s.cfg.TLSCertFile == "" && s.cfg.TLSKeyFile == "" {
+		return nil
+	}
+	if s.cfg.TLSCertFile == "" || s.cfg.TLSKeyFile == "" {
+		return errors.New("both a TLS certificate and key file must be set to enable HTTPS")
+	}
+	certs, err := newCertReloader(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+	if err != nil {
+		return err
+	}
+	s.certs = certs
+	s.httpSrv.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+	return nil
+}
+
+// ReloadTLS re-reads the certificate and key from disk. It is a no-op when
+// TLS isn't enabled.
+func (s *Server) ReloadTLS() error {
+	if s.certs == nil {
+		return nil
+	}
+	return s.certs.Reload()
+}
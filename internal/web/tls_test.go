@@ -0,0 +1,107 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for commonName and its key
+// to certFile and keyFile.
+func writeTestCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+}
+
+func servedCommonName(t *testing.T, s *Server) string {
+	t.Helper()
+	cert, err := s.httpSrv.TLSConfig.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Failed to parse served certificate: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestLoadTLS(t *testing.T) {
+	s, database := setupTestServer(t)
+	defer database.Close()
+
+	if err := s.LoadTLS(); err != nil || s.certs != nil {
+		t.Fatalf("Expected TLS to stay disabled without files, got err=%v", err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	s.cfg.TLSCertFile = certFile
+	if err := s.LoadTLS(); err == nil {
+		t.Error("Expected an error when only the certificate is configured")
+	}
+	s.cfg.TLSKeyFile = keyFile
+	if err := s.LoadTLS(); err == nil {
+		t.Error("Expected an error when the certificate files don't exist")
+	}
+
+	writeTestCert(t, certFile, keyFile, "first")
+	if err := s.LoadTLS(); err != nil {
+		t.Fatalf("LoadTLS failed: %v", err)
+	}
+	if cn := servedCommonName(t, s); cn != "first" {
+		t.Errorf("Expected certificate 'first', got %q", cn)
+	}
+
+	writeTestCert(t, certFile, keyFile, "renewed")
+	if err := s.ReloadTLS(); err != nil {
+		t.Fatalf("ReloadTLS failed: %v", err)
+	}
+	if cn := servedCommonName(t, s); cn != "renewed" {
+		t.Errorf("Expected reloaded certificate 'renewed', got %q", cn)
+	}
+
+	// A broken renewal keeps the last good certificate.
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatalf("Failed to corrupt key: %v", err)
+	}
+	if err := s.ReloadTLS(); err == nil {
+		t.Error("Expected ReloadTLS to fail with a corrupt key")
+	}
+	if cn := servedCommonName(t, s); cn != "renewed" {
+		t.Errorf("Expected the previous certificate to stay in use, got %q", cn)
+	}
+}